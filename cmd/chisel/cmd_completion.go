@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var shortCompletionHelp = "Generate shell completion scripts"
+var longCompletionHelp = `
+The completion command prints a completion script for the given shell,
+covering the chisel commands and their options, including the global ones.
+Slice names, and package names where a package is accepted, are completed
+from the release selected with --release, if any.
+
+To enable completion in the current bash session, for example, run:
+
+  source <(chisel completion bash)
+`
+
+type cmdCompletion struct {
+	Positional struct {
+		Shell string `positional-arg-name:"<shell>" required:"yes"`
+	} `positional-args:"yes"`
+	parser *flags.Parser
+}
+
+func init() {
+	addCommand("completion", shortCompletionHelp, longCompletionHelp, func() flags.Commander { return &cmdCompletion{} }, nil, nil)
+}
+
+func (cmd *cmdCompletion) setParser(parser *flags.Parser) {
+	cmd.parser = parser
+}
+
+func (cmd *cmdCompletion) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	commands := completionCommands(cmd.parser)
+	// The global options are in the groups of the parser.
+	var globalOpts []*flags.Option
+	for _, group := range cmd.parser.Groups() {
+		globalOpts = append(globalOpts, group.Options()...)
+	}
+	global := completionOptions(globalOpts)
+	switch cmd.Positional.Shell {
+	case "bash":
+		writeBashCompletion(Stdout, commands, global)
+	case "zsh":
+		writeZshCompletion(Stdout, commands, global)
+	case "fish":
+		writeFishCompletion(Stdout, commands, global)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", cmd.Positional.Shell)
+	}
+	return nil
+}
+
+type completionOption struct {
+	name       string
+	desc       string
+	hasValue   bool
+	repeatable bool
+	choices    []string
+}
+
+type completionCommand struct {
+	name    string
+	desc    string
+	options []completionOption
+	// slices is whether the positional arguments are slice names.
+	slices bool
+	// packages is whether the positional arguments may be package names
+	// as well.
+	packages bool
+}
+
+// completionCommands returns the visible commands of the parser along with
+// their visible long options, in the order they were registered.
+func completionCommands(parser *flags.Parser) []completionCommand {
+	var commands []completionCommand
+	for _, cmd := range parser.Commands() {
+		if cmd.Hidden {
+			continue
+		}
+		command := completionCommand{
+			name: cmd.Name,
+			desc: cmd.ShortDescription,
+		}
+		command.options = completionOptions(cmd.Options())
+		for _, arg := range cmd.Args() {
+			if arg.Name == "<slice names>" || arg.Name == "<pkg|slice>" {
+				command.slices = true
+			}
+			if arg.Name == "<pkg|slice>" {
+				command.packages = true
+			}
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// completionOptions returns the visible long options among opts.
+func completionOptions(opts []*flags.Option) []completionOption {
+	var options []completionOption
+	for _, opt := range opts {
+		if opt.Hidden || opt.LongName == "" {
+			continue
+		}
+		kind := opt.Field().Type.Kind()
+		repeatable := kind == reflect.Slice || kind == reflect.Map
+		if repeatable {
+			kind = opt.Field().Type.Elem().Kind()
+		}
+		options = append(options, completionOption{
+			name:       opt.LongName,
+			desc:       opt.Description,
+			hasValue:   kind != reflect.Bool && kind != reflect.Func,
+			repeatable: repeatable,
+			choices:    opt.Choices,
+		})
+	}
+	return options
+}
+
+func writeBashCompletion(w io.Writer, commands []completionCommand, global []completionOption) {
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = command.name
+	}
+	fmt.Fprintf(w, "# bash completion for chisel\n\n")
+	fmt.Fprintf(w, "_chisel_slices() {\n")
+	fmt.Fprintf(w, "\tlocal i release\n")
+	fmt.Fprintf(w, "\tfor ((i = 2; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "\t\tif [ \"${COMP_WORDS[i]}\" = --release ]; then\n")
+	fmt.Fprintf(w, "\t\t\trelease=\"${COMP_WORDS[i+1]}\"\n")
+	fmt.Fprintf(w, "\t\t\tif [ \"$release\" = = ]; then\n")
+	fmt.Fprintf(w, "\t\t\t\trelease=\"${COMP_WORDS[i+2]}\"\n")
+	fmt.Fprintf(w, "\t\t\tfi\n")
+	fmt.Fprintf(w, "\t\tfi\n")
+	fmt.Fprintf(w, "\tdone\n")
+	fmt.Fprintf(w, "\tCOMPREPLY=($(chisel debug complete-slices ${release:+--release \"$release\"} ${packages:+--packages} -- \"$1\" 2>/dev/null))\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "_chisel() {\n")
+	fmt.Fprintf(w, "\tlocal cur prev opts slices packages\n")
+	fmt.Fprintf(w, "\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(w, "\tprev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	var globalOpts []string
+	for _, opt := range global {
+		globalOpts = append(globalOpts, "--"+opt.name)
+	}
+	fmt.Fprintf(w, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(w, "\t\tif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(globalOpts, " "))
+	fmt.Fprintf(w, "\t\t\treturn\n")
+	fmt.Fprintf(w, "\t\tfi\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tcase \"${COMP_WORDS[1]}\" in\n")
+	for _, command := range commands {
+		if len(command.options) == 0 && !command.slices {
+			continue
+		}
+		var opts []string
+		for _, opt := range command.options {
+			opts = append(opts, "--"+opt.name)
+		}
+		fmt.Fprintf(w, "\t%s)\n", command.name)
+		fmt.Fprintf(w, "\t\topts=\"%s\"\n", strings.Join(opts, " "))
+		if command.slices {
+			fmt.Fprintf(w, "\t\tslices=1\n")
+		}
+		if command.packages {
+			fmt.Fprintf(w, "\t\tpackages=1\n")
+		}
+		fmt.Fprintf(w, "\t\tcase \"$prev\" in\n")
+		for _, opt := range command.options {
+			if len(opt.choices) > 0 {
+				fmt.Fprintf(w, "\t\t--%s)\n", opt.name)
+				fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(opt.choices, " "))
+				fmt.Fprintf(w, "\t\t\treturn\n")
+				fmt.Fprintf(w, "\t\t\t;;\n")
+			} else if opt.hasValue {
+				fmt.Fprintf(w, "\t\t--%s)\n", opt.name)
+				fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+				fmt.Fprintf(w, "\t\t\treturn\n")
+				fmt.Fprintf(w, "\t\t\t;;\n")
+			}
+		}
+		fmt.Fprintf(w, "\t\tesac\n")
+		fmt.Fprintf(w, "\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"$opts\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\telif [ -n \"$slices\" ]; then\n")
+	fmt.Fprintf(w, "\t\t_chisel_slices \"$cur\"\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "complete -o default -F _chisel chisel\n")
+}
+
+func writeZshCompletion(w io.Writer, commands []completionCommand, global []completionOption) {
+	fmt.Fprintf(w, "#compdef chisel\n\n")
+	fmt.Fprintf(w, "_chisel_slices() {\n")
+	fmt.Fprintf(w, "\tlocal release=${opt_args[--release]}\n")
+	fmt.Fprintf(w, "\tlocal -a slices\n")
+	fmt.Fprintf(w, "\tslices=(${(f)\"$(chisel debug complete-slices ${release:+--release \"$release\"} \"$@\" -- \"$PREFIX\" 2>/dev/null)\"})\n")
+	fmt.Fprintf(w, "\tcompadd -a slices\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "_chisel() {\n")
+	fmt.Fprintf(w, "\tlocal -a commands\n")
+	fmt.Fprintf(w, "\tcommands=(\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t\t%s\n", shellQuote(command.name+":"+command.desc))
+	}
+	fmt.Fprintf(w, "\t)\n")
+	var globalOpts []string
+	for _, opt := range global {
+		globalOpts = append(globalOpts, "--"+opt.name)
+	}
+	fmt.Fprintf(w, "\tif (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(w, "\t\tif [[ $PREFIX == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\t\tcompadd -- %s\n", strings.Join(globalOpts, " "))
+	fmt.Fprintf(w, "\t\t\treturn\n")
+	fmt.Fprintf(w, "\t\tfi\n")
+	fmt.Fprintf(w, "\t\t_describe 'command' commands\n")
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tshift words\n")
+	fmt.Fprintf(w, "\t(( CURRENT-- ))\n")
+	fmt.Fprintf(w, "\tcase $words[1] in\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t%s)\n", command.name)
+		fmt.Fprintf(w, "\t\t_arguments")
+		for _, opt := range command.options {
+			spec := "--" + opt.name
+			if opt.repeatable {
+				spec = "*" + spec
+			}
+			if opt.hasValue {
+				spec += "="
+			}
+			spec += "[" + zshEscape(opt.desc) + "]"
+			if len(opt.choices) > 0 {
+				spec += ":" + opt.name + ":(" + strings.Join(opt.choices, " ") + ")"
+			} else if opt.hasValue {
+				spec += ":" + opt.name + ":_files"
+			}
+			fmt.Fprintf(w, " \\\n\t\t\t%s", shellQuote(spec))
+		}
+		if command.packages {
+			fmt.Fprintf(w, " \\\n\t\t\t'*:slice:_chisel_slices --packages'\n")
+		} else if command.slices {
+			fmt.Fprintf(w, " \\\n\t\t\t'*:slice:_chisel_slices'\n")
+		} else {
+			fmt.Fprintf(w, " \\\n\t\t\t'*:file:_files'\n")
+		}
+		fmt.Fprintf(w, "\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "compdef _chisel chisel\n")
+}
+
+func writeFishCompletion(w io.Writer, commands []completionCommand, global []completionOption) {
+	fmt.Fprintf(w, "# fish completion for chisel\n\n")
+	fmt.Fprintf(w, "function __chisel_slices\n")
+	fmt.Fprintf(w, "\tset -l release\n")
+	fmt.Fprintf(w, "\tset -l tokens (commandline -opc)\n")
+	fmt.Fprintf(w, "\tfor i in (seq (count $tokens))\n")
+	fmt.Fprintf(w, "\t\tif test \"$tokens[$i]\" = --release; and test $i -lt (count $tokens)\n")
+	fmt.Fprintf(w, "\t\t\tset release --release $tokens[(math $i + 1)]\n")
+	fmt.Fprintf(w, "\t\telse if string match -q -- '--release=*' $tokens[$i]\n")
+	fmt.Fprintf(w, "\t\t\tset release $tokens[$i]\n")
+	fmt.Fprintf(w, "\t\tend\n")
+	fmt.Fprintf(w, "\tend\n")
+	fmt.Fprintf(w, "\tchisel debug complete-slices $release $argv -- (commandline -ct) 2>/dev/null\n")
+	fmt.Fprintf(w, "end\n\n")
+	fmt.Fprintf(w, "complete -c chisel -f\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "complete -c chisel -n __fish_use_subcommand -a %s -d %s\n", command.name, shellQuote(command.desc))
+	}
+	for _, opt := range global {
+		writeFishOption(w, "__fish_use_subcommand", opt)
+	}
+	for _, command := range commands {
+		if command.packages {
+			fmt.Fprintf(w, "complete -c chisel -n '__fish_seen_subcommand_from %s' -a '(__chisel_slices --packages)'\n", command.name)
+		} else if command.slices {
+			fmt.Fprintf(w, "complete -c chisel -n '__fish_seen_subcommand_from %s' -a '(__chisel_slices)'\n", command.name)
+		}
+		for _, opt := range command.options {
+			writeFishOption(w, "'__fish_seen_subcommand_from "+command.name+"'", opt)
+		}
+	}
+}
+
+func writeFishOption(w io.Writer, condition string, opt completionOption) {
+	line := fmt.Sprintf("complete -c chisel -n %s -l %s", condition, opt.name)
+	if len(opt.choices) > 0 {
+		line += " -x -a " + shellQuote(strings.Join(opt.choices, " "))
+	} else if opt.hasValue {
+		line += " -r -F"
+	}
+	fmt.Fprintf(w, "%s -d %s\n", line, shellQuote(opt.desc))
+}
+
+// shellQuote quotes s in single quotes so that it is taken literally by the
+// shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshEscape escapes the characters with special meaning inside the
+// description of an _arguments option spec.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`[`, `\[`, `]`, `\]`).Replace(s)
+}
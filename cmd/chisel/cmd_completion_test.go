@@ -0,0 +1,74 @@
+package main_test
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var completionTests = []struct {
+	summary string
+	shell   string
+	lines   []string
+}{{
+	summary: "Bash",
+	shell:   "bash",
+	lines: []string{
+		"\t\tCOMPREPLY=($(compgen -W \"completion cut extract find help info list list-slices sbom validate verify version\" -- \"$cur\"))",
+		"\t\topts=\"--release --packages --format\"",
+		"\t\t\tCOMPREPLY=($(compgen -W \"text json\" -- \"$cur\"))",
+		"\t\t_chisel_slices \"$cur\"",
+		"\t\t\tCOMPREPLY=($(compgen -W \"--log-format --log-level\" -- \"$cur\"))",
+		"\t\tpackages=1",
+		"complete -o default -F _chisel chisel",
+	},
+}, {
+	summary: "Zsh",
+	shell:   "zsh",
+	lines: []string{
+		"#compdef chisel",
+		"\t\t'cut:Cut a tree with selected slices'",
+		"\t\t\t'*--inline-slice=[Ad-hoc slice with the given paths (e.g. mypkg/tmp:/usr/bin/foo,/etc/bar)]:inline-slice:_files' \\",
+		"\t\t\t'--dedup-empty-dirs[Remove directories left empty after mutation]' \\",
+		"\t\t\t'*:slice:_chisel_slices'",
+		"\t\t\t'*:slice:_chisel_slices --packages'",
+		"\t\t\tcompadd -- --log-format --log-level",
+		"\t\t\t'--format=[Output format: text or json]:format:(text json)' \\",
+	},
+}, {
+	summary: "Fish",
+	shell:   "fish",
+	lines: []string{
+		"complete -c chisel -n __fish_use_subcommand -a find -d 'Find existing slices'",
+		"complete -c chisel -n '__fish_seen_subcommand_from cut' -a '(__chisel_slices)'",
+		"complete -c chisel -n '__fish_seen_subcommand_from info' -a '(__chisel_slices --packages)'",
+		"complete -c chisel -n __fish_use_subcommand -l log-format -x -a 'text json' -d 'Format of the log messages (text or json)'",
+		"complete -c chisel -n '__fish_seen_subcommand_from cut' -l release -r -F -d 'Chisel release name or directory (e.g. ubuntu-22.04)'",
+		"complete -c chisel -n '__fish_seen_subcommand_from validate' -l format -x -a 'text json' -d 'Output format: text or json'",
+	},
+}}
+
+func (s *ChiselSuite) TestCompletionCommand(c *C) {
+	for _, test := range completionTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		_, err := chisel.Parser().ParseArgs([]string{"completion", test.shell})
+		c.Assert(err, IsNil)
+		lines := strings.Split(s.Stdout(), "\n")
+		for _, line := range test.lines {
+			c.Assert(lines, testutil.Contains, line)
+		}
+		// Hidden commands are not offered.
+		c.Assert(s.Stdout(), Not(Matches), "(?s).*Run debug commands.*")
+	}
+}
+
+func (s *ChiselSuite) TestCompletionCommandUnknownShell(c *C) {
+	_, err := chisel.Parser().ParseArgs([]string{"completion", "tcsh"})
+	c.Assert(err, ErrorMatches, `unsupported shell "tcsh", expected bash, zsh or fish`)
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/jessevdk/go-flags"
 
 	"github.com/canonical/chisel/internal/archive"
-	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 var shortCutHelp = "Cut a tree with selected slices"
@@ -16,24 +29,226 @@ to create a new filesystem tree in the root location.
 
 By default it fetches the slices for the same Ubuntu version as the
 current host, unless the --release flag is used.
+
+The packages are for the architecture of the host unless --arch is used.
+When --arch is given more than once, the content for every architecture is
+cut into a subdirectory of the root named after it, as in root/amd64 and
+root/arm64, reading the release only once.
+
+The --inline-slice option adds an ad-hoc slice to the selection, made of
+the listed paths of the package, without the need for a slice definition
+file. For example, "mypkg/tmp:/usr/bin/foo,/etc/bar" selects a slice named
+mypkg_tmp with the paths /usr/bin/foo and /etc/bar.
+
+The --select-from-manifest option selects exactly the slices recorded in
+the given Chisel manifest of a previous cut, such as
+root/var/lib/chisel/manifest.wall, instead of the slices listed in the
+command line. All of them must still be defined in the release.
+
+The --verify-debs option verifies the debsig origin signature embedded in
+every fetched package against the public keys of its archive, and fails on
+packages without such signature unless --allow-unsigned is also used.
+
+The --fail-on-unsigned-package option fails unless every package matches
+the SHA256 digest listed for it in the signed package index, completing the
+chain of trust from the archive InRelease file to the package content. The
+check is done even for packages found in the local cache.
+
+Fetched packages are cached by their SHA256 digest, so that later runs use
+them without going through the network. The cache is kept under the user
+cache directory unless another one is given with --cache-dir. Cached
+packages which no longer match their digest are fetched again.
+
+The --max-download-rate option limits the rate in bytes per second at which
+data is downloaded from each archive, so that a cut does not saturate a
+shared link. There is no limit by default.
+
+The --strip-docs option drops the content under /usr/share/man,
+/usr/share/doc and /usr/share/info which is only selected through
+wildcards, along with the directories left empty. Copyright files and the
+paths listed explicitly in slices are kept.
+
+The --no-copyright option drops as well the copyright files under
+/usr/share/doc which are only selected through wildcards, for images
+shipping the license information separately. The --changelogs option
+extracts the changelog and NEWS files from /usr/share/doc/<package>/ of
+every selected package, recording them as part of its first selected slice.
+
+The --deterministic option makes the output only depend on the release
+and on the packages. The modification time of all the created content is
+set to the value of the SOURCE_DATE_EPOCH environment variable, or to the
+Unix epoch if it is unset. File permissions never depend on the umask, and
+manifests are always written in a canonical order with no timestamps.
+
+The --epoch option sets the modification time of all the created content,
+including the generated files, to the given number of seconds since the
+Unix epoch. It takes precedence over SOURCE_DATE_EPOCH, and does not change
+the manifests, which record no timestamps.
+
+The --progress-bar option shows the progress of fetching and extracting
+the packages when the output is a terminal.
+
+The --check-case option fails when two of the resulting paths differ only
+in case, since they would overwrite each other on case-insensitive
+filesystems.
+
+The --archive-priority option overrides the priority of an archive for
+this run, as if it was set in chisel.yaml. For example, "staging=30" makes
+the staging archive preferred over those with lower priorities. Packages
+which pin an archive in their slice definition file keep using it
+regardless of priorities.
+
+The --archive-url option fetches the content of an archive from the given
+URL instead of the default one for it. With a file:// URL, as in
+"ubuntu=file:///srv/mirror/ubuntu", the archive is read from a local
+mirror with the usual dists and pool directories, so that no network is
+needed. The InRelease file is still verified against the archive keys.
+
+The --format-override option parses the release as if the format field in
+its chisel.yaml file had the given value. It is meant for checking that a
+release still works under the rules of another format before migrating it.
+
+The --strip-binaries option removes the debug sections from the ELF
+executables and shared libraries in the output, which requires the strip
+tool to be installed on the host. Stripping is lossy: the resulting files
+differ from the packaged ones, and the manifests record their new hashes.
+The binaries are copied while being stripped under the directory given
+with --tmp-dir, or under the system temporary directory.
+
+The --normalize-perms option sets all the directories in the output to
+mode 0755, and all the files to 0755 or 0644 depending on whether they
+were executable by their owner. The setuid, setgid and sticky bits are
+kept, and the manifests record the resulting modes.
+
+Paths with a uid or gid in their slice definition are given that owner,
+which usually requires running as root. The --ignore-owners option only
+logs the paths whose owner cannot be set, instead of failing, and those
+owners are then left out of the manifests.
+
+The --preserve-xattrs option keeps the extended attributes stored in the
+packages, such as the security.capability of binaries like ping, and
+records them in the manifests. Setting some of them requires privileges.
+They are also recorded in the tarball written with --output, as PAX records.
+
+The --prefers option reads a YAML file mapping paths to the package which
+provides them, for the paths listed in the slices of several packages, as
+in "/usr/bin/foo: mypkg". The path is then dropped from the slices of the
+other packages, which resolves their conflict without changing the release.
+With --interactive, the conflicts found on paths are resolved by asking
+which package provides each path, proceeding once they are all resolved, and
+the answers are saved to the --prefers file when one is given.
+
+The --manifest-schema option writes the manifests with the given schema
+version instead of the current one, so that they remain readable by older
+tools. Only the schema versions known to this chisel are accepted.
+
+The --output option writes the content as a tarball to the given file
+instead of into a root directory, or to the standard output when the file
+is "-". The tarball keeps the modes, symlinks and hard links of the content,
+and includes the manifests at their paths. The owners of the content are
+only kept when running as root, and all of it is recorded as owned by root
+otherwise. The content is first cut into a temporary directory, as below.
+
+The tarball is compressed according to the --compression option, or
+otherwise according to the extension of the file, with gzip for .tar.gz
+and .tgz and zstd for .tar.zst. The --oci-layer option always compresses
+it with gzip, as a tar+gzip OCI image layer, and shows its digest, diff_id
+and size for referencing it from an image manifest and configuration.
+
+The --dry-run option selects the slices and resolves the archive of every
+package as usual, and then shows the packages with their versions and
+archives, the paths listed by each selected slice, and the paths whose
+conflicts are resolved by --prefers, without fetching any package or
+writing anything. No root is needed in that case.
+
+When the root is given as ssh://[user@]host[:port]/path, the content is
+cut into a temporary local directory and then transferred to the remote
+directory with rsync over SSH, along with the extended attributes when
+--preserve-xattrs is used. The temporary directory is created under
+the one given with --tmp-dir, or under the system temporary directory when
+that option is not used.
 `
 
 var cutDescs = map[string]string{
-	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
-	"root":    "Root for generated content",
-	"arch":    "Package architecture",
+	"release":                  "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"root":                     "Root for generated content, or ssh://[user@]host[:port]/path",
+	"output":                   "Write the generated content as a tarball to the file, or - for stdout",
+	"compression":              "Compression of the --output tarball (none, gzip, zstd)",
+	"oci-layer":                "Write the --output tarball as an OCI layer and show its digests",
+	"dry-run":                  "Show the packages and paths which would be cut, without cutting them",
+	"arch":                     "Package architecture (defaults to the host one, may be repeated)",
+	"max-download-size":        "Maximum total size in bytes of the packages to fetch",
+	"max-download-rate":        "Maximum download rate in bytes per second for each archive",
+	"select-from-manifest":     "Select the slices recorded in the Chisel manifest file",
+	"inline-slice":             "Ad-hoc slice with the given paths (e.g. mypkg/tmp:/usr/bin/foo,/etc/bar)",
+	"dedup-empty-dirs":         "Remove directories left empty after mutation",
+	"verify-debs":              "Verify the origin signature embedded in packages",
+	"allow-unsigned":           "Accept packages without origin signature with --verify-debs",
+	"fail-on-unsigned-package": "Fail unless packages match the digest in the signed index",
+	"tmp-dir":                  "Directory for temporary content (defaults to the system one)",
+	"deterministic":            "Produce reproducible content (see the command help)",
+	"progress-bar":             "Show the progress when the output is a terminal",
+	"check-case":               "Fail on paths differing only in case",
+	"strip-docs":               "Drop documentation not listed explicitly in slices",
+	"no-copyright":             "Drop copyright files not listed explicitly in slices",
+	"changelogs":               "Extract the changelog and NEWS files of the selected packages",
+	"format-override":          "Parse the release as if it had the given format",
+	"archive-priority":         "Override the priority of an archive (e.g. staging=30)",
+	"archive-url":              "Fetch an archive from another URL (e.g. ubuntu=file:///srv/mirror)",
+	"manifest-schema":          "Schema version of the generated manifests",
+	"strip-binaries":           "Remove debug sections from ELF binaries (lossy)",
+	"normalize-perms":          "Use uniform permissions for directories and files",
+	"ignore-owners":            "Do not fail when path owners in slices cannot be set",
+	"preserve-xattrs":          "Keep the extended attributes of packaged files, such as capabilities",
+	"prefers":                  "YAML file mapping paths to the package providing them",
+	"interactive":              "Ask which package provides each conflicting path",
+	"cache-dir":                "Directory for caching fetched packages across runs",
+	"epoch":                    "Modification time of the created content, in seconds since the Unix epoch",
 }
 
 type cmdCut struct {
-	Release string `long:"release" value-name:"<dir>"`
-	RootDir string `long:"root" value-name:"<dir>" required:"yes"`
-	Arch    string `long:"arch" value-name:"<arch>"`
+	Release           string   `long:"release" value-name:"<dir>"`
+	RootDir           string   `long:"root" value-name:"<dir|url>"`
+	Output            string   `long:"output" value-name:"<file>"`
+	Compression       string   `long:"compression" value-name:"<type>" choice:"none" choice:"gzip" choice:"zstd"`
+	OCILayer          bool     `long:"oci-layer"`
+	DryRun            bool     `long:"dry-run"`
+	Archs             []string `long:"arch" value-name:"<arch>"`
+	MaxDownloadSize   int64    `long:"max-download-size" value-name:"<bytes>"`
+	MaxDownloadRate   int64    `long:"max-download-rate" value-name:"<bytes>"`
+	InlineSlices      []string `long:"inline-slice" value-name:"<pkg/slice:paths>"`
+	FromManifest      string   `long:"select-from-manifest" value-name:"<file>"`
+	DedupEmptyDirs    bool     `long:"dedup-empty-dirs"`
+	VerifyDebs        bool     `long:"verify-debs"`
+	AllowUnsigned     bool     `long:"allow-unsigned"`
+	FailOnUnsigned    bool     `long:"fail-on-unsigned-package"`
+	TmpDir            string   `long:"tmp-dir" value-name:"<dir>"`
+	Deterministic     bool     `long:"deterministic"`
+	StripDocs         bool     `long:"strip-docs"`
+	NoCopyright       bool     `long:"no-copyright"`
+	Changelogs        bool     `long:"changelogs"`
+	CheckCase         bool     `long:"check-case"`
+	ProgressBar       bool     `long:"progress-bar"`
+	FormatOverride    string   `long:"format-override" value-name:"<format>"`
+	ArchivePriorities []string `long:"archive-priority" value-name:"<archive=priority>"`
+	ArchiveURLs       []string `long:"archive-url" value-name:"<archive=url>"`
+	ManifestSchema    string   `long:"manifest-schema" value-name:"<version>"`
+	StripBinaries     bool     `long:"strip-binaries"`
+	NormalizePerms    bool     `long:"normalize-perms"`
+	IgnoreOwners      bool     `long:"ignore-owners"`
+	PreserveXattrs    bool     `long:"preserve-xattrs"`
+	Prefers           string   `long:"prefers" value-name:"<file>"`
+	Interactive       bool     `long:"interactive"`
+	CacheDir          string   `long:"cache-dir" value-name:"<dir>"`
+	Epoch             string   `long:"epoch" value-name:"<seconds>"`
 
 	Positional struct {
-		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+		SliceRefs []string `positional-arg-name:"<slice names>"`
 	} `positional-args:"yes"`
 }
 
+var archiveOpen = archive.Open
+
 func init() {
 	addCommand("cut", shortCutHelp, longCutHelp, func() flags.Commander { return &cmdCut{} }, cutDescs, nil)
 }
@@ -42,6 +257,80 @@ func (cmd *cmdCut) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
 	}
+	if cmd.RootDir == "" && cmd.Output == "" && !cmd.DryRun {
+		return fmt.Errorf("the required flag `--root' or `--output' was not specified")
+	}
+	if cmd.RootDir != "" && cmd.Output != "" {
+		return fmt.Errorf("cannot use --root with --output")
+	}
+	if cmd.Compression != "" && cmd.Output == "" {
+		return fmt.Errorf("--compression requires --output")
+	}
+	compression, err := tarballCompression(cmd.Output, cmd.Compression)
+	if err != nil {
+		return err
+	}
+	if cmd.OCILayer {
+		if cmd.Output == "" || cmd.Output == "-" {
+			return fmt.Errorf("--oci-layer requires --output with a file")
+		}
+		if cmd.Compression != "" && cmd.Compression != "gzip" {
+			return fmt.Errorf("--oci-layer requires gzip compression")
+		}
+		compression = "gzip"
+	}
+	if cmd.MaxDownloadSize < 0 {
+		return fmt.Errorf("invalid --max-download-size: %d", cmd.MaxDownloadSize)
+	}
+	if cmd.MaxDownloadRate < 0 {
+		return fmt.Errorf("invalid --max-download-rate: %d", cmd.MaxDownloadRate)
+	}
+	if cmd.TmpDir != "" {
+		info, err := os.Stat(cmd.TmpDir)
+		if err != nil {
+			return fmt.Errorf("invalid --tmp-dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid --tmp-dir: %s is not a directory", cmd.TmpDir)
+		}
+	}
+	if cmd.AllowUnsigned && !cmd.VerifyDebs {
+		return fmt.Errorf("--allow-unsigned requires --verify-debs")
+	}
+	if cmd.Interactive && !isStdinTTY {
+		return fmt.Errorf("--interactive requires a terminal")
+	}
+	if cmd.ManifestSchema != "" && !slices.Contains(manifest.SupportedSchemas, cmd.ManifestSchema) {
+		return fmt.Errorf("invalid --manifest-schema: unsupported schema version %q (supported: %s)",
+			cmd.ManifestSchema, strings.Join(manifest.SupportedSchemas, ", "))
+	}
+	if cmd.FromManifest != "" {
+		if len(cmd.Positional.SliceRefs) > 0 || len(cmd.InlineSlices) > 0 {
+			return fmt.Errorf("cannot use --select-from-manifest with other slices")
+		}
+	} else if len(cmd.Positional.SliceRefs) == 0 && len(cmd.InlineSlices) == 0 {
+		return fmt.Errorf("the required argument `<slice names>` was not provided")
+	}
+	archs, err := cutArchs(cmd.Archs)
+	if err != nil {
+		return err
+	}
+	if len(archs) > 1 {
+		if cmd.Output != "" {
+			return fmt.Errorf("cannot use --output with more than one --arch")
+		}
+		if cmd.DryRun {
+			return fmt.Errorf("cannot use --dry-run with more than one --arch")
+		}
+	}
+	modTime, err := cutModTime(cmd.Epoch, cmd.Deterministic)
+	if err != nil {
+		return err
+	}
+	remote, err := parseRemoteRoot(cmd.RootDir)
+	if err != nil {
+		return err
+	}
 
 	sliceKeys := make([]setup.SliceKey, len(cmd.Positional.SliceRefs))
 	for i, sliceRef := range cmd.Positional.SliceRefs {
@@ -52,42 +341,416 @@ func (cmd *cmdCut) Execute(args []string) error {
 		sliceKeys[i] = sliceKey
 	}
 
-	release, err := obtainRelease(cmd.Release)
+	release, prefer, err := cmd.obtainRelease()
 	if err != nil {
 		return err
 	}
 
-	selection, err := setup.Select(release, sliceKeys)
+	if cmd.FromManifest != "" {
+		sliceKeys, err = manifestSliceKeys(release, cmd.FromManifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	source, err := newArchiveSource(release, cmd.ArchivePriorities, cmd.ArchiveURLs, cmd.CacheDir)
 	if err != nil {
 		return err
 	}
 
-	archives := make(map[string]archive.Archive)
-	for archiveName, archiveInfo := range release.Archives {
-		openArchive, err := archive.Open(&archive.Options{
-			Label:      archiveName,
-			Version:    archiveInfo.Version,
-			Arch:       cmd.Arch,
-			Suites:     archiveInfo.Suites,
-			Components: archiveInfo.Components,
-			Pro:        archiveInfo.Pro,
-			CacheDir:   cache.DefaultDir("chisel"),
-			PubKeys:    archiveInfo.PubKeys,
-		})
+	for _, spec := range cmd.InlineSlices {
+		sliceKey, err := addInlineSlice(release, spec)
+		if err != nil {
+			return err
+		}
+		sliceKeys = append(sliceKeys, sliceKey)
+	}
+
+	var cuts []*archCut
+	for _, arch := range archs {
+		cut, err := cmd.prepareCut(release, sliceKeys, arch, source)
 		if err != nil {
-			if err == archive.ErrCredentialsNotFound {
-				logf("Archive %q ignored: credentials not found", archiveName)
-				continue
+			return err
+		}
+		cuts = append(cuts, cut)
+	}
+
+	if cmd.DryRun {
+		return printCutPlan(Stdout, cuts[0].selection, cuts[0].archives, prefer)
+	}
+
+	targetDir := cmd.RootDir
+	if remote != nil || cmd.Output != "" {
+		// The content is cut into a local directory and transferred or
+		// archived once complete, so the remote root is never left half
+		// populated.
+		targetDir, err = os.MkdirTemp(cmd.TmpDir, "chisel-root-")
+		if err != nil {
+			return fmt.Errorf("cannot create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(targetDir)
+	}
+
+	var progress func(event *slicer.ProgressEvent)
+	if cmd.ProgressBar && isStdoutTTY && cmd.Output != "-" {
+		progress = func(event *slicer.ProgressEvent) {
+			renderProgress(Stdout, event)
+		}
+	}
+
+	for _, cut := range cuts {
+		archDir := targetDir
+		if len(cuts) > 1 {
+			// Each architecture has its own tree under the root.
+			archDir = filepath.Join(targetDir, cut.arch)
+			err = os.MkdirAll(archDir, 0755)
+			if err != nil {
+				return err
 			}
+		}
+		err = slicer.Run(&slicer.RunOptions{
+			Selection:       cut.selection,
+			Archives:        cut.archives,
+			TargetDir:       archDir,
+			MaxDownloadSize: cmd.MaxDownloadSize,
+			PruneEmptyDirs:  cmd.DedupEmptyDirs,
+			StripDocs:       cmd.StripDocs,
+			SkipCopyright:   cmd.NoCopyright,
+			Changelogs:      cmd.Changelogs,
+			CheckCase:       cmd.CheckCase,
+			StripBinaries:   cmd.StripBinaries,
+			TmpDir:          cmd.TmpDir,
+			NormalizePerms:  cmd.NormalizePerms,
+			IgnoreOwners:    cmd.IgnoreOwners,
+			PreserveXattrs:  cmd.PreserveXattrs,
+			ManifestSchema:  cmd.ManifestSchema,
+			Progress:        progress,
+			ModTime:         modTime,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if remote != nil {
+		return remote.sync(targetDir, cmd.PreserveXattrs)
+	}
+	if cmd.Output != "" {
+		digests, err := writeTarballFile(cmd.Output, compression, targetDir, cmd.PreserveXattrs)
+		if err != nil {
 			return err
 		}
-		archives[archiveName] = openArchive
+		if cmd.OCILayer {
+			fmt.Fprintf(Stdout, "digest: %s\n", digests.Digest)
+			fmt.Fprintf(Stdout, "diff_id: %s\n", digests.DiffID)
+			fmt.Fprintf(Stdout, "size: %d\n", digests.Size)
+		}
 	}
+	return nil
+}
 
-	err = slicer.Run(&slicer.RunOptions{
-		Selection: selection,
-		Archives:  archives,
-		TargetDir: cmd.RootDir,
+// archCut holds what is needed to cut the selection for one architecture.
+type archCut struct {
+	arch      string
+	selection *setup.Selection
+	archives  map[string]archive.Archive
+}
+
+// prepareCut selects the slices of sliceKeys for arch and opens the release
+// archives for it from source, without fetching any package.
+func (cmd *cmdCut) prepareCut(release *setup.Release, sliceKeys []setup.SliceKey, arch string, source *archiveSource) (*archCut, error) {
+	selection, err := setup.SelectWithOptions(release, sliceKeys, &setup.SelectOptions{Arch: arch})
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make(map[string]archive.Archive)
+	for archiveName := range release.Archives {
+		options := source.options(release, archiveName, arch)
+		options.VerifyDebSignatures = cmd.VerifyDebs
+		options.AllowUnsignedDebs = cmd.AllowUnsigned
+		options.RequireDebDigests = cmd.FailOnUnsigned
+		options.MaxBytesPerSecond = cmd.MaxDownloadRate
+		opened, err := openArchive(options)
+		if err != nil {
+			return nil, err
+		}
+		if opened != nil {
+			archives[archiveName] = opened
+		}
+	}
+	for _, slice := range selection.Slices {
+		pinned := release.Packages[slice.Package].Archive
+		if _, ok := archives[pinned]; pinned != "" && !ok {
+			return nil, fmt.Errorf("cannot fetch package %q: pinned archive %q was ignored", slice.Package, pinned)
+		}
+	}
+
+	for _, spec := range cmd.InlineSlices {
+		pkgName, _, _ := strings.Cut(spec, "/")
+		found := false
+		for _, pkgArchive := range archives {
+			if pkgArchive.Exists(pkgName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid inline slice %q: cannot find package %q in archives", spec, pkgName)
+		}
+	}
+	return &archCut{arch: arch, selection: selection, archives: archives}, nil
+}
+
+// cutArchs returns the package architectures given with --arch, or the one
+// of the host if none is given.
+func cutArchs(archs []string) ([]string, error) {
+	if len(archs) == 0 {
+		arch, err := deb.InferArch()
+		if err != nil {
+			return nil, err
+		}
+		return []string{arch}, nil
+	}
+	seen := make(map[string]bool)
+	for _, arch := range archs {
+		err := deb.ValidateArch(arch)
+		if err != nil {
+			return nil, err
+		}
+		if seen[arch] {
+			return nil, fmt.Errorf("duplicate --arch: %s", arch)
+		}
+		seen[arch] = true
+	}
+	return archs, nil
+}
+
+// obtainRelease reads the release with the preferences in the --prefers
+// file, and returns it along with the preferences. With --interactive, the
+// conflicts on paths are resolved by asking which package provides them, and
+// the answers are saved to the file, which does not need to exist
+// beforehand.
+func (cmd *cmdCut) obtainRelease() (*setup.Release, map[string]string, error) {
+	prefer := make(map[string]string)
+	if cmd.Prefers != "" {
+		var err error
+		prefer, err = readPrefers(cmd.Prefers)
+		if cmd.Interactive && errors.Is(err, os.ErrNotExist) {
+			prefer, err = make(map[string]string), nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	options := &setup.ReadOptions{
+		FormatOverride: cmd.FormatOverride,
+		Prefer:         prefer,
+	}
+	var input *bufio.Reader
+	asked := false
+	for {
+		release, err := obtainReleaseWithOptions(cmd.Release, options)
+		var conflictErr *setup.ConflictError
+		if !cmd.Interactive || !errors.As(err, &conflictErr) {
+			if err == nil && asked && cmd.Prefers != "" {
+				err = writePrefers(cmd.Prefers, prefer)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			return release, prefer, nil
+		}
+		if input == nil {
+			input = bufio.NewReader(Stdin)
+		}
+		resolved, err := askPreferences(conflictErr, prefer, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resolved == 0 {
+			return nil, nil, conflictErr
+		}
+		asked = true
+	}
+}
+
+// manifestSliceKeys returns the keys of the slices recorded in the manifest
+// at path, which must all be defined in release.
+func manifestSliceKeys(release *setup.Release, path string) ([]setup.SliceKey, error) {
+	mfest, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	var sliceKeys []setup.SliceKey
+	err = mfest.IterateSlices("", func(slice *manifest.Slice) error {
+		sliceKey, err := setup.ParseSliceKey(slice.Name)
+		if err != nil {
+			return fmt.Errorf("invalid slice in manifest: %w", err)
+		}
+		pkg, ok := release.Packages[sliceKey.Package]
+		if !ok || pkg.Slices[sliceKey.Slice] == nil {
+			return fmt.Errorf("slice %s from manifest not found in release", sliceKey)
+		}
+		sliceKeys = append(sliceKeys, sliceKey)
+		return nil
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	if len(sliceKeys) == 0 {
+		return nil, fmt.Errorf("manifest %s records no slices", path)
+	}
+	return sliceKeys, nil
+}
+
+// sourceDateEpoch returns the time set in the SOURCE_DATE_EPOCH environment
+// variable, or the Unix epoch if it is unset.
+func sourceDateEpoch() (time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Unix(0, 0), nil
+	}
+	t, ok := parseEpoch(value)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %q", value)
+	}
+	return t, nil
+}
+
+// cutModTime returns the modification time to set on the cut content, which
+// is the one given with --epoch or, with --deterministic, the one from
+// sourceDateEpoch. The zero time is returned if neither option is used.
+func cutModTime(epoch string, deterministic bool) (time.Time, error) {
+	if epoch != "" {
+		t, ok := parseEpoch(epoch)
+		if !ok {
+			return time.Time{}, fmt.Errorf("invalid --epoch: %q", epoch)
+		}
+		return t, nil
+	}
+	if deterministic {
+		return sourceDateEpoch()
+	}
+	return time.Time{}, nil
+}
+
+// parseEpoch parses a non-negative number of seconds since the Unix epoch.
+func parseEpoch(value string) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// parseArchiveURLs returns the URLs of the release archives given in specs
+// of the form <archive>=<url>, indexed by archive name.
+func parseArchiveURLs(release *setup.Release, specs []string) (map[string]string, error) {
+	urls := make(map[string]string)
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid archive URL %q: expected <archive>=<url>", spec)
+		}
+		if _, ok := release.Archives[name]; !ok {
+			return nil, fmt.Errorf("invalid archive URL %q: undefined archive %q", spec, name)
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			return nil, fmt.Errorf("invalid archive URL %q: expected http, https or file URL", spec)
+		}
+		if u.Scheme == "file" && !path.IsAbs(u.Path) {
+			return nil, fmt.Errorf("invalid archive URL %q: file URL must have an absolute path", spec)
+		}
+		urls[name] = value
+	}
+	return urls, nil
+}
+
+// setArchivePriorities overrides the priorities of the release archives
+// according to specs, each in the form "<archive>=<priority>". The resulting
+// priorities must still be valid and distinct.
+func setArchivePriorities(release *setup.Release, specs []string) error {
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return fmt.Errorf("invalid archive priority %q: expected <archive>=<priority>", spec)
+		}
+		archive, ok := release.Archives[name]
+		if !ok {
+			return fmt.Errorf("invalid archive priority %q: undefined archive %q", spec, name)
+		}
+		priority, err := strconv.Atoi(value)
+		if err != nil || priority > setup.MaxArchivePriority || priority < setup.MinArchivePriority || priority == 0 {
+			return fmt.Errorf("invalid archive priority %q: priority must be a non-zero integer between %d and %d",
+				spec, setup.MinArchivePriority, setup.MaxArchivePriority)
+		}
+		archive.Priority = priority
+	}
+	if len(specs) == 0 || release.AllowEqualPriorities {
+		return nil
+	}
+	priorities := make(map[int]string)
+	for name, archive := range release.Archives {
+		if old, ok := priorities[archive.Priority]; ok {
+			if old > name {
+				old, name = name, old
+			}
+			return fmt.Errorf("cannot override archive priorities: archives %q and %q have the same priority value of %d", old, name, archive.Priority)
+		}
+		priorities[archive.Priority] = name
+	}
+	return nil
+}
+
+// addInlineSlice adds to release the ad-hoc slice described by spec, which has
+// the form "<package>/<slice>:<path>[,<path>...]", and returns its key. The
+// paths are extracted from the package as they would be with an empty entry
+// under contents in a slice definition file. The release is validated again
+// so that the slice cannot conflict with those of other packages.
+func addInlineSlice(release *setup.Release, spec string) (setup.SliceKey, error) {
+	ref, paths, ok := strings.Cut(spec, ":")
+	pkgName, sliceName, ok2 := strings.Cut(ref, "/")
+	if !ok || !ok2 || paths == "" {
+		return setup.SliceKey{}, fmt.Errorf("invalid inline slice %q: expected <pkg/slice:paths>", spec)
+	}
+	sliceKey, err := setup.ParseSliceKey(pkgName + "_" + sliceName)
+	if err != nil {
+		return setup.SliceKey{}, fmt.Errorf("invalid inline slice %q: invalid slice reference %q", spec, ref)
+	}
+
+	pkg, ok := release.Packages[pkgName]
+	if !ok {
+		pkg = &setup.Package{
+			Name:   pkgName,
+			Slices: make(map[string]*setup.Slice),
+		}
+		release.Packages[pkgName] = pkg
+	}
+	if _, ok := pkg.Slices[sliceName]; ok {
+		return setup.SliceKey{}, fmt.Errorf("invalid inline slice %q: slice %s already exists", spec, sliceKey)
+	}
+
+	slice := &setup.Slice{
+		Package:  pkgName,
+		Name:     sliceName,
+		Contents: make(map[string]setup.PathInfo),
+	}
+	for _, contPath := range strings.Split(paths, ",") {
+		comparePath := strings.TrimSuffix(contPath, "/")
+		if !path.IsAbs(contPath) || path.Clean(contPath) != comparePath {
+			return setup.SliceKey{}, fmt.Errorf("invalid inline slice %q: invalid content path: %q", spec, contPath)
+		}
+		kind := setup.CopyPath
+		if strings.ContainsAny(contPath, "*?") {
+			kind = setup.GlobPath
+		}
+		slice.Contents[contPath] = setup.PathInfo{Kind: kind}
+	}
+	pkg.Slices[sliceName] = slice
+	err = release.Validate()
+	if err != nil {
+		return setup.SliceKey{}, fmt.Errorf("invalid inline slice %q: %w", spec, err)
+	}
+	return sliceKey, nil
 }
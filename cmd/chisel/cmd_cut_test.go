@@ -0,0 +1,735 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var inlineSliceTests = []struct {
+	summary  string
+	spec     string
+	key      setup.SliceKey
+	contents map[string]setup.PathInfo
+	err      string
+}{{
+	summary: "Paths of a package in the release",
+	spec:    "mypkg1/tmp:/usr/bin/foo,/etc/bar/",
+	key:     setup.SliceKey{Package: "mypkg1", Slice: "tmp"},
+	contents: map[string]setup.PathInfo{
+		"/usr/bin/foo": {Kind: setup.CopyPath},
+		"/etc/bar/":    {Kind: setup.CopyPath},
+	},
+}, {
+	summary: "Glob paths",
+	spec:    "mypkg1/tmp:/usr/lib/*.so*",
+	key:     setup.SliceKey{Package: "mypkg1", Slice: "tmp"},
+	contents: map[string]setup.PathInfo{
+		"/usr/lib/*.so*": {Kind: setup.GlobPath},
+	},
+}, {
+	summary: "Package without slice definitions",
+	spec:    "otherpkg/tmp:/usr/bin/foo",
+	key:     setup.SliceKey{Package: "otherpkg", Slice: "tmp"},
+	contents: map[string]setup.PathInfo{
+		"/usr/bin/foo": {Kind: setup.CopyPath},
+	},
+}, {
+	summary: "Missing paths",
+	spec:    "mypkg1/tmp",
+	err:     `invalid inline slice "mypkg1/tmp": expected <pkg/slice:paths>`,
+}, {
+	summary: "Missing slice name",
+	spec:    "mypkg1:/usr/bin/foo",
+	err:     `invalid inline slice "mypkg1:/usr/bin/foo": expected <pkg/slice:paths>`,
+}, {
+	summary: "Invalid slice name",
+	spec:    "mypkg1/x:/usr/bin/foo",
+	err:     `invalid inline slice "mypkg1/x:/usr/bin/foo": invalid slice reference "mypkg1/x"`,
+}, {
+	summary: "Existing slice",
+	spec:    "mypkg1/myslice1:/usr/bin/foo",
+	err:     `invalid inline slice "mypkg1/myslice1:/usr/bin/foo": slice mypkg1_myslice1 already exists`,
+}, {
+	summary: "Relative path",
+	spec:    "mypkg1/tmp:usr/bin/foo",
+	err:     `invalid inline slice "mypkg1/tmp:usr/bin/foo": invalid content path: "usr/bin/foo"`,
+}, {
+	summary: "Unclean path",
+	spec:    "mypkg1/tmp:/usr/bin/foo,/usr/../etc",
+	err:     `invalid inline slice "mypkg1/tmp:/usr/bin/foo,/usr/../etc": invalid content path: "/usr/../etc"`,
+}, {
+	summary: "Glob conflicting with a path of another package",
+	spec:    "otherpkg/tmp:/dir/*",
+	err:     `invalid inline slice "otherpkg/tmp:/dir/\*": slices mypkg1_myslice1 and otherpkg_tmp conflict on /dir/file and /dir/\*`,
+}, {
+	summary: "Path conflicting with a path of another package",
+	spec:    "otherpkg/tmp:/dir/file",
+	err:     `invalid inline slice "otherpkg/tmp:/dir/file": slices mypkg1_myslice1 and otherpkg_tmp conflict on /dir/file`,
+}}
+
+func (s *ChiselSuite) TestAddInlineSlice(c *C) {
+	for _, test := range inlineSliceTests {
+		c.Logf("Summary: %s", test.summary)
+
+		dir := c.MkDir()
+		for path, data := range infoRelease {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		release, err := setup.ReadRelease(dir)
+		c.Assert(err, IsNil)
+
+		key, err := chisel.AddInlineSlice(release, test.spec)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(key, Equals, test.key)
+		slice := release.Packages[key.Package].Slices[key.Slice]
+		c.Assert(slice.Contents, DeepEquals, test.contents)
+
+		// The slice can be selected along with the others.
+		_, err = setup.Select(release, []setup.SliceKey{key, {Package: "mypkg1", Slice: "myslice1"}})
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *ChiselSuite) TestCutInvalidTmpDir(c *C) {
+	dir := c.MkDir()
+	file := filepath.Join(dir, "file")
+	err := os.WriteFile(file, nil, 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--root", dir, "--tmp-dir", filepath.Join(dir, "missing"), "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `invalid --tmp-dir: stat .*/missing: no such file or directory`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--root", dir, "--tmp-dir", file, "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `invalid --tmp-dir: .*/file is not a directory`)
+}
+
+func (s *ChiselSuite) TestCutInvalidManifestSchema(c *C) {
+	dir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--root", dir, "--manifest-schema", "0.1", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `invalid --manifest-schema: unsupported schema version "0.1" \(supported: 1.0, 1.1\)`)
+}
+
+var sourceDateEpochTests = []struct {
+	value string
+	time  time.Time
+	err   string
+}{{
+	value: "",
+	time:  time.Unix(0, 0),
+}, {
+	value: "1700000000",
+	time:  time.Unix(1700000000, 0),
+}, {
+	value: "-1",
+	err:   `invalid SOURCE_DATE_EPOCH: "-1"`,
+}, {
+	value: "yesterday",
+	err:   `invalid SOURCE_DATE_EPOCH: "yesterday"`,
+}}
+
+func (s *ChiselSuite) TestSourceDateEpoch(c *C) {
+	saved, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	defer func() {
+		if ok {
+			os.Setenv("SOURCE_DATE_EPOCH", saved)
+		} else {
+			os.Unsetenv("SOURCE_DATE_EPOCH")
+		}
+	}()
+	for _, test := range sourceDateEpochTests {
+		c.Logf("Value: %q", test.value)
+		os.Setenv("SOURCE_DATE_EPOCH", test.value)
+		t, err := chisel.SourceDateEpoch()
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(t.Equal(test.time), Equals, true)
+	}
+}
+
+var cutModTimeTests = []struct {
+	summary       string
+	epoch         string
+	deterministic bool
+	sourceDate    string
+	time          time.Time
+	err           string
+}{{
+	summary: "No timestamp by default",
+	time:    time.Time{},
+}, {
+	summary:       "Deterministic uses SOURCE_DATE_EPOCH",
+	deterministic: true,
+	sourceDate:    "1700000000",
+	time:          time.Unix(1700000000, 0),
+}, {
+	summary:    "Epoch takes precedence over SOURCE_DATE_EPOCH",
+	epoch:      "1600000000",
+	sourceDate: "1700000000",
+	time:       time.Unix(1600000000, 0),
+}, {
+	summary: "Epoch of zero",
+	epoch:   "0",
+	time:    time.Unix(0, 0),
+}, {
+	summary: "Invalid epoch",
+	epoch:   "-5",
+	err:     `invalid --epoch: "-5"`,
+}}
+
+func (s *ChiselSuite) TestCutModTime(c *C) {
+	saved, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	defer func() {
+		if ok {
+			os.Setenv("SOURCE_DATE_EPOCH", saved)
+		} else {
+			os.Unsetenv("SOURCE_DATE_EPOCH")
+		}
+	}()
+	for _, test := range cutModTimeTests {
+		c.Logf("Summary: %s", test.summary)
+		os.Setenv("SOURCE_DATE_EPOCH", test.sourceDate)
+		t, err := chisel.CutModTime(test.epoch, test.deterministic)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(t.Equal(test.time), Equals, true)
+	}
+}
+
+var archivePriorityTests = []struct {
+	summary    string
+	specs      []string
+	priorities map[string]int
+	err        string
+}{{
+	summary:    "No overrides",
+	priorities: map[string]int{"foo": 10, "bar": 20},
+}, {
+	summary:    "Override one archive",
+	specs:      []string{"foo=30"},
+	priorities: map[string]int{"foo": 30, "bar": 20},
+}, {
+	summary:    "Swap priorities",
+	specs:      []string{"foo=20", "bar=10"},
+	priorities: map[string]int{"foo": 20, "bar": 10},
+}, {
+	summary:    "Negative priority",
+	specs:      []string{"bar=-1"},
+	priorities: map[string]int{"foo": 10, "bar": -1},
+}, {
+	summary: "Missing value",
+	specs:   []string{"foo"},
+	err:     `invalid archive priority "foo": expected <archive>=<priority>`,
+}, {
+	summary: "Undefined archive",
+	specs:   []string{"baz=30"},
+	err:     `invalid archive priority "baz=30": undefined archive "baz"`,
+}, {
+	summary: "Zero priority",
+	specs:   []string{"foo=0"},
+	err:     `invalid archive priority "foo=0": priority must be a non-zero integer between -1000 and 1000`,
+}, {
+	summary: "Priority out of range",
+	specs:   []string{"foo=1001"},
+	err:     `invalid archive priority "foo=1001": priority must be a non-zero integer between -1000 and 1000`,
+}, {
+	summary: "Not a number",
+	specs:   []string{"foo=high"},
+	err:     `invalid archive priority "foo=high": priority must be a non-zero integer between -1000 and 1000`,
+}, {
+	summary: "Duplicated priority",
+	specs:   []string{"foo=20"},
+	err:     `cannot override archive priorities: archives "bar" and "foo" have the same priority value of 20`,
+}}
+
+var archiveURLTests = []struct {
+	summary string
+	specs   []string
+	urls    map[string]string
+	err     string
+}{{
+	summary: "No overrides",
+	urls:    map[string]string{},
+}, {
+	summary: "Local and remote archives",
+	specs:   []string{"foo=file:///srv/mirror/ubuntu", "bar=http://mirror.example.com/ubuntu/"},
+	urls: map[string]string{
+		"foo": "file:///srv/mirror/ubuntu",
+		"bar": "http://mirror.example.com/ubuntu/",
+	},
+}, {
+	summary: "Missing value",
+	specs:   []string{"foo"},
+	err:     `invalid archive URL "foo": expected <archive>=<url>`,
+}, {
+	summary: "Undefined archive",
+	specs:   []string{"baz=file:///srv/mirror"},
+	err:     `invalid archive URL "baz=file:///srv/mirror": undefined archive "baz"`,
+}, {
+	summary: "Unsupported scheme",
+	specs:   []string{"foo=ftp://mirror.example.com/ubuntu/"},
+	err:     `invalid archive URL "foo=ftp://mirror.example.com/ubuntu/": expected http, https or file URL`,
+}, {
+	summary: "Relative local path",
+	specs:   []string{"foo=file:mirror"},
+	err:     `invalid archive URL "foo=file:mirror": file URL must have an absolute path`,
+}}
+
+func (s *ChiselSuite) TestParseArchiveURLs(c *C) {
+	release := &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"foo": {Name: "foo", Priority: 10},
+			"bar": {Name: "bar", Priority: 20},
+		},
+	}
+	for _, test := range archiveURLTests {
+		c.Logf("Summary: %s", test.summary)
+
+		urls, err := chisel.ParseArchiveURLs(release, test.specs)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(urls, DeepEquals, test.urls)
+	}
+}
+
+func (s *ChiselSuite) TestSetArchivePriorities(c *C) {
+	for _, test := range archivePriorityTests {
+		c.Logf("Summary: %s", test.summary)
+
+		release := &setup.Release{
+			Archives: map[string]*setup.Archive{
+				"foo": {Name: "foo", Priority: 10},
+				"bar": {Name: "bar", Priority: 20},
+			},
+		}
+		err := chisel.SetArchivePriorities(release, test.specs)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		priorities := make(map[string]int)
+		for name, archive := range release.Archives {
+			priorities[name] = archive.Priority
+		}
+		c.Assert(priorities, DeepEquals, test.priorities)
+	}
+}
+
+type fakeArchive struct {
+	options  archive.Options
+	versions map[string]string
+	debs     map[string][]byte
+	depends  map[string]string
+	provides map[string]string
+}
+
+func (a *fakeArchive) Options() *archive.Options {
+	return &a.options
+}
+
+func (a *fakeArchive) Fetch(pkg string) (io.ReadSeekCloser, *archive.PackageInfo, error) {
+	data, ok := a.debs[pkg]
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected fetch of package %q", pkg)
+	}
+	info, err := a.Info(pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.SHA256 = fmt.Sprintf("%x", sha256.Sum256(data))
+	return testutil.ReadSeekNopCloser(bytes.NewReader(data)), info, nil
+}
+
+func (a *fakeArchive) Exists(pkg string) bool {
+	_, ok := a.versions[pkg]
+	return ok
+}
+
+func (a *fakeArchive) Info(pkg string) (*archive.PackageInfo, error) {
+	version, ok := a.versions[pkg]
+	if !ok {
+		return nil, fmt.Errorf("cannot find package %q in archive", pkg)
+	}
+	return &archive.PackageInfo{
+		Name:     pkg,
+		Version:  version,
+		Arch:     a.options.Arch,
+		Depends:  a.depends[pkg],
+		Provides: a.provides[pkg],
+	}, nil
+}
+
+var cutDryRunTests = []struct {
+	summary string
+	args    []string
+	prefers string
+	stdout  string
+	err     string
+}{{
+	summary: "Packages, slices and preferences",
+	args:    []string{"mypkg1_myslice2"},
+	prefers: "/dir/file: mypkg1\n",
+	stdout: `
+		packages:
+		  mypkg1:
+		    version: 1.1
+		    archive: ubuntu
+		    slices:
+		      mypkg1_myslice1:
+		        - /dir/file
+		      mypkg1_myslice2: []
+		  mypkg2:
+		    version: 2.1
+		    archive: ubuntu
+		    slices:
+		      mypkg2_myslice:
+		        - /dir/another-file
+		preferred:
+		  /dir/file: mypkg1
+	`,
+}, {
+	summary: "Glob and copy paths",
+	args:    []string{"mypkg3_myslice"},
+	stdout: `
+		packages:
+		  mypkg1:
+		    version: 1.1
+		    archive: ubuntu
+		    slices:
+		      mypkg1_myslice1:
+		        - /dir/file
+		  mypkg2:
+		    version: 2.1
+		    archive: ubuntu
+		    slices:
+		      mypkg2_myslice:
+		        - /dir/another-file
+		  mypkg3:
+		    archive: ubuntu
+		    slices:
+		      mypkg3_myslice:
+		        - /dir/arch-specific*
+		        - /dir/copy
+		        - /dir/glob*
+		        - /dir/mutable
+		        - /dir/other-file
+		        - /dir/sub-dir/
+		        - /dir/symlink
+		        - /dir/unfolded
+		        - /dir/until
+	`,
+}, {
+	summary: "Selection failure",
+	args:    []string{"mypkg1_foo"},
+	err:     `slice mypkg1_foo not found`,
+}}
+
+var archiveSourceTests = []struct {
+	summary string
+	args    []string
+	err     string
+}{{
+	summary: "Cut",
+	args:    []string{"cut", "--dry-run", "mypkg1_myslice1"},
+}, {
+	summary: "Why package",
+	args:    []string{"debug", "why-package", "mypkg1", "mypkg1_myslice1"},
+}, {
+	summary: "Extract",
+	args:    []string{"extract", "--root", "/nonexistent", "--package", "mypkg1", "/dir/file"},
+	err:     `cannot extract from package "mypkg1": target directory does not exist`,
+}, {
+	summary: "Check dependencies",
+	args:    []string{"debug", "check-deps", "mypkg1_myslice1"},
+}, {
+	summary: "Check archives",
+	args:    []string{"validate", "--check-archives"},
+	err:     `package "mypkg2" defined in slices/mypkg2.yaml is not in archive\(s\)`,
+}}
+
+func (s *ChiselSuite) TestArchiveSource(c *C) {
+	var opened []archive.Options
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		opened = append(opened, *options)
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg1": "1.1"},
+			debs:     map[string][]byte{"mypkg1": testutil.PackageData["test-package"]},
+		}, nil
+	})
+	defer restore()
+
+	releaseDir := c.MkDir()
+	for path, data := range infoRelease {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+	cacheDir := c.MkDir()
+
+	for _, test := range archiveSourceTests {
+		c.Logf("Summary: %s", test.summary)
+		s.ResetStdStreams()
+		opened = nil
+
+		args := append(test.args, "--release", releaseDir, "--arch", "amd64",
+			"--cache-dir", cacheDir, "--archive-url", "ubuntu=file:///srv/mirror",
+			"--archive-priority", "ubuntu=10")
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		c.Assert(opened, HasLen, 1)
+		c.Assert(opened[0].CacheDir, Equals, cacheDir)
+		c.Assert(opened[0].BaseURL, Equals, "file:///srv/mirror")
+	}
+
+	// The archive options of validate need --check-archives.
+	for _, option := range []string{"--archive-priority", "--archive-url", "--cache-dir"} {
+		_, err := chisel.Parser().ParseArgs([]string{"validate", "--release", releaseDir, option, "ubuntu=10"})
+		c.Assert(err, ErrorMatches, option+" requires --check-archives")
+	}
+
+	// Invalid values are rejected before opening any archive.
+	opened = nil
+	_, err := chisel.Parser().ParseArgs([]string{"extract", "--release", releaseDir, "--root", c.MkDir(),
+		"--package", "mypkg1", "--archive-url", "foo=file:///srv/mirror", "/dir/file"})
+	c.Assert(err, ErrorMatches, `invalid archive URL "foo=file:///srv/mirror": undefined archive "foo"`)
+	c.Assert(opened, HasLen, 0)
+}
+
+func (s *ChiselSuite) TestCutDryRun(c *C) {
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		// The version of mypkg3 is not known to the archive.
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg1": "1.1", "mypkg2": "2.1", "mypkg3": ""},
+		}, nil
+	})
+	defer restore()
+
+	for _, test := range cutDryRunTests {
+		c.Logf("Summary: %s", test.summary)
+		s.ResetStdStreams()
+
+		dir := c.MkDir()
+		releaseDir := filepath.Join(dir, "release")
+		for path, data := range infoRelease {
+			fpath := filepath.Join(releaseDir, path)
+			c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+			c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+		}
+		args := []string{"cut", "--dry-run", "--release", releaseDir}
+		if test.prefers != "" {
+			prefersPath := filepath.Join(dir, "prefers.yaml")
+			c.Assert(os.WriteFile(prefersPath, []byte(test.prefers), 0644), IsNil)
+			args = append(args, "--prefers", prefersPath)
+		}
+		args = append(args, test.args...)
+
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		test.stdout = string(testutil.Reindent(test.stdout))
+		c.Assert(s.Stdout(), Equals, strings.TrimSpace(test.stdout)+"\n")
+	}
+}
+
+var selectFromManifestRelease = map[string]string{
+	"chisel.yaml": string(defaultChiselYaml),
+	"slices/mypkg.yaml": `
+		package: mypkg
+		slices:
+			bins:
+				essential:
+					- mypkg_manifest
+				contents:
+					/usr/bin/foo:
+			config:
+				contents:
+					/etc/foo.conf:
+			manifest:
+				contents:
+					/var/lib/chisel/**: {generate: manifest}
+	`,
+}
+
+var selectFromManifestPackage = testutil.MustMakeDeb([]testutil.TarEntry{
+	testutil.Dir(0755, "./etc/"),
+	testutil.Reg(0644, "./etc/foo.conf", "foo"),
+	testutil.Dir(0755, "./usr/"),
+	testutil.Dir(0755, "./usr/bin/"),
+	testutil.Reg(0755, "./usr/bin/foo", "binary"),
+})
+
+func (s *ChiselSuite) TestCutSelectFromManifest(c *C) {
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg": "1.0"},
+			debs:     map[string][]byte{"mypkg": selectFromManifestPackage},
+		}, nil
+	})
+	defer restore()
+
+	dir := c.MkDir()
+	releaseDir := filepath.Join(dir, "release")
+	for path, data := range selectFromManifestRelease {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	firstRoot := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--arch", "amd64", "--root", firstRoot, "mypkg_bins"})
+	c.Assert(err, IsNil)
+
+	manifestPath := filepath.Join(firstRoot, "var/lib/chisel/manifest.wall")
+	secondRoot := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--arch", "amd64", "--root", secondRoot, "--select-from-manifest", manifestPath})
+	c.Assert(err, IsNil)
+
+	c.Assert(testutil.TreeDump(secondRoot), DeepEquals, testutil.TreeDump(firstRoot))
+	_, err = os.Stat(filepath.Join(secondRoot, "etc/foo.conf"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--arch", "amd64", "--root", c.MkDir(), "--select-from-manifest", manifestPath, "mypkg_config"})
+	c.Assert(err, ErrorMatches, "cannot use --select-from-manifest with other slices")
+
+	// Slices recorded in the manifest must still exist in the release.
+	err = os.WriteFile(filepath.Join(releaseDir, "slices/mypkg.yaml"), testutil.Reindent(`
+		package: mypkg
+		slices:
+			manifest:
+				contents:
+					/var/lib/chisel/**: {generate: manifest}
+	`), 0644)
+	c.Assert(err, IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--arch", "amd64", "--root", c.MkDir(), "--select-from-manifest", manifestPath})
+	c.Assert(err, ErrorMatches, "slice mypkg_bins from manifest not found in release")
+}
+
+func (s *ChiselSuite) TestCutArchs(c *C) {
+	var openedArchs []string
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		openedArchs = append(openedArchs, options.Arch)
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg": "1.0"},
+			debs:     map[string][]byte{"mypkg": selectFromManifestPackage},
+		}, nil
+	})
+	defer restore()
+
+	releaseDir := c.MkDir()
+	for path, data := range selectFromManifestRelease {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	// The host architecture is used by default.
+	hostArch, err := deb.InferArch()
+	c.Assert(err, IsNil)
+	rootDir := c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(openedArchs, DeepEquals, []string{hostArch})
+	c.Assert(filepath.Join(rootDir, "usr/bin/foo"), testutil.FilePresent)
+
+	// Every architecture is cut into its own tree.
+	openedArchs = nil
+	rootDir = c.MkDir()
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", rootDir, "--arch", "amd64", "--arch", "arm64", "mypkg_bins"})
+	c.Assert(err, IsNil)
+	c.Assert(openedArchs, DeepEquals, []string{"amd64", "arm64"})
+	for _, arch := range []string{"amd64", "arm64"} {
+		archDir := filepath.Join(rootDir, arch)
+		c.Assert(filepath.Join(archDir, "usr/bin/foo"), testutil.FilePresent)
+		mfest, err := chisel.ReadManifest(filepath.Join(archDir, "var/lib/chisel/manifest.wall"))
+		c.Assert(err, IsNil)
+		pkg, err := mfest.Package("mypkg")
+		c.Assert(err, IsNil)
+		c.Assert(pkg.Arch, Equals, arch)
+	}
+	_, err = os.Stat(filepath.Join(rootDir, "usr"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--output", filepath.Join(c.MkDir(), "out.tar"), "--arch", "amd64", "--arch", "arm64", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "cannot use --output with more than one --arch")
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(), "--arch", "amd64", "--arch", "amd64", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "duplicate --arch: amd64")
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--release", releaseDir, "--root", c.MkDir(), "--arch", "foo", "mypkg_bins"})
+	c.Assert(err, ErrorMatches, "invalid package architecture: foo")
+}
+
+func (s *ChiselSuite) TestCutIgnoredArchive(c *C) {
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		if options.Label == "other" {
+			return nil, fmt.Errorf("%w: cannot talk to archive: timeout", archive.ErrArchiveUnavailable)
+		}
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg1": "1.1", "mypkg2": "2.1"},
+		}, nil
+	})
+	defer restore()
+
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml":        whyPackageArchivesYaml,
+		"slices/mypkg1.yaml": infoRelease["slices/mypkg1.yaml"],
+		"slices/mypkg2.yaml": infoRelease["slices/mypkg2.yaml"],
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	// Unpinned packages are fetched from the remaining archives.
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--dry-run", "--release", releaseDir, "mypkg1_myslice2"})
+	c.Assert(err, IsNil)
+
+	// Packages pinned to the ignored archive cannot be fetched.
+	pinned := "package: mypkg2\narchive: other\n" + strings.TrimPrefix(string(testutil.Reindent(infoRelease["slices/mypkg2.yaml"])), "package: mypkg2\n")
+	c.Assert(os.WriteFile(filepath.Join(releaseDir, "slices/mypkg2.yaml"), []byte(pinned), 0644), IsNil)
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--dry-run", "--release", releaseDir, "mypkg1_myslice2"})
+	c.Assert(err, ErrorMatches, `cannot fetch package "mypkg2": pinned archive "other" was ignored`)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortCheckDepsHelp = "Report package dependencies with no selected slice"
+var longCheckDepsHelp = `
+The check-deps command compares the Depends and Pre-Depends fields of the
+packages selected by the provided slices, as listed in the archive index,
+against the selected packages. A dependency is reported when none of its
+alternatives, nor any package providing them, has a slice selected.
+
+Chisel ignores package dependencies on purpose, so the report is advisory
+and may list dependencies which are not needed by the selected content.
+The command only fails on missing dependencies when --strict is used.
+
+The --archive-priority, --archive-url and --cache-dir options change how
+the archives are opened, as they do for the cut command.
+`
+
+var checkDepsDescs = map[string]string{
+	"release":          "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":             "Package architecture",
+	"strict":           "Fail when dependencies are missing",
+	"archive-priority": "Override the priority of an archive (e.g. staging=30)",
+	"archive-url":      "Fetch an archive from another URL (e.g. ubuntu=file:///srv/mirror)",
+	"cache-dir":        "Directory for caching fetched packages across runs",
+}
+
+type cmdCheckDeps struct {
+	Release           string   `long:"release" value-name:"<branch|dir>"`
+	Arch              string   `long:"arch" value-name:"<arch>"`
+	Strict            bool     `long:"strict"`
+	ArchivePriorities []string `long:"archive-priority" value-name:"<archive=priority>"`
+	ArchiveURLs       []string `long:"archive-url" value-name:"<archive=url>"`
+	CacheDir          string   `long:"cache-dir" value-name:"<dir>"`
+
+	Positional struct {
+		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addDebugCommand("check-deps", shortCheckDepsHelp, longCheckDepsHelp, func() flags.Commander { return &cmdCheckDeps{} }, checkDepsDescs, nil)
+}
+
+func (cmd *cmdCheckDeps) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	sliceKeys := make([]setup.SliceKey, len(cmd.Positional.SliceRefs))
+	for i, sliceRef := range cmd.Positional.SliceRefs {
+		sliceKey, err := setup.ParseSliceKey(sliceRef)
+		if err != nil {
+			return err
+		}
+		sliceKeys[i] = sliceKey
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+	selection, err := setup.Select(release, sliceKeys)
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[string]bool)
+	for _, slice := range selection.Slices {
+		selected[slice.Package] = true
+	}
+	pkgNames := make([]string, 0, len(selected))
+	for pkgName := range selected {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	sort.Strings(pkgNames)
+
+	source, err := newArchiveSource(release, cmd.ArchivePriorities, cmd.ArchiveURLs, cmd.CacheDir)
+	if err != nil {
+		return err
+	}
+	archives := newPackageArchives(release, cmd.Arch, source)
+	depends := make(map[string][]string)
+	provided := make(map[string]bool)
+	for _, pkgName := range pkgNames {
+		pkgArchive, err := archives.find(pkgName)
+		if err != nil {
+			return err
+		}
+		info, err := pkgArchive.Info(pkgName)
+		if err != nil {
+			return err
+		}
+		depends[pkgName] = append(splitRelations(info.PreDepends), splitRelations(info.Depends)...)
+		for _, name := range splitRelations(info.Provides) {
+			provided[relationName(name)] = true
+		}
+	}
+
+	missing := 0
+	for _, pkgName := range pkgNames {
+		for _, relation := range depends[pkgName] {
+			if relationSatisfied(relation, selected, provided) {
+				continue
+			}
+			fmt.Fprintf(Stdout, "%s depends on %s, which has no selected slice\n", pkgName, relation)
+			missing++
+		}
+	}
+	if missing > 0 && cmd.Strict {
+		return fmt.Errorf("selection has %d missing dependencies", missing)
+	}
+	return nil
+}
+
+// splitRelations splits a package relationship field, such as Depends, in
+// its comma-separated relations.
+func splitRelations(field string) []string {
+	var relations []string
+	for _, relation := range strings.Split(field, ",") {
+		relation = strings.Join(strings.Fields(relation), " ")
+		if relation != "" {
+			relations = append(relations, relation)
+		}
+	}
+	return relations
+}
+
+// relationName returns the package name in a single relationship, without
+// its version constraint, architecture qualifier or restrictions.
+func relationName(relation string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(relation), " ")
+	name, _, _ = strings.Cut(name, "(")
+	name, _, _ = strings.Cut(name, "[")
+	name, _, _ = strings.Cut(name, ":")
+	return name
+}
+
+// relationSatisfied returns whether any of the alternatives in relation is
+// a selected or provided package. Version constraints are not checked.
+func relationSatisfied(relation string, selected, provided map[string]bool) bool {
+	for _, alternative := range strings.Split(relation, "|") {
+		name := relationName(alternative)
+		if selected[name] || provided[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var checkDepsTests = []struct {
+	summary string
+	args    []string
+	stdout  string
+	err     string
+}{{
+	summary: "Satisfied dependency",
+	args:    []string{"mypkg1_myslice2"},
+}, {
+	summary: "Unsatisfied dependency",
+	args:    []string{"mypkg1_myslice1"},
+	stdout: `
+		mypkg1 depends on mypkg2 (>= 2.0), which has no selected slice
+	`,
+}, {
+	summary: "Alternatives and provided packages",
+	args:    []string{"mypkg3_myslice"},
+	stdout: `
+		mypkg3 depends on libc6 (>= 2.34), which has no selected slice
+	`,
+}, {
+	summary: "Missing dependencies fail in strict mode",
+	args:    []string{"--strict", "mypkg3_myslice"},
+	stdout: `
+		mypkg3 depends on libc6 (>= 2.34), which has no selected slice
+	`,
+	err: `selection has 1 missing dependencies`,
+}, {
+	summary: "Selection failure",
+	args:    []string{"mypkg1_foo"},
+	err:     `slice mypkg1_foo not found`,
+}}
+
+func (s *ChiselSuite) TestCheckDeps(c *C) {
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg1": "1.1", "mypkg2": "2.1", "mypkg3": "3.1"},
+			depends: map[string]string{
+				"mypkg1": "mypkg2 (>= 2.0)",
+				"mypkg3": "libc6 (>= 2.34), libfoo1 | virtual-foo, mypkg1",
+			},
+			provides: map[string]string{"mypkg2": "virtual-foo"},
+		}, nil
+	})
+	defer restore()
+
+	releaseDir := c.MkDir()
+	for path, data := range infoRelease {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	for _, test := range checkDepsTests {
+		c.Logf("Summary: %s", test.summary)
+		s.ResetStdStreams()
+
+		args := append([]string{"debug", "check-deps", "--release", releaseDir}, test.args...)
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		stdout := strings.TrimSpace(string(testutil.Reindent(test.stdout)))
+		if stdout != "" {
+			stdout += "\n"
+		}
+		c.Assert(s.Stdout(), Equals, stdout)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var shortCompleteSlicesHelp = "List slice names starting with a prefix"
+var longCompleteSlicesHelp = `
+The complete-slices command lists, one per line, the names of the slices
+in the release which start with the provided prefix. It is used by the
+shell completion scripts to suggest slice names.
+
+The --packages option lists the matching package names as well.
+`
+
+var completeSlicesDescs = map[string]string{
+	"release":  "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"packages": "List the package names as well",
+}
+
+type cmdCompleteSlices struct {
+	Release  string `long:"release" value-name:"<branch|dir>"`
+	Packages bool   `long:"packages"`
+
+	Positional struct {
+		Prefix string `positional-arg-name:"<prefix>"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addDebugCommand("complete-slices", shortCompleteSlicesHelp, longCompleteSlicesHelp, func() flags.Commander { return &cmdCompleteSlices{} }, completeSlicesDescs, nil)
+}
+
+func (cmd *cmdCompleteSlices) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, pkg := range release.Packages {
+		if cmd.Packages && strings.HasPrefix(pkg.Name, cmd.Positional.Prefix) {
+			names = append(names, pkg.Name)
+		}
+		for _, slice := range pkg.Slices {
+			name := slice.String()
+			if strings.HasPrefix(name, cmd.Positional.Prefix) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(Stdout, name)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var completeSlicesTests = []struct {
+	summary string
+	args    []string
+	stdout  string
+}{{
+	summary: "No prefix lists all slices",
+	args:    []string{},
+	stdout:  "mypkg1_myslice1\nmypkg1_myslice2\nmypkg2_myslice\nmypkg3_myslice\n",
+}, {
+	summary: "Package prefix",
+	args:    []string{"mypkg1"},
+	stdout:  "mypkg1_myslice1\nmypkg1_myslice2\n",
+}, {
+	summary: "Slice prefix",
+	args:    []string{"mypkg1_myslice2"},
+	stdout:  "mypkg1_myslice2\n",
+}, {
+	summary: "Packages and slices",
+	args:    []string{"--packages", "mypkg1"},
+	stdout:  "mypkg1\nmypkg1_myslice1\nmypkg1_myslice2\n",
+}, {
+	summary: "All packages and slices",
+	args:    []string{"--packages"},
+	stdout:  "mypkg1\nmypkg1_myslice1\nmypkg1_myslice2\nmypkg2\nmypkg2_myslice\nmypkg3\nmypkg3_myslice\n",
+}, {
+	summary: "No matches",
+	args:    []string{"foo"},
+	stdout:  "",
+}}
+
+func (s *ChiselSuite) TestCompleteSlicesCommand(c *C) {
+	dir := c.MkDir()
+	for path, data := range infoRelease {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	for _, test := range completeSlicesTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		args := append([]string{"debug", "complete-slices", "--release", dir}, test.args...)
+		_, err := chisel.Parser().ParseArgs(args)
+		c.Assert(err, IsNil)
+		c.Assert(s.Stdout(), Equals, test.stdout)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/deb"
+)
+
+var shortInspectDebHelp = "Show the metadata and layout of a package"
+var longInspectDebHelp = `
+The inspect-deb command prints the main control fields of a package and a
+summary of its content: the number of files, their total size, and how
+those are spread among the top-level directories.
+
+The package is either the path of a local .deb file, which is read without
+network access, or the name of a package obtained from the archives of the
+release.
+
+With --list, the full listing of the package content is printed instead,
+with the mode, size and path of every entry, and the target of links, in
+the order they are found in the package. Nothing is extracted.
+`
+
+var inspectDebDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+	"list":    "List every entry of the package content",
+}
+
+type cmdInspectDeb struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+	List    bool   `long:"list"`
+
+	Positional struct {
+		Package string `positional-arg-name:"<deb|package>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addDebugCommand("inspect-deb", shortInspectDebHelp, longInspectDebHelp, func() flags.Commander { return &cmdInspectDeb{} }, inspectDebDescs, nil)
+}
+
+// inspectDebFields are the control fields shown, in order.
+var inspectDebFields = []string{"Package", "Version", "Architecture", "Section", "Priority", "Installed-Size", "Pre-Depends", "Depends", "Description"}
+
+func (cmd *cmdInspectDeb) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	var pkgReader io.ReadSeekCloser
+	info, err := os.Stat(cmd.Positional.Package)
+	if err == nil && info.Mode().IsRegular() {
+		pkgReader, err = os.Open(cmd.Positional.Package)
+	} else {
+		pkgReader, err = cmd.fetch(cmd.Positional.Package)
+	}
+	if err != nil {
+		return err
+	}
+	defer pkgReader.Close()
+
+	if cmd.List {
+		return deb.ListData(pkgReader, func(entry *deb.DataEntry) error {
+			fmt.Fprintln(Stdout, formatDataEntry(entry))
+			return nil
+		})
+	}
+
+	section, err := deb.ReadControl(pkgReader)
+	if err != nil {
+		return err
+	}
+	for _, field := range inspectDebFields {
+		value := section.Get(field)
+		if field == "Description" {
+			// Only the synopsis.
+			value, _, _ = strings.Cut(value, "\n")
+		}
+		if value != "" {
+			fmt.Fprintf(Stdout, "%s: %s\n", strings.ToLower(field), value)
+		}
+	}
+
+	type dirSummary struct {
+		files int
+		size  int64
+	}
+	var total dirSummary
+	dirs := make(map[string]*dirSummary)
+	err = deb.ListData(pkgReader, func(entry *deb.DataEntry) error {
+		if strings.HasSuffix(entry.Path, "/") {
+			return nil
+		}
+		top := "/"
+		if name, _, ok := strings.Cut(entry.Path[1:], "/"); ok {
+			top = "/" + name + "/"
+		}
+		dir := dirs[top]
+		if dir == nil {
+			dir = &dirSummary{}
+			dirs[top] = dir
+		}
+		dir.files++
+		dir.size += entry.Size
+		total.files++
+		total.size += entry.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Stdout, "files: %d\n", total.files)
+	fmt.Fprintf(Stdout, "size: %d\n", total.size)
+	if len(dirs) > 0 {
+		names := make([]string, 0, len(dirs))
+		for name := range dirs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(Stdout, "directories:\n")
+		for _, name := range names {
+			fmt.Fprintf(Stdout, "  %s: %d files, %d bytes\n", name, dirs[name].files, dirs[name].size)
+		}
+	}
+	return nil
+}
+
+// formatDataEntry formats the entry in the way of "dpkg-deb --contents",
+// without the owners and times.
+func formatDataEntry(entry *deb.DataEntry) string {
+	var mode [10]byte
+	switch entry.Type {
+	case tar.TypeDir:
+		mode[0] = 'd'
+	case tar.TypeSymlink:
+		mode[0] = 'l'
+	case tar.TypeLink:
+		mode[0] = 'h'
+	default:
+		mode[0] = '-'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		mode[i+1] = '-'
+		if entry.Mode&(1<<(8-i)) != 0 {
+			mode[i+1] = rwx[i]
+		}
+	}
+	// The setuid, setgid and sticky bits replace the execute bit of the
+	// owner, group and others respectively.
+	for i, special := range []struct {
+		bit  int64
+		char byte
+	}{{04000, 's'}, {02000, 's'}, {01000, 't'}} {
+		if entry.Mode&special.bit == 0 {
+			continue
+		}
+		pos := 3 * (i + 1)
+		if mode[pos] == '-' {
+			mode[pos] = special.char - 'a' + 'A'
+		} else {
+			mode[pos] = special.char
+		}
+	}
+	line := fmt.Sprintf("%s %8d %s", mode[:], entry.Size, entry.Path)
+	switch entry.Type {
+	case tar.TypeSymlink:
+		line += " -> " + entry.Link
+	case tar.TypeLink:
+		line += " link to " + entry.Link
+	}
+	return line
+}
+
+// fetch obtains the named package from the archives of the release, using
+// the archive pinned for it or otherwise the highest priority one which has
+// the package.
+func (cmd *cmdInspectDeb) fetch(pkgName string) (io.ReadSeekCloser, error) {
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := newPackageArchives(release, cmd.Arch, nil)
+	pkgArchive, err := archives.find(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	reader, _, err := pkgArchive.Fetch(pkgName)
+	return reader, err
+}
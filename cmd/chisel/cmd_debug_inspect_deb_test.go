@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *ChiselSuite) TestInspectDebLocal(c *C) {
+	control := "Package: mypkg\nVersion: 1.0-1\nArchitecture: amd64\nSection: libs\nPriority: optional\nDepends: libc6\nDescription: My package\n Longer description.\n"
+	data, err := testutil.MakeDebWithControl(control, []testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./etc/"),
+		testutil.Reg(0644, "./etc/mypkg.conf", "config"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/mypkg", "binary"),
+		testutil.Lnk(0777, "./usr/bin/alias", "mypkg"),
+	})
+	c.Assert(err, IsNil)
+	debPath := filepath.Join(c.MkDir(), "mypkg.deb")
+	err = os.WriteFile(debPath, data, 0644)
+	c.Assert(err, IsNil)
+
+	_, err = chisel.Parser().ParseArgs([]string{"debug", "inspect-deb", debPath})
+	c.Assert(err, IsNil)
+	expected := string(testutil.Reindent(`
+		package: mypkg
+		version: 1.0-1
+		architecture: amd64
+		section: libs
+		priority: optional
+		depends: libc6
+		description: My package
+		files: 3
+		size: 12
+		directories:
+		  /etc/: 1 files, 6 bytes
+		  /usr/: 2 files, 6 bytes
+	`))
+	c.Assert(s.Stdout(), Equals, strings.TrimSpace(expected)+"\n")
+}
+
+func (s *ChiselSuite) TestInspectDebList(c *C) {
+	data, err := testutil.MakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(01777, "./tmp/"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(04755, "./usr/bin/mypkg", "binary"),
+		testutil.Hrd(04755, "./usr/bin/mypkg-hard", "./usr/bin/mypkg"),
+		testutil.Lnk(0777, "./usr/bin/alias", "mypkg"),
+		testutil.Reg(02640, "./usr/bin/empty", ""),
+	})
+	c.Assert(err, IsNil)
+	debPath := filepath.Join(c.MkDir(), "mypkg.deb")
+	err = os.WriteFile(debPath, data, 0644)
+	c.Assert(err, IsNil)
+
+	// The entry of the root directory itself is not listed.
+	_, err = chisel.Parser().ParseArgs([]string{"debug", "inspect-deb", "--list", debPath})
+	c.Assert(err, IsNil)
+	expected := string(testutil.Reindent(`
+		drwxrwxrwt        0 /tmp/
+		drwxr-xr-x        0 /usr/
+		drwxr-xr-x        0 /usr/bin/
+		-rwsr-xr-x        6 /usr/bin/mypkg
+		hrwsr-xr-x        0 /usr/bin/mypkg-hard link to /usr/bin/mypkg
+		lrwxrwxrwx        0 /usr/bin/alias -> mypkg
+		-rw-r-S---        0 /usr/bin/empty
+	`))
+	c.Assert(s.Stdout(), Equals, strings.TrimSpace(expected)+"\n")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var shortReproCheckHelp = "Check that cutting slices is reproducible"
+var longReproCheckHelp = `
+The repro-check command cuts the provided slices twice, into two separate
+temporary directories, with the --deterministic option of the cut command.
+It then compares the two trees, including the manifests, and lists every
+path where they differ, failing if there is any.
+`
+
+var reproCheckDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+type cmdReproCheck struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+
+	Positional struct {
+		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addDebugCommand("repro-check", shortReproCheckHelp, longReproCheckHelp, func() flags.Commander { return &cmdReproCheck{} }, reproCheckDescs, nil)
+}
+
+func (cmd *cmdReproCheck) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	var roots []string
+	for i := 0; i < 2; i++ {
+		root, err := os.MkdirTemp("", "chisel-repro-")
+		if err != nil {
+			return fmt.Errorf("cannot create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(root)
+		cut := &cmdCut{
+			Release:       cmd.Release,
+			RootDir:       root,
+			Deterministic: true,
+		}
+		if cmd.Arch != "" {
+			cut.Archs = []string{cmd.Arch}
+		}
+		cut.Positional.SliceRefs = cmd.Positional.SliceRefs
+		err = cut.Execute(nil)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	}
+
+	diffs, err := compareTrees(roots[0], roots[1])
+	if err != nil {
+		return err
+	}
+	for _, diff := range diffs {
+		fmt.Fprintln(Stdout, diff)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("cut is not reproducible: %d paths differ", len(diffs))
+	}
+	return nil
+}
+
+// treeEntry holds the properties of a path which must be the same in
+// reproducible trees.
+type treeEntry struct {
+	mode    fs.FileMode
+	modTime int64
+	link    string
+	data    []byte
+}
+
+func readTree(root string) (map[string]*treeEntry, error) {
+	entries := make(map[string]*treeEntry)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			// The root is a temporary directory, not cut content.
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath := filepath.Clean("/" + filepath.ToSlash(path[len(root):]))
+		entry := &treeEntry{
+			mode:    info.Mode(),
+			modTime: info.ModTime().UnixNano(),
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.link, err = os.Readlink(path)
+		case info.Mode().IsRegular():
+			entry.data, err = os.ReadFile(path)
+		case info.IsDir():
+			relPath += "/"
+		}
+		if err != nil {
+			return err
+		}
+		entries[relPath] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// compareTrees returns a description of every path which differs between
+// the trees at rootA and rootB, in order.
+func compareTrees(rootA, rootB string) ([]string, error) {
+	treeA, err := readTree(rootA)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := readTree(rootB)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	for path := range treeA {
+		paths[path] = true
+	}
+	for path := range treeB {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, path := range sorted {
+		a, b := treeA[path], treeB[path]
+		switch {
+		case a == nil || b == nil:
+			diffs = append(diffs, fmt.Sprintf("%s: only in one of the trees", path))
+		case a.mode != b.mode:
+			diffs = append(diffs, fmt.Sprintf("%s: mode differs: %s != %s", path, a.mode, b.mode))
+		case a.link != b.link:
+			diffs = append(diffs, fmt.Sprintf("%s: link differs: %q != %q", path, a.link, b.link))
+		case !bytes.Equal(a.data, b.data):
+			diffs = append(diffs, fmt.Sprintf("%s: content differs", path))
+		case a.modTime != b.modTime:
+			diffs = append(diffs, fmt.Sprintf("%s: modification time differs", path))
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+func makeReproTree(c *C, root string) {
+	err := os.MkdirAll(filepath.Join(root, "dir/nested"), 0755)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(root, "dir/file"), []byte("data"), 0644)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(root, "dir/other"), []byte("other"), 0644)
+	c.Assert(err, IsNil)
+	err = os.Symlink("file", filepath.Join(root, "dir/link"))
+	c.Assert(err, IsNil)
+	// Symlinks are not followed so that their own time is set as well.
+	ts := unix.NsecToTimespec(time.Unix(1700000000, 0).UnixNano())
+	for _, path := range []string{"dir/link", "dir/file", "dir/other", "dir/nested", "dir", ""} {
+		err = unix.UtimesNanoAt(unix.AT_FDCWD, filepath.Join(root, path), []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *ChiselSuite) TestCompareTrees(c *C) {
+	rootA := c.MkDir()
+	rootB := c.MkDir()
+	makeReproTree(c, rootA)
+	makeReproTree(c, rootB)
+
+	diffs, err := chisel.CompareTrees(rootA, rootB)
+	c.Assert(err, IsNil)
+	c.Assert(diffs, HasLen, 0)
+
+	err = os.WriteFile(filepath.Join(rootB, "dir/file"), []byte("changed"), 0644)
+	c.Assert(err, IsNil)
+	err = os.Chmod(filepath.Join(rootB, "dir/other"), 0600)
+	c.Assert(err, IsNil)
+	err = os.Remove(filepath.Join(rootB, "dir/link"))
+	c.Assert(err, IsNil)
+	err = os.Symlink("other", filepath.Join(rootB, "dir/link"))
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(rootB, "extra"), nil, 0644)
+	c.Assert(err, IsNil)
+	mtime := time.Unix(1700000000, 0)
+	err = os.Chtimes(filepath.Join(rootB, "dir"), mtime, mtime)
+	c.Assert(err, IsNil)
+	err = os.Chtimes(filepath.Join(rootB, "dir/nested"), time.Unix(0, 0), time.Unix(0, 0))
+	c.Assert(err, IsNil)
+
+	diffs, err = chisel.CompareTrees(rootA, rootB)
+	c.Assert(err, IsNil)
+	c.Assert(diffs, DeepEquals, []string{
+		`/dir/file: content differs`,
+		`/dir/link: link differs: "file" != "other"`,
+		`/dir/nested/: modification time differs`,
+		`/dir/other: mode differs: -rw-r--r-- != -rw-------`,
+		`/extra: only in one of the trees`,
+	})
+}
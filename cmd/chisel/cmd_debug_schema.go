@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortSchemaHelp = "Print the JSON Schema of release files"
+var longSchemaHelp = `
+The schema command prints the JSON Schema describing either the
+chisel.yaml release definition or the slice definition files, so
+editors can offer completion and validation.
+`
+
+var schemaDescs = map[string]string{
+	"type": "File type: release or slices",
+}
+
+type cmdSchema struct {
+	Type string `long:"type" value-name:"<type>" choice:"release" choice:"slices" default:"release"`
+}
+
+func init() {
+	addDebugCommand("schema", shortSchemaHelp, longSchemaHelp, func() flags.Commander { return &cmdSchema{} }, schemaDescs, nil)
+}
+
+func (cmd *cmdSchema) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	var data []byte
+	var err error
+	if cmd.Type == "slices" {
+		data, err = setup.PackageSchema()
+	} else {
+		data, err = setup.ReleaseSchema()
+	}
+	if err != nil {
+		return err
+	}
+	_, err = Stdout.Write(data)
+	return err
+}
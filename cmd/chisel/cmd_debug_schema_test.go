@@ -0,0 +1,36 @@
+package main_test
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func (s *ChiselSuite) TestSchemaCommand(c *C) {
+	releaseSchema, err := setup.ReleaseSchema()
+	c.Assert(err, IsNil)
+	packageSchema, err := setup.PackageSchema()
+	c.Assert(err, IsNil)
+
+	tests := []struct {
+		args   []string
+		stdout []byte
+	}{
+		{[]string{"debug", "schema"}, releaseSchema},
+		{[]string{"debug", "schema", "--type", "release"}, releaseSchema},
+		{[]string{"debug", "schema", "--type", "slices"}, packageSchema},
+	}
+	for _, test := range tests {
+		s.ResetStdStreams()
+		_, err := chisel.Parser().ParseArgs(test.args)
+		c.Assert(err, IsNil)
+		c.Assert(s.Stdout(), Equals, string(test.stdout))
+		c.Assert(json.Valid(s.stdout.Bytes()), Equals, true)
+	}
+
+	_, err = chisel.Parser().ParseArgs([]string{"debug", "schema", "--type", "foo"})
+	c.Assert(err, ErrorMatches, `Invalid value .foo. for option .--type.. Allowed values are: release or slices`)
+}
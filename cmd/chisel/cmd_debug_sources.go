@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/pgputil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortSourcesHelp = "Show the archives of a release as apt sources"
+var longSourcesHelp = `
+The sources command prints the archives of the release in the deb822 format
+used by apt in .sources files, so that the same suites, components and keys
+may be used with the standard apt tooling.
+
+Each archive is written as its own stanza, from the highest priority to the
+lowest, with its public keys embedded in the Signed-By field. Ubuntu Pro
+archives use their esm.ubuntu.com URLs, and still require the credentials
+to be configured for apt separately.
+`
+
+var sourcesDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+type cmdSources struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+}
+
+func init() {
+	addDebugCommand("sources", shortSourcesHelp, longSourcesHelp, func() flags.Commander { return &cmdSources{} }, sourcesDescs, nil)
+}
+
+func (cmd *cmdSources) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	arch := cmd.Arch
+	var err error
+	if arch == "" {
+		arch, err = deb.InferArch()
+	} else {
+		err = deb.ValidateArch(arch)
+	}
+	if err != nil {
+		return err
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+
+	archives := make([]*setup.Archive, 0, len(release.Archives))
+	for _, archiveInfo := range release.Archives {
+		archives = append(archives, archiveInfo)
+	}
+	slices.SortFunc(archives, setup.CompareArchives)
+	for i, archiveInfo := range archives {
+		stanza, err := sourcesStanza(archiveInfo, arch)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(Stdout)
+		}
+		fmt.Fprint(Stdout, stanza)
+	}
+	return nil
+}
+
+// sourcesStanza returns the deb822 stanza describing the archive.
+func sourcesStanza(archiveInfo *setup.Archive, arch string) (string, error) {
+	url, err := archive.DefaultURL(archiveInfo.Pro, arch)
+	if err != nil {
+		return "", fmt.Errorf("cannot find URL of archive %q: %w", archiveInfo.Name, err)
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# %s\n", archiveInfo.Name)
+	fmt.Fprintf(&buf, "Types: deb\n")
+	fmt.Fprintf(&buf, "URIs: %s\n", url)
+	fmt.Fprintf(&buf, "Suites: %s\n", strings.Join(archiveInfo.Suites, " "))
+	fmt.Fprintf(&buf, "Components: %s\n", strings.Join(archiveInfo.Components, " "))
+	fmt.Fprintf(&buf, "Architectures: %s\n", arch)
+	if len(archiveInfo.PubKeys) > 0 {
+		fmt.Fprintf(&buf, "Signed-By:\n")
+	}
+	for _, pubKey := range archiveInfo.PubKeys {
+		data, err := pgputil.EncodePubKey(pubKey)
+		if err != nil {
+			return "", fmt.Errorf("cannot encode key of archive %q: %w", archiveInfo.Name, err)
+		}
+		// Continuation lines in deb822 are indented, and empty ones are
+		// written as a single dot.
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if line == "" {
+				line = "."
+			}
+			fmt.Fprintf(&buf, " %s\n", line)
+		}
+	}
+	return buf.String(), nil
+}
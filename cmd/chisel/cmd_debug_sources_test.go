@@ -0,0 +1,129 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/pgputil"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var sourcesProChiselYaml = `
+	format: v1
+	archives:
+		ubuntu:
+			version: 22.04
+			components: [main, universe]
+			suites: [jammy, jammy-security]
+			priority: 10
+			public-keys: [test-key]
+		esm-apps:
+			version: 22.04
+			components: [main]
+			suites: [jammy-apps-security]
+			pro: esm-apps
+			priority: 20
+			public-keys: [test-key]
+	public-keys:
+		test-key:
+			id: ` + testKey.ID + `
+			armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t")
+
+type sourcesTest struct {
+	summary string
+	input   map[string]string
+	args    []string
+	stdout  string
+	err     string
+}
+
+var sourcesTests = []sourcesTest{{
+	summary: "Single archive",
+	input:   infoRelease,
+	args:    []string{"--arch", "amd64"},
+	stdout: `
+		# ubuntu
+		Types: deb
+		URIs: http://archive.ubuntu.com/ubuntu/
+		Suites: jammy
+		Components: main universe
+		Architectures: amd64
+		Signed-By:
+		<key>
+	`,
+}, {
+	summary: "Pro archives and ports",
+	input: map[string]string{
+		"chisel.yaml":        sourcesProChiselYaml,
+		"slices/mypkg.yaml":  "package: mypkg\n",
+		"slices/mypkg2.yaml": "package: mypkg2\n",
+	},
+	args: []string{"--arch", "arm64"},
+	stdout: `
+		# esm-apps
+		Types: deb
+		URIs: https://esm.ubuntu.com/apps/ubuntu/
+		Suites: jammy-apps-security
+		Components: main
+		Architectures: arm64
+		Signed-By:
+		<key>
+
+		# ubuntu
+		Types: deb
+		URIs: http://ports.ubuntu.com/ubuntu-ports/
+		Suites: jammy jammy-security
+		Components: main universe
+		Architectures: arm64
+		Signed-By:
+		<key>
+	`,
+}, {
+	summary: "Invalid architecture",
+	input:   infoRelease,
+	args:    []string{"--arch", "foo"},
+	err:     `invalid package architecture: foo`,
+}}
+
+func (s *ChiselSuite) TestSourcesCommand(c *C) {
+	armor, err := pgputil.EncodePubKey(testKey.PubKey)
+	c.Assert(err, IsNil)
+	var keyLines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(armor), "\n"), "\n") {
+		if line == "" {
+			line = "."
+		}
+		keyLines = append(keyLines, " "+line)
+	}
+	signedBy := strings.Join(keyLines, "\n")
+
+	for _, test := range sourcesTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		dir := c.MkDir()
+		for path, data := range test.input {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		args := append([]string{"debug", "sources", "--release", dir}, test.args...)
+
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		test.stdout = string(testutil.Reindent(test.stdout))
+		test.stdout = strings.ReplaceAll(test.stdout, "<key>", signedBy)
+		c.Assert(s.Stdout(), Equals, strings.TrimSpace(test.stdout)+"\n")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortWhyPackageHelp = "Explain why a package is selected"
+var longWhyPackageHelp = `
+The why-package command explains why a package is pulled into the
+selection made out of the provided slices.
+
+For every selected slice of the package it prints the chain of essential
+slices leading to it from one of the requested slices, followed by the
+archive the package is obtained from. Unless the package is pinned to an
+archive, this needs access to the archives to find the highest priority
+one which has the package.
+
+The --archive-priority, --archive-url and --cache-dir options change how
+the archives are opened, as they do for the cut command.
+`
+
+var whyPackageDescs = map[string]string{
+	"release":          "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":             "Package architecture",
+	"archive-priority": "Override the priority of an archive (e.g. staging=30)",
+	"archive-url":      "Fetch an archive from another URL (e.g. ubuntu=file:///srv/mirror)",
+	"cache-dir":        "Directory for caching fetched packages across runs",
+}
+
+type cmdWhyPackage struct {
+	Release           string   `long:"release" value-name:"<branch|dir>"`
+	Arch              string   `long:"arch" value-name:"<arch>"`
+	ArchivePriorities []string `long:"archive-priority" value-name:"<archive=priority>"`
+	ArchiveURLs       []string `long:"archive-url" value-name:"<archive=url>"`
+	CacheDir          string   `long:"cache-dir" value-name:"<dir>"`
+
+	Positional struct {
+		Package   string   `positional-arg-name:"<package>" required:"yes"`
+		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addDebugCommand("why-package", shortWhyPackageHelp, longWhyPackageHelp, func() flags.Commander { return &cmdWhyPackage{} }, whyPackageDescs, nil)
+}
+
+func (cmd *cmdWhyPackage) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	sliceKeys := make([]setup.SliceKey, len(cmd.Positional.SliceRefs))
+	for i, sliceRef := range cmd.Positional.SliceRefs {
+		sliceKey, err := setup.ParseSliceKey(sliceRef)
+		if err != nil {
+			return err
+		}
+		sliceKeys[i] = sliceKey
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+
+	chains, err := whyPackage(release, sliceKeys, cmd.Positional.Package)
+	if err != nil {
+		return err
+	}
+
+	source, err := newArchiveSource(release, cmd.ArchivePriorities, cmd.ArchiveURLs, cmd.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	pkg := release.Packages[cmd.Positional.Package]
+	archiveName := pkg.Archive
+	if archiveName == "" {
+		pkgArchive, err := newPackageArchives(release, cmd.Arch, source).find(pkg.Name)
+		if err != nil {
+			return err
+		}
+		archiveName = pkgArchive.Options().Label
+	}
+
+	fmt.Fprintf(Stdout, "package: %s\n", cmd.Positional.Package)
+	fmt.Fprintf(Stdout, "slices:\n")
+	for _, chain := range chains {
+		names := make([]string, len(chain))
+		for i, key := range chain {
+			names[i] = key.String()
+		}
+		fmt.Fprintf(Stdout, "  %s: %s\n", names[len(names)-1], strings.Join(names, " -> "))
+	}
+	if pkg.Archive != "" {
+		fmt.Fprintf(Stdout, "archive: %s (pinned)\n", archiveName)
+	} else {
+		fmt.Fprintf(Stdout, "archive: %s (highest priority with the package)\n", archiveName)
+	}
+	return nil
+}
+
+// whyPackage returns, for every selected slice of pkgName, the shortest chain
+// of essential slices starting at one of the requested keys and ending at
+// that slice. Chains are sorted by the slice they lead to.
+func whyPackage(release *setup.Release, keys []setup.SliceKey, pkgName string) ([][]setup.SliceKey, error) {
+	if _, ok := release.Packages[pkgName]; !ok {
+		return nil, fmt.Errorf("slices of package %q not found", pkgName)
+	}
+	// Validate the selection as cut would do.
+	_, err := setup.Select(release, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Breadth-first walk over the essentials, so the first path reaching a
+	// slice is also the shortest one.
+	parent := make(map[setup.SliceKey]setup.SliceKey)
+	seen := make(map[setup.SliceKey]bool)
+	pending := append([]setup.SliceKey(nil), keys...)
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for i := 0; i < len(pending); i++ {
+		key := pending[i]
+		slice := release.Packages[key.Package].Slices[key.Slice]
+		for _, req := range slice.Essential {
+			if seen[req] {
+				continue
+			}
+			seen[req] = true
+			parent[req] = key
+			pending = append(pending, req)
+		}
+	}
+
+	var chains [][]setup.SliceKey
+	for _, key := range pending {
+		if key.Package != pkgName {
+			continue
+		}
+		chain := []setup.SliceKey{key}
+		for {
+			p, ok := parent[chain[0]]
+			if !ok {
+				break
+			}
+			chain = append([]setup.SliceKey{p}, chain...)
+		}
+		chains = append(chains, chain)
+	}
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("package %q is not selected", pkgName)
+	}
+	slices.SortFunc(chains, func(a, b []setup.SliceKey) int {
+		return strings.Compare(a[len(a)-1].String(), b[len(b)-1].String())
+	})
+	return chains, nil
+}
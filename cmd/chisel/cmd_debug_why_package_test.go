@@ -0,0 +1,173 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type whyPackageTest struct {
+	summary  string
+	input    map[string]string
+	archives map[string][]string
+	args     []string
+	stdout   string
+	err      string
+}
+
+var whyPackageArchivesYaml = `
+	format: v1
+	archives:
+		ubuntu:
+			version: 22.04
+			components: [main, universe]
+			suites: [jammy]
+			priority: 20
+			public-keys: [test-key]
+		other:
+			version: 22.04
+			components: [main]
+			suites: [jammy]
+			priority: 10
+			public-keys: [test-key]
+	public-keys:
+		test-key:
+			id: ` + testKey.ID + `
+			armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t")
+
+var whyPackageTests = []whyPackageTest{{
+	summary: "Package required through an essential",
+	input:   infoRelease,
+	args:    []string{"mypkg2", "mypkg1_myslice2"},
+	stdout: `
+		package: mypkg2
+		slices:
+		  mypkg2_myslice: mypkg1_myslice2 -> mypkg2_myslice
+		archive: ubuntu (highest priority with the package)
+	`,
+}, {
+	summary: "Package of a requested slice",
+	input:   infoRelease,
+	args:    []string{"mypkg1", "mypkg1_myslice2"},
+	stdout: `
+		package: mypkg1
+		slices:
+		  mypkg1_myslice1: mypkg1_myslice2 -> mypkg1_myslice1
+		  mypkg1_myslice2: mypkg1_myslice2
+		archive: ubuntu (highest priority with the package)
+	`,
+}, {
+	summary: "Shortest chain is reported",
+	input:   infoRelease,
+	args:    []string{"mypkg2", "mypkg3_myslice", "mypkg1_myslice2"},
+	stdout: `
+		package: mypkg2
+		slices:
+		  mypkg2_myslice: mypkg3_myslice -> mypkg2_myslice
+		archive: ubuntu (highest priority with the package)
+	`,
+}, {
+	summary: "Package only in the lower priority archive",
+	input: map[string]string{
+		"chisel.yaml":        whyPackageArchivesYaml,
+		"slices/mypkg1.yaml": infoRelease["slices/mypkg1.yaml"],
+		"slices/mypkg2.yaml": infoRelease["slices/mypkg2.yaml"],
+	},
+	archives: map[string][]string{
+		"ubuntu": {"mypkg1"},
+		"other":  {"mypkg1", "mypkg2"},
+	},
+	args: []string{"mypkg2", "mypkg1_myslice2"},
+	stdout: `
+		package: mypkg2
+		slices:
+		  mypkg2_myslice: mypkg1_myslice2 -> mypkg2_myslice
+		archive: other (highest priority with the package)
+	`,
+}, {
+	summary: "Package in both archives",
+	input: map[string]string{
+		"chisel.yaml":        whyPackageArchivesYaml,
+		"slices/mypkg1.yaml": infoRelease["slices/mypkg1.yaml"],
+		"slices/mypkg2.yaml": infoRelease["slices/mypkg2.yaml"],
+	},
+	archives: map[string][]string{
+		"ubuntu": {"mypkg1", "mypkg2"},
+		"other":  {"mypkg1", "mypkg2"},
+	},
+	args: []string{"mypkg2", "mypkg1_myslice2"},
+	stdout: `
+		package: mypkg2
+		slices:
+		  mypkg2_myslice: mypkg1_myslice2 -> mypkg2_myslice
+		archive: ubuntu (highest priority with the package)
+	`,
+}, {
+	summary: "Package in no archive",
+	input:   infoRelease,
+	archives: map[string][]string{
+		"ubuntu": {"mypkg1"},
+	},
+	args: []string{"mypkg2", "mypkg1_myslice2"},
+	err:  `cannot find package "mypkg2" in archive\(s\)`,
+}, {
+	summary: "Package not selected",
+	input:   infoRelease,
+	args:    []string{"mypkg3", "mypkg1_myslice1"},
+	err:     `package "mypkg3" is not selected`,
+}, {
+	summary: "Package not in release",
+	input:   infoRelease,
+	args:    []string{"foo", "mypkg1_myslice1"},
+	err:     `slices of package "foo" not found`,
+}, {
+	summary: "Slice not in release",
+	input:   infoRelease,
+	args:    []string{"mypkg1", "mypkg1_foo"},
+	err:     `slice mypkg1_foo not found`,
+}}
+
+func (s *ChiselSuite) TestWhyPackageCommand(c *C) {
+	for _, test := range whyPackageTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		if test.archives == nil {
+			test.archives = map[string][]string{"ubuntu": {"mypkg1", "mypkg2", "mypkg3"}}
+		}
+		restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+			versions := make(map[string]string)
+			for _, pkg := range test.archives[options.Label] {
+				versions[pkg] = "1.0"
+			}
+			return &fakeArchive{options: *options, versions: versions}, nil
+		})
+		defer restore()
+
+		dir := c.MkDir()
+		for path, data := range test.input {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		args := append([]string{"debug", "why-package", "--release", dir}, test.args...)
+
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		test.stdout = string(testutil.Reindent(test.stdout))
+		c.Assert(s.Stdout(), Equals, strings.TrimSpace(test.stdout)+"\n")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/deb"
+)
+
+var shortExtractHelp = "Extract paths from a package without slicing it"
+var longExtractHelp = `
+The extract command fetches a package from the archives of the release, as
+the cut command does, and extracts the given paths from it into the root
+directory, without going through any slice definition.
+
+Every path is given as <source>:<target>, where source is the path in the
+package and target the path it is written to under the root directory. The
+target may be omitted when it is the same as the source. Directories ending
+in "/" are extracted with all their content, and wildcards may be used when
+the target is the same as the source.
+
+The --archive-priority, --archive-url and --cache-dir options change how
+the archives are opened, as they do for the cut command.
+`
+
+var extractDescs = map[string]string{
+	"release":          "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"root":             "Root for the extracted paths",
+	"arch":             "Package architecture",
+	"package":          "Package to extract the paths from",
+	"archive-priority": "Override the priority of an archive (e.g. staging=30)",
+	"archive-url":      "Fetch an archive from another URL (e.g. ubuntu=file:///srv/mirror)",
+	"cache-dir":        "Directory for caching fetched packages across runs",
+}
+
+type cmdExtract struct {
+	Release           string   `long:"release" value-name:"<branch|dir>"`
+	RootDir           string   `long:"root" value-name:"<dir>" required:"yes"`
+	Arch              string   `long:"arch" value-name:"<arch>"`
+	Package           string   `long:"package" value-name:"<pkg>" required:"yes"`
+	ArchivePriorities []string `long:"archive-priority" value-name:"<archive=priority>"`
+	ArchiveURLs       []string `long:"archive-url" value-name:"<archive=url>"`
+	CacheDir          string   `long:"cache-dir" value-name:"<dir>"`
+
+	Positional struct {
+		Paths []string `positional-arg-name:"<source[:target]>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("extract", shortExtractHelp, longExtractHelp, func() flags.Commander { return &cmdExtract{} }, extractDescs, nil)
+}
+
+func (cmd *cmdExtract) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	extract := make(map[string][]deb.ExtractInfo)
+	for _, arg := range cmd.Positional.Paths {
+		source, target, err := parseExtractPath(arg)
+		if err != nil {
+			return err
+		}
+		extract[source] = append(extract[source], deb.ExtractInfo{
+			Path:      target,
+			Recursive: strings.HasSuffix(source, "/"),
+		})
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+	source, err := newArchiveSource(release, cmd.ArchivePriorities, cmd.ArchiveURLs, cmd.CacheDir)
+	if err != nil {
+		return err
+	}
+	archives := newPackageArchives(release, cmd.Arch, source)
+	pkgArchive, err := archives.find(cmd.Package)
+	if err != nil {
+		return err
+	}
+	reader, _, err := pkgArchive.Fetch(cmd.Package)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return deb.Extract(reader, &deb.ExtractOptions{
+		Package:   cmd.Package,
+		TargetDir: cmd.RootDir,
+		Extract:   extract,
+	})
+}
+
+// parseExtractPath parses a <source>[:<target>] argument of the extract
+// command, where both paths are absolute.
+func parseExtractPath(arg string) (source, target string, err error) {
+	source, target, ok := strings.Cut(arg, ":")
+	if !ok {
+		target = source
+	}
+	for _, p := range []string{source, target} {
+		if !path.IsAbs(p) {
+			return "", "", fmt.Errorf("invalid path %q: paths must be absolute", arg)
+		}
+	}
+	if strings.HasSuffix(source, "/") != strings.HasSuffix(target, "/") {
+		return "", "", fmt.Errorf("invalid path %q: source and target must both end in / to extract a directory", arg)
+	}
+	return source, target, nil
+}
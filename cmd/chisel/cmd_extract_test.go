@@ -0,0 +1,93 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var extractTests = []struct {
+	summary string
+	args    []string
+	files   map[string]string
+	err     string
+}{{
+	summary: "Extract two files",
+	args:    []string{"--package", "mypkg1", "/dir/file", "/dir/nested/file:/out/nested-file"},
+	files: map[string]string{
+		"/dir/file":        "12u3q0wej\tajsd",
+		"/out/nested-file": "0jqei",
+	},
+}, {
+	summary: "Extract a directory",
+	args:    []string{"--package", "mypkg1", "/dir/nested/:/nested/"},
+	files: map[string]string{
+		"/nested/file":       "0jqei",
+		"/nested/other-file": "1",
+	},
+}, {
+	summary: "Missing path",
+	args:    []string{"--package", "mypkg1", "/dir/missing"},
+	err:     `cannot extract from package "mypkg1": no content at /dir/missing`,
+}, {
+	summary: "Relative path",
+	args:    []string{"--package", "mypkg1", "/dir/file:out/file"},
+	err:     `invalid path "/dir/file:out/file": paths must be absolute`,
+}, {
+	summary: "Directory and file",
+	args:    []string{"--package", "mypkg1", "/dir/nested/:/nested"},
+	err:     `invalid path "/dir/nested/:/nested": source and target must both end in / to extract a directory`,
+}, {
+	summary: "Unknown package",
+	args:    []string{"--package", "mypkg4", "/dir/file"},
+	err:     `cannot find package "mypkg4" in archive\(s\)`,
+}}
+
+func (s *ChiselSuite) TestExtractCommand(c *C) {
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg1": "1.1"},
+			debs:     map[string][]byte{"mypkg1": testutil.PackageData["test-package"]},
+		}, nil
+	})
+	defer restore()
+
+	releaseDir := c.MkDir()
+	for path, data := range infoRelease {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	for _, test := range extractTests {
+		c.Logf("Summary: %s", test.summary)
+
+		rootDir := c.MkDir()
+		args := append([]string{"extract", "--release", releaseDir, "--arch", "amd64", "--root", rootDir}, test.args...)
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+
+		files := make(map[string]string)
+		err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+			c.Assert(err, IsNil)
+			if info.Mode().IsRegular() {
+				data, err := os.ReadFile(path)
+				c.Assert(err, IsNil)
+				files["/"+filepath.ToSlash(path[len(rootDir)+1:])] = string(data)
+			}
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(files, DeepEquals, test.files)
+	}
+}
@@ -17,16 +17,30 @@ var longFindHelp = `
 The find command queries the slice definitions for matching slices.
 Globs (* and ?) are allowed in the query.
 
+Query strings starting with a slash are matched against the paths in the
+slice contents instead, so that the slices which would install a given
+path are found. Globs (*, ? and **) are allowed both in the query and in
+the slice definitions, and every matching content entry is shown along
+with its kind.
+
 By default it fetches the slices for the same Ubuntu version as the
 current host, unless the --release flag is used.
+
+The --section flag only keeps the slices of packages listed under the
+given section in the archive index (e.g. libs). Sections prefixed with
+a component, as in universe/libs, match as well.
 `
 
 var findDescs = map[string]string{
 	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"section": "Only show slices of packages in the archive section",
+	"arch":    "Package architecture used with --section",
 }
 
 type cmdFind struct {
 	Release string `long:"release" value-name:"<branch|dir>"`
+	Section string `long:"section" value-name:"<section>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
 
 	Positional struct {
 		Query []string `positional-arg-name:"<query>" required:"yes"`
@@ -47,15 +61,54 @@ func (cmd *cmdFind) Execute(args []string) error {
 		return err
 	}
 
-	slices, err := findSlices(release, cmd.Positional.Query)
+	var nameQuery, pathQuery []string
+	for _, term := range cmd.Positional.Query {
+		if strings.HasPrefix(term, "/") {
+			pathQuery = append(pathQuery, term)
+		} else {
+			nameQuery = append(nameQuery, term)
+		}
+	}
+
+	slices, err := findSlices(release, nameQuery)
 	if err != nil {
 		return err
 	}
+	if cmd.Section != "" {
+		archives := newPackageArchives(release, cmd.Arch, nil)
+		slices, err = filterBySection(slices, cmd.Section, func(pkgName string) (string, error) {
+			pkgArchive, err := archives.find(pkgName)
+			if err != nil {
+				return "", err
+			}
+			info, err := pkgArchive.Info(pkgName)
+			if err != nil {
+				return "", err
+			}
+			return info.Section, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var paths []*pathMatch
+	if len(pathQuery) > 0 {
+		slices, paths = findPaths(slices, pathQuery)
+	}
 	if len(slices) == 0 {
 		fmt.Fprintf(Stderr, "No matching slices for \"%s\"\n", strings.Join(cmd.Positional.Query, " "))
 		return nil
 	}
 
+	if len(pathQuery) > 0 {
+		w := tabWriter()
+		fmt.Fprintf(w, "Slice\tPath\tKind\n")
+		for _, p := range paths {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.slice, p.path, p.kind)
+		}
+		return w.Flush()
+	}
+
 	w := tabWriter()
 	fmt.Fprintf(w, "Slice\tSummary\n")
 	for _, s := range slices {
@@ -109,6 +162,84 @@ func findSlices(release *setup.Release, query []string) (slices []*setup.Slice,
 	return slices, nil
 }
 
+// filterBySection returns the slices whose package is in the given archive
+// section, as reported by pkgSection. A section prefixed by a component,
+// such as "universe/libs", matches "libs" too.
+func filterBySection(slices []*setup.Slice, section string, pkgSection func(pkgName string) (string, error)) ([]*setup.Slice, error) {
+	sections := make(map[string]string)
+	filtered := []*setup.Slice{}
+	for _, slice := range slices {
+		pkgSec, ok := sections[slice.Package]
+		if !ok {
+			var err error
+			pkgSec, err = pkgSection(slice.Package)
+			if err != nil {
+				return nil, err
+			}
+			sections[slice.Package] = pkgSec
+		}
+		if pkgSec == section || strings.HasSuffix(pkgSec, "/"+section) {
+			filtered = append(filtered, slice)
+		}
+	}
+	return filtered, nil
+}
+
+type pathMatch struct {
+	slice *setup.Slice
+	path  string
+	kind  setup.PathKind
+}
+
+// findPaths returns the slices with content paths matching each of the
+// query paths, along with the matching content entries. Globs are
+// supported on both sides, as in matchPath.
+func findPaths(slices []*setup.Slice, query []string) ([]*setup.Slice, []*pathMatch) {
+	var found []*setup.Slice
+	var matches []*pathMatch
+	for _, slice := range slices {
+		var sliceMatches []*pathMatch
+		allMatch := true
+		for _, term := range query {
+			termMatch := false
+			for path, info := range slice.Contents {
+				if matchPath(term, path) {
+					termMatch = true
+					sliceMatches = append(sliceMatches, &pathMatch{slice, path, info.Kind})
+				}
+			}
+			if !termMatch {
+				allMatch = false
+				break
+			}
+		}
+		if !allMatch {
+			continue
+		}
+		found = append(found, slice)
+		sort.Slice(sliceMatches, func(i, j int) bool {
+			return sliceMatches[i].path < sliceMatches[j].path
+		})
+		for i, m := range sliceMatches {
+			if i > 0 && m.path == sliceMatches[i-1].path {
+				// Matched by several query paths.
+				continue
+			}
+			matches = append(matches, m)
+		}
+	}
+	return found, matches
+}
+
+// matchPath reports whether the query path matches the content path. The
+// trailing slash of directories may be left out in the query.
+func matchPath(query, path string) bool {
+	if strdist.GlobPath(query, path) {
+		return true
+	}
+	return strings.HasSuffix(path, "/") && !strings.HasSuffix(query, "/") && strdist.GlobPath(query+"/", path)
+}
+
 func tabWriter() *tabwriter.Writer {
 	return tabwriter.NewWriter(Stdout, 5, 3, 2, ' ', 0)
 }
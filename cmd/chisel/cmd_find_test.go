@@ -1,6 +1,11 @@
 package main_test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/setup"
@@ -136,3 +141,125 @@ func (s *ChiselSuite) TestFindSlices(c *C) {
 		}
 	}
 }
+
+func (s *ChiselSuite) TestFilterBySection(c *C) {
+	slices, err := chisel.FindSlices(sampleRelease, []string{"*"})
+	c.Assert(err, IsNil)
+	sections := map[string]string{
+		"openjdk-8-jdk": "java",
+		"python3.10":    "universe/libs",
+	}
+	var looked []string
+	pkgSection := func(pkgName string) (string, error) {
+		looked = append(looked, pkgName)
+		return sections[pkgName], nil
+	}
+
+	filtered, err := chisel.FilterBySection(slices, "libs", pkgSection)
+	c.Assert(err, IsNil)
+	c.Assert(len(filtered) > 0, Equals, true)
+	for _, slice := range filtered {
+		c.Assert(slice.Package, Equals, "python3.10")
+	}
+	// Each package is looked up only once.
+	c.Assert(looked, DeepEquals, []string{"openjdk-8-jdk", "python3.10"})
+
+	filtered, err = chisel.FilterBySection(slices, "universe/libs", pkgSection)
+	c.Assert(err, IsNil)
+	c.Assert(len(filtered) > 0, Equals, true)
+
+	filtered, err = chisel.FilterBySection(slices, "admin", pkgSection)
+	c.Assert(err, IsNil)
+	c.Assert(filtered, DeepEquals, []*setup.Slice{})
+
+	_, err = chisel.FilterBySection(slices, "libs", func(pkgName string) (string, error) {
+		return "", fmt.Errorf("cannot find package %q in archive(s)", pkgName)
+	})
+	c.Assert(err, ErrorMatches, `cannot find package "openjdk-8-jdk" in archive\(s\)`)
+}
+
+var findPathsTests = []struct {
+	summary string
+	query   []string
+	stdout  string
+	stderr  string
+}{{
+	summary: "Literal path",
+	query:   []string{"/dir/file"},
+	stdout: `
+		Slice            Path       Kind
+		mypkg1_myslice1  /dir/file  copy
+	`,
+}, {
+	summary: "Literal path matching a glob in the definitions",
+	query:   []string{"/dir/glob-file"},
+	stdout: `
+		Slice           Path        Kind
+		mypkg3_myslice  /dir/glob*  glob
+	`,
+}, {
+	summary: "Glob query restricted by package",
+	query:   []string{"mypkg3", "/dir/s*"},
+	stdout: `
+		Slice           Path           Kind
+		mypkg3_myslice  /dir/sub-dir/  dir
+		mypkg3_myslice  /dir/symlink   symlink
+	`,
+}, {
+	summary: "Directory without trailing slash",
+	query:   []string{"/dir/sub-dir"},
+	stdout: `
+		Slice           Path           Kind
+		mypkg3_myslice  /dir/sub-dir/  dir
+	`,
+}, {
+	summary: "Double star query",
+	query:   []string{"/**/*-file"},
+	stdout: `
+		Slice           Path                 Kind
+		mypkg2_myslice  /dir/another-file    copy
+		mypkg3_myslice  /dir/arch-specific*  glob
+		mypkg3_myslice  /dir/glob*           glob
+		mypkg3_myslice  /dir/other-file      copy
+	`,
+}, {
+	summary: "Several paths must all match",
+	query:   []string{"/dir/mutable", "/dir/until"},
+	stdout: `
+		Slice           Path          Kind
+		mypkg3_myslice  /dir/mutable  text
+		mypkg3_myslice  /dir/until    copy
+	`,
+}, {
+	summary: "No matches",
+	query:   []string{"/other/**"},
+	stderr:  "No matching slices for \"/other/**\"\n",
+}}
+
+func (s *ChiselSuite) TestFindPathsCommand(c *C) {
+	dir := c.MkDir()
+	for path, data := range infoRelease {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	for _, test := range findPathsTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		args := append([]string{"find", "--release", dir}, test.query...)
+		_, err := chisel.Parser().ParseArgs(args)
+		c.Assert(err, IsNil)
+		if test.stdout == "" {
+			c.Assert(s.Stdout(), Equals, "")
+		} else {
+			stdout := string(testutil.Reindent(test.stdout))
+			c.Assert(s.Stdout(), Equals, strings.TrimSpace(stdout)+"\n")
+		}
+		c.Assert(s.Stderr(), Equals, test.stderr)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -22,14 +23,19 @@ the output is a list of YAML documents separated by a "---" line.
 
 Slice definitions are shown verbatim according to their definition in
 the selected release. For example, globs are not expanded.
+
+The --resolve-essential option also shows the slices which are required
+by the selected ones through their essential lists, transitively.
 `
 
 var infoDescs = map[string]string{
-	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"release":           "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"resolve-essential": "Also show the slices required by the selected ones",
 }
 
 type infoCmd struct {
-	Release string `long:"release" value-name:"<branch|dir>"`
+	Release          string `long:"release" value-name:"<branch|dir>"`
+	ResolveEssential bool   `long:"resolve-essential"`
 
 	Positional struct {
 		Queries []string `positional-arg-name:"<pkg|slice>" required:"yes"`
@@ -51,6 +57,10 @@ func (cmd *infoCmd) Execute(args []string) error {
 	}
 
 	packages, notFound := selectPackageSlices(release, cmd.Positional.Queries)
+	if cmd.ResolveEssential {
+		queries := append(cmd.Positional.Queries, essentialClosure(release, packages)...)
+		packages, _ = selectPackageSlices(release, queries)
+	}
 
 	for i, pkg := range packages {
 		data, err := yaml.Marshal(pkg)
@@ -73,6 +83,37 @@ func (cmd *infoCmd) Execute(args []string) error {
 	return nil
 }
 
+// essentialClosure returns the names of the slices of the release required
+// by the slices in packages through their essential lists, transitively,
+// which are not in packages already.
+func essentialClosure(release *setup.Release, packages []*setup.Package) []string {
+	seen := make(map[setup.SliceKey]bool)
+	var pending []setup.SliceKey
+	for _, pkg := range packages {
+		for _, slice := range pkg.Slices {
+			seen[setup.SliceKey{Package: slice.Package, Slice: slice.Name}] = true
+			pending = append(pending, slice.Essential...)
+		}
+	}
+	var required []string
+	for len(pending) > 0 {
+		key := pending[0]
+		pending = pending[1:]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		required = append(required, key.String())
+		if pkg, ok := release.Packages[key.Package]; ok {
+			if slice, ok := pkg.Slices[key.Slice]; ok {
+				pending = append(pending, slice.Essential...)
+			}
+		}
+	}
+	sort.Strings(required)
+	return required
+}
+
 // selectPackageSlices takes in a release and a list of query strings
 // of package names and/or slice names, and returns a list of packages
 // containing the found slices. It also returns a list of query
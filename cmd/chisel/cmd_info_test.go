@@ -92,6 +92,64 @@ var infoTests = []infoTest{{
 					- mypkg1_myslice1
 					- mypkg2_myslice
 	`,
+}, {
+	summary: "Resolve essential slices transitively",
+	input:   infoRelease,
+	query:   []string{"--resolve-essential", "mypkg3_myslice"},
+	stdout: `
+		package: mypkg3
+		slices:
+			myslice:
+				essential:
+					- mypkg1_myslice1
+					- mypkg2_myslice
+				contents:
+					/dir/arch-specific*: {arch: [amd64, arm64, i386]}
+					/dir/copy: {copy: /dir/file}
+					/dir/glob*: {}
+					/dir/mutable: {text: TODO, mutable: true, arch: riscv64}
+					/dir/other-file: {}
+					/dir/sub-dir/: {make: true, mode: 0644}
+					/dir/symlink: {symlink: /dir/file}
+					/dir/unfolded: {mode: 0644, copy: /dir/file}
+					/dir/until: {until: mutate}
+				mutate: |
+					# Test multi-line string.
+					content.write("/dir/mutable", foo)
+		---
+		package: mypkg1
+		slices:
+			myslice1:
+				contents:
+					/dir/file: {}
+		---
+		package: mypkg2
+		slices:
+			myslice:
+				contents:
+					/dir/another-file: {}
+	`,
+}, {
+	summary: "Resolve essential slices of other slices too",
+	input:   infoRelease,
+	query:   []string{"--resolve-essential", "mypkg1_myslice2"},
+	stdout: `
+		package: mypkg1
+		slices:
+			myslice1:
+				contents:
+					/dir/file: {}
+			myslice2:
+				essential:
+					- mypkg1_myslice1
+					- mypkg2_myslice
+		---
+		package: mypkg2
+		slices:
+			myslice:
+				contents:
+					/dir/another-file: {}
+	`,
 }, {
 	summary: "Same slice appearing multiple times",
 	input:   infoRelease,
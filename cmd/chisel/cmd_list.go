@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var shortListHelp = "List the content of a Chisel manifest"
+var longListHelp = `
+The list command shows what was installed according to the Chisel manifest
+at the provided location, such as root/var/lib/chisel/manifest.wall.
+
+By default it lists the installed slices. The --paths option lists the
+installed paths instead, and --sort=size lists the files ordered by size,
+largest first, followed by their total size.
+`
+
+var listDescs = map[string]string{
+	"manifest": "Path of the Chisel manifest file",
+	"paths":    "List the installed paths instead of the slices",
+	"sort":     "Order of the listed paths (path or size)",
+}
+
+type cmdList struct {
+	Manifest string `long:"manifest" value-name:"<file>" required:"yes"`
+	Paths    bool   `long:"paths"`
+	Sort     string `long:"sort" value-name:"<order>" choice:"path" choice:"size"`
+}
+
+func init() {
+	addCommand("list", shortListHelp, longListHelp, func() flags.Commander { return &cmdList{} }, listDescs, nil)
+}
+
+func (cmd *cmdList) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if cmd.Sort != "" && !cmd.Paths {
+		return fmt.Errorf("--sort requires --paths")
+	}
+
+	mfest, err := readManifest(cmd.Manifest)
+	if err != nil {
+		return err
+	}
+
+	if !cmd.Paths {
+		return mfest.IterateSlices("", func(slice *manifest.Slice) error {
+			fmt.Fprintln(Stdout, slice.Name)
+			return nil
+		})
+	}
+
+	var paths []*manifest.Path
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if cmd.Sort != "size" {
+		for _, path := range paths {
+			fmt.Fprintln(Stdout, path.Path)
+		}
+		return nil
+	}
+
+	// Directories have no size of their own, so they are left out.
+	files := paths[:0]
+	for _, path := range paths {
+		if !strings.HasSuffix(path.Path, "/") {
+			files = append(files, path)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Size > files[j].Size
+	})
+	var total uint64
+	w := tabWriter()
+	fmt.Fprintf(w, "Size\tPath\n")
+	for _, file := range files {
+		fmt.Fprintf(w, "%d\t%s\n", file.Size, file.Path)
+		total += file.Size
+	}
+	fmt.Fprintf(w, "%d\t%s\n", total, "total")
+	return w.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var shortListSlicesHelp = "List the slices defined in a release"
+var longListSlicesHelp = `
+The list-slices command lists, one per line, every slice defined in the
+release in the <package>_<slice> form. Unlike the list command, it does not
+look at what was installed but at what the release offers.
+
+When a pattern is provided, only the slices whose names match it are
+listed. Globs (* and ?) are allowed in the pattern, as in "libc6_*".
+
+The --packages option lists the package names instead, which the pattern
+is then matched against. With --format=json, the entries are printed as a
+JSON array of objects with the package, the slice and the archive the
+package is pinned to, if any.
+
+By default it fetches the slices for the same Ubuntu version as the
+current host, unless the --release flag is used.
+`
+
+var listSlicesDescs = map[string]string{
+	"release":  "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"packages": "List the package names instead of the slices",
+	"format":   "Output format: text or json",
+}
+
+type cmdListSlices struct {
+	Release  string `long:"release" value-name:"<branch|dir>"`
+	Packages bool   `long:"packages"`
+	Format   string `long:"format" value-name:"<format>" choice:"text" choice:"json" default:"text"`
+
+	Positional struct {
+		Pattern string `positional-arg-name:"<pattern>"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("list-slices", shortListSlicesHelp, longListSlicesHelp, func() flags.Commander { return &cmdListSlices{} }, listSlicesDescs, nil)
+}
+
+type jsonListEntry struct {
+	Package string `json:"package"`
+	Slice   string `json:"slice,omitempty"`
+	Archive string `json:"archive,omitempty"`
+}
+
+func (cmd *cmdListSlices) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	pattern := cmd.Positional.Pattern
+	if pattern != "" {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if err != nil {
+		return err
+	}
+
+	matches := func(name string) bool {
+		if pattern == "" {
+			return true
+		}
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	entries := []jsonListEntry{}
+	for _, pkg := range release.Packages {
+		if cmd.Packages {
+			if matches(pkg.Name) {
+				entries = append(entries, jsonListEntry{Package: pkg.Name, Archive: pkg.Archive})
+			}
+			continue
+		}
+		for _, slice := range pkg.Slices {
+			if matches(slice.String()) {
+				entries = append(entries, jsonListEntry{Package: pkg.Name, Slice: slice.Name, Archive: pkg.Archive})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Package != entries[j].Package {
+			return entries[i].Package < entries[j].Package
+		}
+		return entries[i].Slice < entries[j].Slice
+	})
+
+	if cmd.Format == "json" {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "%s\n", data)
+		return nil
+	}
+	for _, entry := range entries {
+		if cmd.Packages {
+			fmt.Fprintln(Stdout, entry.Package)
+		} else {
+			fmt.Fprintln(Stdout, entry.Package+"_"+entry.Slice)
+		}
+	}
+	return nil
+}
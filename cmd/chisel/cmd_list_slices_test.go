@@ -0,0 +1,107 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var listSlicesTests = []struct {
+	summary string
+	args    []string
+	stdout  string
+	err     string
+}{{
+	summary: "No pattern lists all slices",
+	args:    []string{},
+	stdout:  "mypkg1_myslice1\nmypkg1_myslice2\nmypkg2_myslice\nmypkg3_myslice\n",
+}, {
+	summary: "Package glob",
+	args:    []string{"mypkg1_*"},
+	stdout:  "mypkg1_myslice1\nmypkg1_myslice2\n",
+}, {
+	summary: "Slice glob",
+	args:    []string{"*_myslice"},
+	stdout:  "mypkg2_myslice\nmypkg3_myslice\n",
+}, {
+	summary: "Single character glob",
+	args:    []string{"mypkg1_myslice?"},
+	stdout:  "mypkg1_myslice1\nmypkg1_myslice2\n",
+}, {
+	summary: "Exact name",
+	args:    []string{"mypkg2_myslice"},
+	stdout:  "mypkg2_myslice\n",
+}, {
+	summary: "No matches",
+	args:    []string{"foo*"},
+	stdout:  "",
+}, {
+	summary: "Packages",
+	args:    []string{"--packages"},
+	stdout:  "mypkg1\nmypkg2\nmypkg3\n",
+}, {
+	summary: "Packages matching a pattern",
+	args:    []string{"--packages", "*[12]"},
+	stdout:  "mypkg1\nmypkg2\n",
+}, {
+	summary: "JSON format",
+	args:    []string{"--format=json", "mypkg1_*"},
+	stdout: `[
+  {
+    "package": "mypkg1",
+    "slice": "myslice1"
+  },
+  {
+    "package": "mypkg1",
+    "slice": "myslice2"
+  }
+]
+`,
+}, {
+	summary: "JSON format with packages",
+	args:    []string{"--format=json", "--packages", "mypkg3"},
+	stdout: `[
+  {
+    "package": "mypkg3"
+  }
+]
+`,
+}, {
+	summary: "JSON format without matches",
+	args:    []string{"--format=json", "foo*"},
+	stdout:  "[]\n",
+}, {
+	summary: "Invalid pattern",
+	args:    []string{"mypkg1_[*"},
+	err:     `invalid pattern "mypkg1_\[\*": syntax error in pattern`,
+}}
+
+func (s *ChiselSuite) TestListSlicesCommand(c *C) {
+	dir := c.MkDir()
+	for path, data := range infoRelease {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	for _, test := range listSlicesTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		args := append([]string{"list-slices", "--release", dir}, test.args...)
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(s.Stdout(), Equals, test.stdout)
+	}
+}
@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var listManifest = `
+	{"jsonwall":"1.0","schema":"1.0","count":10}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/bar"}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/foo"}
+	{"kind":"content","slice":"mypkg_config","path":"/etc/foo.conf"}
+	{"kind":"package","name":"mypkg","version":"1.0","sha256":"hash","arch":"amd64"}
+	{"kind":"path","path":"/etc/foo.conf","mode":"0644","slices":["mypkg_config"],"sha256":"h1","size":20}
+	{"kind":"path","path":"/usr/bin/","mode":"0755","slices":["mypkg_bins"]}
+	{"kind":"path","path":"/usr/bin/bar","mode":"0755","slices":["mypkg_bins"],"sha256":"h2","size":300}
+	{"kind":"path","path":"/usr/bin/foo","mode":"0755","slices":["mypkg_bins"],"sha256":"h3","size":4000}
+	{"kind":"slice","name":"mypkg_bins"}
+	{"kind":"slice","name":"mypkg_config"}
+`
+
+var listTests = []struct {
+	summary string
+	args    []string
+	stdout  string
+	err     string
+}{{
+	summary: "Slices are listed by default",
+	args:    []string{},
+	stdout:  "mypkg_bins\nmypkg_config\n",
+}, {
+	summary: "Paths",
+	args:    []string{"--paths"},
+	stdout:  "/etc/foo.conf\n/usr/bin/\n/usr/bin/bar\n/usr/bin/foo\n",
+}, {
+	summary: "Paths sorted by path",
+	args:    []string{"--paths", "--sort=path"},
+	stdout:  "/etc/foo.conf\n/usr/bin/\n/usr/bin/bar\n/usr/bin/foo\n",
+}, {
+	summary: "Paths sorted by size",
+	args:    []string{"--paths", "--sort=size"},
+	stdout: `
+		Size  Path
+		4000  /usr/bin/foo
+		300   /usr/bin/bar
+		20    /etc/foo.conf
+		4320  total
+	`,
+}, {
+	summary: "Sort requires paths",
+	args:    []string{"--sort=size"},
+	err:     "--sort requires --paths",
+}, {
+	summary: "Unknown sort order",
+	args:    []string{"--paths", "--sort=mtime"},
+	err:     "Invalid value `mtime' for option `--sort'. Allowed values are: path or size",
+}}
+
+func (s *ChiselSuite) TestListCommand(c *C) {
+	manifestPath := filepath.Join(c.MkDir(), "manifest.wall")
+	f, err := os.Create(manifestPath)
+	c.Assert(err, IsNil)
+	w, err := zstd.NewWriter(f)
+	c.Assert(err, IsNil)
+	_, err = w.Write(testutil.Reindent(listManifest))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	for _, test := range listTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		args := append([]string{"list", "--manifest", manifestPath}, test.args...)
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		test.stdout = string(testutil.Reindent(test.stdout))
+		c.Assert(s.Stdout(), Equals, strings.TrimSpace(test.stdout)+"\n")
+	}
+}
+
+func (s *ChiselSuite) TestListMissingManifest(c *C) {
+	_, err := chisel.Parser().ParseArgs([]string{"list", "--manifest", filepath.Join(c.MkDir(), "missing")})
+	c.Assert(err, ErrorMatches, "cannot read manifest: open .*/missing: no such file or directory")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var shortSBOMHelp = "Write a software bill of materials"
+var longSBOMHelp = `
+The sbom command writes a software bill of materials for the packages and
+files installed according to the Chisel manifest in the root directory,
+such as root/var/lib/chisel/manifest.wall.
+
+The only format currently supported is SPDX 2.3 JSON. The document creation
+time is taken from SOURCE_DATE_EPOCH when set, or otherwise from the current
+time.
+`
+
+var sbomDescs = map[string]string{
+	"root":   "Root directory of the installed slices",
+	"format": "Format of the bill of materials (spdx)",
+}
+
+type cmdSBOM struct {
+	Root   string `long:"root" value-name:"<dir>" required:"yes"`
+	Format string `long:"format" value-name:"<format>" choice:"spdx" default:"spdx"`
+}
+
+func init() {
+	addCommand("sbom", shortSBOMHelp, longSBOMHelp, func() flags.Commander { return &cmdSBOM{} }, sbomDescs, nil)
+}
+
+func (cmd *cmdSBOM) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	manifestPath, err := findManifest(cmd.Root)
+	if err != nil {
+		return err
+	}
+	mfest, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	created := time.Now()
+	if os.Getenv("SOURCE_DATE_EPOCH") != "" {
+		created, err = sourceDateEpoch()
+		if err != nil {
+			return err
+		}
+	}
+	name, err := filepath.Abs(cmd.Root)
+	if err != nil {
+		return err
+	}
+	return manifest.WriteSPDXWithOptions(mfest, Stdout, &manifest.SPDXOptions{
+		Name:    filepath.Base(name),
+		Created: created.Truncate(time.Second),
+	})
+}
+
+// findManifest returns the path of the first Chisel manifest found in the
+// root directory. All manifests written by a cut have the same content.
+func findManifest(rootDir string) (string, error) {
+	info, err := os.Stat(rootDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid --root: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("invalid --root: %s is not a directory", rootDir)
+	}
+	var found string
+	err = filepath.WalkDir(rootDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() && entry.Name() == manifestutil.DefaultFilename {
+			found = path
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot find manifest: %w", err)
+	}
+	if found == "" {
+		return "", fmt.Errorf("cannot find manifest in %s", rootDir)
+	}
+	return found, nil
+}
@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var sbomManifest = `
+	{"jsonwall":"1.0","schema":"1.0","count":13}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/bar"}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/foo"}
+	{"kind":"content","slice":"mypkg_config","path":"/etc/foo.conf"}
+	{"kind":"content","slice":"mypkg_manifest","path":"/var/lib/chisel/manifest.wall"}
+	{"kind":"package","name":"mypkg","version":"1.0","sha256":"hash","arch":"amd64"}
+	{"kind":"path","path":"/etc/foo.conf","mode":"0644","slices":["mypkg_config"],"sha256":"h1","size":20}
+	{"kind":"path","path":"/usr/bin/","mode":"0755","slices":["mypkg_bins"]}
+	{"kind":"path","path":"/usr/bin/bar","mode":"0755","slices":["mypkg_bins"],"sha256":"h2","size":300}
+	{"kind":"path","path":"/usr/bin/foo","mode":"0755","slices":["mypkg_bins"],"sha256":"h3","size":4000}
+	{"kind":"path","path":"/var/lib/chisel/manifest.wall","mode":"0644","slices":["mypkg_manifest"]}
+	{"kind":"slice","name":"mypkg_bins"}
+	{"kind":"slice","name":"mypkg_config"}
+	{"kind":"slice","name":"mypkg_manifest"}
+`
+
+func (s *ChiselSuite) TestSBOMCommand(c *C) {
+	rootDir := filepath.Join(c.MkDir(), "myroot")
+	manifestDir := filepath.Join(rootDir, "var/lib/chisel")
+	c.Assert(os.MkdirAll(manifestDir, 0755), IsNil)
+	f, err := os.Create(filepath.Join(manifestDir, "manifest.wall"))
+	c.Assert(err, IsNil)
+	w, err := zstd.NewWriter(f)
+	c.Assert(err, IsNil)
+	_, err = w.Write(testutil.Reindent(sbomManifest))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	s.ResetStdStreams()
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	_, err = chisel.Parser().ParseArgs([]string{"sbom", "--root", rootDir, "--format", "spdx"})
+	c.Assert(err, IsNil)
+
+	var doc struct {
+		SPDXVersion  string `json:"spdxVersion"`
+		Name         string `json:"name"`
+		CreationInfo struct {
+			Created string `json:"created"`
+		} `json:"creationInfo"`
+		Packages []struct {
+			Name        string `json:"name"`
+			VersionInfo string `json:"versionInfo"`
+		} `json:"packages"`
+		Files []struct {
+			FileName  string `json:"fileName"`
+			Checksums []struct {
+				Value string `json:"checksumValue"`
+			} `json:"checksums"`
+		} `json:"files"`
+		Relationships []struct {
+			Element string `json:"spdxElementId"`
+			Type    string `json:"relationshipType"`
+		} `json:"relationships"`
+	}
+	err = json.Unmarshal([]byte(s.Stdout()), &doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc.SPDXVersion, Equals, "SPDX-2.3")
+	c.Assert(doc.Name, Equals, "myroot")
+	c.Assert(doc.CreationInfo.Created, Equals, "2023-11-14T22:13:20Z")
+	c.Assert(doc.Packages, HasLen, 1)
+	c.Assert(doc.Packages[0].Name, Equals, "mypkg")
+	c.Assert(doc.Packages[0].VersionInfo, Equals, "1.0")
+	var files []string
+	for _, file := range doc.Files {
+		files = append(files, file.FileName)
+		c.Assert(file.Checksums, HasLen, 1)
+		c.Assert(file.Checksums[0].Value, Not(Equals), "")
+	}
+	c.Assert(files, DeepEquals, []string{"/etc/foo.conf", "/usr/bin/bar", "/usr/bin/foo"})
+	c.Assert(doc.Relationships, HasLen, 4)
+}
+
+func (s *ChiselSuite) TestSBOMMissingManifest(c *C) {
+	rootDir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"sbom", "--root", rootDir})
+	c.Assert(err, ErrorMatches, "cannot find manifest in .*")
+
+	_, err = chisel.Parser().ParseArgs([]string{"sbom", "--root", filepath.Join(rootDir, "missing")})
+	c.Assert(err, ErrorMatches, "invalid --root: stat .*/missing: no such file or directory")
+}
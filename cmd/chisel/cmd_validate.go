@@ -0,0 +1,486 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
+)
+
+var shortValidateHelp = "Validate a release"
+var longValidateHelp = `
+The validate command reads the release and checks that its slice
+definitions are well formed and do not conflict with each other.
+
+The --select-all option also selects every slice on its own, which reports
+the problems only found when selecting slices, such as hard links to paths
+which are not selected along with them. These do not need the archives.
+
+The --check-archives option also checks that every package defined in the
+release exists in the archive resolved for it, reporting the orphaned slice
+definitions of packages which do not. It needs access to the archives,
+which are opened as the cut command does when --archive-priority,
+--archive-url or --cache-dir are used along with it.
+
+With --format=json, all the problems found are printed as a JSON object
+instead of only reporting the first one. The --all option prints them as
+text, one per line.
+
+The --perf option also reports the globs which make selection slower
+without a need: broad globs matching everything under the root or under a
+top-level directory, such as /** or /usr/**, and globs in the slices of a
+package which overlap and could be consolidated. The analysis is advisory
+and never makes the command fail.
+
+The --check-elf option checks a populated root directory, such as the
+output of a cut, instead of the release. The shared libraries listed as
+needed by every ELF file in it must be present somewhere in the tree, and
+the ones missing are reported.
+`
+
+var validateDescs = map[string]string{
+	"release":          "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"format":           "Output format: text or json",
+	"select-all":       "Also select every slice on its own",
+	"all":              "Report every problem instead of the first one",
+	"check-archives":   "Check that every package exists in the archives",
+	"arch":             "Package architecture used to check the archives",
+	"perf":             "Report globs that slow down selection",
+	"check-elf":        "Check the shared libraries needed in a root directory",
+	"archive-priority": "Override the priority of an archive (e.g. staging=30)",
+	"archive-url":      "Fetch an archive from another URL (e.g. ubuntu=file:///srv/mirror)",
+	"cache-dir":        "Directory for caching fetched packages across runs",
+}
+
+type cmdValidate struct {
+	Release           string   `long:"release" value-name:"<branch|dir>"`
+	Format            string   `long:"format" value-name:"<format>" choice:"text" choice:"json" default:"text"`
+	SelectAll         bool     `long:"select-all"`
+	All               bool     `long:"all"`
+	CheckArchives     bool     `long:"check-archives"`
+	Arch              string   `long:"arch" value-name:"<arch>"`
+	Perf              bool     `long:"perf"`
+	CheckELF          string   `long:"check-elf" value-name:"<dir>"`
+	ArchivePriorities []string `long:"archive-priority" value-name:"<archive=priority>"`
+	ArchiveURLs       []string `long:"archive-url" value-name:"<archive=url>"`
+	CacheDir          string   `long:"cache-dir" value-name:"<dir>"`
+}
+
+func init() {
+	addCommand("validate", shortValidateHelp, longValidateHelp, func() flags.Commander { return &cmdValidate{} }, validateDescs, nil)
+}
+
+type jsonConflictReport struct {
+	Conflicts []jsonConflict `json:"conflicts"`
+	Errors    []string       `json:"errors,omitempty"`
+	Orphans   []jsonOrphan   `json:"orphans,omitempty"`
+	Warnings  []jsonConflict `json:"warnings,omitempty"`
+}
+
+type jsonConflict struct {
+	Kind   string   `json:"kind"`
+	Slices []string `json:"slices"`
+	Paths  []string `json:"paths"`
+}
+
+type jsonOrphan struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+}
+
+type jsonELFReport struct {
+	Missing []jsonMissingLibrary `json:"missing"`
+}
+
+type jsonMissingLibrary struct {
+	Path    string `json:"path"`
+	Library string `json:"library"`
+}
+
+func (cmd *cmdValidate) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if !cmd.CheckArchives {
+		switch {
+		case len(cmd.ArchivePriorities) > 0:
+			return fmt.Errorf("--archive-priority requires --check-archives")
+		case len(cmd.ArchiveURLs) > 0:
+			return fmt.Errorf("--archive-url requires --check-archives")
+		case cmd.CacheDir != "":
+			return fmt.Errorf("--cache-dir requires --check-archives")
+		}
+	}
+	if cmd.CheckELF != "" {
+		return cmd.checkELF()
+	}
+
+	release, err := obtainRelease(cmd.Release)
+	if cmd.Format != "json" && !cmd.All {
+		if err != nil {
+			return err
+		}
+		if cmd.SelectAll {
+			if errs := selectionErrors(release); len(errs) > 0 {
+				return errs[0]
+			}
+		}
+		if cmd.CheckArchives {
+			orphans, err := cmd.findOrphans(release)
+			if err != nil {
+				return err
+			}
+			if len(orphans) > 0 {
+				return errors.New(orphans[0].String())
+			}
+		}
+		if cmd.Perf {
+			for _, warning := range findPerfWarnings(release) {
+				fmt.Fprintln(Stdout, warning)
+			}
+		}
+		return nil
+	}
+
+	var conflictErr *setup.ConflictError
+	if err != nil && !errors.As(err, &conflictErr) {
+		return err
+	}
+	var selectErrs []error
+	if cmd.SelectAll && release != nil {
+		selectErrs = selectionErrors(release)
+	}
+	var orphans []*orphanPackage
+	if cmd.CheckArchives && release != nil {
+		orphans, err = cmd.findOrphans(release)
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.Format != "json" {
+		if conflictErr != nil {
+			for _, conflict := range conflictErr.Conflicts {
+				fmt.Fprintln(Stdout, conflict.Error())
+			}
+		}
+		for _, err := range selectErrs {
+			fmt.Fprintln(Stdout, err)
+		}
+		for _, orphan := range orphans {
+			fmt.Fprintln(Stdout, orphan)
+		}
+		if cmd.Perf && release != nil {
+			for _, warning := range findPerfWarnings(release) {
+				fmt.Fprintln(Stdout, warning)
+			}
+		}
+		return validateResult(conflictErr, selectErrs, orphans)
+	}
+
+	report := jsonConflictReport{Conflicts: []jsonConflict{}}
+	if conflictErr != nil {
+		for _, conflict := range conflictErr.Conflicts {
+			report.Conflicts = append(report.Conflicts, jsonConflict{
+				Kind:   string(conflict.Kind),
+				Slices: []string{conflict.Slices[0].String(), conflict.Slices[1].String()},
+				Paths:  conflict.Paths,
+			})
+		}
+	}
+	for _, err := range selectErrs {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	for _, orphan := range orphans {
+		report.Orphans = append(report.Orphans, jsonOrphan{Package: orphan.name, Path: orphan.path})
+	}
+	if cmd.Perf && release != nil {
+		for _, warning := range findPerfWarnings(release) {
+			report.Warnings = append(report.Warnings, jsonConflict{
+				Kind:   string(warning.kind),
+				Slices: warning.slices,
+				Paths:  warning.paths,
+			})
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "%s\n", data)
+	return validateResult(conflictErr, selectErrs, orphans)
+}
+
+// validateResult returns the error summarizing the problems reported.
+func validateResult(conflictErr *setup.ConflictError, selectErrs []error, orphans []*orphanPackage) error {
+	if conflictErr != nil && len(conflictErr.Conflicts) > 0 {
+		return fmt.Errorf("release has conflicting slices")
+	}
+	if len(selectErrs) > 0 {
+		return fmt.Errorf("release has slices which cannot be selected")
+	}
+	if len(orphans) > 0 {
+		return fmt.Errorf("release has packages missing from the archives")
+	}
+	return nil
+}
+
+type orphanPackage struct {
+	name string
+	path string
+}
+
+func (o *orphanPackage) String() string {
+	return fmt.Sprintf("package %q defined in %s is not in archive(s)", o.name, o.path)
+}
+
+// findOrphans returns the packages defined in the release, in order, which
+// do not exist in the archive resolved for them.
+func (cmd *cmdValidate) findOrphans(release *setup.Release) ([]*orphanPackage, error) {
+	source, err := newArchiveSource(release, cmd.ArchivePriorities, cmd.ArchiveURLs, cmd.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	archives := newPackageArchives(release, cmd.Arch, source)
+	var orphans []*orphanPackage
+	for _, pkgName := range sortedKeys(release.Packages) {
+		pkgArchive, err := archives.lookup(pkgName)
+		if err != nil {
+			return nil, err
+		}
+		if pkgArchive != nil {
+			_, err = pkgArchive.Info(pkgName)
+		}
+		if pkgArchive == nil || err != nil {
+			orphans = append(orphans, &orphanPackage{pkgName, release.Packages[pkgName].Path})
+		}
+	}
+	return orphans, nil
+}
+
+// selectionErrors selects every slice in the release on its own, in order,
+// and returns the errors found.
+func selectionErrors(release *setup.Release) []error {
+	var errs []error
+	for _, pkgName := range sortedKeys(release.Packages) {
+		pkg := release.Packages[pkgName]
+		for _, sliceName := range sortedKeys(pkg.Slices) {
+			key := setup.SliceKey{Package: pkgName, Slice: sliceName}
+			_, err := setup.Select(release, []setup.SliceKey{key})
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func (cmd *cmdValidate) checkELF() error {
+	info, err := os.Stat(cmd.CheckELF)
+	if err != nil {
+		return fmt.Errorf("invalid --check-elf: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid --check-elf: %s is not a directory", cmd.CheckELF)
+	}
+	missing, err := findMissingLibraries(cmd.CheckELF)
+	if err != nil {
+		return err
+	}
+	if cmd.Format == "json" {
+		report := jsonELFReport{Missing: []jsonMissingLibrary{}}
+		for _, m := range missing {
+			report.Missing = append(report.Missing, jsonMissingLibrary{Path: m.path, Library: m.library})
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(Stdout, "%s\n", data)
+	} else {
+		for _, m := range missing {
+			fmt.Fprintf(Stdout, "%s needs %s, which is missing\n", m.path, m.library)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("root has %d missing shared libraries", len(missing))
+	}
+	return nil
+}
+
+type missingLibrary struct {
+	path    string
+	library string
+}
+
+// findMissingLibraries returns, for every ELF file under rootDir, the
+// shared libraries in its DT_NEEDED entries which are not in the tree. A
+// library name is found if any file or symlink has it as base name, and
+// an absolute library path is looked up as is under rootDir.
+func findMissingLibraries(rootDir string) ([]missingLibrary, error) {
+	names := make(map[string]bool)
+	var elfPaths []string
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		names[d.Name()] = true
+		if d.Type().IsRegular() && isELF(path) {
+			elfPaths = append(elfPaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot check ELF files: %w", err)
+	}
+
+	var missing []missingLibrary
+	for _, path := range elfPaths {
+		relPath := "/" + strings.TrimPrefix(filepath.ToSlash(path[len(rootDir):]), "/")
+		libs, err := neededLibraries(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check ELF file %s: %w", relPath, err)
+		}
+		for _, lib := range libs {
+			if strings.Contains(lib, "/") {
+				_, err := os.Lstat(filepath.Join(rootDir, lib))
+				if err == nil {
+					continue
+				}
+			} else if names[lib] {
+				continue
+			}
+			missing = append(missing, missingLibrary{relPath, lib})
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].path != missing[j].path {
+			return missing[i].path < missing[j].path
+		}
+		return missing[i].library < missing[j].library
+	})
+	return missing, nil
+}
+
+// isELF returns whether the file at path starts with the ELF magic.
+func isELF(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var magic [len(elf.ELFMAG)]byte
+	_, err = io.ReadFull(file, magic[:])
+	return err == nil && string(magic[:]) == elf.ELFMAG
+}
+
+// neededLibraries returns the DT_NEEDED entries of the ELF file at path.
+// Files without a dynamic section need none.
+func neededLibraries(path string) ([]string, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.ImportedLibraries()
+}
+
+type perfWarningKind string
+
+const (
+	broadGlobWarning       perfWarningKind = "broad-glob"
+	overlappingGlobWarning perfWarningKind = "overlapping-globs"
+)
+
+type perfWarning struct {
+	kind   perfWarningKind
+	slices []string
+	paths  []string
+}
+
+func (w *perfWarning) String() string {
+	if w.kind == broadGlobWarning {
+		return fmt.Sprintf("slice %s has broad glob %s", w.slices[0], w.paths[0])
+	}
+	if w.slices[0] == w.slices[1] {
+		return fmt.Sprintf("slice %s has overlapping globs %s and %s", w.slices[0], w.paths[0], w.paths[1])
+	}
+	return fmt.Sprintf("slices %s and %s have overlapping globs %s and %s", w.slices[0], w.slices[1], w.paths[0], w.paths[1])
+}
+
+// findPerfWarnings returns the broad globs in the release and the pairs of
+// globs which overlap within the slices of a package. Globs in different
+// packages can never overlap in a valid release.
+func findPerfWarnings(release *setup.Release) []*perfWarning {
+	type sliceGlob struct {
+		slice string
+		path  string
+	}
+	var warnings []*perfWarning
+	for _, pkgName := range sortedKeys(release.Packages) {
+		pkg := release.Packages[pkgName]
+		var globs []sliceGlob
+		for _, sliceName := range sortedKeys(pkg.Slices) {
+			slice := pkg.Slices[sliceName]
+			for _, path := range sortedKeys(slice.Contents) {
+				if slice.Contents[path].Kind != setup.GlobPath {
+					continue
+				}
+				globs = append(globs, sliceGlob{slice.String(), path})
+				if isBroadGlob(path) {
+					warnings = append(warnings, &perfWarning{
+						kind:   broadGlobWarning,
+						slices: []string{slice.String()},
+						paths:  []string{path},
+					})
+				}
+			}
+		}
+		for i, a := range globs {
+			for _, b := range globs[i+1:] {
+				if strdist.GlobPath(a.path, b.path) {
+					warnings = append(warnings, &perfWarning{
+						kind:   overlappingGlobWarning,
+						slices: []string{a.slice, b.slice},
+						paths:  []string{a.path, b.path},
+					})
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// isBroadGlob returns whether the glob matches entries right under the
+// root, or entries at any depth under the root or a top-level directory.
+func isBroadGlob(path string) bool {
+	prefix := path[:strings.IndexAny(path, "*?")]
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	depth := strings.Count(dir, "/")
+	if strings.Contains(path, "**") {
+		return depth <= 2
+	}
+	return depth <= 1
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
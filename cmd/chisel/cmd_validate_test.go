@@ -0,0 +1,428 @@
+package main_test
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type validateTest struct {
+	summary string
+	input   map[string]string
+	args    []string
+	stdout  string
+	err     string
+}
+
+var conflictingRelease = map[string]string{
+	"chisel.yaml": string(defaultChiselYaml),
+	"slices/mypkg1.yaml": `
+		package: mypkg1
+		slices:
+			myslice:
+				contents:
+					/file: {text: foo}
+					/dir/**:
+	`,
+	"slices/mypkg2.yaml": `
+		package: mypkg2
+		slices:
+			myslice:
+				contents:
+					/file: {text: bar}
+					/dir/file:
+	`,
+}
+
+var perfRelease = map[string]string{
+	"chisel.yaml": string(defaultChiselYaml),
+	"slices/mypkg1.yaml": `
+		package: mypkg1
+		slices:
+			bins:
+				contents:
+					/bin/**:
+					/usr/lib/mypkg1/*.so:
+			libs:
+				contents:
+					/usr/lib/mypkg1/**:
+					/usr/share/mypkg1/file:
+	`,
+	"slices/mypkg2.yaml": `
+		package: mypkg2
+		slices:
+			all:
+				contents:
+					/opt/**:
+	`,
+}
+
+var unselectableRelease = map[string]string{
+	"chisel.yaml": string(defaultChiselYaml),
+	"slices/mypkg1.yaml": `
+		package: mypkg1
+		slices:
+			bins:
+				contents:
+					/usr/bin/foo:
+			bar:
+				contents:
+					/usr/bin/bar: {hardlink: /usr/bin/foo}
+			baz:
+				contents:
+					/usr/bin/baz: {hardlink: /usr/bin/foo}
+	`,
+}
+
+var validateTests = []validateTest{{
+	summary: "Valid release",
+	input:   infoRelease,
+}, {
+	summary: "Valid release with JSON output",
+	input:   infoRelease,
+	args:    []string{"--format", "json"},
+	stdout: `
+		{
+		  "conflicts": []
+		}
+	`,
+}, {
+	summary: "Conflicts are reported as an error",
+	input:   conflictingRelease,
+	err:     `slices mypkg1_myslice and mypkg2_myslice conflict on /dir/\*\* and /dir/file`,
+}, {
+	summary: "All conflicts are reported in JSON",
+	input:   conflictingRelease,
+	args:    []string{"--format", "json"},
+	stdout: `
+		{
+		  "conflicts": [
+		    {
+		      "kind": "glob",
+		      "slices": [
+		        "mypkg1_myslice",
+		        "mypkg2_myslice"
+		      ],
+		      "paths": [
+		        "/dir/**",
+		        "/dir/file"
+		      ]
+		    },
+		    {
+		      "kind": "path",
+		      "slices": [
+		        "mypkg1_myslice",
+		        "mypkg2_myslice"
+		      ],
+		      "paths": [
+		        "/file"
+		      ]
+		    }
+		  ]
+		}
+	`,
+	err: `release has conflicting slices`,
+}, {
+	summary: "All conflicts are reported as text",
+	input:   conflictingRelease,
+	args:    []string{"--all"},
+	stdout: `
+		slices mypkg1_myslice and mypkg2_myslice conflict on /dir/** and /dir/file
+		slices mypkg1_myslice and mypkg2_myslice conflict on /file
+	`,
+	err: `release has conflicting slices`,
+}, {
+	summary: "Selection errors are only found when selecting",
+	input:   unselectableRelease,
+}, {
+	summary: "Selection errors are reported as an error",
+	input:   unselectableRelease,
+	args:    []string{"--select-all"},
+	err:     `slice mypkg1_bar has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not in the selected slices of package mypkg1`,
+}, {
+	summary: "All selection errors are reported as text",
+	input:   unselectableRelease,
+	args:    []string{"--select-all", "--all"},
+	stdout: `
+		slice mypkg1_bar has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not in the selected slices of package mypkg1
+		slice mypkg1_baz has invalid hardlink for path /usr/bin/baz: /usr/bin/foo is not in the selected slices of package mypkg1
+	`,
+	err: `release has slices which cannot be selected`,
+}, {
+	summary: "All selection errors are reported in JSON",
+	input:   unselectableRelease,
+	args:    []string{"--select-all", "--format", "json"},
+	stdout: `
+		{
+		  "conflicts": [],
+		  "errors": [
+		    "slice mypkg1_bar has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not in the selected slices of package mypkg1",
+		    "slice mypkg1_baz has invalid hardlink for path /usr/bin/baz: /usr/bin/foo is not in the selected slices of package mypkg1"
+		  ]
+		}
+	`,
+	err: `release has slices which cannot be selected`,
+}, {
+	summary: "Selecting every slice of a valid release",
+	input:   infoRelease,
+	args:    []string{"--select-all", "--all"},
+}, {
+	summary: "Performance warnings",
+	input:   perfRelease,
+	args:    []string{"--perf"},
+	stdout: `
+		slice mypkg1_bins has broad glob /bin/**
+		slices mypkg1_bins and mypkg1_libs have overlapping globs /usr/lib/mypkg1/*.so and /usr/lib/mypkg1/**
+		slice mypkg2_all has broad glob /opt/**
+	`,
+}, {
+	summary: "Performance warnings in JSON",
+	input:   perfRelease,
+	args:    []string{"--perf", "--format", "json"},
+	stdout: `
+		{
+		  "conflicts": [],
+		  "warnings": [
+		    {
+		      "kind": "broad-glob",
+		      "slices": [
+		        "mypkg1_bins"
+		      ],
+		      "paths": [
+		        "/bin/**"
+		      ]
+		    },
+		    {
+		      "kind": "overlapping-globs",
+		      "slices": [
+		        "mypkg1_bins",
+		        "mypkg1_libs"
+		      ],
+		      "paths": [
+		        "/usr/lib/mypkg1/*.so",
+		        "/usr/lib/mypkg1/**"
+		      ]
+		    },
+		    {
+		      "kind": "broad-glob",
+		      "slices": [
+		        "mypkg2_all"
+		      ],
+		      "paths": [
+		        "/opt/**"
+		      ]
+		    }
+		  ]
+		}
+	`,
+}, {
+	summary: "No performance warnings",
+	input:   infoRelease,
+	args:    []string{"--perf"},
+}, {
+	summary: "Other errors are not reported in JSON",
+	input: map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg1_myslice
+		`,
+	},
+	args: []string{"--format", "json"},
+	err:  `cannot add slice to itself as essential "mypkg1_myslice" in slices/mypkg1.yaml`,
+}}
+
+func (s *ChiselSuite) TestValidateCommand(c *C) {
+	for _, test := range validateTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		dir := c.MkDir()
+		for path, data := range test.input {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		args := append([]string{"validate", "--release", dir}, test.args...)
+
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		if test.stdout == "" {
+			c.Assert(s.Stdout(), Equals, "")
+		} else {
+			stdout := string(testutil.Reindent(test.stdout))
+			c.Assert(s.Stdout(), Equals, strings.TrimSpace(stdout)+"\n")
+		}
+	}
+}
+
+var validateArchivesTests = []validateTest{{
+	summary: "Packages missing from the archives",
+	input:   infoRelease,
+	args:    []string{"--check-archives"},
+	err:     `package "mypkg2" defined in slices/mypkg2.yaml is not in archive\(s\)`,
+}, {
+	summary: "All packages missing from the archives as text",
+	input:   infoRelease,
+	args:    []string{"--check-archives", "--all"},
+	stdout: `
+		package "mypkg2" defined in slices/mypkg2.yaml is not in archive(s)
+		package "mypkg3" defined in slices/mypkg3.yaml is not in archive(s)
+	`,
+	err: `release has packages missing from the archives`,
+}, {
+	summary: "All packages missing from the archives in JSON",
+	input:   infoRelease,
+	args:    []string{"--check-archives", "--format", "json"},
+	stdout: `
+		{
+		  "conflicts": [],
+		  "orphans": [
+		    {
+		      "package": "mypkg2",
+		      "path": "slices/mypkg2.yaml"
+		    },
+		    {
+		      "package": "mypkg3",
+		      "path": "slices/mypkg3.yaml"
+		    }
+		  ]
+		}
+	`,
+	err: `release has packages missing from the archives`,
+}, {
+	summary: "Archives are not checked by default",
+	input:   infoRelease,
+}}
+
+func (s *ChiselSuite) TestValidateCheckArchives(c *C) {
+	opened := 0
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		opened++
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg1": "1.1"},
+		}, nil
+	})
+	defer restore()
+
+	for _, test := range validateArchivesTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+		opened = 0
+
+		dir := c.MkDir()
+		for path, data := range test.input {
+			fpath := filepath.Join(dir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		args := append([]string{"validate", "--release", dir, "--arch", "amd64"}, test.args...)
+
+		_, err := chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		if test.stdout == "" {
+			c.Assert(s.Stdout(), Equals, "")
+		} else {
+			stdout := string(testutil.Reindent(test.stdout))
+			c.Assert(s.Stdout(), Equals, strings.TrimSpace(stdout)+"\n")
+		}
+		if len(test.args) == 0 {
+			c.Assert(opened, Equals, 0)
+		}
+	}
+}
+
+func (s *ChiselSuite) TestValidateCheckELF(c *C) {
+	// A dynamically linked binary from the host provides the ELF file.
+	var binary string
+	var libs []string
+	for _, path := range []string{"/bin/sh", "/bin/ls", "/usr/bin/env"} {
+		file, err := elf.Open(path)
+		if err != nil {
+			continue
+		}
+		libs, err = file.ImportedLibraries()
+		file.Close()
+		if err == nil && len(libs) > 0 {
+			binary = path
+			break
+		}
+	}
+	if binary == "" {
+		c.Skip("no dynamically linked binary found on the host")
+	}
+	data, err := os.ReadFile(binary)
+	c.Assert(err, IsNil)
+
+	rootDir := c.MkDir()
+	err = os.MkdirAll(filepath.Join(rootDir, "usr/bin"), 0755)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(rootDir, "usr/bin/tool"), data, 0755)
+	c.Assert(err, IsNil)
+	err = os.WriteFile(filepath.Join(rootDir, "usr/bin/script"), []byte("#!/bin/sh\n"), 0755)
+	c.Assert(err, IsNil)
+
+	sort.Strings(libs)
+	var expected []string
+	for _, lib := range libs {
+		expected = append(expected, "/usr/bin/tool needs "+lib+", which is missing")
+	}
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"validate", "--check-elf", rootDir})
+	c.Assert(err, ErrorMatches, fmt.Sprintf("root has %d missing shared libraries", len(libs)))
+	c.Assert(s.Stdout(), Equals, strings.Join(expected, "\n")+"\n")
+
+	// The first library is missing in the JSON output too.
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"validate", "--check-elf", rootDir, "--format", "json"})
+	c.Assert(err, NotNil)
+	c.Assert(s.Stdout(), Matches, `(?s){\n  "missing": \[\n    {\n      "path": "/usr/bin/tool",\n      "library": "`+regexp.QuoteMeta(libs[0])+`"\n    }.*`)
+
+	err = os.MkdirAll(filepath.Join(rootDir, "usr/lib"), 0755)
+	c.Assert(err, IsNil)
+	for _, lib := range libs {
+		err = os.WriteFile(filepath.Join(rootDir, "usr/lib", filepath.Base(lib)), nil, 0644)
+		c.Assert(err, IsNil)
+		if strings.Contains(lib, "/") {
+			err = os.MkdirAll(filepath.Join(rootDir, filepath.Dir(lib)), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(filepath.Join(rootDir, lib), nil, 0644)
+			c.Assert(err, IsNil)
+		}
+	}
+	s.ResetStdStreams()
+	_, err = chisel.Parser().ParseArgs([]string{"validate", "--check-elf", rootDir})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+
+	_, err = chisel.Parser().ParseArgs([]string{"validate", "--check-elf", filepath.Join(rootDir, "usr/bin/tool")})
+	c.Assert(err, ErrorMatches, `invalid --check-elf: .*/usr/bin/tool is not a directory`)
+}
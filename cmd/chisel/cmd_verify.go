@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var shortVerifyHelp = "Check a root directory against its manifest"
+var longVerifyHelp = `
+The verify command checks that the content of the root directory matches
+what is recorded in the Chisel manifest found in it, such as
+root/var/lib/chisel/manifest.wall.
+
+The mode, size, symlink target and sha256 of every recorded path are
+compared with the ones on disk, as well as its owner when one was set in
+its slice. Files which were mutated are compared with
+their final content. Paths which are missing, modified, or present without
+being recorded are reported, and the command fails if any is found.
+Directories which are not recorded are not reported themselves, as they
+may be implicit parents of recorded paths.
+
+With --quick, the content of a file is only hashed when its size differs
+from the recorded one or when it was modified after the manifest. This
+trades completeness for speed: a change which preserves the size of a file
+and its modification time, or which sets it back, goes unnoticed.
+`
+
+var verifyDescs = map[string]string{
+	"root":  "Root directory of the installed slices",
+	"quick": "Only hash files whose size or modification time changed",
+}
+
+type cmdVerify struct {
+	Root  string `long:"root" value-name:"<dir>" required:"yes"`
+	Quick bool   `long:"quick"`
+}
+
+func init() {
+	addCommand("verify", shortVerifyHelp, longVerifyHelp, func() flags.Commander { return &cmdVerify{} }, verifyDescs, nil)
+}
+
+func (cmd *cmdVerify) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	manifestPath, err := findManifest(cmd.Root)
+	if err != nil {
+		return err
+	}
+	mfest, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	var quickSince time.Time
+	if cmd.Quick {
+		info, err := os.Stat(manifestPath)
+		if err != nil {
+			return err
+		}
+		quickSince = info.ModTime()
+	}
+	problems, err := verifyRoot(cmd.Root, mfest, quickSince)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(Stdout, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("root has %d discrepancies with the manifest", len(problems))
+	}
+	return nil
+}
+
+// verifyRoot compares the paths in the root directory with the ones in the
+// manifest and returns a description of every discrepancy, ordered by path.
+// If quickSince is not zero, files with the recorded size which were not
+// modified after it are not hashed.
+func verifyRoot(rootDir string, mfest *manifest.Manifest, quickSince time.Time) ([]string, error) {
+	onDisk := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(rootDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == rootDir {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		// Entries are indexed without the trailing slash of directories, so
+		// that a path replaced by one of a different type is still found.
+		onDisk["/"+filepath.ToSlash(relPath)] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify root: %w", err)
+	}
+
+	type problem struct {
+		path string
+		desc string
+	}
+	var problems []problem
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		key := strings.TrimSuffix(path.Path, "/")
+		info, ok := onDisk[key]
+		if !ok {
+			problems = append(problems, problem{path.Path, "missing " + path.Path})
+			return nil
+		}
+		delete(onDisk, key)
+		descs, err := verifyPath(filepath.Join(rootDir, path.Path), info, path, quickSince)
+		if err != nil {
+			return err
+		}
+		for _, desc := range descs {
+			problems = append(problems, problem{path.Path, "modified " + path.Path + ": " + desc})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for relPath, info := range onDisk {
+		if !info.IsDir() {
+			problems = append(problems, problem{relPath, "added " + relPath})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].path < problems[j].path
+	})
+	descs := make([]string, len(problems))
+	for i, problem := range problems {
+		descs[i] = problem.desc
+	}
+	return descs, nil
+}
+
+// verifyPath compares the file at absPath with its manifest entry. See
+// verifyRoot for quickSince.
+func verifyPath(absPath string, info fs.FileInfo, path *manifest.Path, quickSince time.Time) ([]string, error) {
+	var descs []string
+	expectedType := "file"
+	if strings.HasSuffix(path.Path, "/") {
+		expectedType = "directory"
+	} else if path.Link != "" {
+		expectedType = "symlink"
+	}
+	if fileType := verifyFileType(info.Mode()); fileType != expectedType {
+		return []string{fmt.Sprintf("type is %s, expected %s", fileType, expectedType)}, nil
+	}
+
+	if path.Mode != "" && expectedType != "symlink" {
+		mode := manifestPerm(info.Mode())
+		expected, err := strconv.ParseUint(path.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode %q for %s in manifest", path.Mode, path.Path)
+		}
+		if mode != uint32(expected) {
+			descs = append(descs, fmt.Sprintf("mode is 0%o, expected 0%o", mode, expected))
+		}
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if path.UID != nil && int(stat.Uid) != *path.UID {
+			descs = append(descs, fmt.Sprintf("uid is %d, expected %d", stat.Uid, *path.UID))
+		}
+		if path.GID != nil && int(stat.Gid) != *path.GID {
+			descs = append(descs, fmt.Sprintf("gid is %d, expected %d", stat.Gid, *path.GID))
+		}
+	}
+
+	switch expectedType {
+	case "symlink":
+		target, err := os.Readlink(absPath)
+		if err != nil {
+			return nil, err
+		}
+		if target != path.Link {
+			descs = append(descs, fmt.Sprintf("link is %q, expected %q", target, path.Link))
+		}
+	case "file":
+		// The manifest itself is recorded without its own hash and size.
+		expectedSHA256 := path.FinalSHA256
+		if expectedSHA256 == "" {
+			expectedSHA256 = path.SHA256
+		}
+		if expectedSHA256 == "" {
+			break
+		}
+		if uint64(info.Size()) != path.Size {
+			descs = append(descs, fmt.Sprintf("size is %d, expected %d", info.Size(), path.Size))
+		} else if !quickSince.IsZero() && !info.ModTime().After(quickSince) {
+			break
+		}
+		sha, err := fileSHA256(absPath)
+		if err != nil {
+			return nil, err
+		}
+		if sha != expectedSHA256 {
+			descs = append(descs, fmt.Sprintf("sha256 is %s, expected %s", sha, expectedSHA256))
+		}
+	}
+	return descs, nil
+}
+
+func verifyFileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsRegular():
+		return "file"
+	}
+	return "special file"
+}
+
+// manifestPerm returns the permission bits of mode as recorded in the
+// manifest, which includes the sticky bit.
+func manifestPerm(mode fs.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&fs.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
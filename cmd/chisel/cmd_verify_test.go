@@ -0,0 +1,186 @@
+package main_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func sha256hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+var verifyManifest = fmt.Sprintf(`
+	{"jsonwall":"1.0","schema":"1.0","count":14}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/"}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/foo"}
+	{"kind":"content","slice":"mypkg_bins","path":"/usr/bin/link"}
+	{"kind":"content","slice":"mypkg_config","path":"/etc/foo.conf"}
+	{"kind":"content","slice":"mypkg_config","path":"/etc/mutable"}
+	{"kind":"content","slice":"mypkg_manifest","path":"/var/lib/chisel/manifest.wall"}
+	{"kind":"package","name":"mypkg","version":"1.0","sha256":"hash","arch":"amd64"}
+	{"kind":"path","path":"/etc/foo.conf","mode":"0644","slices":["mypkg_config"],"sha256":"%s","size":3,"uid":%d}
+	{"kind":"path","path":"/etc/mutable","mode":"0644","slices":["mypkg_config"],"sha256":"%s","final_sha256":"%s","size":7}
+	{"kind":"path","path":"/usr/bin/","mode":"0755","slices":["mypkg_bins"]}
+	{"kind":"path","path":"/usr/bin/foo","mode":"0755","slices":["mypkg_bins"],"sha256":"%s","size":6}
+	{"kind":"path","path":"/usr/bin/link","mode":"0777","slices":["mypkg_bins"],"link":"foo"}
+	{"kind":"path","path":"/var/lib/chisel/manifest.wall","mode":"0644","slices":["mypkg_manifest"]}
+	{"kind":"slice","name":"mypkg_bins"}
+`, sha256hex("foo"), os.Getuid(), sha256hex("initial"), sha256hex("mutated"), sha256hex("binary"))
+
+var verifyTests = []struct {
+	summary string
+	change  func(c *C, rootDir string)
+	root    bool
+	quick   bool
+	stdout  string
+	err     string
+}{{
+	summary: "Root matching the manifest",
+}, {
+	summary: "Missing, added and modified paths",
+	change: func(c *C, rootDir string) {
+		c.Assert(os.Remove(filepath.Join(rootDir, "etc/foo.conf")), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "etc/new"), []byte("new"), 0644), IsNil)
+		c.Assert(os.Mkdir(filepath.Join(rootDir, "etc/newdir"), 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "usr/bin/foo"), []byte("changed"), 0755), IsNil)
+		c.Assert(os.Chmod(filepath.Join(rootDir, "usr/bin"), 0700), IsNil)
+		c.Assert(os.Remove(filepath.Join(rootDir, "usr/bin/link")), IsNil)
+		c.Assert(os.Symlink("bar", filepath.Join(rootDir, "usr/bin/link")), IsNil)
+	},
+	stdout: `
+		missing /etc/foo.conf
+		added /etc/new
+		modified /usr/bin/: mode is 0700, expected 0755
+		modified /usr/bin/foo: size is 7, expected 6
+		modified /usr/bin/foo: sha256 is ` + sha256hex("changed") + `, expected ` + sha256hex("binary") + `
+		modified /usr/bin/link: link is "bar", expected "foo"
+	`,
+	err: "root has 6 discrepancies with the manifest",
+}, {
+	summary: "Mutable files are compared with their final content",
+	change: func(c *C, rootDir string) {
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "etc/mutable"), []byte("initial"), 0644), IsNil)
+	},
+	stdout: `
+		modified /etc/mutable: sha256 is ` + sha256hex("initial") + `, expected ` + sha256hex("mutated") + `
+	`,
+	err: "root has 1 discrepancies with the manifest",
+}, {
+	summary: "Owner set in the slice",
+	change: func(c *C, rootDir string) {
+		c.Assert(os.Lchown(filepath.Join(rootDir, "etc/foo.conf"), os.Getuid()+1, -1), IsNil)
+	},
+	root: true,
+	stdout: fmt.Sprintf(`
+		modified /etc/foo.conf: uid is %d, expected %d
+	`, os.Getuid()+1, os.Getuid()),
+	err: "root has 1 discrepancies with the manifest",
+}, {
+	summary: "Path of a different type",
+	change: func(c *C, rootDir string) {
+		c.Assert(os.Remove(filepath.Join(rootDir, "etc/foo.conf")), IsNil)
+		c.Assert(os.Mkdir(filepath.Join(rootDir, "etc/foo.conf"), 0755), IsNil)
+	},
+	stdout: `
+		modified /etc/foo.conf: type is directory, expected file
+	`,
+	err: "root has 1 discrepancies with the manifest",
+}, {
+	summary: "Content change preserving size and time",
+	change:  changePreservingSize,
+	stdout: `
+		modified /etc/foo.conf: sha256 is ` + sha256hex("oof") + `, expected ` + sha256hex("foo") + `
+	`,
+	err: "root has 1 discrepancies with the manifest",
+}, {
+	summary: "Quick mode misses content change preserving size and time",
+	change:  changePreservingSize,
+	quick:   true,
+}, {
+	summary: "Quick mode hashes files modified after the manifest",
+	change: func(c *C, rootDir string) {
+		path := filepath.Join(rootDir, "etc/foo.conf")
+		c.Assert(os.WriteFile(path, []byte("oof"), 0644), IsNil)
+		later := time.Now().Add(time.Hour)
+		c.Assert(os.Chtimes(path, later, later), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "usr/bin/foo"), []byte("changed"), 0755), IsNil)
+	},
+	quick: true,
+	stdout: `
+		modified /etc/foo.conf: sha256 is ` + sha256hex("oof") + `, expected ` + sha256hex("foo") + `
+		modified /usr/bin/foo: size is 7, expected 6
+		modified /usr/bin/foo: sha256 is ` + sha256hex("changed") + `, expected ` + sha256hex("binary") + `
+	`,
+	err: "root has 3 discrepancies with the manifest",
+}}
+
+// changePreservingSize changes the content of a file without changing its
+// size and sets its modification time back.
+func changePreservingSize(c *C, rootDir string) {
+	path := filepath.Join(rootDir, "etc/foo.conf")
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(os.WriteFile(path, []byte("oof"), 0644), IsNil)
+	c.Assert(os.Chtimes(path, info.ModTime(), info.ModTime()), IsNil)
+}
+
+func (s *ChiselSuite) TestVerifyCommand(c *C) {
+	for _, test := range verifyTests {
+		c.Logf("Summary: %s", test.summary)
+		if test.root && os.Getuid() != 0 {
+			c.Logf("Skipped: changing the owner requires root")
+			continue
+		}
+
+		rootDir := c.MkDir()
+		for _, dir := range []string{"etc", "usr/bin", "var/lib/chisel"} {
+			c.Assert(os.MkdirAll(filepath.Join(rootDir, dir), 0755), IsNil)
+		}
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "etc/foo.conf"), []byte("foo"), 0644), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "etc/mutable"), []byte("mutated"), 0644), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(rootDir, "usr/bin/foo"), []byte("binary"), 0755), IsNil)
+		c.Assert(os.Symlink("foo", filepath.Join(rootDir, "usr/bin/link")), IsNil)
+		f, err := os.Create(filepath.Join(rootDir, "var/lib/chisel/manifest.wall"))
+		c.Assert(err, IsNil)
+		w, err := zstd.NewWriter(f)
+		c.Assert(err, IsNil)
+		_, err = w.Write(testutil.Reindent(verifyManifest))
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		if test.change != nil {
+			test.change(c, rootDir)
+		}
+
+		s.ResetStdStreams()
+		args := []string{"verify", "--root", rootDir}
+		if test.quick {
+			args = append(args, "--quick")
+		}
+		_, err = chisel.Parser().ParseArgs(args)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		if test.stdout == "" {
+			c.Assert(s.Stdout(), Equals, "")
+		} else {
+			test.stdout = string(testutil.Reindent(test.stdout))
+			c.Assert(s.Stdout(), Equals, strings.TrimSpace(test.stdout)+"\n")
+		}
+	}
+}
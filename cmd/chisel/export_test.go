@@ -1,5 +1,9 @@
 package main
 
+import (
+	"github.com/canonical/chisel/internal/archive"
+)
+
 var RunMain = run
 
 func FakeIsStdoutTTY(t bool) (restore func()) {
@@ -19,3 +23,55 @@ func FakeIsStdinTTY(t bool) (restore func()) {
 }
 
 var FindSlices = findSlices
+
+var FilterBySection = filterBySection
+
+var AddInlineSlice = addInlineSlice
+
+var ReadManifest = readManifest
+
+var SetupLogging = setupLogging
+
+var Logf = logf
+var Debugf = debugf
+var SetArchivePriorities = setArchivePriorities
+
+var ParseArchiveURLs = parseArchiveURLs
+
+var SourceDateEpoch = sourceDateEpoch
+var CutModTime = cutModTime
+
+var RenderProgress = renderProgress
+
+var AskPreferences = askPreferences
+var ReadPrefers = readPrefers
+var WritePrefers = writePrefers
+
+// RemoteSyncArgs returns the arguments of the command transferring dir to
+// root, or nil if root is local.
+func RemoteSyncArgs(root, dir string, xattrs bool) ([]string, error) {
+	remote, err := parseRemoteRoot(root)
+	if err != nil || remote == nil {
+		return nil, err
+	}
+	return remote.syncCommand(dir, xattrs).Args, nil
+}
+
+var CompareTrees = compareTrees
+var WriteTarball = writeTarball
+var WriteTarballFile = func(output, compression, dir string) (digest, diffID string, size int64, err error) {
+	digests, err := writeTarballFile(output, compression, dir, false)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return digests.Digest, digests.DiffID, digests.Size, nil
+}
+var TarballCompression = tarballCompression
+
+func FakeArchiveOpen(open func(options *archive.Options) (archive.Archive, error)) (restore func()) {
+	oldArchiveOpen := archiveOpen
+	archiveOpen = open
+	return func() {
+		archiveOpen = oldArchiveOpen
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 // TODO These need testing
@@ -47,8 +54,20 @@ func readReleaseInfo() (label, version string, err error) {
 // * the path to a directory containing a previously fetched release,
 // * "" and Chisel will attempt to read the release label from the host.
 func obtainRelease(releaseStr string) (release *setup.Release, err error) {
+	return obtainReleaseWithFormat(releaseStr, "")
+}
+
+// obtainReleaseWithFormat is like obtainRelease, but parses the release as if
+// its format were formatOverride when that is not empty.
+func obtainReleaseWithFormat(releaseStr, formatOverride string) (release *setup.Release, err error) {
+	return obtainReleaseWithOptions(releaseStr, &setup.ReadOptions{FormatOverride: formatOverride})
+}
+
+// obtainReleaseWithOptions is like obtainRelease, but reads the release with
+// the provided options.
+func obtainReleaseWithOptions(releaseStr string, options *setup.ReadOptions) (release *setup.Release, err error) {
 	if strings.Contains(releaseStr, "/") {
-		release, err = setup.ReadRelease(releaseStr)
+		release, err = setup.ReadReleaseWithOptions(releaseStr, options)
 	} else {
 		var label, version string
 		if releaseStr == "" {
@@ -60,8 +79,10 @@ func obtainRelease(releaseStr string) (release *setup.Release, err error) {
 			return nil, err
 		}
 		release, err = setup.FetchRelease(&setup.FetchOptions{
-			Label:   label,
-			Version: version,
+			Label:          label,
+			Version:        version,
+			FormatOverride: options.FormatOverride,
+			Prefer:         options.Prefer,
 		})
 	}
 	if err != nil {
@@ -69,3 +90,160 @@ func obtainRelease(releaseStr string) (release *setup.Release, err error) {
 	}
 	return release, nil
 }
+
+// readManifest reads the zstd-compressed Chisel manifest at path.
+func readManifest(path string) (*manifest.Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	defer f.Close()
+	r, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	defer r.Close()
+	return manifest.Read(r)
+}
+
+// archiveSource holds where the archives of a release are fetched from and
+// their packages cached, as given in the command line with --archive-url and
+// --cache-dir.
+type archiveSource struct {
+	urls     map[string]string
+	cacheDir string
+}
+
+// newArchiveSource applies the archive priorities given with
+// --archive-priority to release, and returns the source of its archives
+// according to the values of --archive-url and --cache-dir.
+func newArchiveSource(release *setup.Release, priorities, urls []string, cacheDir string) (*archiveSource, error) {
+	err := setArchivePriorities(release, priorities)
+	if err != nil {
+		return nil, err
+	}
+	archiveURLs, err := parseArchiveURLs(release, urls)
+	if err != nil {
+		return nil, err
+	}
+	if cacheDir == "" {
+		cacheDir = cache.DefaultDir("chisel")
+	}
+	return &archiveSource{urls: archiveURLs, cacheDir: cacheDir}, nil
+}
+
+// options returns the options for opening the archive of release named
+// archiveName for arch.
+func (source *archiveSource) options(release *setup.Release, archiveName, arch string) *archive.Options {
+	archiveInfo := release.Archives[archiveName]
+	return &archive.Options{
+		Label:        archiveName,
+		Version:      archiveInfo.Version,
+		Arch:         arch,
+		Suites:       archiveInfo.Suites,
+		Components:   archiveInfo.Components,
+		Pro:          archiveInfo.Pro,
+		CacheDir:     source.cacheDir,
+		PubKeys:      archiveInfo.PubKeys,
+		KeyExpiry:    archiveInfo.KeyExpiry,
+		ReleaseLabel: archiveInfo.ReleaseLabel,
+		BaseURL:      source.urls[archiveName],
+	}
+}
+
+// openArchive opens the archive with the provided options. It returns a nil
+// archive, and no error, when the archive is ignored because its credentials
+// are not found or it is unavailable.
+func openArchive(options *archive.Options) (archive.Archive, error) {
+	opened, err := archiveOpen(options)
+	if err == archive.ErrCredentialsNotFound {
+		logf("Archive %q ignored: credentials not found", options.Label)
+		return nil, nil
+	}
+	if errors.Is(err, archive.ErrArchiveUnavailable) {
+		logf("Archive %q ignored: %v", options.Label, err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return opened, nil
+}
+
+// packageArchives finds the archive providing each package of a release,
+// which is the archive pinned for the package or otherwise the highest
+// priority one which has it. Archives are opened once, when first needed.
+type packageArchives struct {
+	release *setup.Release
+	arch    string
+	source  *archiveSource
+	opened  map[string]archive.Archive
+}
+
+// newPackageArchives returns the package archives of release for arch,
+// opened from source. A nil source means the default archive URLs and
+// cache directory.
+func newPackageArchives(release *setup.Release, arch string, source *archiveSource) *packageArchives {
+	if source == nil {
+		source = &archiveSource{cacheDir: cache.DefaultDir("chisel")}
+	}
+	return &packageArchives{
+		release: release,
+		arch:    arch,
+		source:  source,
+		opened:  make(map[string]archive.Archive),
+	}
+}
+
+func (pa *packageArchives) find(pkgName string) (archive.Archive, error) {
+	pkgArchive, err := pa.lookup(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	if pkgArchive == nil {
+		return nil, fmt.Errorf("cannot find package %q in archive(s)", pkgName)
+	}
+	return pkgArchive, nil
+}
+
+// lookup is like find but returns a nil archive, and no error, when none
+// of the archives has the package.
+func (pa *packageArchives) lookup(pkgName string) (archive.Archive, error) {
+	candidates := archivesByPriority(pa.release)
+	if pkg, ok := pa.release.Packages[pkgName]; ok && pkg.Archive != "" {
+		candidates = []string{pkg.Archive}
+	}
+	for _, archiveName := range candidates {
+		pkgArchive, ok := pa.opened[archiveName]
+		if !ok {
+			var err error
+			pkgArchive, err = openArchive(pa.source.options(pa.release, archiveName, pa.arch))
+			if err != nil {
+				return nil, err
+			}
+			pa.opened[archiveName] = pkgArchive
+		}
+		if pkgArchive != nil && pkgArchive.Exists(pkgName) {
+			return pkgArchive, nil
+		}
+	}
+	return nil, nil
+}
+
+// archivesByPriority returns the names of the archives that may provide
+// unpinned packages, from the highest priority to the lowest.
+func archivesByPriority(release *setup.Release) []string {
+	var archives []*setup.Archive
+	for _, archive := range release.Archives {
+		if archive.Priority < 0 {
+			continue
+		}
+		archives = append(archives, archive)
+	}
+	slices.SortFunc(archives, setup.CompareArchives)
+	names := make([]string, len(archives))
+	for i, archive := range archives {
+		names[i] = archive.Name
+	}
+	return names
+}
@@ -14,6 +14,7 @@ type log_Logger interface {
 
 var globalLoggerLock sync.Mutex
 var globalLogger log_Logger
+var globalDebugLogger log_Logger
 var globalDebug bool
 
 // Specify the *log.Logger object where log messages should be sent to.
@@ -23,6 +24,14 @@ func SetLogger(logger log_Logger) {
 	globalLoggerLock.Unlock()
 }
 
+// Specify the *log.Logger object where debug messages should be sent to,
+// instead of the one set via SetLogger.
+func SetDebugLogger(logger log_Logger) {
+	globalLoggerLock.Lock()
+	globalDebugLogger = logger
+	globalLoggerLock.Unlock()
+}
+
 // Enable the delivery of debug messages to the logger.  Only meaningful
 // if a logger is also set.
 func SetDebug(debug bool) {
@@ -41,14 +50,18 @@ func logf(format string, args ...interface{}) {
 	}
 }
 
-// debugf sends to the logger registered via SetLogger the string resulting
-// from running format and args through Sprintf, but only if debugging was
-// enabled via SetDebug.
+// debugf sends to the logger registered via SetDebugLogger, or otherwise
+// via SetLogger, the string resulting from running format and args through
+// Sprintf, but only if debugging was enabled via SetDebug.
 func debugf(format string, args ...interface{}) {
 	globalLoggerLock.Lock()
 	defer globalLoggerLock.Unlock()
-	if globalDebug && globalLogger != nil {
-		globalLogger.Output(2, fmt.Sprintf(format, args...))
+	logger := globalLogger
+	if globalDebugLogger != nil {
+		logger = globalDebugLogger
+	}
+	if globalDebug && logger != nil {
+		logger.Output(2, fmt.Sprintf(format, args...))
 	}
 }
 
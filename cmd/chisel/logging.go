@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+// logPackages are the packages whose messages are logged, by name.
+var logPackages = []struct {
+	name           string
+	setLogger      func(logger log_Logger)
+	setDebugLogger func(logger log_Logger)
+	setDebug       func(debug bool)
+}{
+	{"archive", func(logger log_Logger) { archive.SetLogger(logger) }, func(logger log_Logger) { archive.SetDebugLogger(logger) }, archive.SetDebug},
+	{"deb", func(logger log_Logger) { deb.SetLogger(logger) }, func(logger log_Logger) { deb.SetDebugLogger(logger) }, deb.SetDebug},
+	{"fsutil", func(logger log_Logger) { fsutil.SetLogger(logger) }, func(logger log_Logger) { fsutil.SetDebugLogger(logger) }, fsutil.SetDebug},
+	{"setup", func(logger log_Logger) { setup.SetLogger(logger) }, func(logger log_Logger) { setup.SetDebugLogger(logger) }, setup.SetDebug},
+	{"slicer", func(logger log_Logger) { slicer.SetLogger(logger) }, func(logger log_Logger) { slicer.SetDebugLogger(logger) }, slicer.SetDebug},
+	{"chisel", SetLogger, SetDebugLogger, SetDebug},
+}
+
+// setupLogging sets the loggers of the packages according to the
+// --log-format and --log-level options. With the "error" level nothing is
+// logged, and with the "debug" level the debug messages, such as the paths
+// created, are logged as well with their own level.
+func setupLogging(format, level string) {
+	debug := level == "debug"
+	for _, pkg := range logPackages {
+		var logger, debugLogger log_Logger
+		if level != "error" {
+			logger = newLogger(format, "info", pkg.name)
+		}
+		if debug {
+			debugLogger = newLogger(format, "debug", pkg.name)
+		}
+		pkg.setLogger(logger)
+		pkg.setDebugLogger(debugLogger)
+		pkg.setDebug(debug)
+	}
+}
+
+func newLogger(format, level, pkgName string) log_Logger {
+	if format == "json" {
+		return &jsonLogger{level: level, pkgName: pkgName}
+	}
+	return log.Default()
+}
+
+// jsonLogger writes every message to Stderr as a JSON record on its own
+// line.
+type jsonLogger struct {
+	level   string
+	pkgName string
+}
+
+type jsonLogRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Package string `json:"package"`
+	Message string `json:"message"`
+}
+
+func (l *jsonLogger) Output(calldepth int, s string) error {
+	data, err := json.Marshal(&jsonLogRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   l.level,
+		Package: l.pkgName,
+		Message: s,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = Stderr.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,118 @@
+package main_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type logRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Package string `json:"package"`
+	Message string `json:"message"`
+}
+
+// cutWithLogOptions cuts a slice with the given global options, and returns
+// the JSON log records with their time cleared along with the release
+// directory.
+func (s *ChiselSuite) cutWithLogOptions(c *C, logOptions ...string) ([]logRecord, string) {
+	restore := chisel.FakeArchiveOpen(func(options *archive.Options) (archive.Archive, error) {
+		return &fakeArchive{
+			options:  *options,
+			versions: map[string]string{"mypkg": "1.0"},
+			debs:     map[string][]byte{"mypkg": selectFromManifestPackage},
+		}, nil
+	})
+	defer restore()
+	defer chisel.SetupLogging("text", "error")
+
+	releaseDir := c.MkDir()
+	for path, data := range selectFromManifestRelease {
+		fpath := filepath.Join(releaseDir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	s.ResetStdStreams()
+	args := append(logOptions, "cut", "--release", releaseDir, "--root", c.MkDir(), "--arch", "amd64", "mypkg_bins")
+	_, err := chisel.Parser().ParseArgs(args)
+	c.Assert(err, IsNil)
+
+	var records []logRecord
+	for _, line := range strings.Split(strings.TrimSpace(s.Stderr()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record logRecord
+		err := json.Unmarshal([]byte(line), &record)
+		c.Assert(err, IsNil, Commentf("line: %s", line))
+		_, err = time.Parse(time.RFC3339Nano, record.Time)
+		c.Assert(err, IsNil)
+		record.Time = ""
+		records = append(records, record)
+	}
+	return records, releaseDir
+}
+
+func (s *ChiselSuite) TestLogFormatJSON(c *C) {
+	records, releaseDir := s.cutWithLogOptions(c, "--log-format=json")
+	c.Assert(records, DeepEquals, []logRecord{
+		{Level: "info", Package: "setup", Message: "Processing " + releaseDir + " release..."},
+		{Level: "info", Package: "setup", Message: "Selecting slices..."},
+		{Level: "info", Package: "deb", Message: `Extracting files from package "mypkg"...`},
+		{Level: "info", Package: "slicer", Message: "Generating manifest at /var/lib/chisel/manifest.wall..."},
+	})
+}
+
+func (s *ChiselSuite) TestLogLevel(c *C) {
+	records, _ := s.cutWithLogOptions(c, "--log-format=json", "--log-level=debug")
+	var debugRecords int
+	for _, record := range records {
+		if record.Level == "debug" {
+			c.Assert(record.Package, Equals, "fsutil")
+			debugRecords++
+		}
+	}
+	c.Assert(debugRecords > 0, Equals, true)
+	c.Assert(len(records) > debugRecords, Equals, true)
+
+	records, _ = s.cutWithLogOptions(c, "--log-format=json", "--log-level=error")
+	c.Assert(records, HasLen, 0)
+}
+
+func (s *ChiselSuite) TestLogLevelDebugRecords(c *C) {
+	defer chisel.SetupLogging("text", "error")
+
+	for _, level := range []string{"info", "debug"} {
+		c.Logf("Level: %s", level)
+		s.ResetStdStreams()
+		chisel.SetupLogging("json", level)
+		chisel.Logf("info message")
+		chisel.Debugf("debug message")
+
+		var records []logRecord
+		for _, line := range strings.Split(strings.TrimSpace(s.Stderr()), "\n") {
+			var record logRecord
+			err := json.Unmarshal([]byte(line), &record)
+			c.Assert(err, IsNil, Commentf("line: %s", line))
+			record.Time = ""
+			records = append(records, record)
+		}
+		expected := []logRecord{
+			{Level: "info", Package: "chisel", Message: "info message"},
+		}
+		if level == "debug" {
+			expected = append(expected, logRecord{Level: "debug", Package: "chisel", Message: "debug message"})
+		}
+		c.Assert(records, DeepEquals, expected)
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
 	"unicode"
@@ -11,11 +10,6 @@ import (
 
 	"github.com/jessevdk/go-flags"
 	"golang.org/x/term"
-
-	"github.com/canonical/chisel/internal/archive"
-	"github.com/canonical/chisel/internal/deb"
-	"github.com/canonical/chisel/internal/setup"
-	"github.com/canonical/chisel/internal/slicer"
 	//"github.com/canonical/chisel/internal/logger"
 )
 
@@ -31,7 +25,9 @@ var (
 )
 
 type options struct {
-	Version func() `long:"version"`
+	Version   func() `long:"version"`
+	LogFormat string `long:"log-format" value-name:"<format>" choice:"text" choice:"json" description:"Format of the log messages (text or json)"`
+	LogLevel  string `long:"log-level" value-name:"<level>" choice:"error" choice:"info" choice:"debug" description:"Log messages up to the level (error, info or debug)"`
 }
 
 type argDesc struct {
@@ -154,6 +150,7 @@ func fixupArg(optName string) string {
 // Since commands have local state a fresh parser is required to isolate tests
 // from each other.
 func Parser() *flags.Parser {
+	optionsData = options{}
 	optionsData.Version = func() {
 		err := printVersions()
 		if err != nil {
@@ -171,6 +168,19 @@ func Parser() *flags.Parser {
 		version.Description = "Print the version and exit"
 		version.Hidden = true
 	}
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if optionsData.LogFormat != "" || optionsData.LogLevel != "" {
+			level := optionsData.LogLevel
+			if level == "" {
+				level = "info"
+			}
+			setupLogging(optionsData.LogFormat, level)
+		}
+		if command == nil {
+			return nil
+		}
+		return command.Execute(args)
+	}
 	// add --help like what go-flags would do for us, but hidden
 	err := addHelp(parser)
 	if err != nil {
@@ -323,11 +333,7 @@ func (e *exitStatus) Error() string {
 }
 
 func run() error {
-	archive.SetLogger(log.Default())
-	deb.SetLogger(log.Default())
-	setup.SetLogger(log.Default())
-	slicer.SetLogger(log.Default())
-	SetLogger(log.Default())
+	setupLogging("text", "info")
 
 	parser := Parser()
 	xtra, err := parser.Parse()
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+// printCutPlan writes what cutting the selection would do, without fetching
+// any package: the packages with their versions and archives, the paths
+// listed by each selected slice, and the preferences resolving conflicts.
+func printCutPlan(w io.Writer, selection *setup.Selection, archives map[string]archive.Archive, prefer map[string]string) error {
+	pkgArchive, err := slicer.PackageArchives(archives, selection)
+	if err != nil {
+		return err
+	}
+
+	slicesByPkg := make(map[string][]*setup.Slice)
+	var pkgNames []string
+	for _, slice := range selection.Slices {
+		if _, ok := slicesByPkg[slice.Package]; !ok {
+			pkgNames = append(pkgNames, slice.Package)
+		}
+		slicesByPkg[slice.Package] = append(slicesByPkg[slice.Package], slice)
+	}
+	sort.Strings(pkgNames)
+
+	fmt.Fprintf(w, "packages:\n")
+	for _, pkgName := range pkgNames {
+		fmt.Fprintf(w, "  %s:\n", pkgName)
+		// The version is only shown when the archive index has it.
+		if info, err := pkgArchive[pkgName].Info(pkgName); err == nil && info.Version != "" {
+			fmt.Fprintf(w, "    version: %s\n", info.Version)
+		}
+		fmt.Fprintf(w, "    archive: %s\n", pkgArchive[pkgName].Options().Label)
+		fmt.Fprintf(w, "    slices:\n")
+		pkgSlices := slicesByPkg[pkgName]
+		sort.Slice(pkgSlices, func(i, j int) bool {
+			return pkgSlices[i].Name < pkgSlices[j].Name
+		})
+		for _, slice := range pkgSlices {
+			paths := make([]string, 0, len(slice.Contents))
+			for path := range slice.Contents {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			if len(paths) == 0 {
+				fmt.Fprintf(w, "      %s: []\n", slice)
+				continue
+			}
+			fmt.Fprintf(w, "      %s:\n", slice)
+			for _, path := range paths {
+				fmt.Fprintf(w, "        - %s\n", path)
+			}
+		}
+	}
+
+	if len(prefer) > 0 {
+		paths := make([]string, 0, len(prefer))
+		for path := range prefer {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(w, "preferred:\n")
+		for _, path := range paths {
+			fmt.Fprintf(w, "  %s: %s\n", path, prefer[path])
+		}
+	}
+	return nil
+}
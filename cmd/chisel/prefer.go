@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// readPrefers reads the YAML file mapping paths to the package preferred
+// for them.
+func readPrefers(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read prefers: %w", err)
+	}
+	prefer := make(map[string]string)
+	err = yaml.Unmarshal(data, &prefer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot parse prefers: %v", path, err)
+	}
+	for prefPath, pkgName := range prefer {
+		if !strings.HasPrefix(prefPath, "/") {
+			return nil, fmt.Errorf("%s: invalid path %q: must be absolute", path, prefPath)
+		}
+		if pkgName == "" {
+			return nil, fmt.Errorf("%s: no package preferred for path %s", path, prefPath)
+		}
+	}
+	return prefer, nil
+}
+
+func writePrefers(path string, prefer map[string]string) error {
+	data, err := yaml.Marshal(prefer)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write prefers: %w", err)
+	}
+	return nil
+}
+
+// askPreferences asks which package provides each of the paths that slices
+// of different packages conflict on, and records the answers in prefer. It
+// returns how many paths were resolved. Conflicts with globs cannot be
+// resolved by preferring a package and are left as they are.
+func askPreferences(conflictErr *setup.ConflictError, prefer map[string]string, input *bufio.Reader) (resolved int, err error) {
+	for _, conflict := range conflictErr.Conflicts {
+		pkgs := []string{conflict.Slices[0].Package, conflict.Slices[1].Package}
+		if conflict.Kind != setup.PathConflict || pkgs[0] == pkgs[1] {
+			continue
+		}
+		path := conflict.Paths[0]
+		if _, ok := prefer[path]; ok {
+			continue
+		}
+		fmt.Fprintf(Stdout, "Slices %s and %s conflict on %s.\n", conflict.Slices[0], conflict.Slices[1], path)
+		for prefer[path] == "" {
+			fmt.Fprintf(Stdout, "Which package provides %s? [1] %s [2] %s: ", path, pkgs[0], pkgs[1])
+			line, err := input.ReadString('\n')
+			answer := strings.TrimSpace(line)
+			switch answer {
+			case "1", pkgs[0]:
+				prefer[path] = pkgs[0]
+			case "2", pkgs[1]:
+				prefer[path] = pkgs[1]
+			default:
+				if err == io.EOF {
+					fmt.Fprintln(Stdout)
+					return resolved, fmt.Errorf("cannot resolve conflict on %s: no package chosen", path)
+				}
+				if err != nil {
+					return resolved, err
+				}
+				fmt.Fprintf(Stdout, "Invalid answer %q.\n", answer)
+			}
+		}
+		resolved++
+	}
+	return resolved, nil
+}
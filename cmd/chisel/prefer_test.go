@@ -0,0 +1,143 @@
+package main_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var preferConflicts = &setup.ConflictError{Conflicts: []*setup.Conflict{{
+	Kind:   setup.GlobConflict,
+	Slices: [2]setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
+	Paths:  []string{"/dir/**", "/dir/file"},
+}, {
+	Kind:   setup.PathConflict,
+	Slices: [2]setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
+	Paths:  []string{"/file"},
+}, {
+	Kind:   setup.PathConflict,
+	Slices: [2]setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg3", "myslice"}},
+	Paths:  []string{"/other"},
+}}}
+
+var askPreferencesTests = []struct {
+	summary  string
+	input    string
+	prefer   map[string]string
+	resolved int
+	stdout   string
+	err      string
+}{{
+	summary:  "Answers by number and by name",
+	input:    "2\nmypkg1\n",
+	prefer:   map[string]string{"/file": "mypkg2", "/other": "mypkg1"},
+	resolved: 2,
+	stdout: "" +
+		"Slices mypkg1_myslice and mypkg2_myslice conflict on /file.\n" +
+		"Which package provides /file? [1] mypkg1 [2] mypkg2: " +
+		"Slices mypkg1_myslice and mypkg3_myslice conflict on /other.\n" +
+		"Which package provides /other? [1] mypkg1 [2] mypkg3: ",
+}, {
+	summary:  "Invalid answers are asked again",
+	input:    "3\n\n1\n2\n",
+	prefer:   map[string]string{"/file": "mypkg1", "/other": "mypkg3"},
+	resolved: 2,
+	stdout: "" +
+		"Slices mypkg1_myslice and mypkg2_myslice conflict on /file.\n" +
+		"Which package provides /file? [1] mypkg1 [2] mypkg2: " +
+		"Invalid answer \"3\".\n" +
+		"Which package provides /file? [1] mypkg1 [2] mypkg2: " +
+		"Invalid answer \"\".\n" +
+		"Which package provides /file? [1] mypkg1 [2] mypkg2: " +
+		"Slices mypkg1_myslice and mypkg3_myslice conflict on /other.\n" +
+		"Which package provides /other? [1] mypkg1 [2] mypkg3: ",
+}, {
+	summary:  "Missing answer",
+	input:    "1\n",
+	prefer:   map[string]string{"/file": "mypkg1"},
+	resolved: 1,
+	stdout: "" +
+		"Slices mypkg1_myslice and mypkg2_myslice conflict on /file.\n" +
+		"Which package provides /file? [1] mypkg1 [2] mypkg2: " +
+		"Slices mypkg1_myslice and mypkg3_myslice conflict on /other.\n" +
+		"Which package provides /other? [1] mypkg1 [2] mypkg3: \n",
+	err: `cannot resolve conflict on /other: no package chosen`,
+}}
+
+func (s *ChiselSuite) TestAskPreferences(c *C) {
+	for _, test := range askPreferencesTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.ResetStdStreams()
+
+		prefer := map[string]string{}
+		input := bufio.NewReader(strings.NewReader(test.input))
+		resolved, err := chisel.AskPreferences(preferConflicts, prefer, input)
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		c.Assert(resolved, Equals, test.resolved)
+		c.Assert(prefer, DeepEquals, test.prefer)
+		c.Assert(s.Stdout(), Equals, test.stdout)
+	}
+}
+
+func (s *ChiselSuite) TestPrefersFile(c *C) {
+	path := filepath.Join(c.MkDir(), "prefers.yaml")
+
+	_, err := chisel.ReadPrefers(path)
+	c.Assert(err, ErrorMatches, `cannot read prefers: open .*/prefers.yaml: no such file or directory`)
+
+	err = chisel.WritePrefers(path, map[string]string{"/file": "mypkg2", "/dir/other": "mypkg1"})
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "/dir/other: mypkg1\n/file: mypkg2\n")
+
+	prefer, err := chisel.ReadPrefers(path)
+	c.Assert(err, IsNil)
+	c.Assert(prefer, DeepEquals, map[string]string{"/file": "mypkg2", "/dir/other": "mypkg1"})
+}
+
+var readPrefersErrorTests = []struct {
+	data string
+	err  string
+}{{
+	data: "- foo\n",
+	err:  `(?s).*/prefers.yaml: cannot parse prefers: .*`,
+}, {
+	data: "dir/file: mypkg1\n",
+	err:  `.*/prefers.yaml: invalid path "dir/file": must be absolute`,
+}, {
+	data: "/dir/file:\n",
+	err:  `.*/prefers.yaml: no package preferred for path /dir/file`,
+}}
+
+func (s *ChiselSuite) TestReadPrefersErrors(c *C) {
+	path := filepath.Join(c.MkDir(), "prefers.yaml")
+	for _, test := range readPrefersErrorTests {
+		err := os.WriteFile(path, []byte(test.data), 0644)
+		c.Assert(err, IsNil)
+		_, err = chisel.ReadPrefers(path)
+		c.Assert(err, ErrorMatches, test.err)
+	}
+}
+
+func (s *ChiselSuite) TestCutPrefersMissingFile(c *C) {
+	dir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--release", dir, "--root", dir, "--prefers", filepath.Join(dir, "missing.yaml"), "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `cannot read prefers: open .*/missing.yaml: no such file or directory`)
+}
+
+func (s *ChiselSuite) TestCutInteractiveRequiresTerminal(c *C) {
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--root", c.MkDir(), "--interactive", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `--interactive requires a terminal`)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+const progressBarWidth = 30
+
+// renderProgress redraws in place on w a progress bar for the event. The line
+// is terminated once the last package is extracted. The bar only moves once
+// packages are done with a phase.
+func renderProgress(w io.Writer, event *slicer.ProgressEvent) {
+	if event.Started || event.Path != "" {
+		return
+	}
+	label := "Fetching"
+	if event.Phase == slicer.ExtractPhase {
+		label = "Extracting"
+	}
+	filled := progressBarWidth
+	if event.TotalBytes > 0 {
+		filled = int(int64(progressBarWidth) * event.Bytes / event.TotalBytes)
+	} else if event.TotalPackages > 0 {
+		filled = progressBarWidth * event.Packages / event.TotalPackages
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(w, "\r\033[K%-10s [%s] %d/%d packages, %s/%s", label, bar,
+		event.Packages, event.TotalPackages, formatBytes(event.Bytes), formatBytes(event.TotalBytes))
+	if event.Phase == slicer.ExtractPhase && event.Packages == event.TotalPackages {
+		fmt.Fprintln(w)
+	}
+}
+
+// formatBytes returns n as a short human readable size.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"kB", "MB", "GB"} {
+		value /= unit
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f%s", value, suffix)
+		}
+	}
+	panic("unreachable")
+}
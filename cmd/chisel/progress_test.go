@@ -0,0 +1,85 @@
+package main_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var renderProgressTests = []struct {
+	summary string
+	event   slicer.ProgressEvent
+	output  string
+}{{
+	summary: "Fetch in progress",
+	event: slicer.ProgressEvent{
+		Phase:         slicer.FetchPhase,
+		Package:       "mypkg",
+		Packages:      1,
+		TotalPackages: 3,
+		Bytes:         1500,
+		TotalBytes:    4500,
+	},
+	output: "\r\033[KFetching   [==========                    ] 1/3 packages, 1.5kB/4.5kB",
+}, {
+	summary: "Extraction in progress",
+	event: slicer.ProgressEvent{
+		Phase:         slicer.ExtractPhase,
+		Package:       "mypkg",
+		Packages:      2,
+		TotalPackages: 3,
+		Bytes:         3000,
+		TotalBytes:    4500,
+	},
+	output: "\r\033[KExtracting [====================          ] 2/3 packages, 3.0kB/4.5kB",
+}, {
+	summary: "Extraction done",
+	event: slicer.ProgressEvent{
+		Phase:         slicer.ExtractPhase,
+		Package:       "mypkg",
+		Packages:      3,
+		TotalPackages: 3,
+		Bytes:         2500000,
+		TotalBytes:    2500000,
+	},
+	output: "\r\033[KExtracting [==============================] 3/3 packages, 2.5MB/2.5MB\n",
+}, {
+	summary: "Unknown sizes",
+	event: slicer.ProgressEvent{
+		Phase:         slicer.FetchPhase,
+		Package:       "mypkg",
+		Packages:      1,
+		TotalPackages: 2,
+	},
+	output: "\r\033[KFetching   [===============               ] 1/2 packages, 0B/0B",
+}, {
+	summary: "Packages starting a phase are not shown",
+	event: slicer.ProgressEvent{
+		Phase:         slicer.FetchPhase,
+		Package:       "mypkg",
+		Started:       true,
+		TotalPackages: 2,
+	},
+	output: "",
+}, {
+	summary: "Created paths are not shown",
+	event: slicer.ProgressEvent{
+		Phase:         slicer.ExtractPhase,
+		Package:       "mypkg",
+		Path:          "/etc/mypkg.conf",
+		TotalPackages: 2,
+	},
+	output: "",
+}}
+
+func (s *ChiselSuite) TestRenderProgress(c *C) {
+	for _, test := range renderProgressTests {
+		c.Logf("Summary: %s", test.summary)
+		var buf bytes.Buffer
+		chisel.RenderProgress(&buf, &test.event)
+		c.Assert(buf.String(), Equals, test.output)
+	}
+}
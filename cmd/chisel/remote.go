@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// remoteRoot is a root directory on a remote host, reached over SSH.
+type remoteRoot struct {
+	User string
+	Host string
+	Port string
+	Path string
+}
+
+// parseRemoteRoot parses a root of the form ssh://[user@]host[:port]/path.
+// It returns nil if root is a local directory.
+func parseRemoteRoot(root string) (*remoteRoot, error) {
+	if !strings.HasPrefix(root, "ssh://") {
+		return nil, nil
+	}
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote root %q: %v", root, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid remote root %q: missing host", root)
+	}
+	if u.Path == "" || u.Path == "/" || path.Clean(u.Path) != strings.TrimSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("invalid remote root %q: invalid path", root)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid remote root %q: unexpected query or fragment", root)
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("invalid remote root %q: passwords are not supported", root)
+	}
+	// A leading dash would make ssh take the user or host as an option.
+	if strings.HasPrefix(u.User.Username(), "-") {
+		return nil, fmt.Errorf("invalid remote root %q: user cannot start with \"-\"", root)
+	}
+	if strings.HasPrefix(u.Hostname(), "-") {
+		return nil, fmt.Errorf("invalid remote root %q: host cannot start with \"-\"", root)
+	}
+	return &remoteRoot{
+		User: u.User.Username(),
+		Host: u.Hostname(),
+		Port: u.Port(),
+		Path: path.Clean(u.Path),
+	}, nil
+}
+
+func (r *remoteRoot) String() string {
+	dest := r.Host
+	if r.User != "" {
+		dest = r.User + "@" + dest
+	}
+	if r.Port != "" {
+		dest += ":" + r.Port
+	}
+	return "ssh://" + dest + r.Path
+}
+
+// syncCommand returns the command transferring the content of the local
+// directory dir to the remote root, preserving hard links, permissions and
+// numeric ownership, and extended attributes if xattrs is true.
+func (r *remoteRoot) syncCommand(dir string, xattrs bool) *exec.Cmd {
+	dest := r.Host + ":" + r.Path + "/"
+	if r.User != "" {
+		dest = r.User + "@" + dest
+	}
+	args := []string{"--archive", "--hard-links", "--numeric-ids"}
+	if xattrs {
+		args = append(args, "--xattrs")
+	}
+	if r.Port != "" {
+		args = append(args, "--rsh", "ssh -p "+r.Port)
+	}
+	args = append(args, "--", strings.TrimSuffix(dir, "/")+"/", dest)
+	return exec.Command("rsync", args...)
+}
+
+// sync transfers the content of the local directory dir to the remote root,
+// along with extended attributes if xattrs is true.
+func (r *remoteRoot) sync(dir string, xattrs bool) error {
+	logf("Transferring content to %s...", r)
+	cmd := r.syncCommand(dir, xattrs)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(output))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("cannot transfer content to %s: %s", r, msg)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
+)
+
+// writeTarball writes the content of dir to w as a tar stream, in lexical
+// order. Files sharing an inode are written as hard links to the first one.
+// Owners are only recorded when running as root, as the content is otherwise
+// owned by the current user rather than by the ones in the slices, and is
+// recorded as owned by root instead. If xattrs is true, the extended
+// attributes of files and directories are recorded as PAX records.
+func writeTarball(w io.Writer, dir string, xattrs bool) error {
+	tw := tar.NewWriter(w)
+	links := make(map[uint64]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		if os.Getuid() != 0 {
+			hdr.Uid, hdr.Gid = 0, 0
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && stat.Nlink > 1 {
+			if target, ok := links[stat.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = target
+				hdr.Size = 0
+			} else {
+				links[stat.Ino] = hdr.Name
+			}
+		}
+		// Hard links share the attributes of their target.
+		if xattrs && (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeDir) {
+			values, err := readXattrs(path)
+			if err != nil {
+				return err
+			}
+			for name, value := range values {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = make(map[string]string)
+					hdr.Format = tar.FormatPAX
+				}
+				hdr.PAXRecords["SCHILY.xattr."+name] = value
+			}
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write tarball: %w", err)
+	}
+	return nil
+}
+
+// readXattrs returns the extended attributes of the entry at path, which are
+// none when the filesystem does not support them.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || err == nil && size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(path, names)
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(string(names[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(path, name, value)
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+		xattrs[name] = string(value[:size])
+	}
+	return xattrs, nil
+}
+
+// tarballDigests describes a written tarball, as needed for referencing it
+// as an OCI image layer.
+type tarballDigests struct {
+	// Digest is the digest of the written data, possibly compressed.
+	Digest string
+	// DiffID is the digest of the uncompressed tar stream.
+	DiffID string
+	// Size is the size in bytes of the written data.
+	Size int64
+}
+
+// tarballCompression returns the compression for the tarball written to
+// output, which is the given one if any, or otherwise inferred from the
+// extension of output. No compression is returned as "".
+func tarballCompression(output, compression string) (string, error) {
+	switch compression {
+	case "gzip", "zstd":
+		return compression, nil
+	case "none":
+		return "", nil
+	case "":
+		switch {
+		case strings.HasSuffix(output, ".tar.gz") || strings.HasSuffix(output, ".tgz"):
+			return "gzip", nil
+		case strings.HasSuffix(output, ".tar.zst"):
+			return "zstd", nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("invalid compression %q", compression)
+}
+
+// writeTarballFile writes the content of dir as a tarball to the output
+// file, or to the standard output when it is "-", compressed with the given
+// compression. A partially written file is removed on failure.
+func writeTarballFile(output, compression, dir string, xattrs bool) (*tarballDigests, error) {
+	if output == "-" {
+		return writeCompressedTarball(Stdout, compression, dir, xattrs)
+	}
+	logf("Writing tarball to %s...", output)
+	f, err := os.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create tarball: %w", err)
+	}
+	digests, err := writeCompressedTarball(f, compression, dir, xattrs)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("cannot write tarball: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(output)
+		return nil, err
+	}
+	return digests, nil
+}
+
+// writeCompressedTarball is like writeTarball, but compresses the tar stream
+// and returns the digests of the written data.
+func writeCompressedTarball(w io.Writer, compression, dir string, xattrs bool) (*tarballDigests, error) {
+	blobHash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, blobHash)}
+	var compressor io.WriteCloser
+	var err error
+	switch compression {
+	case "gzip":
+		compressor = gzip.NewWriter(counter)
+	case "zstd":
+		compressor, err = zstd.NewWriter(counter)
+		if err != nil {
+			return nil, err
+		}
+	case "":
+	default:
+		return nil, fmt.Errorf("invalid compression %q", compression)
+	}
+
+	diffHash := sha256.New()
+	var tarWriter io.Writer = counter
+	if compressor != nil {
+		tarWriter = compressor
+	}
+	err = writeTarball(io.MultiWriter(tarWriter, diffHash), dir, xattrs)
+	if err != nil {
+		return nil, err
+	}
+	if compressor != nil {
+		err = compressor.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot write tarball: %w", err)
+		}
+	}
+	return &tarballDigests{
+		Digest: "sha256:" + hex.EncodeToString(blobHash.Sum(nil)),
+		DiffID: "sha256:" + hex.EncodeToString(diffHash.Sum(nil)),
+		Size:   counter.n,
+	}, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,284 @@
+package main_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+// tarDump returns the entries of the tarball in the format of
+// testutil.TreeDump, along with the owners of the entries.
+func tarDump(c *C, data []byte) (entries map[string]string, owners map[string]string) {
+	entries = make(map[string]string)
+	owners = make(map[string]string)
+	var groups []string
+	groupOf := make(map[string]int)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		path := "/" + hdr.Name
+		perm := hdr.FileInfo().Mode().Perm()
+		if hdr.Mode&01000 != 0 {
+			perm |= 01000
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entries[path] = fmt.Sprintf("dir %#o", perm)
+		case tar.TypeSymlink:
+			entries[path] = "symlink " + hdr.Linkname
+		case tar.TypeReg:
+			content, err := io.ReadAll(tr)
+			c.Assert(err, IsNil)
+			if len(content) == 0 {
+				entries[path] = fmt.Sprintf("file %#o empty", perm)
+			} else {
+				entries[path] = fmt.Sprintf("file %#o %.4x", perm, sha256.Sum256(content))
+			}
+		case tar.TypeLink:
+			target := "/" + hdr.Linkname
+			if _, ok := groupOf[target]; !ok {
+				groups = append(groups, target)
+				groupOf[target] = len(groups)
+			}
+			groupOf[path] = groupOf[target]
+			entries[path] = entries[target]
+		default:
+			c.Fatalf("unexpected tar entry type %q for %s", hdr.Typeflag, path)
+		}
+		owners[path] = fmt.Sprintf("%d:%d", hdr.Uid, hdr.Gid)
+	}
+	for path, group := range groupOf {
+		entries[path] = fmt.Sprintf("%s <%d>", entries[path], group)
+	}
+	return entries, owners
+}
+
+// makeTarballTree creates a tree with every kind of entry supported in
+// tarballs, all with the same modification time.
+func makeTarballTree(c *C) string {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "usr/bin"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "var/lib/chisel"), 0755), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(dir, "tmp"), 0755), IsNil)
+	c.Assert(os.Chmod(filepath.Join(dir, "tmp"), 0777|os.ModeSticky), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/foo"), []byte("foo"), 0755), IsNil)
+	c.Assert(os.Link(filepath.Join(dir, "usr/bin/foo"), filepath.Join(dir, "usr/bin/foo-link")), IsNil)
+	c.Assert(os.Symlink("foo", filepath.Join(dir, "usr/bin/bar")), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/empty"), nil, 0600), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "var/lib/chisel/manifest.wall"), []byte("manifest"), 0644), IsNil)
+	modTime := time.Unix(1700000000, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		ts := unix.NsecToTimespec(modTime.UnixNano())
+		return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+	})
+	c.Assert(err, IsNil)
+	return dir
+}
+
+func (s *ChiselSuite) TestWriteTarball(c *C) {
+	dir := makeTarballTree(c)
+
+	var buf bytes.Buffer
+	err := chisel.WriteTarball(&buf, dir, false)
+	c.Assert(err, IsNil)
+
+	entries, owners := tarDump(c, buf.Bytes())
+	c.Assert(entries, DeepEquals, map[string]string{
+		"/tmp/":                         "dir 01777",
+		"/usr/":                         "dir 0755",
+		"/usr/bin/":                     "dir 0755",
+		"/usr/bin/bar":                  "symlink foo",
+		"/usr/bin/empty":                "file 0600 empty",
+		"/usr/bin/foo":                  "file 0755 2c26b46b <1>",
+		"/usr/bin/foo-link":             "file 0755 2c26b46b <1>",
+		"/var/":                         "dir 0755",
+		"/var/lib/":                     "dir 0755",
+		"/var/lib/chisel/":              "dir 0755",
+		"/var/lib/chisel/manifest.wall": "file 0644 05b3abf2",
+	})
+	c.Assert(entries, DeepEquals, testutil.TreeDump(dir))
+
+	if os.Getuid() != 0 {
+		for path, owner := range owners {
+			c.Assert(owner, Equals, "0:0", Commentf("path: %s", path))
+		}
+	}
+
+	// The output is stable.
+	var again bytes.Buffer
+	err = chisel.WriteTarball(&again, dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(again.Bytes(), DeepEquals, buf.Bytes())
+}
+
+func (s *ChiselSuite) TestWriteTarballXattrs(c *C) {
+	dir := makeTarballTree(c)
+	foo := filepath.Join(dir, "usr/bin/foo")
+	err := unix.Setxattr(foo, "user.test", []byte("value"), 0)
+	if err == unix.ENOTSUP {
+		c.Skip("extended attributes are not supported in " + dir)
+	}
+	c.Assert(err, IsNil)
+	expected := map[string]map[string]string{
+		"/usr/bin/foo": {"SCHILY.xattr.user.test": "value"},
+	}
+	// File capabilities of version 2 granting cap_net_raw, as set on ping.
+	capability := []byte{
+		0x01, 0x00, 0x00, 0x02,
+		0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	err = unix.Setxattr(foo, "security.capability", capability, 0)
+	if err == nil {
+		expected["/usr/bin/foo"]["SCHILY.xattr.security.capability"] = string(capability)
+	} else {
+		c.Logf("Cannot set file capabilities: %v", err)
+	}
+
+	for _, xattrs := range []bool{false, true} {
+		c.Logf("Xattrs: %v", xattrs)
+		var buf bytes.Buffer
+		err = chisel.WriteTarball(&buf, dir, xattrs)
+		c.Assert(err, IsNil)
+
+		records := make(map[string]map[string]string)
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			c.Assert(err, IsNil)
+			for name, value := range hdr.PAXRecords {
+				if !strings.HasPrefix(name, "SCHILY.xattr.") {
+					continue
+				}
+				if records["/"+hdr.Name] == nil {
+					records["/"+hdr.Name] = make(map[string]string)
+				}
+				records["/"+hdr.Name][name] = value
+			}
+		}
+		if xattrs {
+			c.Assert(records, DeepEquals, expected)
+		} else {
+			c.Assert(records, HasLen, 0)
+		}
+	}
+}
+
+func (s *ChiselSuite) TestCutOutputWithRoot(c *C) {
+	dir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--root", dir, "--output", "-", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `cannot use --root with --output`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, "the required flag `--root' or `--output' was not specified")
+}
+
+var tarballCompressionTests = []struct {
+	output      string
+	compression string
+	result      string
+}{
+	{"root.tar", "", ""},
+	{"-", "", ""},
+	{"root.tar.gz", "", "gzip"},
+	{"root.tgz", "", "gzip"},
+	{"root.tar.zst", "", "zstd"},
+	{"root.tar.gz", "none", ""},
+	{"root.tar", "zstd", "zstd"},
+	{"-", "gzip", "gzip"},
+}
+
+func (s *ChiselSuite) TestTarballCompression(c *C) {
+	for _, test := range tarballCompressionTests {
+		c.Logf("Output: %s, compression: %q", test.output, test.compression)
+		compression, err := chisel.TarballCompression(test.output, test.compression)
+		c.Assert(err, IsNil)
+		c.Assert(compression, Equals, test.result)
+	}
+}
+
+func (s *ChiselSuite) TestWriteTarballFile(c *C) {
+	dir := makeTarballTree(c)
+	var tarball bytes.Buffer
+	err := chisel.WriteTarball(&tarball, dir, false)
+	c.Assert(err, IsNil)
+	tarballDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(tarball.Bytes()))
+
+	for _, compression := range []string{"", "gzip", "zstd"} {
+		c.Logf("Compression: %q", compression)
+		output := filepath.Join(c.MkDir(), "layer")
+		digest, diffID, size, err := chisel.WriteTarballFile(output, compression, dir)
+		c.Assert(err, IsNil)
+
+		data, err := os.ReadFile(output)
+		c.Assert(err, IsNil)
+		c.Assert(digest, Equals, fmt.Sprintf("sha256:%x", sha256.Sum256(data)))
+		c.Assert(size, Equals, int64(len(data)))
+		c.Assert(diffID, Equals, tarballDigest)
+
+		var reader io.Reader = bytes.NewReader(data)
+		switch compression {
+		case "gzip":
+			reader, err = gzip.NewReader(reader)
+			c.Assert(err, IsNil)
+		case "zstd":
+			decoder, err := zstd.NewReader(reader)
+			c.Assert(err, IsNil)
+			defer decoder.Close()
+			reader = decoder
+		}
+		uncompressed, err := io.ReadAll(reader)
+		c.Assert(err, IsNil)
+		c.Assert(uncompressed, DeepEquals, tarball.Bytes())
+	}
+}
+
+func (s *ChiselSuite) TestWriteTarballFileDiffID(c *C) {
+	dir := makeTarballTree(c)
+	output := filepath.Join(c.MkDir(), "layer.tar.gz")
+	digest, diffID, _, err := chisel.WriteTarballFile(output, "gzip", dir)
+	c.Assert(err, IsNil)
+	c.Assert(diffID, Equals, "sha256:c49a74a0352620f3ab20c9f2451c535fc279ae0ae60e00bbf1718988c49b4217")
+
+	// The compressed layer is reproducible as well.
+	again, _, _, err := chisel.WriteTarballFile(output, "gzip", dir)
+	c.Assert(err, IsNil)
+	c.Assert(again, Equals, digest)
+}
+
+func (s *ChiselSuite) TestCutOCILayerOptions(c *C) {
+	dir := c.MkDir()
+	_, err := chisel.Parser().ParseArgs([]string{"cut", "--oci-layer", "--output", "-", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `--oci-layer requires --output with a file`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--oci-layer", "--output", filepath.Join(dir, "layer"), "--compression", "zstd", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `--oci-layer requires gzip compression`)
+
+	_, err = chisel.Parser().ParseArgs([]string{"cut", "--root", dir, "--compression", "gzip", "mypkg1_myslice1"})
+	c.Assert(err, ErrorMatches, `--compression requires --output`)
+}
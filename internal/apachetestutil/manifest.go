@@ -13,6 +13,7 @@ type ManifestContents struct {
 	Packages []*manifest.Package
 	Slices   []*manifest.Slice
 	Contents []*manifest.Content
+	Prefers  []*manifest.Prefer
 }
 
 func DumpManifestContents(c *check.C, mfest *manifest.Manifest) *ManifestContents {
@@ -44,11 +45,19 @@ func DumpManifestContents(c *check.C, mfest *manifest.Manifest) *ManifestContent
 	})
 	c.Assert(err, check.IsNil)
 
+	var prefers []*manifest.Prefer
+	err = mfest.IteratePrefers("", func(prefer *manifest.Prefer) error {
+		prefers = append(prefers, prefer)
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+
 	mc := ManifestContents{
 		Paths:    paths,
 		Packages: pkgs,
 		Slices:   slices,
 		Contents: contents,
+		Prefers:  prefers,
 	}
 	return &mc
 }
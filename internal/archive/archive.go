@@ -2,12 +2,21 @@ package archive
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 	"golang.org/x/crypto/openpgp/packet"
 
 	"github.com/canonical/chisel/internal/cache"
@@ -28,17 +37,85 @@ type PackageInfo struct {
 	Version string
 	Arch    string
 	SHA256  string
+	// SHA512 is the digest listed in the archive index, if present.
+	SHA512 string
+	// Size is the size in bytes of the package file, as listed in the
+	// archive index, or zero if unknown.
+	Size int64
+	// Section and Priority are as listed in the archive index, if present.
+	Section  string
+	Priority string
+	// Depends, PreDepends and Provides are the raw relationship fields
+	// listed in the archive index, if present. They are not used when
+	// slicing, as slices declare their own dependencies.
+	Depends    string
+	PreDepends string
+	Provides   string
 }
 
 type Options struct {
-	Label      string
-	Version    string
-	Arch       string
+	Label   string
+	Version string
+	Arch    string
+	// Suites ending in a slash, such as "./", name the directory of a flat
+	// repository relative to the base URL instead, as in apt sources. Its
+	// InRelease and Packages files are found directly in that directory, the
+	// package paths are relative to the base URL, and there are no
+	// components.
 	Suites     []string
 	Components []string
 	Pro        string
 	CacheDir   string
 	PubKeys    []*packet.PublicKey
+	// KeyExpiry holds when the PubKeys expire, indexed by key ID. The
+	// InRelease file is not accepted when only signed by an expired key,
+	// unless AllowExpiredKeys is set. Keys not listed never expire.
+	KeyExpiry        map[uint64]time.Time
+	AllowExpiredKeys bool
+
+	// ReleaseLabel overrides the Label expected in the InRelease file,
+	// which defaults to the one of the Ubuntu archives.
+	ReleaseLabel string
+
+	// BaseURL overrides the URL of the archive, which is otherwise derived
+	// from Pro and Arch. A file:// URL reads the dists and pool directories
+	// of a local mirror instead of going through the network. The InRelease
+	// file is verified against PubKeys either way.
+	BaseURL string
+	// Mirrors lists further base URLs serving the same archive, which are
+	// tried in order when the previous one is unreachable, fails with a
+	// server error after the retries, or misses the data. Everything they
+	// serve is verified against PubKeys and the signed indexes as well.
+	Mirrors []string
+
+	// VerifyDebSignatures enables the verification of the origin signature
+	// embedded in the fetched packages against PubKeys.
+	VerifyDebSignatures bool
+	// AllowUnsignedDebs accepts packages with no origin signature when
+	// VerifyDebSignatures is set.
+	AllowUnsignedDebs bool
+
+	// RequireDebDigests makes fetching a package fail unless its content
+	// matches the SHA256 digest listed in the signed package index, even
+	// when it comes from the local cache.
+	RequireDebDigests bool
+
+	// Retries is the number of times a request for archive data is retried
+	// after a network error or a server error, which defaults to 3. A
+	// negative value disables retries. Client errors are never retried.
+	Retries int
+	// RetryBackoff is the delay before the first retry, which is doubled
+	// for every following one. It defaults to one second.
+	RetryBackoff time.Duration
+
+	// MaxBytesPerSecond limits the rate at which data is downloaded from
+	// the archive, across all its concurrent fetches. Zero means unlimited.
+	MaxBytesPerSecond int64
+
+	// HealthTimeout is how long Open waits for the archive to answer the
+	// initial health check before failing with ErrArchiveUnavailable. It
+	// defaults to five seconds.
+	HealthTimeout time.Duration
 }
 
 func Open(options *Options) (Archive, error) {
@@ -73,11 +150,35 @@ var bulkClient = &http.Client{
 
 var bulkDo = bulkClient.Do
 
+const (
+	defaultRetries       = 3
+	defaultRetryBackoff  = time.Second
+	defaultHealthTimeout = 5 * time.Second
+)
+
+// healthClient is used to check that an archive is reachable before fetching
+// anything from it. Its requests are bound by Options.HealthTimeout, so a
+// single misbehaving archive fails quickly.
+var healthClient = &http.Client{}
+
+var healthDo = healthClient.Do
+
+// ErrArchiveUnavailable is returned by Open when the archive does not respond
+// to the initial health check.
+var ErrArchiveUnavailable = errors.New("archive unavailable")
+
 type ubuntuArchive struct {
 	options Options
 	indexes []*ubuntuIndex
 	cache   *cache.Cache
 	pubKeys []*packet.PublicKey
+	// mirrors holds the base URLs the archive is fetched from, in the
+	// order they are tried.
+	mirrors []*archiveMirror
+	limiter *rateLimiter
+}
+
+type archiveMirror struct {
 	baseURL string
 	creds   *credentials
 }
@@ -102,6 +203,11 @@ func (a *ubuntuArchive) Exists(pkg string) bool {
 	return err == nil
 }
 
+// selectPackage returns the index section of pkg with the highest version
+// across all the suites and components of the archive, as apt does, so the
+// result does not depend on which component lists the package. When the
+// highest version is listed more than once, the first one wins, in the order
+// of Options.Suites and then of Options.Components.
 func (a *ubuntuArchive) selectPackage(pkg string) (control.Section, *ubuntuIndex, error) {
 	var selectedVersion string
 	var selectedSection control.Section
@@ -129,11 +235,31 @@ func (a *ubuntuArchive) Fetch(pkg string) (io.ReadSeekCloser, *PackageInfo, erro
 		return nil, nil, err
 	}
 	suffix := section.Get("Filename")
+	digest := section.Get("SHA256")
+	if a.options.RequireDebDigests && !validDigest(digest) {
+		return nil, nil, fmt.Errorf("cannot verify package %q: no valid SHA256 digest in package index", pkg)
+	}
 	logf("Fetching %s...", suffix)
-	reader, err := index.fetch("../../"+suffix, section.Get("SHA256"), fetchBulk)
+	reader, err := index.fetch(index.poolSuffix(suffix), digest, fetchBulk)
 	if err != nil {
 		return nil, nil, err
 	}
+	if a.options.RequireDebDigests {
+		err = verifyDigest(reader, digest)
+		if err != nil {
+			reader.Close()
+			return nil, nil, fmt.Errorf("cannot verify package %q: %w", pkg, err)
+		}
+	}
+	if a.options.VerifyDebSignatures {
+		err = deb.VerifySignature(reader, a.options.PubKeys)
+		if err == deb.ErrNoSignature && a.options.AllowUnsignedDebs {
+			logf("Package %q has no origin signature", pkg)
+		} else if err != nil {
+			reader.Close()
+			return nil, nil, fmt.Errorf("cannot verify package %q: %w", pkg, err)
+		}
+	}
 	info := sectionPackageInfo(section)
 	return reader, info, nil
 }
@@ -178,40 +304,89 @@ var proArchiveInfo = map[string]struct {
 	},
 }
 
-func archiveURL(pro, arch string) (string, *credentials, error) {
-	if pro != "" {
-		archiveInfo, ok := proArchiveInfo[pro]
-		if !ok {
-			return "", nil, fmt.Errorf("invalid pro value: %q", pro)
+// archiveURL returns the base URL of the archive named label, along with
+// the credentials for fetching from it, if any. Pro archives need
+// credentials, while other archives fetched from a given http(s) base URL
+// use the ones set in the environment, if any (see findCredentialsInEnv).
+func archiveURL(label, pro, arch, baseURL string) (string, *credentials, error) {
+	if baseURL != "" {
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		if strings.HasPrefix(baseURL, "file://") {
+			return baseURL, nil, nil
 		}
-		url := archiveInfo.BaseURL
-		creds, err := findCredentials(url)
+		if pro == "" {
+			return baseURL, findCredentialsInEnv(label), nil
+		}
+		creds, err := findCredentials(label, baseURL)
 		if err != nil {
 			return "", nil, err
 		}
-		return url, creds, nil
+		return baseURL, creds, nil
+	}
+	url, err := DefaultURL(pro, arch)
+	if err != nil {
+		return "", nil, err
+	}
+	if pro == "" {
+		return url, nil, nil
+	}
+	creds, err := findCredentials(label, url)
+	if err != nil {
+		return "", nil, err
 	}
+	return url, creds, nil
+}
 
+// DefaultURL returns the base URL an archive is fetched from when no other
+// URL is provided for it, which depends on its pro value and architecture.
+func DefaultURL(pro, arch string) (string, error) {
+	if pro != "" {
+		archiveInfo, ok := proArchiveInfo[pro]
+		if !ok {
+			return "", fmt.Errorf("invalid pro value: %q", pro)
+		}
+		return archiveInfo.BaseURL, nil
+	}
 	if arch == "amd64" || arch == "i386" {
-		return ubuntuURL, nil, nil
+		return ubuntuURL, nil
 	}
-	return ubuntuPortsURL, nil, nil
+	return ubuntuPortsURL, nil
 }
 
 func openUbuntu(options *Options) (Archive, error) {
-	if len(options.Components) == 0 {
-		return nil, fmt.Errorf("archive options missing components")
-	}
 	if len(options.Suites) == 0 {
 		return nil, fmt.Errorf("archive options missing suites")
 	}
+	for _, suite := range options.Suites {
+		if !isFlatSuite(suite) && len(options.Components) == 0 {
+			return nil, fmt.Errorf("archive options missing components")
+		}
+		if isFlatSuite(suite) && len(options.Components) > 0 {
+			return nil, fmt.Errorf("archive options have components for flat suite %q", suite)
+		}
+	}
 	if len(options.Version) == 0 {
 		return nil, fmt.Errorf("archive options missing version")
 	}
+	if options.MaxBytesPerSecond < 0 {
+		return nil, fmt.Errorf("invalid archive download rate: %d", options.MaxBytesPerSecond)
+	}
+	if options.HealthTimeout < 0 {
+		return nil, fmt.Errorf("invalid archive health timeout: %v", options.HealthTimeout)
+	}
 
-	baseURL, creds, err := archiveURL(options.Pro, options.Arch)
-	if err != nil {
-		return nil, err
+	var mirrors []*archiveMirror
+	for _, url := range append([]string{options.BaseURL}, options.Mirrors...) {
+		if url == "" && len(mirrors) > 0 {
+			return nil, fmt.Errorf("archive options have empty mirror URL")
+		}
+		baseURL, creds, err := archiveURL(options.Label, options.Pro, options.Arch, url)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, &archiveMirror{baseURL: baseURL, creds: creds})
 	}
 
 	archive := &ubuntuArchive{
@@ -220,13 +395,25 @@ func openUbuntu(options *Options) (Archive, error) {
 			Dir: options.CacheDir,
 		},
 		pubKeys: options.PubKeys,
-		baseURL: baseURL,
-		creds:   creds,
+		mirrors: mirrors,
+	}
+	if options.MaxBytesPerSecond > 0 {
+		archive.limiter = newRateLimiter(options.MaxBytesPerSecond)
+	}
+
+	err := archive.checkHealth(options.Suites[0])
+	if err != nil {
+		return nil, err
 	}
 
 	for _, suite := range options.Suites {
 		var release control.Section
-		for _, component := range options.Components {
+		components := options.Components
+		if isFlatSuite(suite) {
+			// Flat repositories have a single index with no component.
+			components = []string{""}
+		}
+		for _, component := range components {
 			index := &ubuntuIndex{
 				label:     options.Label,
 				version:   options.Version,
@@ -247,9 +434,11 @@ func openUbuntu(options *Options) (Archive, error) {
 					// not add any of its indexes.
 					break
 				}
-				err = index.checkComponents(options.Components)
-				if err != nil {
-					return nil, err
+				if !isFlatSuite(suite) {
+					err = index.checkComponents(options.Components)
+					if err != nil {
+						return nil, err
+					}
 				}
 			}
 			err := index.fetchIndex()
@@ -263,6 +452,87 @@ func openUbuntu(options *Options) (Archive, error) {
 	return archive, nil
 }
 
+// checkHealth sends a HEAD request for the InRelease file of suite, as a
+// lightweight way of checking that the archive is reachable. Local archives
+// are always reachable. The mirrors which are not reachable before the first
+// one which is are no longer tried.
+func (a *ubuntuArchive) checkHealth(suite string) error {
+	timeout := a.options.HealthTimeout
+	if timeout == 0 {
+		timeout = defaultHealthTimeout
+	}
+	var err error
+	for i, mirror := range a.mirrors {
+		err = mirror.checkHealth(suite, timeout)
+		if err == nil {
+			a.mirrors = a.mirrors[i:]
+			return nil
+		}
+		if i+1 < len(a.mirrors) {
+			logf("Archive mirror %s is unavailable, trying %s: %v", mirror.baseURL, a.mirrors[i+1].baseURL, err)
+		}
+	}
+	return err
+}
+
+func (mirror *archiveMirror) checkHealth(suite string, timeout time.Duration) error {
+	if strings.HasPrefix(mirror.baseURL, "file://") {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", mirror.baseURL+suiteDir(suite)+"InRelease", nil)
+	if err != nil {
+		return fmt.Errorf("cannot create HTTP request: %v", err)
+	}
+	if mirror.creds != nil && !mirror.creds.Empty() {
+		req.SetBasicAuth(mirror.creds.Username, mirror.creds.Password)
+	}
+	resp, err := healthDo(req)
+	if err != nil {
+		return fmt.Errorf("%w: cannot talk to archive: %v", ErrArchiveUnavailable, err)
+	}
+	defer resp.Body.Close()
+	// Other errors, such as missing credentials or data, are reported in more
+	// detail when fetching.
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: error from archive: %v", ErrArchiveUnavailable, resp.Status)
+	}
+	return nil
+}
+
+// do sends the request, retrying it with an exponential backoff while it
+// fails with a network error or a server error. The last response or error
+// is returned once the retries are exhausted.
+func (a *ubuntuArchive) do(req *http.Request, flags fetchFlags) (*http.Response, error) {
+	do := httpDo
+	if flags&fetchBulk != 0 {
+		do = bulkDo
+	}
+	retries := a.options.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	backoff := a.options.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := do(req)
+		if err == nil && resp.StatusCode < 500 || attempt >= retries {
+			return resp, err
+		}
+		if err != nil {
+			logf("Cannot talk to archive, retrying in %v: %v", backoff, err)
+		} else {
+			resp.Body.Close()
+			logf("Error from archive, retrying in %v: %v", backoff, resp.Status)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 func (index *ubuntuIndex) fetchRelease() error {
 	logf("Fetching %s %s %s suite details...", index.displayName(), index.version, index.suite)
 	reader, err := index.fetch("InRelease", "", fetchDefault)
@@ -286,10 +556,18 @@ func (index *ubuntuIndex) fetchRelease() error {
 	if err != nil {
 		return fmt.Errorf("cannot decode clearsigned InRelease file: %v", err)
 	}
-	err = pgputil.VerifyAnySignature(index.archive.pubKeys, sigs, canonicalBody)
+	key, err := pgputil.VerifyAnySignatureWithOptions(index.archive.pubKeys, sigs, canonicalBody, &pgputil.VerifyOptions{
+		KeyExpiry:        index.archive.options.KeyExpiry,
+		AllowExpiredKeys: index.archive.options.AllowExpiredKeys,
+	})
 	if err != nil {
+		var expiredErr *pgputil.KeyExpiredError
+		if errors.As(err, &expiredErr) {
+			return fmt.Errorf("cannot verify signature of the InRelease file: %v", err)
+		}
 		return fmt.Errorf("cannot verify signature of the InRelease file")
 	}
+	logf("Release signed by key %s", key.KeyIdString())
 
 	// canonicalBody has <CR><LF> line endings, reverting that to match the
 	// expected control file format.
@@ -300,7 +578,9 @@ func (index *ubuntuIndex) fetchRelease() error {
 	}
 	// Parse the appropriate section for the type of archive.
 	label := "Ubuntu"
-	if index.archive.options.Pro != "" {
+	if index.archive.options.ReleaseLabel != "" {
+		label = index.archive.options.ReleaseLabel
+	} else if index.archive.options.Pro != "" {
 		label = proArchiveInfo[index.archive.options.Pro].Label
 	}
 	section := ctrl.Section(label)
@@ -313,16 +593,45 @@ func (index *ubuntuIndex) fetchRelease() error {
 	return nil
 }
 
+// indexExtensions lists the compressed variants of package indexes which
+// are supported, from the most preferred to the least.
+var indexExtensions = []string{".zst", ".xz", ".gz"}
+
 func (index *ubuntuIndex) fetchIndex() error {
 	digests := index.release.Get("SHA256")
 	packagesPath := fmt.Sprintf("%s/binary-%s/Packages", index.component, index.arch)
+	if isFlatSuite(index.suite) {
+		packagesPath = "Packages"
+	}
 	digest, _, _ := control.ParsePathInfo(digests, packagesPath)
 	if digest == "" {
+		if isFlatSuite(index.suite) {
+			return fmt.Errorf("%s is missing from %s digests", packagesPath, index.suite)
+		}
 		return fmt.Errorf("%s is missing from %s %s component digests", packagesPath, index.suite, index.component)
 	}
+	// The best compression listed in the release is used. The decompressed
+	// content is verified against the digest of the uncompressed index
+	// either way.
+	fetchPath, fetchExt, fetchDigest := packagesPath, "", digest
+	for _, ext := range indexExtensions {
+		if extDigest, _, _ := control.ParsePathInfo(digests, packagesPath+ext); extDigest != "" {
+			fetchPath, fetchExt, fetchDigest = packagesPath+ext, ext, extDigest
+			break
+		}
+	}
+	// Mirrors which are updated in place may serve an index which does not
+	// match the release yet, so it is fetched by its digest when possible.
+	if index.release.Get("Acquire-By-Hash") == "yes" {
+		fetchPath = path.Join(path.Dir(fetchPath), "by-hash/SHA256", fetchDigest)
+	}
 
-	logf("Fetching index for %s %s %s %s component...", index.displayName(), index.version, index.suite, index.component)
-	reader, err := index.fetch(packagesPath+".gz", digest, fetchBulk)
+	if isFlatSuite(index.suite) {
+		logf("Fetching index for %s %s %s...", index.displayName(), index.version, index.suite)
+	} else {
+		logf("Fetching index for %s %s %s %s component...", index.displayName(), index.version, index.suite, index.component)
+	}
+	reader, err := index.fetchCompressed(fetchPath, fetchExt, digest, fetchBulk)
 	if err != nil {
 		return err
 	}
@@ -343,7 +652,12 @@ func (index *ubuntuIndex) fetchIndex() error {
 // (faultly) specifies all those architectures in their InRelease files.
 // Reference: [1] https://wiki.debian.org/DebianRepository/Format#Architectures
 func (index *ubuntuIndex) supportsArch(arch string) bool {
-	return strings.Contains(index.release.Get("Architectures"), arch)
+	archs := index.release.Get("Architectures")
+	if archs == "" && isFlatSuite(index.suite) {
+		// Flat repositories commonly omit the field.
+		return true
+	}
+	return strings.Contains(archs, arch)
 }
 
 func (index *ubuntuIndex) checkComponents(components []string) error {
@@ -363,82 +677,212 @@ func (index *ubuntuIndex) checkComponents(components []string) error {
 	return nil
 }
 
+// isFlatSuite returns whether suite names the directory of a flat repository.
+func isFlatSuite(suite string) bool {
+	return strings.HasSuffix(suite, "/")
+}
+
+// suiteDir returns the directory with the InRelease file of suite, relative
+// to the base URL of the archive.
+func suiteDir(suite string) string {
+	if isFlatSuite(suite) {
+		return strings.TrimPrefix(suite, "./")
+	}
+	return "dists/" + suite + "/"
+}
+
+// poolSuffix returns the path of a package file, given relative to the base
+// URL in the index, as relative to the directory of the index suite.
+func (index *ubuntuIndex) poolSuffix(filename string) string {
+	dir := suiteDir(index.suite)
+	return strings.Repeat("../", strings.Count(dir, "/")) + filename
+}
+
 func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.ReadSeekCloser, error) {
-	reader, err := index.archive.cache.Open(digest)
+	var ext string
+	for _, indexExt := range indexExtensions {
+		if strings.HasSuffix(suffix, indexExt) {
+			ext = indexExt
+		}
+	}
+	return index.fetchCompressed(suffix, ext, digest, flags)
+}
+
+// fetchCompressed is like fetch, but decompresses the data according to
+// ext instead of the extension of suffix, which by-hash paths do not have.
+func (index *ubuntuIndex) fetchCompressed(suffix, ext, digest string, flags fetchFlags) (io.ReadSeekCloser, error) {
+	// Content found in the cache is used without going through the network,
+	// unless it no longer matches its digest, in which case it is fetched
+	// again.
+	dataCache := index.archive.cache
+	reader, err := dataCache.Open(digest)
+	if err == nil && validDigest(digest) {
+		err = verifyDigest(reader, digest)
+		if err != nil {
+			reader.Close()
+			logf("Cached content for %s is corrupt, fetching it again: %v", suffix, err)
+			err = dataCache.Remove(digest)
+			if err != nil {
+				return nil, err
+			}
+			err = cache.MissErr
+		}
+	}
 	if err == nil {
 		return reader, nil
 	} else if err != cache.MissErr {
 		return nil, err
 	}
 
-	baseURL, creds := index.archive.baseURL, index.archive.creds
+	var body io.ReadCloser
+	mirrors := index.archive.mirrors
+	for i, mirror := range mirrors {
+		var failover bool
+		body, failover, err = index.openMirror(mirror, suffix, flags)
+		if err == nil {
+			break
+		}
+		if !failover || i+1 == len(mirrors) {
+			return nil, err
+		}
+		logf("Cannot fetch %s from %s, trying %s: %v", suffix, mirror.baseURL, mirrors[i+1].baseURL, err)
+	}
+	defer body.Close()
+
+	switch ext {
+	case ".gz":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress data: %v", err)
+		}
+		defer reader.Close()
+		body = reader
+	case ".xz":
+		reader, err := xz.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress data: %v", err)
+		}
+		body = io.NopCloser(reader)
+	case ".zst":
+		reader, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress data: %v", err)
+		}
+		defer reader.Close()
+		body = io.NopCloser(reader)
+	}
+
+	writer := index.archive.cache.Create(digest)
+	defer writer.Close()
+
+	_, err = io.Copy(writer, body)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch from archive: %v", err)
+	}
+
+	return index.archive.cache.Open(writer.Digest())
+}
 
+// openMirror opens the archive data at suffix in mirror. The failover result
+// reports whether another mirror may succeed where this one failed, such as
+// when it is unreachable or misses the data.
+func (index *ubuntuIndex) openMirror(mirror *archiveMirror, suffix string, flags fetchFlags) (body io.ReadCloser, failover bool, err error) {
 	var url string
 	if strings.HasPrefix(suffix, "pool/") {
-		url = baseURL + suffix
+		url = mirror.baseURL + suffix
 	} else {
-		url = baseURL + "dists/" + index.suite + "/" + suffix
+		url = mirror.baseURL + suiteDir(index.suite) + suffix
+	}
+
+	if localPath, ok := strings.CutPrefix(url, "file://"); ok {
+		file, err := os.Open(localPath)
+		if os.IsNotExist(err) {
+			return nil, true, fmt.Errorf("cannot find archive data")
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("cannot read from archive: %v", err)
+		}
+		return file, false, nil
 	}
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create HTTP request: %v", err)
+		return nil, false, fmt.Errorf("cannot create HTTP request: %v", err)
 	}
-	if creds != nil && !creds.Empty() {
-		req.SetBasicAuth(creds.Username, creds.Password)
-	}
-	var resp *http.Response
-	if flags&fetchBulk != 0 {
-		resp, err = bulkDo(req)
-	} else {
-		resp, err = httpDo(req)
+	if mirror.creds != nil && !mirror.creds.Empty() {
+		req.SetBasicAuth(mirror.creds.Username, mirror.creds.Password)
 	}
+	resp, err := index.archive.do(req, flags)
 	if err != nil {
-		return nil, fmt.Errorf("cannot talk to archive: %v", err)
+		return nil, true, fmt.Errorf("cannot talk to archive: %v", err)
 	}
-	defer resp.Body.Close()
-
 	switch resp.StatusCode {
 	case 200:
 		// ok
 	case 401:
-		return nil, fmt.Errorf("cannot fetch from %q: unauthorized", index.label)
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("cannot fetch from %q: unauthorized", index.label)
 	case 404:
-		return nil, fmt.Errorf("cannot find archive data")
+		resp.Body.Close()
+		return nil, true, fmt.Errorf("cannot find archive data")
 	default:
-		return nil, fmt.Errorf("error from archive: %v", resp.Status)
+		resp.Body.Close()
+		return nil, resp.StatusCode >= 500, fmt.Errorf("error from archive: %v", resp.Status)
 	}
-
-	body := resp.Body
-	if strings.HasSuffix(suffix, ".gz") {
-		reader, err := gzip.NewReader(body)
-		if err != nil {
-			return nil, fmt.Errorf("cannot decompress data: %v", err)
-		}
-		defer reader.Close()
-		body = reader
+	body = resp.Body
+	if index.archive.limiter != nil {
+		body = index.archive.limiter.Reader(body)
 	}
+	return body, false, nil
+}
 
-	writer := index.archive.cache.Create(digest)
-	defer writer.Close()
+// validDigest reports whether digest is a hex encoded SHA256 digest.
+func validDigest(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
 
-	_, err = io.Copy(writer, body)
-	if err == nil {
-		err = writer.Close()
+// verifyDigest checks that the content of reader has the provided SHA256
+// digest. The reader is rewound before returning.
+func verifyDigest(reader io.ReadSeeker, digest string) error {
+	h := sha256.New()
+	_, err := io.Copy(h, reader)
+	if err != nil {
+		return err
 	}
+	_, err = reader.Seek(0, io.SeekStart)
 	if err != nil {
-		return nil, fmt.Errorf("cannot fetch from archive: %v", err)
+		return err
 	}
-
-	return index.archive.cache.Open(writer.Digest())
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != strings.ToLower(digest) {
+		return fmt.Errorf("expected digest %s, got %s", digest, sum)
+	}
+	return nil
 }
 
 func sectionPackageInfo(section control.Section) *PackageInfo {
+	// The size is informational only, so an invalid value is left as zero.
+	size, _ := strconv.ParseInt(section.Get("Size"), 10, 64)
 	return &PackageInfo{
-		Name:    section.Get("Package"),
-		Version: section.Get("Version"),
-		Arch:    section.Get("Architecture"),
-		SHA256:  section.Get("SHA256"),
+		Name:     section.Get("Package"),
+		Version:  section.Get("Version"),
+		Arch:     section.Get("Architecture"),
+		SHA256:   section.Get("SHA256"),
+		SHA512:   section.Get("SHA512"),
+		Size:     size,
+		Section:  section.Get("Section"),
+		Priority: section.Get("Priority"),
+
+		Depends:    section.Get("Depends"),
+		PreDepends: section.Get("Pre-Depends"),
+		Provides:   section.Get("Provides"),
 	}
 }
 
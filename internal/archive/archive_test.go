@@ -4,7 +4,9 @@ import (
 	"golang.org/x/crypto/openpgp/packet"
 	. "gopkg.in/check.v1"
 
+	"crypto/sha256"
 	"debug/elf"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -15,6 +17,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/archive/testarchive"
@@ -100,7 +103,86 @@ func (s *httpSuite) TestDoError(c *C) {
 	}
 
 	_, err := archive.Open(&options)
-	c.Check(err, ErrorMatches, "cannot talk to archive: BAM")
+	c.Check(err, ErrorMatches, "archive unavailable: cannot talk to archive: BAM")
+	c.Check(errors.Is(err, archive.ErrArchiveUnavailable), Equals, true)
+}
+
+func (s *httpSuite) TestHealthCheck(c *C) {
+	s.status = 503
+	s.response = "unavailable"
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+	}
+
+	_, err := archive.Open(&options)
+	c.Check(err, ErrorMatches, "archive unavailable: error from archive: .*")
+	c.Check(errors.Is(err, archive.ErrArchiveUnavailable), Equals, true)
+	c.Assert(s.requests, HasLen, 1)
+	c.Check(s.requests[0].Method, Equals, "HEAD")
+	c.Check(s.requests[0].URL.String(), Equals, "http://archive.ubuntu.com/ubuntu/dists/jammy/InRelease")
+
+	// Other errors are reported when fetching.
+	s.requests = nil
+	s.status = 404
+	_, err = archive.Open(&options)
+	c.Check(err, ErrorMatches, "cannot find archive data")
+	c.Check(errors.Is(err, archive.ErrArchiveUnavailable), Equals, false)
+	c.Assert(s.requests, HasLen, 2)
+	c.Check(s.requests[1].Method, Equals, "GET")
+}
+
+func (s *httpSuite) TestHealthCheckTimeout(c *C) {
+	var timeouts []time.Duration
+	do := func(req *http.Request) (*http.Response, error) {
+		if req.Method == "HEAD" {
+			deadline, ok := req.Context().Deadline()
+			c.Assert(ok, Equals, true)
+			timeouts = append(timeouts, time.Until(deadline))
+			if err := req.Context().Err(); err != nil {
+				return nil, err
+			}
+		}
+		return s.Do(req)
+	}
+	restore := archive.FakeDo(do)
+	defer restore()
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+	_, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	options.HealthTimeout = time.Minute
+	_, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	c.Assert(timeouts, HasLen, 2)
+	c.Check(timeouts[0] > 4*time.Second && timeouts[0] <= 5*time.Second, Equals, true, Commentf("%v", timeouts[0]))
+	c.Check(timeouts[1] > 59*time.Second && timeouts[1] <= time.Minute, Equals, true, Commentf("%v", timeouts[1]))
+
+	// The health check fails once the timeout expires.
+	options.HealthTimeout = time.Nanosecond
+	_, err = archive.Open(&options)
+	c.Check(err, ErrorMatches, "archive unavailable: cannot talk to archive: context deadline exceeded")
+	c.Check(errors.Is(err, archive.ErrArchiveUnavailable), Equals, true)
+
+	options.HealthTimeout = -time.Second
+	_, err = archive.Open(&options)
+	c.Check(err, ErrorMatches, "invalid archive health timeout: -1s")
 }
 
 func (s *httpSuite) prepareArchive(suite, version, arch string, components []string) *testarchive.Release {
@@ -225,10 +307,13 @@ func (s *httpSuite) TestFetchPackage(c *C) {
 	pkg, info, err := testArchive.Fetch("mypkg1")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1",
-		Arch:    "amd64",
-		SHA256:  "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Name:     "mypkg1",
+		Version:  "1.1",
+		Arch:     "amd64",
+		SHA256:   "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Size:     15,
+		Section:  "admin",
+		Priority: "required",
 	})
 	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
 
@@ -236,12 +321,618 @@ func (s *httpSuite) TestFetchPackage(c *C) {
 	pkg, info, err = testArchive.Fetch("mypkg4")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg4",
-		Version: "1.4",
-		Arch:    "amd64",
-		SHA256:  "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Name:     "mypkg4",
+		Version:  "1.4",
+		Arch:     "amd64",
+		SHA256:   "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Size:     15,
+		Section:  "admin",
+		Priority: "required",
+	})
+	c.Assert(read(pkg), Equals, "mypkg4 1.4 data")
+}
+
+func (s *httpSuite) TestFetchPackageAcrossComponents(c *C) {
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main", "universe"}, func(release *testarchive.Release) {
+		// The universe index is the one after main and its gzipped copy.
+		index := release.Items[2].(*testarchive.PackageIndex)
+		index.Packages = append(index.Packages, &testarchive.Package{
+			Name:      "mypkg1",
+			Version:   "1.10",
+			Arch:      "amd64",
+			Component: "universe",
+			Data:      []byte("mypkg1 from universe"),
+		}, &testarchive.Package{
+			Name:      "mypkg2",
+			Version:   "1.2",
+			Arch:      "amd64",
+			Component: "universe",
+			Data:      []byte("mypkg2 from universe"),
+		})
+	})
+
+	for _, components := range [][]string{{"main", "universe"}, {"universe", "main"}} {
+		c.Logf("Components: %v", components)
+		options := archive.Options{
+			Label:      "ubuntu",
+			Version:    "22.04",
+			Arch:       "amd64",
+			Suites:     []string{"jammy"},
+			Components: components,
+			CacheDir:   c.MkDir(),
+			PubKeys:    []*packet.PublicKey{s.pubKey},
+		}
+
+		testArchive, err := archive.Open(&options)
+		c.Assert(err, IsNil)
+
+		// The highest version wins regardless of the component order.
+		pkg, info, err := testArchive.Fetch("mypkg1")
+		c.Assert(err, IsNil)
+		c.Assert(info.Version, Equals, "1.10")
+		c.Assert(read(pkg), Equals, "mypkg1 from universe")
+
+		// With equal versions, the first component listed wins.
+		pkg, info, err = testArchive.Fetch("mypkg2")
+		c.Assert(err, IsNil)
+		c.Assert(info.Version, Equals, "1.2")
+		if components[0] == "main" {
+			c.Assert(read(pkg), Equals, "mypkg2 1.2 data")
+		} else {
+			c.Assert(read(pkg), Equals, "mypkg2 from universe")
+		}
+	}
+}
+
+func (s *httpSuite) TestFetchRetries(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	options := archive.Options{
+		Label:        "ubuntu",
+		Version:      "22.04",
+		Arch:         "amd64",
+		Suites:       []string{"jammy"},
+		Components:   []string{"main"},
+		CacheDir:     c.MkDir(),
+		PubKeys:      []*packet.PublicKey{s.pubKey},
+		RetryBackoff: time.Millisecond,
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	// The package is fetched after two server errors.
+	failures := 2
+	var attempts int
+	restoreDo := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		if !strings.Contains(req.URL.Path, "/pool/") {
+			return s.Do(req)
+		}
+		attempts++
+		if attempts <= failures {
+			return &http.Response{
+				Body:       io.NopCloser(strings.NewReader("unavailable")),
+				Status:     "503 Service Unavailable",
+				StatusCode: 503,
+			}, nil
+		}
+		return s.Do(req)
+	})
+	defer restoreDo()
+
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(attempts, Equals, 3)
+
+	// Server errors are reported once the retries are exhausted.
+	options.CacheDir = c.MkDir()
+	options.Retries = 1
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	attempts, failures = 0, 10
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, ErrorMatches, `error from archive: 503 Service Unavailable`)
+	c.Assert(attempts, Equals, 2)
+
+	// Client errors are not retried.
+	options.CacheDir = c.MkDir()
+	options.Retries = 0
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	attempts = 0
+	restoreDo = archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		if strings.Contains(req.URL.Path, "/pool/") {
+			attempts++
+			s.status = 401
+		} else {
+			s.status = 200
+		}
+		return s.Do(req)
 	})
+	defer restoreDo()
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, ErrorMatches, `cannot fetch from "ubuntu": unauthorized`)
+	c.Assert(attempts, Equals, 1)
+}
+
+func (s *httpSuite) TestFetchMirrors(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+	s.base = ""
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		BaseURL:    "http://mirror1.example.com/ubuntu/",
+		Mirrors:    []string{"http://mirror2.example.com/ubuntu/"},
+		Retries:    -1,
+	}
+
+	// The first mirror fails with a server error for the packages only.
+	var hosts []string
+	restoreDo := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		if strings.Contains(req.URL.Path, "/pool/") {
+			hosts = append(hosts, req.URL.Host)
+			if req.URL.Host == "mirror1.example.com" {
+				return &http.Response{
+					Body:       io.NopCloser(strings.NewReader("failed")),
+					Status:     "500 Internal Server Error",
+					StatusCode: 500,
+				}, nil
+			}
+		}
+		return s.Do(req)
+	})
+	defer restoreDo()
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	for _, req := range s.requests {
+		c.Assert(req.URL.Host, Equals, "mirror1.example.com")
+	}
+
+	pkg, info, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Version, Equals, "1.1")
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(hosts, DeepEquals, []string{"mirror1.example.com", "mirror2.example.com"})
+
+	// Client errors other than missing data do not move to the next mirror.
+	hosts = nil
+	options.CacheDir = c.MkDir()
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	restoreDo = archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		if strings.Contains(req.URL.Path, "/pool/") {
+			hosts = append(hosts, req.URL.Host)
+			s.status = 401
+		} else {
+			s.status = 200
+		}
+		return s.Do(req)
+	})
+	defer restoreDo()
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, ErrorMatches, `cannot fetch from "ubuntu": unauthorized`)
+	c.Assert(hosts, DeepEquals, []string{"mirror1.example.com"})
+}
+
+func (s *httpSuite) TestOpenMirrorsHealthCheck(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+	s.base = ""
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		BaseURL:    "http://mirror1.example.com/ubuntu/",
+		Mirrors:    []string{"http://mirror2.example.com/ubuntu/"},
+	}
+
+	// An unreachable mirror is no longer tried once another one is found.
+	restoreDo := archive.FakeDo(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "mirror1.example.com" {
+			s.requests = append(s.requests, req)
+			return nil, errors.New("BAM")
+		}
+		return s.Do(req)
+	})
+	defer restoreDo()
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(s.requests[0].URL.Host, Equals, "mirror1.example.com")
+	for _, req := range s.requests[1:] {
+		c.Assert(req.URL.Host, Equals, "mirror2.example.com")
+	}
+
+	// The archive is unavailable when no mirror is reachable.
+	options.Mirrors = []string{"http://mirror1.example.com/other/"}
+	_, err = archive.Open(&options)
+	c.Assert(err, ErrorMatches, "archive unavailable: cannot talk to archive: BAM")
+}
+
+var indexCompressionTests = []struct {
+	summary  string
+	variants func(index testarchive.Item) []testarchive.Item
+	fetched  string
+}{{
+	summary: "Zstd is preferred",
+	variants: func(index testarchive.Item) []testarchive.Item {
+		return []testarchive.Item{&testarchive.Gzip{index}, &testarchive.Xz{index}, &testarchive.Zstd{index}}
+	},
+	fetched: "Packages.zst",
+}, {
+	summary: "Xz is preferred over gzip",
+	variants: func(index testarchive.Item) []testarchive.Item {
+		return []testarchive.Item{&testarchive.Gzip{index}, &testarchive.Xz{index}}
+	},
+	fetched: "Packages.xz",
+}, {
+	summary: "Gzip only",
+	variants: func(index testarchive.Item) []testarchive.Item {
+		return []testarchive.Item{&testarchive.Gzip{index}}
+	},
+	fetched: "Packages.gz",
+}, {
+	summary: "Uncompressed only",
+	variants: func(index testarchive.Item) []testarchive.Item {
+		return nil
+	},
+	fetched: "Packages",
+}}
+
+func (s *httpSuite) TestFetchIndexCompressions(c *C) {
+	for _, test := range indexCompressionTests {
+		c.Logf("Summary: %s", test.summary)
+		s.responses = make(map[string][]byte)
+		s.requests = nil
+
+		s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(release *testarchive.Release) {
+			index := release.Items[0]
+			release.Items = append([]testarchive.Item{index}, test.variants(index)...)
+		})
+
+		options := archive.Options{
+			Label:      "ubuntu",
+			Version:    "22.04",
+			Arch:       "amd64",
+			Suites:     []string{"jammy"},
+			Components: []string{"main"},
+			CacheDir:   c.MkDir(),
+			PubKeys:    []*packet.PublicKey{s.pubKey},
+		}
+
+		testArchive, err := archive.Open(&options)
+		c.Assert(err, IsNil)
+		last := s.requests[len(s.requests)-1]
+		c.Assert(last.URL.Path, Equals, "/ubuntu/dists/jammy/main/binary-amd64/"+test.fetched)
+
+		pkg, info, err := testArchive.Fetch("mypkg1")
+		c.Assert(err, IsNil)
+		c.Assert(info.Version, Equals, "1.1")
+		c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	}
+}
+
+func (s *httpSuite) TestFetchIndexByHash(c *C) {
+	release := s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(release *testarchive.Release) {
+		release.ByHash = true
+	})
+	gzipIndex := release.Items[1]
+	c.Assert(s.responses["/ubuntu/dists/jammy/main/binary-amd64/Packages.gz"], IsNil)
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	last := s.requests[len(s.requests)-1]
+	c.Assert(last.URL.Path, Equals, "/ubuntu/dists/jammy/main/binary-amd64/by-hash/SHA256/"+sha256hex(gzipIndex.Content()))
+
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+}
+
+func (s *httpSuite) TestFetchPackageLocalArchive(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+
+	// Mirror the archive content in a local directory and forbid any
+	// request from then on.
+	mirrorDir := c.MkDir()
+	for itemPath, data := range s.responses {
+		localPath := filepath.Join(mirrorDir, strings.TrimPrefix(itemPath, "/ubuntu/"))
+		err := os.MkdirAll(filepath.Dir(localPath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(localPath, data, 0644)
+		c.Assert(err, IsNil)
+	}
+	s.err = errors.New("no network")
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		BaseURL:    "file://" + mirrorDir,
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	pkg, info, err := testArchive.Fetch("mypkg4")
+	c.Assert(err, IsNil)
+	c.Assert(info.Version, Equals, "1.4")
 	c.Assert(read(pkg), Equals, "mypkg4 1.4 data")
+	c.Assert(s.requests, HasLen, 0)
+
+	// The InRelease file is still verified.
+	options.PubKeys = []*packet.PublicKey{key2.PubKey}
+	options.CacheDir = c.MkDir()
+	_, err = archive.Open(&options)
+	c.Assert(err, ErrorMatches, "cannot verify signature of the InRelease file")
+
+	// Missing content is reported as such.
+	options.PubKeys = []*packet.PublicKey{s.pubKey}
+	options.Suites = []string{"focal"}
+	_, err = archive.Open(&options)
+	c.Assert(err, ErrorMatches, "cannot find archive data")
+}
+
+func (s *httpSuite) TestFetchRateLimit(c *C) {
+	const size = 32 * 1024
+	const rate = 64 * 1024
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(release *testarchive.Release) {
+		release.Walk(func(item testarchive.Item) error {
+			if p, ok := item.(*testarchive.Package); ok && p.Name == "mypkg1" {
+				p.Data = make([]byte, size)
+			}
+			return nil
+		})
+	})
+
+	options := archive.Options{
+		Label:             "ubuntu",
+		Version:           "22.04",
+		Arch:              "amd64",
+		Suites:            []string{"jammy"},
+		Components:        []string{"main"},
+		CacheDir:          c.MkDir(),
+		PubKeys:           []*packet.PublicKey{s.pubKey},
+		MaxBytesPerSecond: rate,
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	start := time.Now()
+	pkg, _, err := testArchive.Fetch("mypkg1")
+	elapsed := time.Since(start)
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), HasLen, size)
+
+	// Fetching at the configured rate takes half a second.
+	c.Assert(elapsed > 400*time.Millisecond, Equals, true, Commentf("elapsed: %v", elapsed))
+	c.Assert(elapsed < 5*time.Second, Equals, true, Commentf("elapsed: %v", elapsed))
+
+	options.MaxBytesPerSecond = -1
+	_, err = archive.Open(&options)
+	c.Assert(err, ErrorMatches, "invalid archive download rate: -1")
+}
+
+func (s *httpSuite) TestFetchPackageFlatRepository(c *C) {
+	s.base = "http://mirror.example.com/flat/"
+	for _, suite := range []string{"./", "sub/"} {
+		c.Logf("Suite: %s", suite)
+		s.responses = make(map[string][]byte)
+		s.requests = nil
+
+		index := &testarchive.PackageIndex{}
+		for i := 1; i <= 2; i++ {
+			index.Packages = append(index.Packages, &testarchive.Package{
+				Name:      fmt.Sprintf("mypkg%d", i),
+				Version:   fmt.Sprintf("1.%d", i),
+				Arch:      "amd64",
+				Component: "main",
+			})
+		}
+		release := &testarchive.Release{
+			Suite:   suite,
+			Version: "22.04",
+			Label:   "Ubuntu",
+			Items:   []testarchive.Item{index, &testarchive.Gzip{index}},
+			PrivKey: s.privKey,
+		}
+		release.Render("/flat", s.responses)
+
+		options := archive.Options{
+			Label:    "ubuntu",
+			Version:  "22.04",
+			Arch:     "amd64",
+			Suites:   []string{suite},
+			CacheDir: c.MkDir(),
+			PubKeys:  []*packet.PublicKey{s.pubKey},
+			BaseURL:  s.base,
+		}
+
+		testArchive, err := archive.Open(&options)
+		c.Assert(err, IsNil)
+		c.Assert(s.requests[0].URL.Path, Equals, path.Join("/flat", suite, "InRelease"))
+
+		pkg, info, err := testArchive.Fetch("mypkg2")
+		c.Assert(err, IsNil)
+		c.Assert(info.Version, Equals, "1.2")
+		c.Assert(read(pkg), Equals, "mypkg2 1.2 data")
+		last := s.requests[len(s.requests)-1]
+		c.Assert(path.Clean(last.URL.Path), Equals, "/flat/pool/main/m/mypkg2/mypkg2_1.2ubuntu1_amd64.deb")
+
+		// The InRelease file is still verified.
+		options.PubKeys = []*packet.PublicKey{key2.PubKey}
+		options.CacheDir = c.MkDir()
+		_, err = archive.Open(&options)
+		c.Assert(err, ErrorMatches, "cannot verify signature of the InRelease file")
+	}
+
+	// Flat suites have no components.
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"./"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		BaseURL:    s.base,
+	}
+	_, err := archive.Open(&options)
+	c.Assert(err, ErrorMatches, `archive options have components for flat suite "./"`)
+}
+
+func (s *httpSuite) TestFetchVerifyDebSignatures(c *C) {
+	signed, err := testutil.MakeSignedDeb(testutil.TestPackageEntries, key1.PrivKey)
+	c.Assert(err, IsNil)
+	signedOther, err := testutil.MakeSignedDeb(testutil.TestPackageEntries, key2.PrivKey)
+	c.Assert(err, IsNil)
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main", "universe"}, func(release *testarchive.Release) {
+		release.Walk(func(item testarchive.Item) error {
+			if p, ok := item.(*testarchive.Package); ok {
+				switch p.Name {
+				case "mypkg1":
+					p.Data = signed
+				case "mypkg2":
+					p.Data = testutil.PackageData["test-package"]
+				case "mypkg3":
+					p.Data = signedOther
+				}
+			}
+			return nil
+		})
+	})
+
+	for _, allowUnsigned := range []bool{false, true} {
+		options := archive.Options{
+			Label:               "ubuntu",
+			Version:             "22.04",
+			Arch:                "amd64",
+			Suites:              []string{"jammy"},
+			Components:          []string{"main", "universe"},
+			CacheDir:            c.MkDir(),
+			PubKeys:             []*packet.PublicKey{s.pubKey},
+			VerifyDebSignatures: true,
+			AllowUnsignedDebs:   allowUnsigned,
+		}
+		testArchive, err := archive.Open(&options)
+		c.Assert(err, IsNil)
+
+		pkg, _, err := testArchive.Fetch("mypkg1")
+		c.Assert(err, IsNil)
+		c.Assert(read(pkg), Equals, string(signed))
+
+		pkg, _, err = testArchive.Fetch("mypkg2")
+		if allowUnsigned {
+			c.Assert(err, IsNil)
+			c.Assert(read(pkg), Equals, string(testutil.PackageData["test-package"]))
+		} else {
+			c.Assert(err, ErrorMatches, `cannot verify package "mypkg2": package has no origin signature`)
+		}
+
+		_, _, err = testArchive.Fetch("mypkg3")
+		c.Assert(err, ErrorMatches, `cannot verify package "mypkg3": cannot verify origin signature`)
+	}
+}
+
+func (s *httpSuite) TestFetchRequireDebDigests(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+
+	cacheDir := c.MkDir()
+	options := archive.Options{
+		Label:             "ubuntu",
+		Version:           "22.04",
+		Arch:              "amd64",
+		Suites:            []string{"jammy"},
+		Components:        []string{"main", "universe"},
+		CacheDir:          cacheDir,
+		PubKeys:           []*packet.PublicKey{s.pubKey},
+		RequireDebDigests: true,
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	pkg, info, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+
+	// Tampered content from the archive is rejected.
+	err = os.Remove(filepath.Join(cacheDir, "sha256", info.SHA256))
+	c.Assert(err, IsNil)
+	s.responses["/ubuntu/pool/main/m/mypkg1/mypkg1_1.1ubuntu1_amd64.deb"] = []byte("tampered")
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, ErrorMatches, `cannot fetch from archive: expected digest `+info.SHA256+`, got [0-9a-f]{64}`)
+}
+
+func (s *httpSuite) TestFetchCachedPackage(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	cacheDir := c.MkDir()
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   cacheDir,
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	s.requests = nil
+	pkg, info, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(s.requests, HasLen, 1)
+
+	// A warm cache skips the network, also from another run.
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	s.requests = nil
+	pkg, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(s.requests, HasLen, 0)
+
+	// Corrupt content in the cache is fetched again.
+	cachePath := filepath.Join(cacheDir, "sha256", info.SHA256)
+	err = os.WriteFile(cachePath, []byte("tampered"), 0644)
+	c.Assert(err, IsNil)
+	pkg, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+	c.Assert(s.requests, HasLen, 1)
+	data, err := os.ReadFile(cachePath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "mypkg1 1.1 data")
 }
 
 func (s *httpSuite) TestFetchPortsPackage(c *C) {
@@ -267,10 +958,13 @@ func (s *httpSuite) TestFetchPortsPackage(c *C) {
 	pkg, info, err := testArchive.Fetch("mypkg1")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1",
-		Arch:    "arm64",
-		SHA256:  "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Name:     "mypkg1",
+		Version:  "1.1",
+		Arch:     "arm64",
+		SHA256:   "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Size:     15,
+		Section:  "admin",
+		Priority: "required",
 	})
 	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
 
@@ -278,10 +972,13 @@ func (s *httpSuite) TestFetchPortsPackage(c *C) {
 	pkg, info, err = testArchive.Fetch("mypkg4")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg4",
-		Version: "1.4",
-		Arch:    "arm64",
-		SHA256:  "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Name:     "mypkg4",
+		Version:  "1.4",
+		Arch:     "arm64",
+		SHA256:   "54af70097b30b33cfcbb6911ad3d0df86c2d458928169e348fa7873e4fc678e4",
+		Size:     15,
+		Section:  "admin",
+		Priority: "required",
 	})
 	c.Assert(read(pkg), Equals, "mypkg4 1.4 data")
 }
@@ -316,20 +1013,26 @@ func (s *httpSuite) TestFetchSecurityPackage(c *C) {
 	pkg, info, err := testArchive.Fetch("mypkg1")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1.2.2",
-		Arch:    "amd64",
-		SHA256:  "5448585bdd916e5023eff2bc1bc3b30bcc6ee9db9c03e531375a6a11ddf0913c",
+		Name:     "mypkg1",
+		Version:  "1.1.2.2",
+		Arch:     "amd64",
+		SHA256:   "5448585bdd916e5023eff2bc1bc3b30bcc6ee9db9c03e531375a6a11ddf0913c",
+		Size:     27,
+		Section:  "admin",
+		Priority: "required",
 	})
 	c.Assert(read(pkg), Equals, "package from jammy-security")
 
 	pkg, info, err = testArchive.Fetch("mypkg2")
 	c.Assert(err, IsNil)
 	c.Assert(info, DeepEquals, &archive.PackageInfo{
-		Name:    "mypkg2",
-		Version: "1.2",
-		Arch:    "amd64",
-		SHA256:  "a4b4f3f3a8fa09b69e3ba23c60a41a1f8144691fd371a2455812572fd02e6f79",
+		Name:     "mypkg2",
+		Version:  "1.2",
+		Arch:     "amd64",
+		SHA256:   "a4b4f3f3a8fa09b69e3ba23c60a41a1f8144691fd371a2455812572fd02e6f79",
+		Size:     15,
+		Section:  "admin",
+		Priority: "required",
 	})
 	c.Assert(read(pkg), Equals, "mypkg2 1.2 data")
 }
@@ -342,9 +1045,10 @@ func (s *httpSuite) TestArchiveLabels(c *C) {
 	}
 
 	tests := []struct {
-		summary string
-		label   string
-		err     string
+		summary      string
+		label        string
+		releaseLabel string
+		err          string
 	}{{
 		summary: "Ubuntu label",
 		label:   "Ubuntu",
@@ -352,6 +1056,15 @@ func (s *httpSuite) TestArchiveLabels(c *C) {
 		summary: "Unknown label",
 		label:   "Unknown",
 		err:     "corrupted archive InRelease file: no Ubuntu section",
+	}, {
+		summary:      "Custom label",
+		label:        "Debian",
+		releaseLabel: "Debian",
+	}, {
+		summary:      "Custom label does not match",
+		label:        "Ubuntu",
+		releaseLabel: "Debian",
+		err:          "corrupted archive InRelease file: no Debian section",
 	}}
 
 	for _, test := range tests {
@@ -364,13 +1077,14 @@ func (s *httpSuite) TestArchiveLabels(c *C) {
 		s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main", "universe"}, adjust)
 
 		options := archive.Options{
-			Label:      "ubuntu",
-			Version:    "22.04",
-			Arch:       "amd64",
-			Suites:     []string{"jammy"},
-			Components: []string{"main", "universe"},
-			CacheDir:   c.MkDir(),
-			PubKeys:    []*packet.PublicKey{s.pubKey},
+			Label:        "ubuntu",
+			Version:      "22.04",
+			Arch:         "amd64",
+			Suites:       []string{"jammy"},
+			Components:   []string{"main", "universe"},
+			CacheDir:     c.MkDir(),
+			PubKeys:      []*packet.PublicKey{s.pubKey},
+			ReleaseLabel: test.releaseLabel,
 		}
 
 		_, err := archive.Open(&options)
@@ -491,10 +1205,61 @@ func (s *httpSuite) TestProArchives(c *C) {
 	}
 }
 
+func (s *httpSuite) TestCustomArchiveCredentials(c *C) {
+	restore := fakeEnv("CHISEL_AUTH_MY_ARCHIVE", "foo:bar")
+	defer restore()
+
+	var auth []string
+	do := func(req *http.Request) (*http.Response, error) {
+		auth = append(auth, req.Header.Get("Authorization"))
+		return s.Do(req)
+	}
+	restoreDo := archive.FakeDo(do)
+	defer restoreDo()
+
+	s.base = "https://private.example.com/ubuntu/"
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	options := archive.Options{
+		Label:      "my-archive",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		BaseURL:    s.base,
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(auth, Not(HasLen), 0)
+	for _, value := range auth {
+		c.Assert(value, Equals, "Basic Zm9vOmJhcg==")
+	}
+
+	// Without credentials in the environment the archive is still
+	// fetched, with no authorization.
+	auth = nil
+	options.Label = "other"
+	options.CacheDir = c.MkDir()
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	_, _, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(auth, Not(HasLen), 0)
+	for _, value := range auth {
+		c.Assert(value, Equals, "")
+	}
+}
+
 type verifyArchiveReleaseTest struct {
-	summary string
-	pubKeys []*packet.PublicKey
-	error   string
+	summary          string
+	pubKeys          []*packet.PublicKey
+	keyExpiry        map[uint64]time.Time
+	allowExpiredKeys bool
+	error            string
 }
 
 var verifyArchiveReleaseTests = []verifyArchiveReleaseTest{{
@@ -510,6 +1275,20 @@ var verifyArchiveReleaseTests = []verifyArchiveReleaseTest{{
 }, {
 	summary: "Multiple public keys (invalid, valid)",
 	pubKeys: []*packet.PublicKey{key2.PubKey, key1.PubKey},
+}, {
+	summary:   "Public key expiring in the future",
+	pubKeys:   []*packet.PublicKey{key1.PubKey},
+	keyExpiry: map[uint64]time.Time{key1.PubKey.KeyId: time.Now().AddDate(1, 0, 0)},
+}, {
+	summary:   "Expired public key",
+	pubKeys:   []*packet.PublicKey{key2.PubKey, key1.PubKey},
+	keyExpiry: map[uint64]time.Time{key1.PubKey.KeyId: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	error:     `cannot verify signature of the InRelease file: key 854BAF1AA9D76600 expired on 2020-01-01`,
+}, {
+	summary:          "Expired public key allowed",
+	pubKeys:          []*packet.PublicKey{key1.PubKey},
+	keyExpiry:        map[uint64]time.Time{key1.PubKey.KeyId: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	allowExpiredKeys: true,
 }}
 
 func (s *httpSuite) TestVerifyArchiveRelease(c *C) {
@@ -526,6 +1305,9 @@ func (s *httpSuite) TestVerifyArchiveRelease(c *C) {
 			Components: []string{"main", "universe"},
 			CacheDir:   c.MkDir(),
 			PubKeys:    test.pubKeys,
+
+			KeyExpiry:        test.keyExpiry,
+			AllowExpiredKeys: test.allowExpiredKeys,
 		}
 
 		_, err := archive.Open(&options)
@@ -546,10 +1328,13 @@ var packageInfoTests = []struct {
 	summary: "Basic",
 	pkg:     "mypkg1",
 	info: &archive.PackageInfo{
-		Name:    "mypkg1",
-		Version: "1.1",
-		Arch:    "amd64",
-		SHA256:  "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Name:     "mypkg1",
+		Version:  "1.1",
+		Arch:     "amd64",
+		SHA256:   "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05",
+		Size:     15,
+		Section:  "admin",
+		Priority: "required",
 	},
 }, {
 	summary: "Package not found in archive",
@@ -584,6 +1369,51 @@ func (s *httpSuite) TestPackageInfo(c *C) {
 	}
 }
 
+func (s *httpSuite) TestPackageInfoDependencies(c *C) {
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "amd64", []string{"main"}, func(release *testarchive.Release) {
+		index := release.Items[0].(*testarchive.PackageIndex)
+		pkg := index.Packages[0].(*testarchive.Package)
+		pkg.Depends = "libc6 (>= 2.34), libfoo1 | libbar1"
+		pkg.PreDepends = "dpkg (>= 1.15.6~)"
+		pkg.Provides = "mypkg-virtual (= 1.1)"
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	info, err := testArchive.Info("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Depends, Equals, "libc6 (>= 2.34), libfoo1 | libbar1")
+	c.Assert(info.PreDepends, Equals, "dpkg (>= 1.15.6~)")
+	c.Assert(info.Provides, Equals, "mypkg-virtual (= 1.1)")
+
+	_, info, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Depends, Equals, "libc6 (>= 2.34), libfoo1 | libbar1")
+
+	// Packages without relationships have the fields empty.
+	info, err = testArchive.Info("mypkg2")
+	c.Assert(err, IsNil)
+	c.Assert(info.Depends, Equals, "")
+	c.Assert(info.PreDepends, Equals, "")
+	c.Assert(info.Provides, Equals, "")
+}
+
+func sha256hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 func read(r io.Reader) string {
 	data, err := io.ReadAll(r)
 	if err != nil {
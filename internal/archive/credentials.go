@@ -77,10 +77,15 @@ const defaultCredsDir = "/etc/apt/auth.conf.d"
 
 var ErrCredentialsNotFound = errors.New("credentials not found")
 
-// findCredentials searches credentials for repoURL in configuration files in
-// directory specified by CHISEL_AUTH_DIR environment variable if it's
-// non-empty, otherwise /etc/apt/auth.conf.d.
-func findCredentials(repoURL string) (*credentials, error) {
+// findCredentials searches credentials for the archive named archiveName at
+// repoURL. Credentials provided via environment variables (see
+// findCredentialsInEnv) take precedence. Otherwise, configuration files are
+// searched in the directory specified by CHISEL_AUTH_DIR environment variable
+// if it's non-empty, otherwise /etc/apt/auth.conf.d.
+func findCredentials(archiveName, repoURL string) (*credentials, error) {
+	if creds := findCredentialsInEnv(archiveName); creds != nil {
+		return creds, nil
+	}
 	credsDir := defaultCredsDir
 	if v := os.Getenv("CHISEL_AUTH_DIR"); v != "" {
 		credsDir = v
@@ -88,6 +93,46 @@ func findCredentials(repoURL string) (*credentials, error) {
 	return findCredentialsInDir(repoURL, credsDir)
 }
 
+// findCredentialsInEnv returns the credentials for the archive named
+// archiveName that are set in the environment, or nil if there are none.
+//
+// The CHISEL_AUTH_<NAME> variable, where <NAME> is the archive name in upper
+// case with any character other than letters and digits replaced by "_",
+// holds "<username>:<password>" and applies to that archive only. It takes
+// precedence over the CHISEL_ARCHIVE_USER and CHISEL_ARCHIVE_PASSWORD pair,
+// which applies to every archive.
+func findCredentialsInEnv(archiveName string) *credentials {
+	if archiveName != "" {
+		if v := os.Getenv(archiveAuthEnv(archiveName)); v != "" {
+			username, password, _ := strings.Cut(v, ":")
+			creds := &credentials{Username: username, Password: password}
+			if !creds.Empty() {
+				return creds
+			}
+		}
+	}
+	creds := &credentials{
+		Username: os.Getenv("CHISEL_ARCHIVE_USER"),
+		Password: os.Getenv("CHISEL_ARCHIVE_PASSWORD"),
+	}
+	if creds.Empty() {
+		return nil
+	}
+	return creds
+}
+
+// archiveAuthEnv returns the name of the environment variable holding the
+// credentials for the archive named archiveName.
+func archiveAuthEnv(archiveName string) string {
+	name := []byte(strings.ToUpper(archiveName))
+	for i, c := range name {
+		if !('A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return "CHISEL_AUTH_" + string(name)
+}
+
 // findCredentialsInDir searches for credentials for repoURL in configuration
 // files in credsDir directory. If the directory does not exist, empty
 // credentials structure with nil err is returned.
@@ -299,14 +299,14 @@ func (s *S) TestFindCredentials(c *C) {
 	restore := fakeEnv("CHISEL_AUTH_DIR", credsDir)
 	defer restore()
 
-	creds, err = archive.FindCredentials("http://example.com/my/site")
+	creds, err = archive.FindCredentials("", "http://example.com/my/site")
 	c.Assert(err, ErrorMatches, "^credentials not found$")
 	c.Assert(creds, IsNil)
 
 	err = os.Mkdir(credsDir, 0755)
 	c.Assert(err, IsNil)
 
-	creds, err = archive.FindCredentials("http://example.com/my/site")
+	creds, err = archive.FindCredentials("", "http://example.com/my/site")
 	c.Assert(err, ErrorMatches, "^credentials not found$")
 	c.Assert(creds, IsNil)
 
@@ -314,9 +314,95 @@ func (s *S) TestFindCredentials(c *C) {
 	err = os.WriteFile(confFile, []byte("machine http://example.com/my login johndoe password 12345"), 0600)
 	c.Assert(err, IsNil)
 
-	creds, err = archive.FindCredentials("http://example.com/my/site")
+	creds, err = archive.FindCredentials("", "http://example.com/my/site")
 	c.Assert(err, IsNil)
 	c.Assert(creds, NotNil)
 	c.Assert(creds.Username, Equals, "johndoe")
 	c.Assert(creds.Password, Equals, "12345")
 }
+
+var findCredentialsInEnvTests = []struct {
+	summary string
+	archive string
+	env     map[string]string
+	creds   *archive.Credentials
+}{{
+	summary: "No variables set",
+	archive: "ubuntu",
+}, {
+	summary: "Generic credentials",
+	archive: "ubuntu",
+	env: map[string]string{
+		"CHISEL_ARCHIVE_USER":     "johndoe",
+		"CHISEL_ARCHIVE_PASSWORD": "12345",
+	},
+	creds: &archive.Credentials{Username: "johndoe", Password: "12345"},
+}, {
+	summary: "Per-archive credentials",
+	archive: "my-fips.archive",
+	env: map[string]string{
+		"CHISEL_AUTH_MY_FIPS_ARCHIVE": "johndoe:123:45",
+	},
+	creds: &archive.Credentials{Username: "johndoe", Password: "123:45"},
+}, {
+	summary: "Per-archive credentials take precedence",
+	archive: "fips",
+	env: map[string]string{
+		"CHISEL_ARCHIVE_USER":     "janedoe",
+		"CHISEL_ARCHIVE_PASSWORD": "54321",
+		"CHISEL_AUTH_FIPS":        "johndoe:12345",
+	},
+	creds: &archive.Credentials{Username: "johndoe", Password: "12345"},
+}, {
+	summary: "Credentials of other archives are ignored",
+	archive: "fips",
+	env: map[string]string{
+		"CHISEL_AUTH_UBUNTU": "johndoe:12345",
+	},
+}, {
+	summary: "Empty per-archive credentials are ignored",
+	archive: "fips",
+	env: map[string]string{
+		"CHISEL_ARCHIVE_USER":     "janedoe",
+		"CHISEL_ARCHIVE_PASSWORD": "54321",
+		"CHISEL_AUTH_FIPS":        ":",
+	},
+	creds: &archive.Credentials{Username: "janedoe", Password: "54321"},
+}}
+
+func (s *S) TestFindCredentialsInEnv(c *C) {
+	names := []string{"CHISEL_ARCHIVE_USER", "CHISEL_ARCHIVE_PASSWORD"}
+	for _, test := range findCredentialsInEnvTests {
+		for name := range test.env {
+			names = append(names, name)
+		}
+	}
+	for _, test := range findCredentialsInEnvTests {
+		c.Logf("Summary: %s", test.summary)
+		for _, name := range names {
+			defer fakeEnv(name, test.env[name])()
+		}
+		creds := archive.FindCredentialsInEnv(test.archive)
+		c.Assert(creds, DeepEquals, test.creds)
+	}
+}
+
+func (s *S) TestFindCredentialsEnvPrecedence(c *C) {
+	credsDir := c.MkDir()
+	restore := fakeEnv("CHISEL_AUTH_DIR", credsDir)
+	defer restore()
+	confFile := filepath.Join(credsDir, "mysite")
+	err := os.WriteFile(confFile, []byte("machine http://example.com/my login johndoe password 12345"), 0600)
+	c.Assert(err, IsNil)
+
+	restore = fakeEnv("CHISEL_AUTH_MYSITE", "janedoe:54321")
+	defer restore()
+
+	creds, err := archive.FindCredentials("mysite", "http://example.com/my/site")
+	c.Assert(err, IsNil)
+	c.Assert(creds, DeepEquals, &archive.Credentials{Username: "janedoe", Password: "54321"})
+
+	creds, err = archive.FindCredentials("other", "http://example.com/my/site")
+	c.Assert(err, IsNil)
+	c.Assert(creds, DeepEquals, &archive.Credentials{Username: "johndoe", Password: "12345"})
+}
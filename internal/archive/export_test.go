@@ -7,11 +7,14 @@ import (
 func FakeDo(do func(req *http.Request) (*http.Response, error)) (restore func()) {
 	_httpDo := httpDo
 	_bulkDo := bulkDo
+	_healthDo := healthDo
 	httpDo = do
 	bulkDo = do
+	healthDo = do
 	return func() {
 		httpDo = _httpDo
 		bulkDo = _bulkDo
+		healthDo = _healthDo
 	}
 }
 
@@ -19,5 +22,6 @@ type Credentials = credentials
 
 var FindCredentials = findCredentials
 var FindCredentialsInDir = findCredentialsInDir
+var FindCredentialsInEnv = findCredentialsInEnv
 
 var ProArchiveInfo = proArchiveInfo
@@ -0,0 +1,55 @@
+package archive
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter paces the data read through its readers so that, all together,
+// they do not exceed rate bytes per second.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	// next is the time at which all the data read so far is within the rate.
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until reading n more bytes is within the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// Reader returns a reader which reads from r at the pace of the limiter.
+func (l *rateLimiter) Reader(r io.ReadCloser) io.ReadCloser {
+	return &rateLimitedReader{r, l}
+}
+
+type rateLimitedReader struct {
+	io.ReadCloser
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Reads are kept small enough for the pace to remain steady.
+	if int64(len(p)) > r.limiter.rate {
+		p = p[:r.limiter.rate]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
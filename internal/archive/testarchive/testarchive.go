@@ -8,6 +8,8 @@ import (
 	"path"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 	"golang.org/x/crypto/openpgp/clearsign"
 	"golang.org/x/crypto/openpgp/packet"
 
@@ -57,12 +59,58 @@ func (gz *Gzip) Content() []byte {
 	return makeGzip(gz.Item.Content())
 }
 
+type Xz struct {
+	Item Item
+}
+
+func (x *Xz) Path() string {
+	return x.Item.Path() + ".xz"
+}
+
+func (x *Xz) Walk(f func(Item) error) error {
+	return CallWalkFunc(x, f, x.Item)
+}
+
+func (x *Xz) Section() []byte {
+	return x.Item.Section()
+}
+
+func (x *Xz) Content() []byte {
+	return makeXz(x.Item.Content())
+}
+
+type Zstd struct {
+	Item Item
+}
+
+func (z *Zstd) Path() string {
+	return z.Item.Path() + ".zst"
+}
+
+func (z *Zstd) Walk(f func(Item) error) error {
+	return CallWalkFunc(z, f, z.Item)
+}
+
+func (z *Zstd) Section() []byte {
+	return z.Item.Section()
+}
+
+func (z *Zstd) Content() []byte {
+	return makeZstd(z.Item.Content())
+}
+
 type Package struct {
 	Name      string
 	Version   string
 	Arch      string
 	Component string
 	Data      []byte
+
+	// Depends, PreDepends and Provides are added to the index section
+	// when set.
+	Depends    string
+	PreDepends string
+	Provides   string
 }
 
 func (p *Package) Path() string {
@@ -75,6 +123,16 @@ func (p *Package) Walk(f func(Item) error) error {
 
 func (p *Package) Section() []byte {
 	content := p.Content()
+	var relations strings.Builder
+	for _, field := range []struct{ name, value string }{
+		{"Depends", p.Depends},
+		{"Pre-Depends", p.PreDepends},
+		{"Provides", p.Provides},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&relations, "%s: %s\n", field.name, field.value)
+		}
+	}
 	section := fmt.Sprintf(string(testutil.Reindent(`
 		Package: %s
 		Architecture: %s
@@ -87,10 +145,10 @@ func (p *Package) Section() []byte {
 		Filename: %s
 		Size: %d
 		SHA256: %s
-		Description: Description of %s
+		%sDescription: Description of %s
 		Task: minimal
 
-	`)), p.Name, p.Arch, p.Version, p.Path(), len(content), makeSha256(content), p.Name)
+	`)), p.Name, p.Arch, p.Version, p.Path(), len(content), makeSha256(content), relations.String(), p.Name)
 	return []byte(section)
 }
 
@@ -107,6 +165,10 @@ type Release struct {
 	Label   string
 	Items   []Item
 	PrivKey *packet.PrivateKey
+
+	// ByHash makes the release advertise Acquire-By-Hash, and renders its
+	// indexes only under their by-hash paths.
+	ByHash bool
 }
 
 func (r *Release) Walk(f func(Item) error) error {
@@ -122,6 +184,10 @@ func (r *Release) Section() []byte {
 }
 
 func (r *Release) Content() []byte {
+	var byHash string
+	if r.ByHash {
+		byHash = "Acquire-By-Hash: yes\n"
+	}
 	digests := bytes.Buffer{}
 	for _, item := range r.Items {
 		content := item.Content()
@@ -137,9 +203,9 @@ func (r *Release) Content() []byte {
 		Architectures: amd64 arm64 armhf i386 ppc64el riscv64 s390x
 		Components: main restricted universe multiverse
 		Description: Ubuntu %s
-		SHA256:
+		%sSHA256:
 		%s
-	`)), r.Label, r.Suite, r.Version, r.Version, digests.String())
+	`)), r.Label, r.Suite, r.Version, r.Version, byHash, digests.String())
 
 	var buf bytes.Buffer
 	writer, err := clearsign.Encode(&buf, r.PrivKey, nil)
@@ -157,13 +223,24 @@ func (r *Release) Content() []byte {
 	return buf.Bytes()
 }
 
+// Render adds the content of the release under prefix. When the suite ends
+// in a slash, such as "./", the release is rendered as a flat repository
+// with its files directly in the suite directory instead of under dists.
+// With ByHash, the indexes are rendered under their by-hash paths.
 func (r *Release) Render(prefix string, content map[string][]byte) error {
 	return r.Walk(func(item Item) error {
 		itemPath := item.Path()
 		if strings.HasPrefix(itemPath, "pool/") {
 			itemPath = path.Join(prefix, itemPath)
 		} else {
-			itemPath = path.Join(prefix, "dists", r.Suite, itemPath)
+			if r.ByHash && item != r {
+				itemPath = path.Join(path.Dir(itemPath), "by-hash/SHA256", makeSha256(item.Content()))
+			}
+			if strings.HasSuffix(r.Suite, "/") {
+				itemPath = path.Join(prefix, r.Suite, itemPath)
+			} else {
+				itemPath = path.Join(prefix, "dists", r.Suite, itemPath)
+			}
 		}
 		content[itemPath] = item.Content()
 		return nil
@@ -184,7 +261,12 @@ type PackageIndex struct {
 	Packages  []Item
 }
 
+// Path returns the path of the index in the release, which is the one of a
+// flat repository when the index has no component.
 func (pi *PackageIndex) Path() string {
+	if pi.Component == "" {
+		return "Packages"
+	}
 	return fmt.Sprintf("%s/binary-%s/Packages", pi.Component, pi.Arch)
 }
 
@@ -217,3 +299,29 @@ func makeGzip(b []byte) []byte {
 	}
 	return buf.Bytes()
 }
+
+func makeXz(b []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		panic(err)
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		panic(err)
+	}
+	err = w.Close()
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZstd(b []byte) []byte {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(b, nil)
+}
@@ -151,6 +151,18 @@ func (c *Cache) Open(digest string) (io.ReadSeekCloser, error) {
 	return file, nil
 }
 
+// Remove drops the content with the given digest from the cache, if present.
+func (c *Cache) Remove(digest string) error {
+	if c.Dir == "" || digest == "" {
+		return nil
+	}
+	err := os.Remove(c.filePath(digest))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove cache file: %v", err)
+	}
+	return nil
+}
+
 func (c *Cache) Read(digest string) ([]byte, error) {
 	file, err := c.Open(digest)
 	if err != nil {
@@ -151,3 +151,18 @@ func (s *S) TestCacheOpen(c *C) {
 
 	c.Assert(string(data1), Equals, "data1")
 }
+
+func (s *S) TestCacheRemove(c *C) {
+	cc := cache.Cache{Dir: c.MkDir()}
+
+	err := cc.Write(data1Digest, []byte("data1"))
+	c.Assert(err, IsNil)
+	err = cc.Remove(data1Digest)
+	c.Assert(err, IsNil)
+	_, err = cc.Read(data1Digest)
+	c.Assert(err, Equals, cache.MissErr)
+
+	// Removing missing content is not an error.
+	err = cc.Remove(data1Digest)
+	c.Assert(err, IsNil)
+}
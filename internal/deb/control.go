@@ -0,0 +1,73 @@
+package deb
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/canonical/chisel/internal/control"
+)
+
+// ReadControl returns the fields of the control file shipped in the control
+// member of the package.
+//
+// The package reader is rewound before returning.
+func ReadControl(pkgReader io.ReadSeeker) (section control.Section, err error) {
+	defer func() {
+		_, seekErr := pkgReader.Seek(0, io.SeekStart)
+		if err == nil {
+			err = seekErr
+		}
+		if err != nil {
+			err = fmt.Errorf("cannot read package control: %w", err)
+		}
+	}()
+
+	controlReader, err := getTarReader(pkgReader, "control")
+	if err != nil {
+		return nil, err
+	}
+	defer controlReader.Close()
+
+	tarReader := tar.NewReader(controlReader)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tarHeader.Name != "./control" && tarHeader.Name != "control" {
+			continue
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		return parseControl(string(data))
+	}
+}
+
+func parseControl(content string) (control.Section, error) {
+	var name string
+	for _, line := range strings.Split(content, "\n") {
+		if value, ok := strings.CutPrefix(line, "Package:"); ok {
+			name = strings.TrimSpace(value)
+			break
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("control file has no Package field")
+	}
+	file, err := control.ParseString("Package", content)
+	if err != nil {
+		return nil, err
+	}
+	section := file.Section(name)
+	if section == nil {
+		return nil, fmt.Errorf("control file has no Package field")
+	}
+	return section, nil
+}
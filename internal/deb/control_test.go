@@ -0,0 +1,70 @@
+package deb_test
+
+import (
+	"bytes"
+	"io"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var testControl = `Package: test-package
+Version: 1.2-3
+Architecture: amd64
+Description: A test package
+ with a longer description.
+`
+
+var readControlTests = []struct {
+	summary string
+	pkgdata []byte
+	fields  map[string]string
+	error   string
+}{{
+	summary: "Control fields",
+	pkgdata: mustMakeDebWithControl(testControl),
+	fields: map[string]string{
+		"Package":      "test-package",
+		"Version":      "1.2-3",
+		"Architecture": "amd64",
+		"Description":  "A test package\nwith a longer description.",
+		"Depends":      "",
+	},
+}, {
+	summary: "No control member",
+	pkgdata: testutil.PackageData["test-package"],
+	error:   "cannot read package control: no control payload",
+}, {
+	summary: "No package field",
+	pkgdata: mustMakeDebWithControl("Version: 1.0\n"),
+	error:   "cannot read package control: control file has no Package field",
+}}
+
+func mustMakeDebWithControl(control string) []byte {
+	data, err := testutil.MakeDebWithControl(control, testutil.TestPackageEntries)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (s *S) TestReadControl(c *C) {
+	for _, test := range readControlTests {
+		c.Logf("Test: %s", test.summary)
+		reader := bytes.NewReader(test.pkgdata)
+		section, err := deb.ReadControl(reader)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		for key, value := range test.fields {
+			c.Assert(section.Get(key), Equals, value, Commentf("field %s", key))
+		}
+		offset, err := reader.Seek(0, io.SeekCurrent)
+		c.Assert(err, IsNil)
+		c.Assert(offset, Equals, int64(0))
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/blakesmith/ar"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/strdist"
@@ -29,6 +30,19 @@ type ExtractOptions struct {
 	// extractInfos is set to the matching entries in Extract, and is nil in cases where
 	// the created entry is implicit and unlisted (for example, parent directories).
 	Create func(extractInfos []ExtractInfo, options *fsutil.CreateOptions) error
+	// Stats is optionally filled with statistics about the package entries.
+	Stats *ExtractStats
+	// PreserveXattrs sets the extended attributes found in the PAX records
+	// of the package entries, such as file capabilities, on the extracted
+	// files and directories.
+	PreserveXattrs bool
+}
+
+// ExtractStats holds how many of the files in a package were extracted.
+// Directories are not accounted for.
+type ExtractStats struct {
+	ExtractedFiles int
+	TotalFiles     int
 }
 
 type ExtractInfo struct {
@@ -36,6 +50,10 @@ type ExtractInfo struct {
 	Mode     uint
 	Optional bool
 	Context  any
+	// Recursive, when set for a directory, also extracts all of the content
+	// under it into the target directory in Path. Mode only applies to the
+	// directory itself.
+	Recursive bool
 }
 
 func getValidOptions(options *ExtractOptions) (*ExtractOptions, error) {
@@ -48,6 +66,11 @@ func getValidOptions(options *ExtractOptions) (*ExtractOptions, error) {
 				}
 			}
 		}
+		for _, extractInfo := range extractInfos {
+			if extractInfo.Recursive && (isGlob || !strings.HasSuffix(extractPath, "/") || !strings.HasSuffix(extractInfo.Path, "/")) {
+				return nil, fmt.Errorf("recursive extraction requires source and target directories: %s", extractPath)
+			}
+		}
 	}
 
 	if options.Create == nil {
@@ -142,6 +165,8 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 		sourceIsDir := sourcePath[len(sourcePath)-1] == '/'
 		if sourceIsDir {
 			tarDirMode[sourcePath] = tarHeader.FileInfo().Mode()
+		} else if options.Stats != nil {
+			options.Stats.TotalFiles++
 		}
 
 		// Find all globs and copies that require this source, and map them by
@@ -161,12 +186,24 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 					targetPaths[extractInfo.Path] = append(targetPaths[extractInfo.Path], extractInfo)
 				}
 				delete(pendingPaths, extractPath)
+			} else if strings.HasPrefix(sourcePath, extractPath) && strings.HasSuffix(extractPath, "/") {
+				for _, extractInfo := range extractInfos {
+					if !extractInfo.Recursive {
+						continue
+					}
+					targetPath := extractInfo.Path + strings.TrimPrefix(sourcePath, extractPath)
+					extractInfo.Mode = 0
+					targetPaths[targetPath] = append(targetPaths[targetPath], extractInfo)
+				}
 			}
 		}
 		if len(targetPaths) == 0 {
 			// Nothing to do.
 			continue
 		}
+		if !sourceIsDir && options.Stats != nil {
+			options.Stats.ExtractedFiles++
+		}
 
 		var contentCache []byte
 		var contentIsCached = len(targetPaths) > 1 && !sourceIsDir
@@ -244,6 +281,9 @@ func extractData(pkgReader io.ReadSeeker, options *ExtractOptions) error {
 				MakeParents:  true,
 				OverrideMode: true,
 			}
+			if options.PreserveXattrs {
+				createOptions.Xattrs = tarXattrs(tarHeader)
+			}
 			err := options.Create(extractInfos, createOptions)
 			if err != nil && os.IsNotExist(err) && tarHeader.Typeflag == tar.TypeLink {
 				// The hard link could not be created because the content
@@ -383,39 +423,54 @@ func extractHardLinks(pkgReader io.ReadSeeker, opts *extractHardLinkOptions) err
 }
 
 func getDataReader(pkgReader io.ReadSeeker) (io.ReadCloser, error) {
+	return getTarReader(pkgReader, "data")
+}
+
+// getTarReader returns a reader for the decompressed content of the
+// <name>.tar member of the package, which may be compressed.
+func getTarReader(pkgReader io.ReadSeeker, name string) (io.ReadCloser, error) {
 	arReader := ar.NewReader(pkgReader)
-	var dataReader io.ReadCloser
-	for dataReader == nil {
+	var tarReader io.ReadCloser
+	for tarReader == nil {
 		arHeader, err := arReader.Next()
 		if err == io.EOF {
-			return nil, fmt.Errorf("no data payload")
+			return nil, fmt.Errorf("no %s payload", name)
 		}
 		if err != nil {
 			return nil, err
 		}
-		switch arHeader.Name {
-		case "data.tar.gz":
+		// Member names in the GNU ar format end with a slash.
+		switch strings.TrimSuffix(arHeader.Name, "/") {
+		case name + ".tar":
+			tarReader = io.NopCloser(arReader)
+		case name + ".tar.gz":
 			gzipReader, err := gzip.NewReader(arReader)
 			if err != nil {
 				return nil, err
 			}
-			dataReader = gzipReader
-		case "data.tar.xz":
+			tarReader = gzipReader
+		case name + ".tar.xz":
 			xzReader, err := xz.NewReader(arReader)
 			if err != nil {
 				return nil, err
 			}
-			dataReader = io.NopCloser(xzReader)
-		case "data.tar.zst":
+			tarReader = io.NopCloser(xzReader)
+		case name + ".tar.lzma":
+			lzmaReader, err := lzma.NewReader(arReader)
+			if err != nil {
+				return nil, err
+			}
+			tarReader = io.NopCloser(lzmaReader)
+		case name + ".tar.zst":
 			zstdReader, err := zstd.NewReader(arReader)
 			if err != nil {
 				return nil, err
 			}
-			dataReader = zstdReader.IOReadCloser()
+			tarReader = zstdReader.IOReadCloser()
 		}
 	}
 
-	return dataReader, nil
+	return tarReader, nil
 }
 
 func parentDirs(path string) []string {
@@ -439,3 +494,20 @@ func sanitizeTarPath(path string) (string, bool) {
 	}
 	return path[1:], true
 }
+
+// paxXattrPrefix prefixes the PAX records holding extended attributes.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// tarXattrs returns the extended attributes of the entry, indexed by name.
+func tarXattrs(tarHeader *tar.Header) map[string]string {
+	var xattrs map[string]string
+	for key, value := range tarHeader.PAXRecords {
+		if name, ok := strings.CutPrefix(key, paxXattrPrefix); ok {
+			if xattrs == nil {
+				xattrs = make(map[string]string)
+			}
+			xattrs[name] = value
+		}
+	}
+	return xattrs
+}
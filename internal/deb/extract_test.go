@@ -1,13 +1,16 @@
 package deb_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"errors"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"golang.org/x/sys/unix"
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/deb"
@@ -125,6 +128,38 @@ var extractTests = []extractTest{{
 		"/foo/file-copy":     "file 0600 cc55e2ec",
 	},
 	notCreated: []string{"/foo/", "/foo/bar/"},
+}, {
+	summary: "Copy a directory recursively",
+	pkgdata: testutil.PackageData["test-package"],
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/several/": []deb.ExtractInfo{{
+				Path:      "/foo/copy/",
+				Mode:      0700,
+				Recursive: true,
+			}},
+		},
+	},
+	result: map[string]string{
+		"/foo/":                      "dir 0755",
+		"/foo/copy/":                 "dir 0700",
+		"/foo/copy/levels/":          "dir 0755",
+		"/foo/copy/levels/deep/":     "dir 0755",
+		"/foo/copy/levels/deep/file": "file 0644 6bc26dff",
+	},
+	notCreated: []string{"/foo/"},
+}, {
+	summary: "Recursive copies require directories",
+	pkgdata: testutil.PackageData["test-package"],
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/several/": []deb.ExtractInfo{{
+				Path:      "/foo/copy",
+				Recursive: true,
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": recursive extraction requires source and target directories: /dir/several/`,
 }, {
 	summary: "Copy same file twice",
 	pkgdata: testutil.PackageData["test-package"],
@@ -600,3 +635,125 @@ func (s *S) TestExtractCreateCallback(c *C) {
 		c.Assert(createExtractInfos, DeepEquals, test.calls)
 	}
 }
+
+func (s *S) TestExtractStats(c *C) {
+	dir := c.MkDir()
+	stats := &deb.ExtractStats{}
+	options := deb.ExtractOptions{
+		Package:   "test-package",
+		TargetDir: dir,
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/file": []deb.ExtractInfo{{
+				Path: "/dir/file",
+			}, {
+				Path: "/dir/file-copy",
+			}},
+			"/dir/nested/*": []deb.ExtractInfo{{
+				Path: "/dir/nested/*",
+			}},
+			"/other-dir/": []deb.ExtractInfo{{
+				Path: "/other-dir/",
+			}},
+		},
+		Stats: stats,
+	}
+
+	err := deb.Extract(bytes.NewReader(testutil.PackageData["test-package"]), &options)
+	c.Assert(err, IsNil)
+
+	c.Assert(stats, DeepEquals, &deb.ExtractStats{
+		ExtractedFiles: 3,
+		TotalFiles:     6,
+	})
+}
+
+func (s *S) TestExtractPreserveXattrs(c *C) {
+	entry := testutil.Reg(0755, "./usr/bin/ping", "ping")
+	entry.Header.Format = tar.FormatPAX
+	entry.Header.PAXRecords = map[string]string{
+		"SCHILY.xattr.user.test": "value",
+	}
+	pkgData := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		entry,
+	})
+
+	for _, preserve := range []bool{false, true} {
+		c.Logf("PreserveXattrs: %v", preserve)
+		dir := c.MkDir()
+		var created []*fsutil.Entry
+		options := deb.ExtractOptions{
+			Package:   "test-package",
+			TargetDir: dir,
+			Extract: map[string][]deb.ExtractInfo{
+				"/usr/bin/ping": []deb.ExtractInfo{{
+					Path: "/usr/bin/ping",
+				}},
+			},
+			Create: func(_ []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+				entry, err := fsutil.Create(o)
+				if err == nil && entry.Mode.IsRegular() {
+					created = append(created, entry)
+				}
+				return err
+			},
+			PreserveXattrs: preserve,
+		}
+		err := deb.Extract(bytes.NewReader(pkgData), &options)
+		if preserve && errors.Is(err, unix.ENOTSUP) {
+			c.Skip("extended attributes are not supported in " + dir)
+		}
+		c.Assert(err, IsNil)
+		c.Assert(created, HasLen, 1)
+
+		value := make([]byte, 64)
+		n, err := unix.Getxattr(filepath.Join(dir, "usr/bin/ping"), "user.test", value)
+		if !preserve {
+			c.Assert(err, Equals, unix.ENODATA)
+			c.Assert(created[0].Xattrs, IsNil)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(string(value[:n]), Equals, "value")
+		c.Assert(created[0].Xattrs, DeepEquals, map[string]string{"user.test": "value"})
+	}
+}
+
+func (s *S) TestExtractCompressedData(c *C) {
+	entries := []testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./dir/"),
+		testutil.Reg(0644, "./dir/file", "data"),
+	}
+	members := []string{
+		"data.tar",
+		"data.tar.gz",
+		"data.tar.xz",
+		"data.tar.lzma",
+		"data.tar.zst",
+		// GNU ar format.
+		"data.tar.zst/",
+	}
+	for _, member := range members {
+		c.Logf("Member: %s", member)
+		pkgData, err := testutil.MakeDebWithData(member, entries)
+		c.Assert(err, IsNil)
+		dir := c.MkDir()
+		options := deb.ExtractOptions{
+			Package:   "test-package",
+			TargetDir: dir,
+			Extract: map[string][]deb.ExtractInfo{
+				"/dir/file": []deb.ExtractInfo{{
+					Path: "/dir/file",
+				}},
+			},
+		}
+		err = deb.Extract(bytes.NewReader(pkgData), &options)
+		c.Assert(err, IsNil)
+		c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+			"/dir/":     "dir 0755",
+			"/dir/file": "file 0644 3a6eb079",
+		})
+	}
+}
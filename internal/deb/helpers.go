@@ -8,16 +8,19 @@ import (
 type archPair struct {
 	goArch  string
 	debArch string
+	// triplet is the GNU triplet of the architecture, as used in the
+	// multiarch library paths.
+	triplet string
 }
 
 var knownArchs = []archPair{
-	{"386", "i386"},
-	{"amd64", "amd64"},
-	{"arm", "armhf"},
-	{"arm64", "arm64"},
-	{"ppc64le", "ppc64el"},
-	{"riscv64", "riscv64"},
-	{"s390x", "s390x"},
+	{"386", "i386", "i386-linux-gnu"},
+	{"amd64", "amd64", "x86_64-linux-gnu"},
+	{"arm", "armhf", "arm-linux-gnueabihf"},
+	{"arm64", "arm64", "aarch64-linux-gnu"},
+	{"ppc64le", "ppc64el", "powerpc64le-linux-gnu"},
+	{"riscv64", "riscv64", "riscv64-linux-gnu"},
+	{"s390x", "s390x", "s390x-linux-gnu"},
 }
 
 var platformGoArch = runtime.GOARCH
@@ -39,3 +42,23 @@ func ValidateArch(debArch string) error {
 	}
 	return fmt.Errorf("invalid package architecture: %s", debArch)
 }
+
+// KnownArchs returns the package architectures supported.
+func KnownArchs() []string {
+	archs := make([]string, len(knownArchs))
+	for i, arch := range knownArchs {
+		archs[i] = arch.debArch
+	}
+	return archs
+}
+
+// ArchTriplet returns the GNU triplet of the package architecture, which is
+// the name of its multiarch library directories (e.g. x86_64-linux-gnu).
+func ArchTriplet(debArch string) (string, error) {
+	for _, arch := range knownArchs {
+		if arch.debArch == debArch {
+			return arch.triplet, nil
+		}
+	}
+	return "", fmt.Errorf("invalid package architecture: %s", debArch)
+}
@@ -42,3 +42,23 @@ func (s *S) TestValidateArch(c *C) {
 	c.Assert(deb.ValidateArch("i3866"), Not(IsNil))
 	c.Assert(deb.ValidateArch(""), Not(IsNil))
 }
+
+func (s *S) TestArchTriplet(c *C) {
+	triplets := map[string]string{
+		"i386":    "i386-linux-gnu",
+		"amd64":   "x86_64-linux-gnu",
+		"armhf":   "arm-linux-gnueabihf",
+		"arm64":   "aarch64-linux-gnu",
+		"ppc64el": "powerpc64le-linux-gnu",
+		"riscv64": "riscv64-linux-gnu",
+		"s390x":   "s390x-linux-gnu",
+	}
+	c.Assert(deb.KnownArchs(), HasLen, len(triplets))
+	for _, arch := range deb.KnownArchs() {
+		triplet, err := deb.ArchTriplet(arch)
+		c.Assert(err, IsNil)
+		c.Assert(triplet, Equals, triplets[arch])
+	}
+	_, err := deb.ArchTriplet("foo")
+	c.Assert(err, ErrorMatches, "invalid package architecture: foo")
+}
@@ -0,0 +1,66 @@
+package deb
+
+import (
+	"archive/tar"
+	"io"
+)
+
+// DataEntry describes an entry of the data member of a package.
+type DataEntry struct {
+	// Path is absolute, and ends with a slash for directories.
+	Path string
+	Mode int64
+	Size int64
+	// Link is the target of symbolic and hard links.
+	Link string
+	Type byte
+}
+
+// ListData calls onEntry for every entry of the data member of the package,
+// in the order they appear, without extracting them.
+//
+// The package reader is rewound before returning.
+func ListData(pkgReader io.ReadSeeker, onEntry func(entry *DataEntry) error) (err error) {
+	defer func() {
+		_, seekErr := pkgReader.Seek(0, io.SeekStart)
+		if err == nil {
+			err = seekErr
+		}
+	}()
+
+	dataReader, err := getDataReader(pkgReader)
+	if err != nil {
+		return err
+	}
+	defer dataReader.Close()
+
+	tarReader := tar.NewReader(dataReader)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path, ok := sanitizeTarPath(tarHeader.Name)
+		if !ok {
+			continue
+		}
+		link := tarHeader.Linkname
+		if tarHeader.Typeflag == tar.TypeLink {
+			// Hard link targets are paths in the tarball as well.
+			link, _ = sanitizeTarPath(link)
+		}
+		err = onEntry(&DataEntry{
+			Path: path,
+			Mode: tarHeader.Mode,
+			Size: tarHeader.Size,
+			Link: link,
+			Type: tarHeader.Typeflag,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
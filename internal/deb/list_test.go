@@ -0,0 +1,33 @@
+package deb_test
+
+import (
+	"archive/tar"
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestListData(c *C) {
+	pkgdata := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Reg(0644, "./usr/file", "data"),
+		testutil.Lnk(0777, "./usr/link", "file"),
+		testutil.Hrd(0644, "./usr/hardlink", "./usr/file"),
+	})
+	var entries []deb.DataEntry
+	err := deb.ListData(bytes.NewReader(pkgdata), func(entry *deb.DataEntry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []deb.DataEntry{
+		{Path: "/usr/", Mode: 0755, Type: tar.TypeDir},
+		{Path: "/usr/file", Mode: 0644, Size: 4, Type: tar.TypeReg},
+		{Path: "/usr/link", Mode: 0777, Link: "file", Type: tar.TypeSymlink},
+		{Path: "/usr/hardlink", Mode: 0644, Link: "/usr/file", Type: tar.TypeLink},
+	})
+}
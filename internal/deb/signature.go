@@ -0,0 +1,111 @@
+package deb
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/blakesmith/ar"
+	"golang.org/x/crypto/openpgp/packet"
+
+	"github.com/canonical/chisel/internal/pgputil"
+)
+
+// ErrNoSignature is returned by VerifySignature when the package has no
+// embedded origin signature.
+var ErrNoSignature = errors.New("package has no origin signature")
+
+// signatureMember is the ar member holding the debsig origin signature.
+const signatureMember = "_gpgorigin"
+
+// VerifySignature verifies the debsig origin signature embedded in the
+// package against pubKeys. The signature covers the concatenation of the
+// debian-binary, control and data members, in the order they appear.
+//
+// The package reader is rewound before returning.
+func VerifySignature(pkgReader io.ReadSeeker, pubKeys []*packet.PublicKey) (err error) {
+	defer func() {
+		_, seekErr := pkgReader.Seek(0, io.SeekStart)
+		if err == nil {
+			err = seekErr
+		}
+	}()
+
+	var sigData []byte
+	arReader := ar.NewReader(pkgReader)
+	for {
+		arHeader, err := arReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if arHeader.Name == signatureMember {
+			sigData, err = io.ReadAll(arReader)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if sigData == nil {
+		return ErrNoSignature
+	}
+	sigs, err := pgputil.DecodeSignatures(sigData)
+	if err != nil {
+		return fmt.Errorf("cannot decode origin signature: %w", err)
+	}
+
+	// Every key may verify every signature, and a hash is consumed by each
+	// verification, so one is computed for every pair.
+	type candidate struct {
+		sig  *packet.Signature
+		key  *packet.PublicKey
+		hash hash.Hash
+	}
+	var candidates []candidate
+	var writers []io.Writer
+	for _, sig := range sigs {
+		for _, key := range pubKeys {
+			h := sig.Hash.New()
+			candidates = append(candidates, candidate{sig, key, h})
+			writers = append(writers, h)
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("cannot verify origin signature: no public keys")
+	}
+
+	_, err = pkgReader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	signed := io.MultiWriter(writers...)
+	arReader = ar.NewReader(pkgReader)
+	for {
+		arHeader, err := arReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := arHeader.Name
+		if name == "debian-binary" || strings.HasPrefix(name, "control.tar") || strings.HasPrefix(name, "data.tar") {
+			_, err = io.Copy(signed, arReader)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, c := range candidates {
+		if c.key.VerifySignature(c.hash, c.sig) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot verify origin signature")
+}
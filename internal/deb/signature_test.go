@@ -0,0 +1,94 @@
+package deb_test
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/crypto/openpgp/packet"
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var (
+	key1 = testutil.PGPKeys["key1"]
+	key2 = testutil.PGPKeys["key2"]
+)
+
+var verifySignatureTests = []struct {
+	summary string
+	pkgdata []byte
+	pubKeys []*packet.PublicKey
+	error   string
+}{{
+	summary: "Valid signature",
+	pkgdata: mustMakeSignedDeb(key1.PrivKey),
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+}, {
+	summary: "Valid signature from any of the keys",
+	pkgdata: mustMakeSignedDeb(key2.PrivKey),
+	pubKeys: []*packet.PublicKey{key1.PubKey, key2.PubKey},
+}, {
+	summary: "Signature from an unknown key",
+	pkgdata: mustMakeSignedDeb(key2.PrivKey),
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+	error:   "cannot verify origin signature",
+}, {
+	summary: "Tampered content",
+	pkgdata: bytes.Replace(mustMakeSignedDeb(key1.PrivKey), []byte("2.0\n"), []byte("3.0\n"), 1),
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+	error:   "cannot verify origin signature",
+}, {
+	summary: "No signature",
+	pkgdata: testutil.PackageData["test-package"],
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+	error:   "package has no origin signature",
+}, {
+	summary: "No public keys",
+	pkgdata: mustMakeSignedDeb(key1.PrivKey),
+	error:   "cannot verify origin signature: no public keys",
+}}
+
+func mustMakeSignedDeb(privKey *packet.PrivateKey) []byte {
+	data, err := testutil.MakeSignedDeb(testutil.TestPackageEntries, privKey)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (s *S) TestVerifySignature(c *C) {
+	for _, test := range verifySignatureTests {
+		c.Logf("Test: %s", test.summary)
+		reader := bytes.NewReader(test.pkgdata)
+		err := deb.VerifySignature(reader, test.pubKeys)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+		} else {
+			c.Assert(err, IsNil)
+		}
+		offset, err := reader.Seek(0, io.SeekCurrent)
+		c.Assert(err, IsNil)
+		c.Assert(offset, Equals, int64(0))
+	}
+}
+
+func (s *S) TestExtractSignedPackage(c *C) {
+	dir := c.MkDir()
+	options := deb.ExtractOptions{
+		Package:   "test-package",
+		TargetDir: dir,
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/file": []deb.ExtractInfo{{
+				Path: "/dir/file",
+			}},
+		},
+	}
+	err := deb.Extract(bytes.NewReader(mustMakeSignedDeb(key1.PrivKey)), &options)
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	})
+}
@@ -121,28 +121,37 @@ func compareSubversion(va, vb string) int {
 	return res
 }
 
-// CompareVersions compare two version strings that follow the debian
-// version policy and
+// CompareVersions compares two version strings following the Debian version
+// policy, in the form [epoch:]upstream_version[-debian_revision]. The epoch
+// is compared numerically first, defaulting to zero, then the upstream
+// version and finally the revision, which starts after the last hyphen.
 // Returns:
 //
 //	-1 if a is smaller than b
 //	 0 if a equals b
 //	+1 if a is bigger than b
 func CompareVersions(va, vb string) int {
+	ea, va := splitEpoch(va)
+	eb, vb := splitEpoch(vb)
+	res := cmpNumeric(ea, eb)
+	if res != 0 {
+		return res
+	}
+
 	var sa, sb string
-	if ia := strings.IndexByte(va, '-'); ia < 0 {
+	if ia := strings.LastIndexByte(va, '-'); ia < 0 {
 		sa = "0"
 	} else {
 		va, sa = va[:ia], va[ia+1:]
 	}
-	if ib := strings.IndexByte(vb, '-'); ib < 0 {
+	if ib := strings.LastIndexByte(vb, '-'); ib < 0 {
 		sb = "0"
 	} else {
 		vb, sb = vb[:ib], vb[ib+1:]
 	}
 
 	// the main version number (before the "-")
-	res := compareSubversion(va, vb)
+	res = compareSubversion(va, vb)
 	if res != 0 {
 		return res
 	}
@@ -150,3 +159,19 @@ func CompareVersions(va, vb string) int {
 	// the subversion revision behind the "-"
 	return compareSubversion(sa, sb)
 }
+
+// splitEpoch returns the epoch of version v, or "0" if it has none, and the
+// rest of the version. The part before the first colon is only taken as the
+// epoch when it is numeric.
+func splitEpoch(v string) (epoch, rest string) {
+	i := strings.IndexByte(v, ':')
+	if i <= 0 {
+		return "0", v
+	}
+	for j := 0; j < i; j++ {
+		if v[j] < '0' || v[j] > '9' {
+			return "0", v
+		}
+	}
+	return v[:i], v[i+1:]
+}
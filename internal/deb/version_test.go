@@ -95,6 +95,32 @@ func (s *VersionTestSuite) TestVersionCompare(c *C) {
 		{"1.002-1+b2", "1.00", 1},                            // whatever...
 		{"12-20220319-1ubuntu1", "12-20220319-1ubuntu2", -1}, // libgcc-s1
 		{"1:13.0.1-2ubuntu2", "1:13.0.1-2ubuntu3", -1},
+		// epochs
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"00:1.0", "0:1.0", 0},
+		{"2:0.1", "1:9.9", 1},
+		{"1:1.0-1", "1:1.0-2", -1},
+		{"10:1.0", "9:1.0", 1},
+		{"a:1.0", "1:1.0", -1},
+		// the revision starts after the last hyphen
+		{"1.0-5", "1.0-1-9", -1},
+		{"1.0-1-9", "1.0-1-10", -1},
+		{"2:1.0-beta-1", "2:1.0-beta-1", 0},
+		// tildes sort before everything, even the end of the version
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1-1", "1.0~rc2-1", -1},
+		{"1.0~~", "1.0~~a", -1},
+		{"1.0~~a", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0-1~bpo1", "1.0-1", -1},
+		{"2.36-0ubuntu1~22.04.1", "2.36-0ubuntu1", -1},
+		// letters sort before non-letters
+		{"1.0a", "1.0+", -1},
+		{"1.0A", "1.0a", -1},
+		{"1.0+dfsg", "1.0.1", -1},
+		{"1.0ubuntu1", "1.0ubuntu1.1", -1},
+		{"1.0build1", "1.0ubuntu1", -1},
 	} {
 		res := deb.CompareVersions(t.A, t.B)
 		c.Assert(res, Equals, t.res, Commentf("%#v %#v: %v but got %v", t.A, t.B, res, t.res))
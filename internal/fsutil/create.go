@@ -2,6 +2,7 @@ package fsutil
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"hash"
@@ -9,6 +10,8 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+
+	"golang.org/x/sys/unix"
 )
 
 type CreateOptions struct {
@@ -24,14 +27,30 @@ type CreateOptions struct {
 	// If OverrideMode is true and entry already exists, update the mode. Does
 	// not affect symlinks.
 	OverrideMode bool
+	// Xattrs holds extended attributes to set on the created entry, indexed
+	// by their name. They are not set on symlinks and hard links, as the
+	// latter share them with their target.
+	Xattrs map[string]string
+	// If SHA512 is true, the SHA512 digest of regular files is computed and
+	// recorded in the entry as well.
+	SHA512 bool
+	// FS is the filesystem where the entry is created. It defaults to
+	// HostFS. Extended attributes can only be set on HostFS.
+	FS FS
 }
 
 type Entry struct {
-	Path   string
+	Path string
+	// Mode is the mode of the entry once created, so for symlinks it is
+	// the one set by the platform rather than the requested one.
 	Mode   fs.FileMode
 	SHA256 string
+	// SHA512 is only set when requested in CreateOptions.
+	SHA512 string
 	Size   int
 	Link   string
+	// Xattrs holds the extended attributes set from CreateOptions.
+	Xattrs map[string]string
 }
 
 // Create creates a filesystem entry according to the provided options and returns
@@ -40,15 +59,21 @@ type Entry struct {
 // Create can return errors from the os package.
 func Create(options *CreateOptions) (*Entry, error) {
 	rp := &readerProxy{inner: options.Data, h: sha256.New()}
+	if options.SHA512 {
+		rp.h512 = sha512.New()
+	}
 	// Use the proxy instead of the raw Reader.
 	optsCopy := *options
 	optsCopy.Data = rp
+	if optsCopy.FS == nil {
+		optsCopy.FS = HostFS
+	}
 	o := &optsCopy
 
 	var err error
-	var hash string
+	var hash, hash512 string
 	if o.MakeParents {
-		if err := os.MkdirAll(filepath.Dir(o.Path), 0755); err != nil {
+		if err := o.FS.MkdirAll(filepath.Dir(o.Path), 0755); err != nil {
 			return nil, err
 		}
 	}
@@ -60,6 +85,9 @@ func Create(options *CreateOptions) (*Entry, error) {
 		} else {
 			err = createFile(o)
 			hash = hex.EncodeToString(rp.h.Sum(nil))
+			if rp.h512 != nil {
+				hash512 = hex.EncodeToString(rp.h512.Sum(nil))
+			}
 		}
 	case fs.ModeDir:
 		err = createDir(o)
@@ -73,7 +101,7 @@ func Create(options *CreateOptions) (*Entry, error) {
 	}
 
 	// Entry should describe the created file, not the target the link points to.
-	s, err := os.Lstat(o.Path)
+	s, err := o.FS.Lstat(o.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -87,19 +115,35 @@ func Create(options *CreateOptions) (*Entry, error) {
 			mode = mode &^ fs.ModeSymlink
 		}
 	} else if o.OverrideMode && mode != o.Mode {
-		err := os.Chmod(o.Path, o.Mode)
+		err := o.FS.Chmod(o.Path, o.Mode)
 		if err != nil {
 			return nil, err
 		}
 		mode = o.Mode
 	}
 
+	var xattrs map[string]string
+	if len(o.Xattrs) > 0 && o.Link == "" && mode&fs.ModeSymlink == 0 {
+		xattrs = o.Xattrs
+		if o.FS != HostFS {
+			return nil, fmt.Errorf("cannot set extended attributes outside of the host filesystem: %s", o.Path)
+		}
+		for name, value := range xattrs {
+			err := unix.Setxattr(o.Path, name, []byte(value), 0)
+			if err != nil {
+				return nil, &os.PathError{Op: "setxattr " + name, Path: o.Path, Err: err}
+			}
+		}
+	}
+
 	entry := &Entry{
 		Path:   o.Path,
 		Mode:   mode,
 		SHA256: hash,
+		SHA512: hash512,
 		Size:   rp.size,
 		Link:   o.Link,
+		Xattrs: xattrs,
 	}
 	return entry, nil
 }
@@ -111,12 +155,16 @@ func CreateWriter(options *CreateOptions) (io.WriteCloser, *Entry, error) {
 	if !options.Mode.IsRegular() {
 		return nil, nil, fmt.Errorf("unsupported file type: %s", options.Path)
 	}
+	fsys := options.FS
+	if fsys == nil {
+		fsys = HostFS
+	}
 	if options.MakeParents {
-		if err := os.MkdirAll(filepath.Dir(options.Path), 0755); err != nil {
+		if err := fsys.MkdirAll(filepath.Dir(options.Path), 0755); err != nil {
 			return nil, nil, err
 		}
 	}
-	file, err := os.OpenFile(options.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, options.Mode)
+	file, err := fsys.Create(options.Path, options.Mode)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -130,12 +178,15 @@ func CreateWriter(options *CreateOptions) (io.WriteCloser, *Entry, error) {
 		h:     sha256.New(),
 		size:  0,
 	}
+	if options.SHA512 {
+		wp.h512 = sha512.New()
+	}
 	return wp, entry, nil
 }
 
 func createDir(o *CreateOptions) error {
 	debugf("Creating directory: %s (mode %#o)", o.Path, o.Mode)
-	err := os.Mkdir(o.Path, o.Mode)
+	err := o.FS.Mkdir(o.Path, o.Mode)
 	if os.IsExist(err) {
 		return nil
 	}
@@ -144,7 +195,7 @@ func createDir(o *CreateOptions) error {
 
 func createFile(o *CreateOptions) error {
 	debugf("Writing file: %s (mode %#o)", o.Path, o.Mode)
-	file, err := os.OpenFile(o.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, o.Mode)
+	file, err := o.FS.Create(o.Path, o.Mode)
 	if err != nil {
 		return err
 	}
@@ -158,10 +209,10 @@ func createFile(o *CreateOptions) error {
 
 func createSymlink(o *CreateOptions) error {
 	debugf("Creating symlink: %s => %s", o.Path, o.Link)
-	fileinfo, err := os.Lstat(o.Path)
+	fileinfo, err := o.FS.Lstat(o.Path)
 	if err == nil {
 		if (fileinfo.Mode() & os.ModeSymlink) != 0 {
-			link, err := os.Readlink(o.Path)
+			link, err := o.FS.Readlink(o.Path)
 			if err != nil {
 				return err
 			}
@@ -169,25 +220,25 @@ func createSymlink(o *CreateOptions) error {
 				return nil
 			}
 		}
-		err = os.Remove(o.Path)
+		err = o.FS.Remove(o.Path)
 		if err != nil {
 			return err
 		}
 	} else if !os.IsNotExist(err) {
 		return err
 	}
-	return os.Symlink(o.Link, o.Path)
+	return o.FS.Symlink(o.Link, o.Path)
 }
 
 func createHardLink(o *CreateOptions) error {
 	debugf("Creating hard link: %s => %s", o.Path, o.Link)
-	err := os.Link(o.Link, o.Path)
+	err := o.FS.Link(o.Link, o.Path)
 	if err != nil && os.IsExist(err) {
-		linkInfo, serr := os.Lstat(o.Link)
+		linkInfo, serr := o.FS.Lstat(o.Link)
 		if serr != nil {
 			return serr
 		}
-		pathInfo, serr := os.Lstat(o.Path)
+		pathInfo, serr := o.FS.Lstat(o.Path)
 		if serr != nil {
 			return serr
 		}
@@ -203,6 +254,7 @@ func createHardLink(o *CreateOptions) error {
 type readerProxy struct {
 	inner io.Reader
 	h     hash.Hash
+	h512  hash.Hash
 	size  int
 }
 
@@ -211,6 +263,9 @@ var _ io.Reader = (*readerProxy)(nil)
 func (rp *readerProxy) Read(p []byte) (n int, err error) {
 	n, err = rp.inner.Read(p)
 	rp.h.Write(p[:n])
+	if rp.h512 != nil {
+		rp.h512.Write(p[:n])
+	}
 	rp.size += n
 	return n, err
 }
@@ -222,6 +277,7 @@ func (rp *readerProxy) Read(p []byte) (n int, err error) {
 type writerProxy struct {
 	inner io.WriteCloser
 	h     hash.Hash
+	h512  hash.Hash
 	size  int
 	entry *Entry
 }
@@ -231,12 +287,18 @@ var _ io.WriteCloser = (*writerProxy)(nil)
 func (rp *writerProxy) Write(p []byte) (n int, err error) {
 	n, err = rp.inner.Write(p)
 	rp.h.Write(p[:n])
+	if rp.h512 != nil {
+		rp.h512.Write(p[:n])
+	}
 	rp.size += n
 	return n, err
 }
 
 func (rp *writerProxy) Close() error {
 	rp.entry.SHA256 = hex.EncodeToString(rp.h.Sum(nil))
+	if rp.h512 != nil {
+		rp.entry.SHA512 = hex.EncodeToString(rp.h512.Sum(nil))
+	}
 	rp.entry.Size = rp.size
 	return rp.inner.Close()
 }
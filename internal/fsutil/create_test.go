@@ -2,6 +2,8 @@ package fsutil_test
 
 import (
 	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -423,3 +425,37 @@ func (s *S) TestCreateWriter(c *C) {
 		c.Assert(testutil.TreeDumpEntry(entry), DeepEquals, test.result[slashPath])
 	}
 }
+
+func (s *S) TestCreateSHA512(c *C) {
+	dir := c.MkDir()
+	sum := sha512.Sum512([]byte("data"))
+	expected := hex.EncodeToString(sum[:])
+
+	entry, err := fsutil.Create(&fsutil.CreateOptions{
+		Path:   filepath.Join(dir, "file"),
+		Mode:   0644,
+		Data:   bytes.NewBufferString("data"),
+		SHA512: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(entry.SHA512, Equals, expected)
+
+	entry, err = fsutil.Create(&fsutil.CreateOptions{
+		Path: filepath.Join(dir, "other"),
+		Mode: 0644,
+		Data: bytes.NewBufferString("data"),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(entry.SHA512, Equals, "")
+
+	writer, entry, err := fsutil.CreateWriter(&fsutil.CreateOptions{
+		Path:   filepath.Join(dir, "written"),
+		Mode:   0644,
+		SHA512: true,
+	})
+	c.Assert(err, IsNil)
+	_, err = writer.Write([]byte("data"))
+	c.Assert(err, IsNil)
+	c.Assert(writer.Close(), IsNil)
+	c.Assert(entry.SHA512, Equals, expected)
+}
@@ -0,0 +1,69 @@
+package fsutil
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// FS is a filesystem where Create and CreateWriter create entries. Errors
+// for missing and already existing paths must match fs.ErrNotExist and
+// fs.ErrExist respectively, as with *fs.PathError.
+type FS interface {
+	Mkdir(path string, perm fs.FileMode) error
+	// MkdirAll creates the directory at path along with any missing
+	// parent directories, doing nothing if it exists already.
+	MkdirAll(path string, perm fs.FileMode) error
+	// Create creates or truncates the regular file at path for writing.
+	Create(path string, perm fs.FileMode) (io.WriteCloser, error)
+	Symlink(target, path string) error
+	// Link creates path as a hard link to target.
+	Link(target, path string) error
+	Chmod(path string, mode fs.FileMode) error
+	// Lstat describes the entry at path without following symlinks.
+	Lstat(path string) (fs.FileInfo, error)
+	Readlink(path string) (string, error)
+	Remove(path string) error
+}
+
+// HostFS is the filesystem of the host, which is used when no other one is
+// given.
+var HostFS FS = hostFS{}
+
+type hostFS struct{}
+
+func (hostFS) Mkdir(path string, perm fs.FileMode) error {
+	return os.Mkdir(path, perm)
+}
+
+func (hostFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (hostFS) Create(path string, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+}
+
+func (hostFS) Symlink(target, path string) error {
+	return os.Symlink(target, path)
+}
+
+func (hostFS) Link(target, path string) error {
+	return os.Link(target, path)
+}
+
+func (hostFS) Chmod(path string, mode fs.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (hostFS) Lstat(path string) (fs.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (hostFS) Readlink(path string) (string, error) {
+	return os.Readlink(path)
+}
+
+func (hostFS) Remove(path string) error {
+	return os.Remove(path)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/canonical/chisel/internal/apacheutil"
 	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/public/jsonwall"
 	"github.com/canonical/chisel/public/manifest"
@@ -36,21 +37,41 @@ func FindPaths(slices []*setup.Slice) map[string][]*setup.Slice {
 
 type WriteOptions struct {
 	PackageInfo []*archive.PackageInfo
-	Selection   []*setup.Slice
-	Report      *Report
+	// PackageStats optionally holds the extraction statistics of the
+	// packages, by package name.
+	PackageStats map[string]*deb.ExtractStats
+	Selection    []*setup.Slice
+	Report       *Report
+	// Schema is the version of the manifest schema written, which must be
+	// one of manifest.SupportedSchemas. It defaults to manifest.Schema.
+	Schema string
+	// SHA512 records the sha512 digests of the packages and of the paths in
+	// the report, which requires schema 1.1 or later.
+	SHA512 bool
+	// Prefers holds the preferences which resolved the paths of the
+	// selection, as returned by setup.Selection.Prefers.
+	Prefers []*setup.Prefer
 }
 
 func Write(options *WriteOptions, writer io.Writer) error {
+	schema := options.Schema
+	if schema == "" {
+		schema = manifest.Schema
+	}
+	err := checkSchema(schema, options.SHA512)
+	if err != nil {
+		return err
+	}
 	dbw := jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{
-		Schema: manifest.Schema,
+		Schema: schema,
 	})
 
-	err := fastValidate(options)
+	err = fastValidate(options, nil)
 	if err != nil {
 		return err
 	}
 
-	err = manifestAddPackages(dbw, options.PackageInfo)
+	err = manifestAddPackages(dbw, options.PackageInfo, options.PackageStats, options.SHA512)
 	if err != nil {
 		return err
 	}
@@ -60,7 +81,12 @@ func Write(options *WriteOptions, writer io.Writer) error {
 		return err
 	}
 
-	err = manifestAddReport(dbw, options.Report)
+	err = manifestAddReport(dbw, options.Report, options.SHA512)
+	if err != nil {
+		return err
+	}
+
+	err = manifestAddPrefers(dbw, options.Prefers)
 	if err != nil {
 		return err
 	}
@@ -69,15 +95,37 @@ func Write(options *WriteOptions, writer io.Writer) error {
 	return err
 }
 
-func manifestAddPackages(dbw *jsonwall.DBWriter, infos []*archive.PackageInfo) error {
+// checkSchema returns an error if the manifest schema is not supported, or
+// does not support the sha512 digests when they are requested.
+func checkSchema(schema string, sha512 bool) error {
+	if !slices.Contains(manifest.SupportedSchemas, schema) {
+		return fmt.Errorf("unsupported manifest schema %q", schema)
+	}
+	if sha512 && schema == "1.0" {
+		return fmt.Errorf("manifest schema %q does not support sha512 digests", schema)
+	}
+	return nil
+}
+
+func manifestAddPackages(dbw *jsonwall.DBWriter, infos []*archive.PackageInfo, stats map[string]*deb.ExtractStats, sha512 bool) error {
 	for _, info := range infos {
-		err := dbw.Add(&manifest.Package{
-			Kind:    "package",
-			Name:    info.Name,
-			Version: info.Version,
-			Digest:  info.SHA256,
-			Arch:    info.Arch,
-		})
+		pkg := &manifest.Package{
+			Kind:     "package",
+			Name:     info.Name,
+			Version:  info.Version,
+			Digest:   info.SHA256,
+			Arch:     info.Arch,
+			Section:  info.Section,
+			Priority: info.Priority,
+		}
+		if sha512 {
+			pkg.SHA512 = info.SHA512
+		}
+		if stat, ok := stats[info.Name]; ok {
+			pkg.ExtractedFiles = stat.ExtractedFiles
+			pkg.TotalFiles = stat.TotalFiles
+		}
+		err := dbw.Add(pkg)
 		if err != nil {
 			return err
 		}
@@ -98,7 +146,22 @@ func manifestAddSlices(dbw *jsonwall.DBWriter, slices []*setup.Slice) error {
 	return nil
 }
 
-func manifestAddReport(dbw *jsonwall.DBWriter, report *Report) error {
+func manifestAddPrefers(dbw *jsonwall.DBWriter, prefers []*setup.Prefer) error {
+	for _, prefer := range prefers {
+		err := dbw.Add(&manifest.Prefer{
+			Kind:       "prefer",
+			Path:       prefer.Path,
+			Package:    prefer.Package,
+			Candidates: prefer.Candidates,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func manifestAddReport(dbw *jsonwall.DBWriter, report *Report, sha512 bool) error {
 	for _, entry := range report.Entries {
 		sliceNames := []string{}
 		for slice := range entry.Slices {
@@ -113,17 +176,7 @@ func manifestAddReport(dbw *jsonwall.DBWriter, report *Report) error {
 			sliceNames = append(sliceNames, slice.String())
 		}
 		sort.Strings(sliceNames)
-		err := dbw.Add(&manifest.Path{
-			Kind:        "path",
-			Path:        entry.Path,
-			Mode:        fmt.Sprintf("0%o", unixPerm(entry.Mode)),
-			Slices:      sliceNames,
-			SHA256:      entry.SHA256,
-			FinalSHA256: entry.FinalSHA256,
-			Size:        uint64(entry.Size),
-			Link:        entry.Link,
-			Inode:       entry.Inode,
-		})
+		err := dbw.Add(reportEntryPath(&entry, sliceNames, sha512))
 		if err != nil {
 			return err
 		}
@@ -131,6 +184,37 @@ func manifestAddReport(dbw *jsonwall.DBWriter, report *Report) error {
 	return nil
 }
 
+// reportEntryPath returns the manifest path for the report entry, owned by
+// the sorted sliceNames.
+func reportEntryPath(entry *ReportEntry, sliceNames []string, sha512 bool) *manifest.Path {
+	var xattrs map[string][]byte
+	if len(entry.Xattrs) > 0 {
+		xattrs = make(map[string][]byte, len(entry.Xattrs))
+		for name, value := range entry.Xattrs {
+			xattrs[name] = []byte(value)
+		}
+	}
+	path := &manifest.Path{
+		Kind:        "path",
+		Path:        entry.Path,
+		Mode:        fmt.Sprintf("0%o", unixPerm(entry.Mode)),
+		Slices:      sliceNames,
+		SHA256:      entry.SHA256,
+		FinalSHA256: entry.FinalSHA256,
+		Size:        uint64(entry.Size),
+		Link:        entry.Link,
+		Inode:       entry.Inode,
+		UID:         entry.UID,
+		GID:         entry.GID,
+		Xattrs:      xattrs,
+	}
+	if sha512 {
+		path.SHA512 = entry.SHA512
+		path.FinalSHA512 = entry.FinalSHA512
+	}
+	return path
+}
+
 func unixPerm(mode fs.FileMode) (perm uint32) {
 	perm = uint32(mode.Perm())
 	if mode&fs.ModeSticky != 0 {
@@ -142,8 +226,9 @@ func unixPerm(mode fs.FileMode) (perm uint32) {
 // fastValidate validates the data to be written into the manifest.
 // This is validating internal structures which are supposed to be correct unless there is
 // a bug. As such, only assertions that can be done quickly are performed here, instead
-// of it being a comprehensive validation of all the structures.
-func fastValidate(options *WriteOptions) (err error) {
+// of it being a comprehensive validation of all the structures. Paths may also
+// refer to the priorSlices, when updating a prior manifest which has them.
+func fastValidate(options *WriteOptions, priorSlices map[string]bool) (err error) {
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("internal error: invalid manifest: %s", err)
@@ -164,6 +249,11 @@ func fastValidate(options *WriteOptions) (err error) {
 		}
 		sliceExist[slice.String()] = true
 	}
+	for _, prefer := range options.Prefers {
+		if !pkgExist[prefer.Package] {
+			return fmt.Errorf("preference for %s refers to missing package %q", prefer.Path, prefer.Package)
+		}
+	}
 	hardLinkGroups := make(map[uint64][]*ReportEntry)
 	for _, entry := range options.Report.Entries {
 		err := validateReportEntry(&entry)
@@ -171,7 +261,7 @@ func fastValidate(options *WriteOptions) (err error) {
 			return err
 		}
 		for slice := range entry.Slices {
-			if _, ok := sliceExist[slice.String()]; !ok {
+			if !sliceExist[slice.String()] && !priorSlices[slice.String()] {
 				return fmt.Errorf("path %q refers to missing slice %s", entry.Path, slice.String())
 			}
 		}
@@ -223,6 +313,9 @@ func validateReportEntry(entry *ReportEntry) (err error) {
 		if entry.FinalSHA256 != "" {
 			return fmt.Errorf("final_sha256 set for directory")
 		}
+		if entry.SHA512 != "" || entry.FinalSHA512 != "" {
+			return fmt.Errorf("sha512 set for directory")
+		}
 		if entry.Size != 0 {
 			return fmt.Errorf("size set for directory")
 		}
@@ -236,6 +329,9 @@ func validateReportEntry(entry *ReportEntry) (err error) {
 		if entry.FinalSHA256 != "" {
 			return fmt.Errorf("final_sha256 set for symlink")
 		}
+		if entry.SHA512 != "" || entry.FinalSHA512 != "" {
+			return fmt.Errorf("sha512 set for symlink")
+		}
 		if entry.Size != 0 {
 			return fmt.Errorf("size set for symlink")
 		}
@@ -276,8 +372,14 @@ func Validate(mfest *manifest.Manifest) (err error) {
 		}
 	}()
 
+	// Schema 1.0 predates the sha512 digests.
+	noSHA512 := mfest.Schema() == "1.0"
+
 	pkgExist := map[string]bool{}
 	err = mfest.IteratePackages(func(pkg *manifest.Package) error {
+		if noSHA512 && pkg.SHA512 != "" {
+			return fmt.Errorf("package %q has sha512 digest in schema %s", pkg.Name, mfest.Schema())
+		}
 		pkgExist[pkg.Name] = true
 		return nil
 	})
@@ -315,6 +417,19 @@ func Validate(mfest *manifest.Manifest) (err error) {
 		return err
 	}
 
+	err = mfest.IteratePrefers("", func(prefer *manifest.Prefer) error {
+		if !pkgExist[prefer.Package] {
+			return fmt.Errorf("preference for %s refers to missing package %q", prefer.Path, prefer.Package)
+		}
+		if !slices.Contains(prefer.Candidates, prefer.Package) {
+			return fmt.Errorf("preference for %s has package %q outside of its candidates", prefer.Path, prefer.Package)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
 	done := map[string]bool{}
 	err = mfest.IteratePaths("", func(path *manifest.Path) error {
 		pathSlices, ok := pathToSlices[path.Path]
@@ -326,6 +441,12 @@ func Validate(mfest *manifest.Manifest) (err error) {
 		if !slices.Equal(pathSlices, path.Slices) {
 			return fmt.Errorf("path %s and content have diverging slices: %q != %q", path.Path, path.Slices, pathSlices)
 		}
+		if noSHA512 && (path.SHA512 != "" || path.FinalSHA512 != "") {
+			return fmt.Errorf("path %s has sha512 digest in schema %s", path.Path, mfest.Schema())
+		}
+		if path.FinalSHA512 != "" && path.SHA512 == "" {
+			return fmt.Errorf("path %s has final_sha512 without sha512", path.Path)
+		}
 		done[path.Path] = true
 		return nil
 	})
@@ -13,6 +13,7 @@ import (
 
 	"github.com/canonical/chisel/internal/apachetestutil"
 	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/manifestutil"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/public/manifest"
@@ -120,12 +121,15 @@ var slice2 = &setup.Slice{
 }
 
 var generateManifestTests = []struct {
-	summary     string
-	report      *manifestutil.Report
-	packageInfo []*archive.PackageInfo
-	selection   []*setup.Slice
-	expected    *apachetestutil.ManifestContents
-	error       string
+	summary      string
+	report       *manifestutil.Report
+	packageInfo  []*archive.PackageInfo
+	packageStats map[string]*deb.ExtractStats
+	selection    []*setup.Slice
+	sha512       bool
+	prefers      []*setup.Prefer
+	expected     *apachetestutil.ManifestContents
+	error        string
 }{{
 	summary:   "Basic",
 	selection: []*setup.Slice{slice1, slice2},
@@ -149,16 +153,21 @@ var generateManifestTests = []struct {
 		},
 	},
 	packageInfo: []*archive.PackageInfo{{
-		Name:    "package1",
-		Version: "v1",
-		Arch:    "a1",
-		SHA256:  "s1",
+		Name:     "package1",
+		Version:  "v1",
+		Arch:     "a1",
+		SHA256:   "s1",
+		Section:  "libs",
+		Priority: "optional",
 	}, {
 		Name:    "package2",
 		Version: "v2",
 		Arch:    "a2",
 		SHA256:  "s2",
 	}},
+	packageStats: map[string]*deb.ExtractStats{
+		"package1": {ExtractedFiles: 2, TotalFiles: 5},
+	},
 	expected: &apachetestutil.ManifestContents{
 		Paths: []*manifest.Path{{
 			Kind:        "path",
@@ -176,11 +185,15 @@ var generateManifestTests = []struct {
 			Slices: []string{"package1_slice1", "package2_slice2"},
 		}},
 		Packages: []*manifest.Package{{
-			Kind:    "package",
-			Name:    "package1",
-			Version: "v1",
-			Digest:  "s1",
-			Arch:    "a1",
+			Kind:           "package",
+			Name:           "package1",
+			Version:        "v1",
+			Digest:         "s1",
+			Arch:           "a1",
+			Section:        "libs",
+			Priority:       "optional",
+			ExtractedFiles: 2,
+			TotalFiles:     5,
 		}, {
 			Kind:    "package",
 			Name:    "package2",
@@ -209,6 +222,137 @@ var generateManifestTests = []struct {
 			Path:  "/link",
 		}},
 	},
+}, {
+	summary: "SHA512 digests",
+	sha512:  true,
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file": {
+				Path:        "/file",
+				Mode:        0644,
+				SHA256:      "hash",
+				SHA512:      "hash512",
+				Size:        1234,
+				Slices:      map[*setup.Slice]bool{slice1: true},
+				FinalSHA256: "final-hash",
+				FinalSHA512: "final-hash512",
+			},
+			"/manifest.wall": {
+				Path:   "/manifest.wall",
+				Mode:   0644,
+				Slices: map[*setup.Slice]bool{slice1: true},
+			},
+		},
+	},
+	packageInfo: []*archive.PackageInfo{{
+		Name:    "package1",
+		Version: "v1",
+		Arch:    "a1",
+		SHA256:  "s1",
+		SHA512:  "s1-512",
+	}},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{{
+			Kind:        "path",
+			Path:        "/file",
+			Mode:        "0644",
+			Slices:      []string{"package1_slice1"},
+			Size:        1234,
+			SHA256:      "hash",
+			SHA512:      "hash512",
+			FinalSHA256: "final-hash",
+			FinalSHA512: "final-hash512",
+		}, {
+			Kind:   "path",
+			Path:   "/manifest.wall",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+		}},
+		Packages: []*manifest.Package{{
+			Kind:    "package",
+			Name:    "package1",
+			Version: "v1",
+			Digest:  "s1",
+			SHA512:  "s1-512",
+			Arch:    "a1",
+		}},
+		Slices: []*manifest.Slice{{
+			Kind: "slice",
+			Name: "package1_slice1",
+		}},
+		Contents: []*manifest.Content{{
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file",
+		}, {
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/manifest.wall",
+		}},
+	},
+}, {
+	summary: "SHA512 digests are only recorded when requested",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file": {
+				Path:   "/file",
+				Mode:   0644,
+				SHA256: "hash",
+				SHA512: "hash512",
+				Size:   1234,
+				Slices: map[*setup.Slice]bool{slice1: true},
+			},
+		},
+	},
+	packageInfo: []*archive.PackageInfo{{
+		Name:    "package1",
+		Version: "v1",
+		Arch:    "a1",
+		SHA256:  "s1",
+		SHA512:  "s1-512",
+	}},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{{
+			Kind:   "path",
+			Path:   "/file",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			Size:   1234,
+			SHA256: "hash",
+		}},
+		Packages: []*manifest.Package{{
+			Kind:    "package",
+			Name:    "package1",
+			Version: "v1",
+			Digest:  "s1",
+			Arch:    "a1",
+		}},
+		Slices: []*manifest.Slice{{
+			Kind: "slice",
+			Name: "package1_slice1",
+		}},
+		Contents: []*manifest.Content{{
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file",
+		}},
+	},
+}, {
+	summary: "Invalid path: sha512 set for directory",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/dir/": {
+				Path:   "/dir/",
+				Mode:   fs.ModeDir | 0755,
+				SHA512: "hash512",
+				Slices: map[*setup.Slice]bool{slice1: true},
+			},
+		},
+	},
+	error: `internal error: invalid manifest: path "/dir/" has invalid options: sha512 set for directory`,
 }, {
 	summary: "Missing slice",
 	report: &manifestutil.Report{
@@ -500,6 +644,69 @@ var generateManifestTests = []struct {
 		SHA256:  "s1",
 	}},
 	error: `internal error: invalid manifest: package name not set`,
+}, {
+	summary: "Preferences",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file": {
+				Path:   "/file",
+				Mode:   0644,
+				SHA256: "hash",
+				Size:   1,
+				Slices: map[*setup.Slice]bool{slice1: true},
+			},
+		},
+	},
+	prefers: []*setup.Prefer{{
+		Path:       "/file",
+		Package:    "package1",
+		Candidates: []string{"other-package", "package1"},
+	}},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{{
+			Kind:   "path",
+			Path:   "/file",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			SHA256: "hash",
+			Size:   1,
+		}},
+		Packages: []*manifest.Package{{
+			Kind:    "package",
+			Name:    "package1",
+			Version: "v1",
+			Digest:  "s1",
+			Arch:    "a1",
+		}},
+		Slices: []*manifest.Slice{{
+			Kind: "slice",
+			Name: "package1_slice1",
+		}},
+		Contents: []*manifest.Content{{
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file",
+		}},
+		Prefers: []*manifest.Prefer{{
+			Kind:       "prefer",
+			Path:       "/file",
+			Package:    "package1",
+			Candidates: []string{"other-package", "package1"},
+		}},
+	},
+}, {
+	summary: "Preference for missing package",
+	report: &manifestutil.Report{
+		Root:    "/",
+		Entries: map[string]manifestutil.ReportEntry{},
+	},
+	prefers: []*setup.Prefer{{
+		Path:       "/file",
+		Package:    "other-package",
+		Candidates: []string{"other-package", "package1"},
+	}},
+	error: `internal error: invalid manifest: preference for /file refers to missing package "other-package"`,
 }, {
 	summary: "Invalid package: missing version",
 	packageInfo: []*archive.PackageInfo{{
@@ -542,9 +749,12 @@ func (s *S) TestGenerateManifests(c *C) {
 		}
 
 		options := &manifestutil.WriteOptions{
-			PackageInfo: test.packageInfo,
-			Selection:   test.selection,
-			Report:      test.report,
+			PackageInfo:  test.packageInfo,
+			PackageStats: test.packageStats,
+			Selection:    test.selection,
+			Report:       test.report,
+			SHA512:       test.sha512,
+			Prefers:      test.prefers,
 		}
 		var buffer bytes.Buffer
 		err := manifestutil.Write(options, &buffer)
@@ -579,6 +789,27 @@ func (s *S) TestGenerateNoManifests(c *C) {
 	c.Assert(n, Equals, 0)
 }
 
+func (s *S) TestGenerateManifestsSchema(c *C) {
+	report, err := manifestutil.NewReport("/")
+	c.Assert(err, IsNil)
+	options := &manifestutil.WriteOptions{
+		Report: report,
+		Schema: "1.0",
+	}
+	var buffer bytes.Buffer
+	err = manifestutil.Write(options, &buffer)
+	c.Assert(err, IsNil)
+
+	options.Schema = "0.1"
+	err = manifestutil.Write(options, &buffer)
+	c.Assert(err, ErrorMatches, `unsupported manifest schema "0.1"`)
+
+	options.Schema = "1.0"
+	options.SHA512 = true
+	err = manifestutil.Write(options, &buffer)
+	c.Assert(err, ErrorMatches, `manifest schema "1.0" does not support sha512 digests`)
+}
+
 var validateManifestTests = []struct {
 	summary string
 	input   string
@@ -624,6 +855,60 @@ var validateManifestTests = []struct {
 		{"kind":"slice","name":"pkg1_myslice"}
 	`,
 	error: `invalid manifest: content path /dir/ has no matching entry in paths`,
+}, {
+	summary: "Mixed digests",
+	input: `
+		{"jsonwall":"1.0","schema":"1.1","count":6}
+		{"kind":"content","slice":"pkg1_myslice","path":"/file"}
+		{"kind":"content","slice":"pkg1_myslice","path":"/other"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1","sha512":"hash1-512"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","sha512":"hash512","final_sha512":"final512","size":3}
+		{"kind":"path","path":"/other","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+}, {
+	summary: "SHA512 digest in schema 1.0",
+	input: `
+		{"jsonwall":"1.0","schema":"1.0","count":4}
+		{"kind":"content","slice":"pkg1_myslice","path":"/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","sha512":"hash512","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	error: `invalid manifest: path /file has sha512 digest in schema 1.0`,
+}, {
+	summary: "Package SHA512 digest in schema 1.0",
+	input: `
+		{"jsonwall":"1.0","schema":"1.0","count":1}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1","sha512":"hash1-512"}
+	`,
+	error: `invalid manifest: package "pkg1" has sha512 digest in schema 1.0`,
+}, {
+	summary: "Final sha512 without sha512",
+	input: `
+		{"jsonwall":"1.0","schema":"1.1","count":4}
+		{"kind":"content","slice":"pkg1_myslice","path":"/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","final_sha512":"final512","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	error: `invalid manifest: path /file has final_sha512 without sha512`,
+}, {
+	summary: "Preference for missing package",
+	input: `
+		{"jsonwall":"1.0","schema":"1.1","count":2}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"prefer","path":"/file","package":"pkg2","candidates":["pkg1","pkg2"]}
+	`,
+	error: `invalid manifest: preference for /file refers to missing package "pkg2"`,
+}, {
+	summary: "Preference outside of candidates",
+	input: `
+		{"jsonwall":"1.0","schema":"1.1","count":2}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"prefer","path":"/file","package":"pkg1","candidates":["pkg2","pkg3"]}
+	`,
+	error: `invalid manifest: preference for /file has package "pkg1" outside of its candidates`,
 }, {
 	summary: "Malformed jsonwall",
 	input: `
@@ -8,6 +8,7 @@ import (
 
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 type ReportEntry struct {
@@ -18,9 +19,18 @@ type ReportEntry struct {
 	Slices      map[*setup.Slice]bool
 	Link        string
 	FinalSHA256 string
+	// SHA512 and FinalSHA512 are only set when the digests were requested
+	// when creating the content.
+	SHA512      string
+	FinalSHA512 string
 	// If Inode is greater than 0, all entries represent hard links to the same
 	// inode.
 	Inode uint64
+	// UID and GID are the owner set explicitly for the path, if any.
+	UID *int
+	GID *int
+	// Xattrs holds the extended attributes preserved from the package.
+	Xattrs map[string]string
 }
 
 // Report holds the information about files and directories created when slicing
@@ -74,8 +84,10 @@ func (r *Report) Add(slice *setup.Slice, fsEntry *fsutil.Entry) error {
 		}
 		inode = entry.Inode
 		fsEntryCpy.SHA256 = entry.SHA256
+		fsEntryCpy.SHA512 = entry.SHA512
 		fsEntryCpy.Size = entry.Size
 		fsEntryCpy.Link = entry.Link
+		fsEntryCpy.Xattrs = entry.Xattrs
 	}
 
 	if entry, ok := r.Entries[relPath]; ok {
@@ -95,10 +107,12 @@ func (r *Report) Add(slice *setup.Slice, fsEntry *fsutil.Entry) error {
 			Path:   relPath,
 			Mode:   fsEntry.Mode,
 			SHA256: fsEntryCpy.SHA256,
+			SHA512: fsEntryCpy.SHA512,
 			Size:   fsEntryCpy.Size,
 			Slices: map[*setup.Slice]bool{slice: true},
 			Link:   fsEntryCpy.Link,
 			Inode:  inode,
+			Xattrs: fsEntryCpy.Xattrs,
 		}
 	}
 	return nil
@@ -123,11 +137,55 @@ func (r *Report) Mutate(fsEntry *fsutil.Entry) error {
 		return nil
 	}
 	entry.FinalSHA256 = fsEntry.SHA256
+	entry.FinalSHA512 = fsEntry.SHA512
 	entry.Size = fsEntry.Size
 	r.Entries[relPath] = entry
 	return nil
 }
 
+// Merge merges into the report the paths recorded in mfest, the manifest of
+// content previously written under the same root. Paths present in both must
+// have the same content, and the slices owning them in mfest are added to the
+// report entries. The paths only present in mfest are left untouched, so the
+// report is meant to be written with Update on top of mfest.
+func (r *Report) Merge(mfest *manifest.Manifest) error {
+	slices := make(map[string]*setup.Slice)
+	for _, entry := range r.Entries {
+		for slice := range entry.Slices {
+			slices[slice.String()] = slice
+		}
+	}
+	return mfest.IteratePaths("", func(path *manifest.Path) error {
+		entry, ok := r.Entries[path.Path]
+		if !ok {
+			return nil
+		}
+		current := reportEntryPath(&entry, nil, false)
+		if current.Mode != path.Mode {
+			return fmt.Errorf("cannot merge manifest: path %s has diverging mode: %s != %s", path.Path, current.Mode, path.Mode)
+		} else if current.Link != path.Link {
+			return fmt.Errorf("cannot merge manifest: path %s has diverging link: %q != %q", path.Path, current.Link, path.Link)
+		} else if current.Size != path.Size {
+			return fmt.Errorf("cannot merge manifest: path %s has diverging size: %d != %d", path.Path, current.Size, path.Size)
+		} else if current.SHA256 != path.SHA256 || current.FinalSHA256 != path.FinalSHA256 {
+			return fmt.Errorf("cannot merge manifest: path %s has diverging hash", path.Path)
+		}
+		for _, name := range path.Slices {
+			slice, ok := slices[name]
+			if !ok {
+				sk, err := setup.ParseSliceKey(name)
+				if err != nil {
+					return fmt.Errorf("cannot merge manifest: %s", err)
+				}
+				slice = &setup.Slice{Package: sk.Package, Name: sk.Slice}
+				slices[name] = slice
+			}
+			entry.Slices[slice] = true
+		}
+		return nil
+	})
+}
+
 func (r *Report) sanitizeAbsPath(path string, isDir bool) (relPath string, err error) {
 	if !strings.HasPrefix(path, r.Root) {
 		return "", fmt.Errorf("%s outside of root %s", path, r.Root)
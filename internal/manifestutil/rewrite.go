@@ -0,0 +1,234 @@
+package manifestutil
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/jsonwall"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+type RewriteOptions struct {
+	// Manifest is the prior manifest being updated.
+	Manifest     *manifest.Manifest
+	PackageInfo  []*archive.PackageInfo
+	PackageStats map[string]*deb.ExtractStats
+	Selection    []*setup.Slice
+	Report       *Report
+	// Schema is the version of the manifest schema written, which must be
+	// one of manifest.SupportedSchemas. It defaults to the schema of the
+	// prior manifest.
+	Schema string
+	// SHA512 records the sha512 digests of the updated packages and paths.
+	SHA512 bool
+	// Prefers holds the preferences which resolved the updated paths. They
+	// replace the prior ones for the same paths.
+	Prefers []*setup.Prefer
+}
+
+// Rewrite writes into writer a new manifest holding the prior manifest
+// merged with the packages, slices and paths in options. Entries of the prior
+// manifest which are not affected by the delta are kept as they are, packages
+// and paths present in the delta replace the prior ones, and slices are
+// merged. The resulting manifest is validated before being written.
+//
+// The manifest is always regenerated as a whole: every entry is written out
+// again and the hard link ids are renumbered, so the cost is the same as
+// writing the manifest from scratch with Write.
+func Rewrite(options *RewriteOptions, writer io.Writer) error {
+	prior := options.Manifest
+	schema := options.Schema
+	if schema == "" {
+		schema = prior.Schema()
+	}
+	err := checkSchema(schema, options.SHA512)
+	if err != nil {
+		return err
+	}
+	// The report may refer to the slices of the prior manifest once merged
+	// with it, see Report.Merge.
+	priorSlices := make(map[string]bool)
+	err = prior.IterateSlices("", func(slice *manifest.Slice) error {
+		priorSlices[slice.Name] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = fastValidate(&WriteOptions{
+		PackageInfo: options.PackageInfo,
+		Selection:   options.Selection,
+		Report:      options.Report,
+	}, priorSlices)
+	if err != nil {
+		return err
+	}
+
+	dbw := jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{
+		Schema: schema,
+	})
+
+	updatedPkgs := make(map[string]bool)
+	for _, info := range options.PackageInfo {
+		updatedPkgs[info.Name] = true
+	}
+	err = prior.IteratePackages(func(pkg *manifest.Package) error {
+		if updatedPkgs[pkg.Name] {
+			return nil
+		}
+		return dbw.Add(pkg)
+	})
+	if err != nil {
+		return err
+	}
+	err = manifestAddPackages(dbw, options.PackageInfo, options.PackageStats, options.SHA512)
+	if err != nil {
+		return err
+	}
+
+	updatedSlices := make(map[string]bool)
+	for _, slice := range options.Selection {
+		updatedSlices[slice.String()] = true
+	}
+	err = prior.IterateSlices("", func(slice *manifest.Slice) error {
+		if updatedSlices[slice.Name] {
+			return nil
+		}
+		return dbw.Add(slice)
+	})
+	if err != nil {
+		return err
+	}
+	err = manifestAddSlices(dbw, options.Selection)
+	if err != nil {
+		return err
+	}
+
+	updatedPaths := make(map[string]bool)
+	for _, entry := range options.Report.Entries {
+		updatedPaths[entry.Path] = true
+	}
+	err = prior.IterateContents("", func(content *manifest.Content) error {
+		if updatedPaths[content.Path] {
+			return nil
+		}
+		return dbw.Add(content)
+	})
+	if err != nil {
+		return err
+	}
+	// Hard link ids of the prior manifest and of the report are unrelated,
+	// so paths are collected and the ids renumbered before being added.
+	var paths []*manifest.Path
+	groups := make(map[*manifest.Path]inodeGroup)
+	err = prior.IteratePaths("", func(path *manifest.Path) error {
+		if updatedPaths[path.Path] {
+			return nil
+		}
+		paths = append(paths, path)
+		if path.Inode != 0 {
+			groups[path] = inodeGroup{inode: path.Inode}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range options.Report.Entries {
+		sliceNames := []string{}
+		for slice := range entry.Slices {
+			err := dbw.Add(&manifest.Content{
+				Kind:  "content",
+				Slice: slice.String(),
+				Path:  entry.Path,
+			})
+			if err != nil {
+				return err
+			}
+			sliceNames = append(sliceNames, slice.String())
+		}
+		sort.Strings(sliceNames)
+		path := reportEntryPath(&entry, sliceNames, options.SHA512)
+		paths = append(paths, path)
+		if entry.Inode != 0 {
+			groups[path] = inodeGroup{inode: entry.Inode, delta: true}
+		}
+	}
+	renumberInodes(paths, groups)
+	for _, path := range paths {
+		err := dbw.Add(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	updatedPrefers := make(map[string]bool)
+	for _, prefer := range options.Prefers {
+		updatedPrefers[prefer.Path] = true
+	}
+	err = prior.IteratePrefers("", func(prefer *manifest.Prefer) error {
+		if updatedPrefers[prefer.Path] {
+			return nil
+		}
+		return dbw.Add(prefer)
+	})
+	if err != nil {
+		return err
+	}
+	err = manifestAddPrefers(dbw, options.Prefers)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	_, err = dbw.WriteTo(&buffer)
+	if err != nil {
+		return err
+	}
+	mfest, err := manifest.Read(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		return err
+	}
+	err = Validate(mfest)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(buffer.Bytes())
+	return err
+}
+
+// inodeGroup identifies a hard link group either in the prior manifest or in
+// the delta report.
+type inodeGroup struct {
+	inode uint64
+	delta bool
+}
+
+// renumberInodes assigns sequential hard link ids to the groups in paths,
+// following the order of the paths. Groups left with a single path are no
+// longer hard links.
+func renumberInodes(paths []*manifest.Path, groups map[*manifest.Path]inodeGroup) {
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Path < paths[j].Path
+	})
+	members := make(map[inodeGroup]int)
+	for _, group := range groups {
+		members[group]++
+	}
+	ids := make(map[inodeGroup]uint64)
+	for _, path := range paths {
+		group, ok := groups[path]
+		if !ok || members[group] < 2 {
+			path.Inode = 0
+			continue
+		}
+		if _, ok := ids[group]; !ok {
+			ids[group] = uint64(len(ids) + 1)
+		}
+		path.Inode = ids[group]
+	}
+}
@@ -0,0 +1,564 @@
+package manifestutil_test
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/apachetestutil"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var rewritePackageInfo = []*archive.PackageInfo{{
+	Name:    "package1",
+	Version: "v1",
+	Arch:    "a1",
+	SHA256:  "s1",
+}, {
+	Name:    "package2",
+	Version: "v2",
+	Arch:    "a2",
+	SHA256:  "s2",
+}}
+
+var rewritePriorReport = &manifestutil.Report{
+	Root: "/",
+	Entries: map[string]manifestutil.ReportEntry{
+		"/file1": {
+			Path:   "/file1",
+			Mode:   0644,
+			SHA256: "hash1",
+			Size:   1,
+			Slices: map[*setup.Slice]bool{slice1: true},
+			Inode:  1,
+		},
+		"/file2": {
+			Path:   "/file2",
+			Mode:   0644,
+			SHA256: "hash1",
+			Size:   1,
+			Slices: map[*setup.Slice]bool{slice1: true},
+			Inode:  1,
+		},
+		"/file3": {
+			Path:   "/file3",
+			Mode:   0644,
+			SHA256: "hash3",
+			Size:   3,
+			Slices: map[*setup.Slice]bool{slice2: true},
+		},
+	},
+}
+
+var rewriteManifestTests = []struct {
+	summary     string
+	report      *manifestutil.Report
+	packageInfo []*archive.PackageInfo
+	selection   []*setup.Slice
+	expected    *apachetestutil.ManifestContents
+	error       string
+}{{
+	summary:   "Replace path and package",
+	selection: []*setup.Slice{slice2},
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file3": {
+				Path:   "/file3",
+				Mode:   0755,
+				SHA256: "new-hash3",
+				Size:   33,
+				Slices: map[*setup.Slice]bool{slice2: true},
+			},
+			"/file4": {
+				Path:   "/file4",
+				Mode:   0644,
+				SHA256: "hash4",
+				Size:   4,
+				Slices: map[*setup.Slice]bool{slice2: true},
+			},
+		},
+	},
+	packageInfo: []*archive.PackageInfo{{
+		Name:    "package2",
+		Version: "v2.1",
+		Arch:    "a2",
+		SHA256:  "s2.1",
+	}},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{{
+			Kind:   "path",
+			Path:   "/file1",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			SHA256: "hash1",
+			Size:   1,
+			Inode:  1,
+		}, {
+			Kind:   "path",
+			Path:   "/file2",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			SHA256: "hash1",
+			Size:   1,
+			Inode:  1,
+		}, {
+			Kind:   "path",
+			Path:   "/file3",
+			Mode:   "0755",
+			Slices: []string{"package2_slice2"},
+			SHA256: "new-hash3",
+			Size:   33,
+		}, {
+			Kind:   "path",
+			Path:   "/file4",
+			Mode:   "0644",
+			Slices: []string{"package2_slice2"},
+			SHA256: "hash4",
+			Size:   4,
+		}},
+		Packages: []*manifest.Package{{
+			Kind:    "package",
+			Name:    "package1",
+			Version: "v1",
+			Digest:  "s1",
+			Arch:    "a1",
+		}, {
+			Kind:    "package",
+			Name:    "package2",
+			Version: "v2.1",
+			Digest:  "s2.1",
+			Arch:    "a2",
+		}},
+		Slices: []*manifest.Slice{{
+			Kind: "slice",
+			Name: "package1_slice1",
+		}, {
+			Kind: "slice",
+			Name: "package2_slice2",
+		}},
+		Contents: []*manifest.Content{{
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file1",
+		}, {
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file2",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file3",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file4",
+		}},
+	},
+}, {
+	summary:   "Hard link groups are renumbered",
+	selection: []*setup.Slice{slice2},
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file2": {
+				Path:   "/file2",
+				Mode:   0644,
+				SHA256: "hash2",
+				Size:   2,
+				Slices: map[*setup.Slice]bool{slice2: true},
+			},
+			"/file5": {
+				Path:   "/file5",
+				Mode:   0644,
+				SHA256: "hash5",
+				Size:   5,
+				Slices: map[*setup.Slice]bool{slice2: true},
+				Inode:  1,
+			},
+			"/file6": {
+				Path:   "/file6",
+				Mode:   0644,
+				SHA256: "hash5",
+				Size:   5,
+				Slices: map[*setup.Slice]bool{slice2: true},
+				Inode:  1,
+			},
+		},
+	},
+	packageInfo: []*archive.PackageInfo{rewritePackageInfo[1]},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{{
+			Kind:   "path",
+			Path:   "/file1",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			SHA256: "hash1",
+			Size:   1,
+		}, {
+			Kind:   "path",
+			Path:   "/file2",
+			Mode:   "0644",
+			Slices: []string{"package2_slice2"},
+			SHA256: "hash2",
+			Size:   2,
+		}, {
+			Kind:   "path",
+			Path:   "/file3",
+			Mode:   "0644",
+			Slices: []string{"package2_slice2"},
+			SHA256: "hash3",
+			Size:   3,
+		}, {
+			Kind:   "path",
+			Path:   "/file5",
+			Mode:   "0644",
+			Slices: []string{"package2_slice2"},
+			SHA256: "hash5",
+			Size:   5,
+			Inode:  1,
+		}, {
+			Kind:   "path",
+			Path:   "/file6",
+			Mode:   "0644",
+			Slices: []string{"package2_slice2"},
+			SHA256: "hash5",
+			Size:   5,
+			Inode:  1,
+		}},
+		Packages: []*manifest.Package{{
+			Kind:    "package",
+			Name:    "package1",
+			Version: "v1",
+			Digest:  "s1",
+			Arch:    "a1",
+		}, {
+			Kind:    "package",
+			Name:    "package2",
+			Version: "v2",
+			Digest:  "s2",
+			Arch:    "a2",
+		}},
+		Slices: []*manifest.Slice{{
+			Kind: "slice",
+			Name: "package1_slice1",
+		}, {
+			Kind: "slice",
+			Name: "package2_slice2",
+		}},
+		Contents: []*manifest.Content{{
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file1",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file2",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file3",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file5",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file6",
+		}},
+	},
+}, {
+	summary:   "Delta refers to missing package",
+	selection: []*setup.Slice{slice2},
+	report: &manifestutil.Report{
+		Root:    "/",
+		Entries: map[string]manifestutil.ReportEntry{},
+	},
+	packageInfo: []*archive.PackageInfo{},
+	error:       `internal error: invalid manifest: slice package2_slice2 refers to missing package "package2"`,
+}}
+
+func (s *S) TestRewriteManifest(c *C) {
+	for _, test := range rewriteManifestTests {
+		c.Logf(test.summary)
+
+		var prior bytes.Buffer
+		err := manifestutil.Write(&manifestutil.WriteOptions{
+			PackageInfo: rewritePackageInfo,
+			Selection:   []*setup.Slice{slice1, slice2},
+			Report:      rewritePriorReport,
+		}, &prior)
+		c.Assert(err, IsNil)
+		priorManifest, err := manifest.Read(&prior)
+		c.Assert(err, IsNil)
+
+		options := &manifestutil.RewriteOptions{
+			Manifest:    priorManifest,
+			PackageInfo: test.packageInfo,
+			Selection:   test.selection,
+			Report:      test.report,
+		}
+		var buffer bytes.Buffer
+		err = manifestutil.Rewrite(options, &buffer)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		mfest, err := manifest.Read(&buffer)
+		c.Assert(err, IsNil)
+		err = manifestutil.Validate(mfest)
+		c.Assert(err, IsNil)
+		contents := apachetestutil.DumpManifestContents(c, mfest)
+		c.Assert(contents, DeepEquals, test.expected)
+	}
+}
+
+var rewriteManifestSchemaTests = []struct {
+	summary string
+	schema  string
+	sha512  bool
+	result  string
+	error   string
+}{{
+	summary: "Schema of the prior manifest is kept by default",
+	result:  "1.0",
+}, {
+	summary: "Requested schema",
+	schema:  "1.1",
+	result:  "1.1",
+}, {
+	summary: "Unsupported schema",
+	schema:  "0.9",
+	error:   `unsupported manifest schema "0.9"`,
+}, {
+	summary: "Prior schema without sha512 digests",
+	sha512:  true,
+	error:   `manifest schema "1.0" does not support sha512 digests`,
+}}
+
+func (s *S) TestRewriteManifestSchema(c *C) {
+	for _, test := range rewriteManifestSchemaTests {
+		c.Logf("Summary: %s", test.summary)
+
+		var prior bytes.Buffer
+		err := manifestutil.Write(&manifestutil.WriteOptions{
+			PackageInfo: rewritePackageInfo,
+			Selection:   []*setup.Slice{slice1, slice2},
+			Report:      rewritePriorReport,
+			Schema:      "1.0",
+		}, &prior)
+		c.Assert(err, IsNil)
+		priorManifest, err := manifest.Read(&prior)
+		c.Assert(err, IsNil)
+
+		options := &manifestutil.RewriteOptions{
+			Manifest: priorManifest,
+			Report:   &manifestutil.Report{Root: "/"},
+			Schema:   test.schema,
+			SHA512:   test.sha512,
+		}
+		var buffer bytes.Buffer
+		err = manifestutil.Rewrite(options, &buffer)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		mfest, err := manifest.Read(&buffer)
+		c.Assert(err, IsNil)
+		c.Assert(mfest.Schema(), Equals, test.result)
+	}
+}
+
+var mergeSlice = &setup.Slice{
+	Package: "package3",
+	Name:    "slice3",
+}
+
+var mergeManifestTests = []struct {
+	summary  string
+	report   *manifestutil.Report
+	expected *apachetestutil.ManifestContents
+	error    string
+}{{
+	summary: "Clean merge",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file3": {
+				Path:   "/file3",
+				Mode:   0644,
+				SHA256: "hash3",
+				Size:   3,
+				Slices: map[*setup.Slice]bool{mergeSlice: true},
+			},
+			"/file7": {
+				Path:   "/file7",
+				Mode:   0644,
+				SHA256: "hash7",
+				Size:   7,
+				Slices: map[*setup.Slice]bool{mergeSlice: true},
+			},
+		},
+	},
+	expected: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{{
+			Kind:   "path",
+			Path:   "/file1",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			SHA256: "hash1",
+			Size:   1,
+			Inode:  1,
+		}, {
+			Kind:   "path",
+			Path:   "/file2",
+			Mode:   "0644",
+			Slices: []string{"package1_slice1"},
+			SHA256: "hash1",
+			Size:   1,
+			Inode:  1,
+		}, {
+			Kind:   "path",
+			Path:   "/file3",
+			Mode:   "0644",
+			Slices: []string{"package2_slice2", "package3_slice3"},
+			SHA256: "hash3",
+			Size:   3,
+		}, {
+			Kind:   "path",
+			Path:   "/file7",
+			Mode:   "0644",
+			Slices: []string{"package3_slice3"},
+			SHA256: "hash7",
+			Size:   7,
+		}},
+		Packages: []*manifest.Package{{
+			Kind:    "package",
+			Name:    "package1",
+			Version: "v1",
+			Digest:  "s1",
+			Arch:    "a1",
+		}, {
+			Kind:    "package",
+			Name:    "package2",
+			Version: "v2",
+			Digest:  "s2",
+			Arch:    "a2",
+		}, {
+			Kind:    "package",
+			Name:    "package3",
+			Version: "v3",
+			Digest:  "s3",
+			Arch:    "a3",
+		}},
+		Slices: []*manifest.Slice{{
+			Kind: "slice",
+			Name: "package1_slice1",
+		}, {
+			Kind: "slice",
+			Name: "package2_slice2",
+		}, {
+			Kind: "slice",
+			Name: "package3_slice3",
+		}},
+		Contents: []*manifest.Content{{
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file1",
+		}, {
+			Kind:  "content",
+			Slice: "package1_slice1",
+			Path:  "/file2",
+		}, {
+			Kind:  "content",
+			Slice: "package2_slice2",
+			Path:  "/file3",
+		}, {
+			Kind:  "content",
+			Slice: "package3_slice3",
+			Path:  "/file3",
+		}, {
+			Kind:  "content",
+			Slice: "package3_slice3",
+			Path:  "/file7",
+		}},
+	},
+}, {
+	summary: "Conflicting merge",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file3": {
+				Path:   "/file3",
+				Mode:   0644,
+				SHA256: "other-hash3",
+				Size:   3,
+				Slices: map[*setup.Slice]bool{mergeSlice: true},
+			},
+		},
+	},
+	error: `cannot merge manifest: path /file3 has diverging hash`,
+}, {
+	summary: "Conflicting mode",
+	report: &manifestutil.Report{
+		Root: "/",
+		Entries: map[string]manifestutil.ReportEntry{
+			"/file1": {
+				Path:   "/file1",
+				Mode:   0755,
+				SHA256: "hash1",
+				Size:   1,
+				Slices: map[*setup.Slice]bool{mergeSlice: true},
+			},
+		},
+	},
+	error: `cannot merge manifest: path /file1 has diverging mode: 0755 != 0644`,
+}}
+
+func (s *S) TestMergeManifest(c *C) {
+	for _, test := range mergeManifestTests {
+		c.Logf("Summary: %s", test.summary)
+
+		var prior bytes.Buffer
+		err := manifestutil.Write(&manifestutil.WriteOptions{
+			PackageInfo: rewritePackageInfo,
+			Selection:   []*setup.Slice{slice1, slice2},
+			Report:      rewritePriorReport,
+		}, &prior)
+		c.Assert(err, IsNil)
+		priorManifest, err := manifest.Read(&prior)
+		c.Assert(err, IsNil)
+
+		err = test.report.Merge(priorManifest)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+
+		options := &manifestutil.RewriteOptions{
+			Manifest: priorManifest,
+			PackageInfo: []*archive.PackageInfo{{
+				Name:    "package3",
+				Version: "v3",
+				Arch:    "a3",
+				SHA256:  "s3",
+			}},
+			Selection: []*setup.Slice{mergeSlice},
+			Report:    test.report,
+		}
+		var buffer bytes.Buffer
+		err = manifestutil.Rewrite(options, &buffer)
+		c.Assert(err, IsNil)
+		mfest, err := manifest.Read(&buffer)
+		c.Assert(err, IsNil)
+		contents := apachetestutil.DumpManifestContents(c, mfest)
+		c.Assert(contents, DeepEquals, test.expected)
+	}
+}
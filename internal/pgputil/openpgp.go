@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"time"
 
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/clearsign"
@@ -55,6 +56,26 @@ func DecodePubKey(armoredData []byte) (*packet.PublicKey, error) {
 	return pubKeys[0], nil
 }
 
+// EncodePubKey encodes the public key packet as armored data. Only the key
+// packet itself is encoded, without any user IDs or signatures.
+func EncodePubKey(pubKey *packet.PublicKey) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, "PGP PUBLIC KEY BLOCK", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = pubKey.Serialize(w)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode public key: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
 // DecodeClearSigned decodes the first clearsigned message in the data and
 // returns the signatures and the message body.
 //
@@ -84,6 +105,36 @@ func DecodeClearSigned(clearData []byte) (sigs []*packet.Signature, canonicalBod
 	return sigs, block.Bytes, nil
 }
 
+// DecodeSignatures decodes the detached signatures in data, which may be
+// either armored or binary.
+func DecodeSignatures(data []byte) (sigs []*packet.Signature, err error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		block, err := armor.Decode(reader)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode armored data: %w", err)
+		}
+		reader = block.Body
+	}
+	packets := packet.NewReader(reader)
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("cannot parse signature data: %w", err)
+		}
+		if sig, ok := p.(*packet.Signature); ok {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("data contains no signatures")
+	}
+	return sigs, nil
+}
+
 // VerifySignature returns nil if sig is a valid signature from pubKey.
 func VerifySignature(pubKey *packet.PublicKey, sig *packet.Signature, body []byte) error {
 	hash := sig.Hash.New()
@@ -97,17 +148,128 @@ func VerifySignature(pubKey *packet.PublicKey, sig *packet.Signature, body []byt
 // VerifyAnySignature returns nil if any signature in sigs is a valid signature
 // mady by any of the public keys in pubKeys.
 func VerifyAnySignature(pubKeys []*packet.PublicKey, sigs []*packet.Signature, body []byte) error {
+	_, err := VerifyAnySignatureWithOptions(pubKeys, sigs, body, nil)
+	return err
+}
+
+// VerifyOptions holds the checks made by VerifyAnySignatureWithOptions on
+// the validity period of signatures and keys.
+type VerifyOptions struct {
+	// Time is when the signatures and keys must be valid. It defaults to
+	// the current time.
+	Time time.Time
+	// KeyExpiry holds when the public keys expire, indexed by key ID. Keys
+	// not in it never expire.
+	KeyExpiry map[uint64]time.Time
+	// AllowExpiredKeys accepts signatures from keys which have expired.
+	AllowExpiredKeys bool
+}
+
+// KeyExpiredError is returned when a signature is only valid from a key
+// which has expired.
+type KeyExpiredError struct {
+	KeyID  uint64
+	Expiry time.Time
+}
+
+func (e *KeyExpiredError) Error() string {
+	return fmt.Sprintf("key %X expired on %s", e.KeyID, e.Expiry.UTC().Format(time.DateOnly))
+}
+
+// VerifyAnySignatureWithOptions is like VerifyAnySignature, but it returns
+// the public key which made the valid signature. With options, signatures
+// whose lifetime has passed are not valid, and neither are the ones made by
+// expired keys unless they are allowed.
+func VerifyAnySignatureWithOptions(pubKeys []*packet.PublicKey, sigs []*packet.Signature, body []byte, options *VerifyOptions) (*packet.PublicKey, error) {
+	now := time.Now()
+	if options != nil && !options.Time.IsZero() {
+		now = options.Time
+	}
 	var err error
+	var expiredErr *KeyExpiredError
 	for _, sig := range sigs {
 		for _, key := range pubKeys {
 			err = VerifySignature(key, sig, body)
-			if err == nil {
-				return nil
+			if err != nil {
+				continue
 			}
+			if options == nil {
+				return key, nil
+			}
+			if sig.SigLifetimeSecs != nil && *sig.SigLifetimeSecs != 0 {
+				expiry := sig.CreationTime.Add(time.Duration(*sig.SigLifetimeSecs) * time.Second)
+				if !now.Before(expiry) {
+					err = fmt.Errorf("signature expired on %s", expiry.UTC().Format(time.DateOnly))
+					continue
+				}
+			}
+			if expiry, ok := options.KeyExpiry[key.KeyId]; ok && !now.Before(expiry) && !options.AllowExpiredKeys {
+				expiredErr = &KeyExpiredError{KeyID: key.KeyId, Expiry: expiry}
+				err = expiredErr
+				continue
+			}
+			return key, nil
 		}
 	}
+	if expiredErr != nil {
+		return nil, expiredErr
+	}
 	if len(sigs) == 1 && len(pubKeys) == 1 {
-		return err
+		return nil, err
+	}
+	return nil, fmt.Errorf("cannot verify any signatures")
+}
+
+// DecodeKeyExpiry returns when the primary public key in the armored data
+// expires, according to its most recent valid self-signature, or the zero
+// time if it does not expire.
+func DecodeKeyExpiry(armoredData []byte) (time.Time, error) {
+	block, err := armor.Decode(bytes.NewReader(armoredData))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot decode armored data")
+	}
+
+	var pubKey *packet.PublicKey
+	var userID *packet.UserId
+	var selfSig *packet.Signature
+	reader := packet.NewReader(block.Body)
+	for {
+		p, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return time.Time{}, err
+		}
+		switch p := p.(type) {
+		case *packet.PublicKey:
+			if pubKey == nil {
+				pubKey = p
+			} else if !p.IsSubkey {
+				return time.Time{}, fmt.Errorf("armored data contains more than one public key")
+			}
+			// Signatures after subkeys bind them rather than the
+			// primary key.
+			userID = nil
+		case *packet.UserId:
+			userID = p
+		case *packet.Signature:
+			if pubKey == nil || userID == nil || p.IssuerKeyId == nil || *p.IssuerKeyId != pubKey.KeyId {
+				continue
+			}
+			if pubKey.VerifyUserIdSignature(userID.Id, pubKey, p) != nil {
+				continue
+			}
+			if selfSig == nil || p.CreationTime.After(selfSig.CreationTime) {
+				selfSig = p
+			}
+		}
+	}
+	if pubKey == nil {
+		return time.Time{}, fmt.Errorf("armored data contains no public key")
+	}
+	if selfSig == nil || selfSig.KeyLifetimeSecs == nil || *selfSig.KeyLifetimeSecs == 0 {
+		return time.Time{}, nil
 	}
-	return fmt.Errorf("cannot verify any signatures")
+	return pubKey.CreationTime.Add(time.Duration(*selfSig.KeyLifetimeSecs) * time.Second), nil
 }
@@ -1,6 +1,11 @@
 package pgputil_test
 
 import (
+	"bytes"
+	"crypto"
+	"time"
+
+	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
 	. "gopkg.in/check.v1"
 
@@ -112,6 +117,15 @@ var verifyClearSignTests = []verifyClearSignTest{{
 	relerror:  "openpgp: .*invalid signature:.*verification failure",
 }}
 
+func (s *S) TestEncodePubKey(c *C) {
+	data, err := pgputil.EncodePubKey(key1.PubKey)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, "(?s)-----BEGIN PGP PUBLIC KEY BLOCK-----\n.*-----END PGP PUBLIC KEY BLOCK-----\n")
+	pubKey, err := pgputil.DecodePubKey(data)
+	c.Assert(err, IsNil)
+	c.Assert(pubKey.Fingerprint, DeepEquals, key1.PubKey.Fingerprint)
+}
+
 func (s *S) TestVerifySignature(c *C) {
 	for _, test := range verifyClearSignTests {
 		c.Logf("Summary: %s", test.summary)
@@ -129,6 +143,141 @@ func (s *S) TestVerifySignature(c *C) {
 	}
 }
 
+func (s *S) TestDecodeSignatures(c *C) {
+	binarySig, err := testutil.DetachSign(key1.PrivKey, []byte("foo"))
+	c.Assert(err, IsNil)
+	var armored bytes.Buffer
+	writer, err := armor.Encode(&armored, "PGP SIGNATURE", nil)
+	c.Assert(err, IsNil)
+	_, err = writer.Write(binarySig)
+	c.Assert(err, IsNil)
+	c.Assert(writer.Close(), IsNil)
+
+	for _, data := range [][]byte{binarySig, armored.Bytes()} {
+		sigs, err := pgputil.DecodeSignatures(data)
+		c.Assert(err, IsNil)
+		c.Assert(sigs, HasLen, 1)
+		err = pgputil.VerifyAnySignature([]*packet.PublicKey{key1.PubKey}, sigs, []byte("foo"))
+		c.Assert(err, IsNil)
+	}
+
+	_, err = pgputil.DecodeSignatures([]byte("foo"))
+	c.Assert(err, ErrorMatches, "cannot parse signature data: .*")
+}
+
+// sign returns a signature of data made with privKey at the given time,
+// which expires after lifetime unless it is zero.
+func sign(c *C, privKey *packet.PrivateKey, data []byte, created time.Time, lifetime time.Duration) *packet.Signature {
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeBinary,
+		PubKeyAlgo:   privKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: created,
+		IssuerKeyId:  &privKey.KeyId,
+	}
+	if lifetime != 0 {
+		secs := uint32(lifetime / time.Second)
+		sig.SigLifetimeSecs = &secs
+	}
+	hash := sig.Hash.New()
+	hash.Write(data)
+	c.Assert(sig.Sign(hash, privKey, nil), IsNil)
+	return sig
+}
+
+var (
+	verifyTime  = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	signTime    = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiredTime = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+)
+
+var verifyOptionsTests = []struct {
+	summary     string
+	pubKeys     []*packet.PublicKey
+	sigLifetime time.Duration
+	options     *pgputil.VerifyOptions
+	key         *packet.PublicKey
+	error       string
+}{{
+	summary: "Key which does not expire",
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+	options: &pgputil.VerifyOptions{Time: verifyTime},
+	key:     key1.PubKey,
+}, {
+	summary: "Key expiring later",
+	pubKeys: []*packet.PublicKey{key2.PubKey, key1.PubKey},
+	options: &pgputil.VerifyOptions{
+		Time:      verifyTime,
+		KeyExpiry: map[uint64]time.Time{key1.PubKey.KeyId: verifyTime.AddDate(1, 0, 0)},
+	},
+	key: key1.PubKey,
+}, {
+	summary: "Valid signature from an expired key",
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+	options: &pgputil.VerifyOptions{
+		Time:      verifyTime,
+		KeyExpiry: map[uint64]time.Time{key1.PubKey.KeyId: expiredTime},
+	},
+	error: `key 854BAF1AA9D76600 expired on 2024-03-01`,
+}, {
+	summary: "Expired key among others",
+	pubKeys: []*packet.PublicKey{key2.PubKey, key1.PubKey},
+	options: &pgputil.VerifyOptions{
+		Time:      verifyTime,
+		KeyExpiry: map[uint64]time.Time{key1.PubKey.KeyId: expiredTime},
+	},
+	error: `key 854BAF1AA9D76600 expired on 2024-03-01`,
+}, {
+	summary: "Expired keys may be allowed",
+	pubKeys: []*packet.PublicKey{key1.PubKey},
+	options: &pgputil.VerifyOptions{
+		Time:             verifyTime,
+		KeyExpiry:        map[uint64]time.Time{key1.PubKey.KeyId: expiredTime},
+		AllowExpiredKeys: true,
+	},
+	key: key1.PubKey,
+}, {
+	summary:     "Expired signature",
+	pubKeys:     []*packet.PublicKey{key1.PubKey},
+	sigLifetime: 24 * time.Hour,
+	options:     &pgputil.VerifyOptions{Time: verifyTime, AllowExpiredKeys: true},
+	error:       `signature expired on 2024-01-02`,
+}, {
+	summary:     "Signature lifetime is only checked with options",
+	pubKeys:     []*packet.PublicKey{key1.PubKey},
+	sigLifetime: 24 * time.Hour,
+	key:         key1.PubKey,
+}}
+
+func (s *S) TestVerifyAnySignatureWithOptions(c *C) {
+	for _, test := range verifyOptionsTests {
+		c.Logf("Summary: %s", test.summary)
+		sig := sign(c, key1.PrivKey, []byte("foo"), signTime, test.sigLifetime)
+		key, err := pgputil.VerifyAnySignatureWithOptions(test.pubKeys, []*packet.Signature{sig}, []byte("foo"), test.options)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(key, Equals, test.key)
+	}
+}
+
+func (s *S) TestDecodeKeyExpiry(c *C) {
+	expiry, err := pgputil.DecodeKeyExpiry([]byte(key1.PubKeyArmor))
+	c.Assert(err, IsNil)
+	c.Assert(expiry.IsZero(), Equals, true)
+
+	key, err := testutil.MakeExpiringKey(signTime, 30*24*time.Hour)
+	c.Assert(err, IsNil)
+	expiry, err = pgputil.DecodeKeyExpiry([]byte(key.PubKeyArmor))
+	c.Assert(err, IsNil)
+	c.Assert(expiry.Equal(signTime.AddDate(0, 0, 30)), Equals, true, Commentf("expiry: %s", expiry))
+
+	_, err = pgputil.DecodeKeyExpiry([]byte(twoPubKeysArmor))
+	c.Assert(err, ErrorMatches, "armored data contains more than one public key")
+}
+
 // twoPubKeysArmor contains two public keys:
 //   - 854BAF1AA9D76600 ("foo-bar <foo@bar>")
 //   - 871920D1991BC93C ("Ubuntu Archive Automatic Signing Key (2018) <ftpmaster@ubuntu.com>")
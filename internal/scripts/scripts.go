@@ -3,6 +3,7 @@ package scripts
 import (
 	"bytes"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,7 +11,9 @@ import (
 	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/strdist"
 )
 
 func init() {
@@ -39,6 +42,8 @@ type ContentValue struct {
 	// OnWrite has to be called after a successful write with the entry resulting
 	// from the write.
 	OnWrite func(entry *fsutil.Entry) error
+	// SHA512 makes the entries passed to OnWrite include the SHA512 digest.
+	SHA512 bool
 }
 
 // Content starlark.Value interface
@@ -76,12 +81,14 @@ func (c *ContentValue) Attr(name string) (Value, error) {
 		return starlark.NewBuiltin("Content.write", c.Write), nil
 	case "list":
 		return starlark.NewBuiltin("Content.list", c.List), nil
+	case "glob":
+		return starlark.NewBuiltin("Content.glob", c.Glob), nil
 	}
 	return nil, nil
 }
 
 func (c *ContentValue) AttrNames() []string {
-	return []string{"read", "write", "list"}
+	return []string{"read", "write", "list", "glob"}
 }
 
 // Content methods
@@ -178,9 +185,10 @@ func (c *ContentValue) Write(thread *starlark.Thread, fn *starlark.Builtin, args
 	// No mode parameter for now as slices are supposed to list files
 	// explicitly instead.
 	entry, err := fsutil.Create(&fsutil.CreateOptions{
-		Path: fpath,
-		Data: bytes.NewReader(fdata),
-		Mode: 0644,
+		Path:   fpath,
+		Data:   bytes.NewReader(fdata),
+		Mode:   0644,
+		SHA512: c.SHA512,
 	})
 	if err != nil {
 		return nil, c.polishError(path, err)
@@ -221,3 +229,147 @@ func (c *ContentValue) List(thread *starlark.Thread, fn *starlark.Builtin, args
 	}
 	return starlark.NewList(values), nil
 }
+
+// Glob returns the paths matching the pattern, in lexical order. The
+// directory holding the first wildcard must be readable, as with List, and
+// only the paths which are readable themselves are returned.
+func (c *ContentValue) Glob(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
+	var pattern starlark.String
+	err := starlark.UnpackArgs("Content.glob", args, kwargs, "pattern", &pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	gpath := pattern.GoString()
+	if !filepath.IsAbs(gpath) {
+		return nil, fmt.Errorf("content path must be absolute, got: %s", gpath)
+	}
+	dpath := gpath
+	if i := strings.IndexAny(gpath, "*?"); i >= 0 {
+		dpath = gpath[:i]
+	}
+	dpath = dpath[:strings.LastIndex(dpath, "/")+1]
+	// The pattern is matched against clean paths.
+	rest := gpath[len(dpath):]
+	dpath = filepath.Clean(dpath)
+	if dpath != "/" {
+		dpath += "/"
+	}
+	gpath = dpath + rest
+	fpath, err := c.RealPath(dpath, CheckRead)
+	if err != nil {
+		return nil, err
+	}
+	var values []Value
+	err = filepath.WalkDir(fpath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == fpath {
+			return nil
+		}
+		cpath := filepath.Join(dpath, strings.TrimPrefix(path, fpath))
+		if entry.IsDir() {
+			cpath += "/"
+		}
+		if !strdist.GlobPath(gpath, cpath) {
+			return nil
+		}
+		if c.CheckRead != nil && c.CheckRead(cpath) != nil {
+			return nil
+		}
+		values = append(values, starlark.String(cpath))
+		return nil
+	})
+	if err != nil {
+		return nil, c.polishError(pattern, err)
+	}
+	return starlark.NewList(values), nil
+}
+
+// PackageValue gives read-only access to the information of the selected
+// packages, such as their resolved versions.
+type PackageValue struct {
+	// Name is the package of the slice whose script is running.
+	Name string
+	// Packages holds the information of the selected packages, indexed by
+	// their names.
+	Packages map[string]*archive.PackageInfo
+}
+
+// Package starlark.Value interface
+// --------------------------------------------------------------------------
+
+func (p *PackageValue) String() string {
+	return fmt.Sprintf("Package{%s}", p.Name)
+}
+
+func (p *PackageValue) Type() string {
+	return "Package"
+}
+
+func (p *PackageValue) Freeze() {
+}
+
+func (p *PackageValue) Truth() starlark.Bool {
+	return true
+}
+
+func (p *PackageValue) Hash() (uint32, error) {
+	return starlark.String(p.Name).Hash()
+}
+
+// Package starlark.HasAttrs interface
+// --------------------------------------------------------------------------
+
+var _ starlark.HasAttrs = new(PackageValue)
+
+func (p *PackageValue) Attr(name string) (Value, error) {
+	switch name {
+	case "name":
+		return starlark.String(p.Name), nil
+	case "version":
+		return starlark.NewBuiltin("Package.version", p.Version), nil
+	case "arch":
+		return starlark.NewBuiltin("Package.arch", p.Arch), nil
+	}
+	return nil, nil
+}
+
+func (p *PackageValue) AttrNames() []string {
+	return []string{"name", "version", "arch"}
+}
+
+// Package methods
+// --------------------------------------------------------------------------
+
+// info returns the information of the package named in args, which defaults
+// to the package of the running script.
+func (p *PackageValue) info(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (*archive.PackageInfo, error) {
+	name := starlark.String(p.Name)
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name?", &name)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := p.Packages[name.GoString()]
+	if !ok {
+		return nil, fmt.Errorf("package %q is not selected", name.GoString())
+	}
+	return info, nil
+}
+
+func (p *PackageValue) Version(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
+	info, err := p.info(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(info.Version), nil
+}
+
+func (p *PackageValue) Arch(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (Value, error) {
+	info, err := p.info(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(info.Arch), nil
+}
@@ -8,6 +8,7 @@ import (
 
 	. "gopkg.in/check.v1"
 
+	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/scripts"
 	"github.com/canonical/chisel/internal/testutil"
@@ -87,6 +88,57 @@ var scriptsTests = []scriptsTest{{
 		"/bar/":          "dir 0755",
 		"/bar/file3.txt": "file 0644 5b41362b",
 	},
+}, {
+	summary: "Glob paths across directories",
+	content: map[string]string{
+		"foo/file1.conf":     ``,
+		"foo/file2.txt":      ``,
+		"foo/bar/file3.conf": ``,
+		"bar/file4.conf":     ``,
+	},
+	script: `
+		content.write("/foo/file2.txt", ",".join(content.glob("/foo/**.conf")))
+		content.write("/bar/file4.conf", ",".join(content.glob("/foo/../*/")))
+	`,
+	result: map[string]string{
+		"/foo/":               "dir 0755",
+		"/foo/bar/":           "dir 0755",
+		"/foo/bar/file3.conf": "file 0644 empty",
+		"/foo/file1.conf":     "file 0644 empty",
+		"/foo/file2.txt":      "file 0644 db22476c", // "/foo/bar/file3.conf,/foo/file1.conf"
+		"/bar/":               "dir 0755",
+		"/bar/file4.conf":     "file 0644 5e4973cb", // "/bar/,/foo/"
+	},
+}, {
+	summary: "Glob paths which are not readable",
+	content: map[string]string{
+		"foo/file1.conf": ``,
+		"foo/file2.conf": ``,
+	},
+	script: `
+		content.write("/foo/file1.conf", ",".join(content.glob("/foo/*.conf")))
+	`,
+	checkr: func(p string) error {
+		if p == "/foo/file2.conf" {
+			return fmt.Errorf("no read: %s", p)
+		}
+		return nil
+	},
+	result: map[string]string{
+		"/foo/":           "dir 0755",
+		"/foo/file1.conf": "file 0644 59909cc3", // "/foo/file1.conf"
+		"/foo/file2.conf": "file 0644 empty",
+	},
+}, {
+	summary: "Check globs",
+	content: map[string]string{
+		"foo/bar/file1.conf": ``,
+	},
+	script: `
+		content.glob("/foo/b*/*.conf")
+	`,
+	checkr: func(p string) error { return fmt.Errorf("no read: %s", p) },
+	error:  `no read: /foo/`,
 }, {
 	summary: "OnWrite is called for modified files only",
 	content: map[string]string{
@@ -313,3 +365,41 @@ func (s *S) TestContentRelative(c *C) {
 	_, err := content.RealPath("/bar", scripts.CheckNone)
 	c.Assert(err, ErrorMatches, "internal error: content defined with relative root: foo")
 }
+
+func (s *S) TestPackage(c *C) {
+	pkg := &scripts.PackageValue{
+		Name: "mypkg",
+		Packages: map[string]*archive.PackageInfo{
+			"mypkg":    {Name: "mypkg", Version: "1.0", Arch: "amd64"},
+			"otherpkg": {Name: "otherpkg", Version: "2.0-1", Arch: "all"},
+		},
+	}
+	rootDir := c.MkDir()
+	err := os.WriteFile(filepath.Join(rootDir, "version"), nil, 0644)
+	c.Assert(err, IsNil)
+	content := &scripts.ContentValue{
+		RootDir: rootDir,
+		OnWrite: func(entry *fsutil.Entry) error { return nil },
+	}
+	run := func(script string) error {
+		return scripts.Run(&scripts.RunOptions{
+			Namespace: map[string]scripts.Value{
+				"content": content,
+				"pkg":     pkg,
+			},
+			Script: script,
+		})
+	}
+
+	err = run(`content.write("/version", " ".join([pkg.name, pkg.version(), pkg.arch(), pkg.version("otherpkg"), pkg.arch(name="otherpkg")]))`)
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(filepath.Join(rootDir, "version"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "mypkg 1.0 amd64 2.0-1 all")
+
+	err = run(`pkg.version("unknown")`)
+	c.Assert(err, ErrorMatches, `package "unknown" is not selected`)
+
+	err = run(`pkg.name = "otherpkg"`)
+	c.Assert(err, ErrorMatches, `.*can't assign to .name field of Package`)
+}
@@ -0,0 +1,59 @@
+package setup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ConflictKind string
+
+const (
+	// PathConflict means that two slices have different content for the
+	// same path.
+	PathConflict ConflictKind = "path"
+	// GlobConflict means that the path of a slice matches the glob or
+	// generate path of another slice.
+	GlobConflict ConflictKind = "glob"
+)
+
+// Conflict holds the details about two slices which cannot be installed
+// together. Paths holds the conflicting path of each slice, or a single path
+// if the kind is PathConflict.
+type Conflict struct {
+	Kind   ConflictKind
+	Slices [2]SliceKey
+	Paths  []string
+}
+
+func (c *Conflict) Error() string {
+	return fmt.Sprintf("slices %s and %s conflict on %s", c.Slices[0], c.Slices[1], strings.Join(c.Paths, " and "))
+}
+
+// ConflictError is returned when reading a release with conflicting slices.
+// It holds all the conflicts found, sorted by the slices involved.
+type ConflictError struct {
+	Conflicts []*Conflict
+}
+
+func (e *ConflictError) Error() string {
+	return e.Conflicts[0].Error()
+}
+
+// conflictSet collects conflicts, ignoring duplicates.
+type conflictSet map[string]*Conflict
+
+func (cs conflictSet) add(c *Conflict) {
+	cs[c.Error()] = c
+}
+
+func (cs conflictSet) sorted() []*Conflict {
+	conflicts := make([]*Conflict, 0, len(cs))
+	for _, c := range cs {
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Error() < conflicts[j].Error()
+	})
+	return conflicts
+}
@@ -1,3 +1,8 @@
 package setup
 
 type YAMLPath = yamlPath
+
+func FakeGenerateKind(kind GenerateKind) (restore func()) {
+	RegisterGenerateKind(kind)
+	return func() { delete(generateKinds, kind) }
+}
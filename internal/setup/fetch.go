@@ -21,6 +21,11 @@ type FetchOptions struct {
 	Label    string
 	Version  string
 	CacheDir string
+
+	// FormatOverride is passed on to ReadReleaseWithOptions.
+	FormatOverride string
+	// Prefer is passed on to ReadReleaseWithOptions.
+	Prefer map[string]string
 }
 
 var bulkClient = &http.Client{
@@ -105,7 +110,10 @@ func FetchRelease(options *FetchOptions) (*Release, error) {
 		}
 	}
 
-	return ReadRelease(dirName)
+	return ReadReleaseWithOptions(dirName, &ReadOptions{
+		FormatOverride: options.FormatOverride,
+		Prefer:         options.Prefer,
+	})
 }
 
 func extractTarGz(dataReader io.Reader, targetDir string) error {
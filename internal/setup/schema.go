@@ -0,0 +1,140 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ReleaseSchema returns a JSON Schema describing the chisel.yaml format.
+func ReleaseSchema() ([]byte, error) {
+	return marshalSchema("Chisel release definition (chisel.yaml)", reflect.TypeOf(yamlRelease{}), "format", "archives")
+}
+
+// PackageSchema returns a JSON Schema describing the format of the slice
+// definition files.
+func PackageSchema() ([]byte, error) {
+	return marshalSchema("Chisel slice definitions (slices/<package>.yaml)", reflect.TypeOf(yamlPackage{}), "package")
+}
+
+// schemaOverrides holds the schema of the types which are either decoded in
+// a custom way or which can only take a few values.
+var schemaOverrides = map[reflect.Type]map[string]any{
+	reflect.TypeOf(yamlArch{}): {
+		"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	},
+	reflect.TypeOf(yamlMutate{}): {
+		"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	},
+	reflect.TypeOf(PathUntil("")): {
+		"type": "string",
+		"enum": []any{string(UntilMutate), string(UntilEnd)},
+	},
+}
+
+// generateKindSchema returns the schema of the "generate" field, listing the
+// built-in kinds followed by the registered ones in sorted order.
+func generateKindSchema() map[string]any {
+	enum := make([]any, 0, len(generateKinds))
+	for _, kind := range builtinGenerateKinds {
+		enum = append(enum, string(kind))
+	}
+	var registered []string
+	for kind := range generateKinds {
+		if !IsBuiltinGenerateKind(kind) {
+			registered = append(registered, string(kind))
+		}
+	}
+	sort.Strings(registered)
+	for _, kind := range registered {
+		enum = append(enum, kind)
+	}
+	return map[string]any{"type": "string", "enum": enum}
+}
+
+func marshalSchema(title string, t reflect.Type, required ...string) ([]byte, error) {
+	schema, err := typeSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	schema["$schema"] = schemaDialect
+	schema["title"] = title
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// typeSchema generates the JSON Schema for values of type t as they are
+// decoded from YAML, according to the yaml struct tags.
+func typeSchema(t reflect.Type) (map[string]any, error) {
+	if t == reflect.TypeOf(GenerateKind("")) {
+		return generateKindSchema(), nil
+	}
+	if override, ok := schemaOverrides[t]; ok {
+		schema := make(map[string]any, len(override))
+		for k, v := range override {
+			schema[k] = v
+		}
+		return schema, nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		// Pointers are used for values which may be unset.
+		elem, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"oneOf": []any{map[string]any{"type": "null"}, elem}}, nil
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				return nil, fmt.Errorf("internal error: field %s.%s has no yaml name", t.Name(), field.Name)
+			}
+			fieldSchema, err := typeSchema(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			properties[name] = fieldSchema
+		}
+		return map[string]any{"type": "object", "properties": properties}, nil
+	case reflect.Map:
+		elem, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": elem}, nil
+	case reflect.Slice:
+		elem, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": elem}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	}
+	return nil, fmt.Errorf("internal error: cannot generate schema for type %s", t)
+}
@@ -0,0 +1,101 @@
+package setup_test
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func (s *S) TestReleaseSchema(c *C) {
+	data, err := setup.ReleaseSchema()
+	c.Assert(err, IsNil)
+
+	var schema map[string]any
+	err = json.Unmarshal(data, &schema)
+	c.Assert(err, IsNil)
+	c.Assert(schema["$schema"], Equals, "https://json-schema.org/draft/2020-12/schema")
+	c.Assert(schema["required"], DeepEquals, []any{"format", "archives"})
+
+	properties := schema["properties"].(map[string]any)
+	c.Assert(properties["format"], DeepEquals, map[string]any{"type": "string"})
+	for _, name := range []string{"archives", "v2-archives"} {
+		archives := properties[name].(map[string]any)
+		c.Assert(archives["type"], Equals, "object")
+		archive := archives["additionalProperties"].(map[string]any)["properties"].(map[string]any)
+		c.Assert(archive["priority"], DeepEquals, map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "null"},
+				map[string]any{"type": "integer"},
+			},
+		})
+		c.Assert(archive["suites"], DeepEquals, map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string"},
+		})
+		c.Assert(archive["default"], DeepEquals, map[string]any{"type": "boolean"})
+	}
+	pubKey := properties["public-keys"].(map[string]any)["additionalProperties"].(map[string]any)
+	c.Assert(pubKey["properties"], DeepEquals, map[string]any{
+		"id":    map[string]any{"type": "string"},
+		"armor": map[string]any{"type": "string"},
+	})
+}
+
+func (s *S) TestPackageSchema(c *C) {
+	data, err := setup.PackageSchema()
+	c.Assert(err, IsNil)
+
+	var schema map[string]any
+	err = json.Unmarshal(data, &schema)
+	c.Assert(err, IsNil)
+	c.Assert(schema["required"], DeepEquals, []any{"package"})
+
+	properties := schema["properties"].(map[string]any)
+	slice := properties["slices"].(map[string]any)["additionalProperties"].(map[string]any)["properties"].(map[string]any)
+	c.Assert(slice["mutate"], DeepEquals, map[string]any{
+		"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	})
+	c.Assert(slice["include"], DeepEquals, map[string]any{
+		"type":  "array",
+		"items": map[string]any{"type": "string"},
+	})
+
+	contents := slice["contents"].(map[string]any)["additionalProperties"].(map[string]any)
+	options := contents["oneOf"].([]any)
+	c.Assert(options[0], DeepEquals, map[string]any{"type": "null"})
+
+	path := options[1].(map[string]any)["properties"].(map[string]any)
+	c.Assert(path["mode"], DeepEquals, map[string]any{"type": "integer", "minimum": float64(0)})
+	c.Assert(path["until"], DeepEquals, map[string]any{"type": "string", "enum": []any{"mutate", "end"}})
+	c.Assert(path["generate"], DeepEquals, map[string]any{"type": "string", "enum": []any{"manifest", "filelist", "package-files"}})
+	c.Assert(path["arch"], DeepEquals, map[string]any{
+		"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	})
+	c.Assert(path["make"], DeepEquals, map[string]any{"type": "boolean"})
+}
+
+func (s *S) TestPackageSchemaRegisteredGenerateKind(c *C) {
+	restore := setup.FakeGenerateKind("custom")
+	defer restore()
+
+	data, err := setup.PackageSchema()
+	c.Assert(err, IsNil)
+
+	var schema map[string]any
+	err = json.Unmarshal(data, &schema)
+	c.Assert(err, IsNil)
+
+	properties := schema["properties"].(map[string]any)
+	slice := properties["slices"].(map[string]any)["additionalProperties"].(map[string]any)["properties"].(map[string]any)
+	contents := slice["contents"].(map[string]any)["additionalProperties"].(map[string]any)
+	path := contents["oneOf"].([]any)[1].(map[string]any)["properties"].(map[string]any)
+	c.Assert(path["generate"], DeepEquals, map[string]any{"type": "string", "enum": []any{"manifest", "filelist", "package-files", "custom"}})
+}
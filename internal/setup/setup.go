@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
 
 	"github.com/canonical/chisel/internal/apacheutil"
+	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/strdist"
 )
 
@@ -18,6 +22,24 @@ type Release struct {
 	Path     string
 	Packages map[string]*Package
 	Archives map[string]*Archive
+
+	// AllowEqualPriorities permits archives to share the same priority.
+	// Ties are broken by the archive name, in alphabetical order.
+	AllowEqualPriorities bool
+
+	// Prefers holds the preferences from ReadOptions.Prefer which resolved
+	// a path listed in the slices of several packages, indexed by path.
+	Prefers map[string]*Prefer
+}
+
+// Prefer records the package preferred to provide a path listed in the
+// slices of several packages.
+type Prefer struct {
+	Path    string
+	Package string
+	// Candidates holds the sorted names of all the packages which listed
+	// the path, including Package.
+	Candidates []string
 }
 
 // Archive is the location from which binary packages are obtained.
@@ -29,6 +51,13 @@ type Archive struct {
 	Priority   int
 	Pro        string
 	PubKeys    []*packet.PublicKey
+	// KeyExpiry holds when the PubKeys expire, indexed by key ID, for the
+	// ones which do.
+	KeyExpiry map[uint64]time.Time
+
+	// ReleaseLabel is the Label expected in the InRelease file of the
+	// archive. If empty, the label of Ubuntu archives is expected.
+	ReleaseLabel string
 }
 
 // Package holds a collection of slices that represent parts of themselves.
@@ -44,12 +73,18 @@ type Slice struct {
 	Package   string
 	Name      string
 	Essential []SliceKey
-	Contents  map[string]PathInfo
-	Scripts   SliceScripts
+	// MutateAfter lists the slices whose mutation scripts must run before
+	// the ones of this slice when they are selected as well. Unlike
+	// Essential, it does not cause them to be selected.
+	MutateAfter []SliceKey
+	Contents    map[string]PathInfo
+	Scripts     SliceScripts
 }
 
 type SliceScripts struct {
-	Mutate string
+	// Mutate holds the phases of the mutation script, which are run in
+	// order, each one seeing the content written by the previous ones.
+	Mutate []string
 }
 
 type PathKind string
@@ -61,6 +96,7 @@ const (
 	TextPath     PathKind = "text"
 	SymlinkPath  PathKind = "symlink"
 	GeneratePath PathKind = "generate"
+	HardLinkPath PathKind = "hardlink"
 
 	// TODO Maybe in the future, for binary support.
 	//Base64Path PathKind = "base64"
@@ -71,19 +107,66 @@ type PathUntil string
 const (
 	UntilNone   PathUntil = ""
 	UntilMutate PathUntil = "mutate"
+	UntilEnd    PathUntil = "end"
 )
 
+// untilConflict returns whether two slices remove the same path at different
+// phases. Not removing the path at all never conflicts, as it always wins.
+func untilConflict(a, b PathUntil) bool {
+	return a != UntilNone && b != UntilNone && a != b
+}
+
 type GenerateKind string
 
 const (
-	GenerateNone     GenerateKind = ""
-	GenerateManifest GenerateKind = "manifest"
+	GenerateNone         GenerateKind = ""
+	GenerateManifest     GenerateKind = "manifest"
+	GenerateFileList     GenerateKind = "filelist"
+	GeneratePackageFiles GenerateKind = "package-files"
 )
 
+// builtinGenerateKinds holds the kinds implemented by chisel itself, in the
+// order they are documented.
+var builtinGenerateKinds = []GenerateKind{
+	GenerateManifest,
+	GenerateFileList,
+	GeneratePackageFiles,
+}
+
+// generateKinds holds the kinds accepted in the "generate" field of the
+// selected slices.
+var generateKinds = make(map[GenerateKind]bool)
+
+func init() {
+	for _, kind := range builtinGenerateKinds {
+		generateKinds[kind] = true
+	}
+}
+
+// RegisterGenerateKind makes kind acceptable in the "generate" field of the
+// selected slices. It must be called before any selection is made, and is
+// meant for the packages which implement the generation of such content.
+func RegisterGenerateKind(kind GenerateKind) {
+	generateKinds[kind] = true
+}
+
+// IsBuiltinGenerateKind returns whether kind is implemented by chisel
+// itself, including GenerateNone, and thus cannot be registered.
+func IsBuiltinGenerateKind(kind GenerateKind) bool {
+	return kind == GenerateNone || slices.Contains(builtinGenerateKinds, kind)
+}
+
 type PathInfo struct {
 	Kind PathKind
 	Info string
 	Mode uint
+	// ParentMode, when set, is the mode of the missing parent directories
+	// created for the path which are not provided by the package.
+	ParentMode uint
+	// UID and GID, when set, are the owner user and group of the path.
+	// Otherwise the path is owned by the user running chisel.
+	UID *int
+	GID *int
 
 	Mutable  bool
 	Until    PathUntil
@@ -91,6 +174,12 @@ type PathInfo struct {
 	Generate GenerateKind
 }
 
+// IsDirCopy returns whether the path copies a directory from a different
+// location in the package along with all the content under it.
+func (pi *PathInfo) IsDirCopy() bool {
+	return pi.Kind == CopyPath && strings.HasSuffix(pi.Info, "/")
+}
+
 // SameContent returns whether the path has the same content properties as some
 // other path. In other words, the resulting file/dir entry is the same. The
 // Mutable flag must also match, as that's a common agreement that the actual
@@ -99,10 +188,21 @@ func (pi *PathInfo) SameContent(other *PathInfo) bool {
 	return (pi.Kind == other.Kind &&
 		pi.Info == other.Info &&
 		pi.Mode == other.Mode &&
+		pi.ParentMode == other.ParentMode &&
+		sameID(pi.UID, other.UID) &&
+		sameID(pi.GID, other.GID) &&
 		pi.Mutable == other.Mutable &&
 		pi.Generate == other.Generate)
 }
 
+// sameID returns whether the optional user or group IDs are the same.
+func sameID(id1, id2 *int) bool {
+	if id1 == nil || id2 == nil {
+		return id1 == id2
+	}
+	return *id1 == *id2
+}
+
 type SliceKey = apacheutil.SliceKey
 
 func ParseSliceKey(sliceKey string) (SliceKey, error) {
@@ -120,7 +220,64 @@ type Selection struct {
 	Slices  []*Slice
 }
 
+// Packages returns the sorted names of the packages with selected slices,
+// which are all the packages needed to build the selection.
+func (s *Selection) Packages() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, slice := range s.Slices {
+		if !seen[slice.Package] {
+			seen[slice.Package] = true
+			names = append(names, slice.Package)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prefers returns the preferences of the release which resolved the paths
+// listed in the selected slices, ordered by path.
+func (s *Selection) Prefers() []*Prefer {
+	var prefers []*Prefer
+	for path, prefer := range s.Release.Prefers {
+		for _, slice := range s.Slices {
+			if _, ok := slice.Contents[path]; ok {
+				prefers = append(prefers, prefer)
+				break
+			}
+		}
+	}
+	sort.Slice(prefers, func(i, j int) bool {
+		return prefers[i].Path < prefers[j].Path
+	})
+	return prefers
+}
+
+// ReadOptions holds optional settings for reading a release.
+type ReadOptions struct {
+	// FormatOverride, when set, makes the release be parsed as if its
+	// format field had this value instead.
+	FormatOverride string
+	// Prefer maps paths to the package which provides them when the slices
+	// of several packages list them. The paths are dropped from the slices
+	// of the other packages before the release is validated.
+	Prefer map[string]string
+}
+
 func ReadRelease(dir string) (*Release, error) {
+	return ReadReleaseWithOptions(dir, nil)
+}
+
+// ReadReleaseWithOptions is like ReadRelease but accepts options which change
+// the way the release is read. The options may be nil.
+func ReadReleaseWithOptions(dir string, options *ReadOptions) (*Release, error) {
+	if options == nil {
+		options = &ReadOptions{}
+	}
+	if options.FormatOverride != "" && !knownFormat(options.FormatOverride) {
+		return nil, fmt.Errorf("invalid format override: unknown format %q", options.FormatOverride)
+	}
+
 	logDir := dir
 	if strings.Contains(dir, "/.cache/") {
 		logDir = filepath.Base(dir)
@@ -132,7 +289,12 @@ func ReadRelease(dir string) (*Release, error) {
 		Packages: make(map[string]*Package),
 	}
 
-	release, err := readRelease(dir)
+	release, err := readRelease(dir, options.FormatOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	err = release.applyPrefer(options.Prefer)
 	if err != nil {
 		return nil, err
 	}
@@ -144,9 +306,135 @@ func ReadRelease(dir string) (*Release, error) {
 	return release, nil
 }
 
+// CompareArchives orders archives from the highest priority to the lowest,
+// and by name when the priorities are the same. It is suitable for use with
+// slices.SortFunc.
+func CompareArchives(a, b *Archive) int {
+	if a.Priority != b.Priority {
+		return b.Priority - a.Priority
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// applyPrefer drops every path in prefer from the slices of the packages
+// other than the one preferred for it.
+func (r *Release) applyPrefer(prefer map[string]string) error {
+	for path, pkgName := range prefer {
+		pkg, ok := r.Packages[pkgName]
+		if !ok {
+			return fmt.Errorf("cannot prefer package %q for path %s: package not found", pkgName, path)
+		}
+		found := false
+		for _, slice := range pkg.Slices {
+			if _, ok := slice.Contents[path]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot prefer package %q for path %s: path not in its slices", pkgName, path)
+		}
+		candidates := []string{pkgName}
+		for _, other := range r.Packages {
+			if other.Name == pkgName {
+				continue
+			}
+			listed := false
+			for _, slice := range other.Slices {
+				if _, ok := slice.Contents[path]; ok {
+					listed = true
+					delete(slice.Contents, path)
+				}
+			}
+			if listed {
+				candidates = append(candidates, other.Name)
+			}
+		}
+		if len(candidates) > 1 {
+			sort.Strings(candidates)
+			if r.Prefers == nil {
+				r.Prefers = make(map[string]*Prefer)
+			}
+			r.Prefers[path] = &Prefer{
+				Path:       path,
+				Package:    pkgName,
+				Candidates: candidates,
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the release as ReadRelease does, including for conflicts
+// between the slices of different packages. It is meant for releases which
+// had slices added after being read.
+func (r *Release) Validate() error {
+	return r.validate()
+}
+
 func (r *Release) validate() error {
 	keys := []SliceKey(nil)
+	usesVars := false
+	for _, pkg := range r.Packages {
+		for _, slice := range pkg.Slices {
+			keys = append(keys, SliceKey{pkg.Name, slice.Name})
+			usesVars = usesVars || slice.usesVars()
+		}
+	}
+
+	// Paths with variables may only conflict once resolved, so they are
+	// checked as resolved for every architecture.
+	conflicts := conflictSet{}
+	if usesVars {
+		for _, arch := range deb.KnownArchs() {
+			pkgs, err := expandPackages(r.Packages, arch)
+			if err != nil {
+				return err
+			}
+			addConflicts(conflicts, pkgs)
+		}
+	} else {
+		addConflicts(conflicts, r.Packages)
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts.sorted()}
+	}
 
+	// Check for cycles.
+	_, err := order(r.Packages, keys)
+	if err != nil {
+		return err
+	}
+
+	// Check for archive priority conflicts.
+	if !r.AllowEqualPriorities {
+		priorities := make(map[int]*Archive)
+		for _, archive := range r.Archives {
+			if old, ok := priorities[archive.Priority]; ok {
+				if old.Name > archive.Name {
+					archive, old = old, archive
+				}
+				return fmt.Errorf("chisel.yaml: archives %q and %q have the same priority value of %d", old.Name, archive.Name, archive.Priority)
+			}
+			priorities[archive.Priority] = archive
+		}
+	}
+
+	// Check that archives pinned in packages are defined.
+	for _, pkg := range r.Packages {
+		if pkg.Archive == "" {
+			continue
+		}
+		if _, ok := r.Archives[pkg.Archive]; !ok {
+			return fmt.Errorf("%s: package refers to undefined archive %q", pkg.Path, pkg.Archive)
+		}
+	}
+
+	return nil
+}
+
+// addConflicts adds to conflicts those found between the slices of pkgs.
+func addConflicts(conflicts conflictSet, pkgs map[string]*Package) {
 	// Check for info conflicts and prepare for following checks. A conflict
 	// means that two slices attempt to extract different files or directories
 	// to the same location.
@@ -160,17 +448,22 @@ func (r *Release) validate() error {
 	// cannot validate that they are the same without downloading the package.
 	paths := make(map[string]*Slice)
 	globs := make(map[string]*Slice)
-	for _, pkg := range r.Packages {
+	for _, pkg := range pkgs {
 		for _, new := range pkg.Slices {
-			keys = append(keys, SliceKey{pkg.Name, new.Name})
 			for newPath, newInfo := range new.Contents {
 				if old, ok := paths[newPath]; ok {
 					oldInfo := old.Contents[newPath]
-					if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
+					if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package ||
+						untilConflict(newInfo.Until, oldInfo.Until) {
+						new := new
 						if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
 							old, new = new, old
 						}
-						return fmt.Errorf("slices %s and %s conflict on %s", old, new, newPath)
+						conflicts.add(&Conflict{
+							Kind:   PathConflict,
+							Slices: [2]SliceKey{{old.Package, old.Name}, {new.Package, new.Name}},
+							Paths:  []string{newPath},
+						})
 					}
 					// Note: Because for conflict resolution we only check that
 					// the created file would be the same and we know newInfo and
@@ -178,7 +471,7 @@ func (r *Release) validate() error {
 					// newInfo.
 				} else {
 					paths[newPath] = new
-					if newInfo.Kind == GeneratePath || newInfo.Kind == GlobPath {
+					if newInfo.Kind == GeneratePath || newInfo.Kind == GlobPath || newInfo.IsDirCopy() {
 						globs[newPath] = new
 					}
 				}
@@ -186,9 +479,14 @@ func (r *Release) validate() error {
 		}
 	}
 
-	// Check for glob and generate conflicts.
+	// Check for glob and generate conflicts. Directory copies are checked as
+	// globs matching all of the content they create.
 	for oldPath, old := range globs {
 		oldInfo := old.Contents[oldPath]
+		oldPattern := oldPath
+		if oldInfo.IsDirCopy() {
+			oldPattern += "**"
+		}
 		for newPath, new := range paths {
 			if oldPath == newPath {
 				// Identical paths have been filtered earlier. This must be the
@@ -201,46 +499,25 @@ func (r *Release) validate() error {
 					continue
 				}
 			}
-			if strdist.GlobPath(newPath, oldPath) {
+			newPattern := newPath
+			if newInfo.IsDirCopy() {
+				newPattern += "**"
+			}
+			if strdist.GlobPath(newPattern, oldPattern) {
+				old, oldPath := old, oldPath
 				if (old.Package > new.Package) || (old.Package == new.Package && old.Name > new.Name) ||
 					(old.Package == new.Package && old.Name == new.Name && oldPath > newPath) {
 					old, new = new, old
 					oldPath, newPath = newPath, oldPath
 				}
-				return fmt.Errorf("slices %s and %s conflict on %s and %s", old, new, oldPath, newPath)
-			}
-		}
-	}
-
-	// Check for cycles.
-	_, err := order(r.Packages, keys)
-	if err != nil {
-		return err
-	}
-
-	// Check for archive priority conflicts.
-	priorities := make(map[int]*Archive)
-	for _, archive := range r.Archives {
-		if old, ok := priorities[archive.Priority]; ok {
-			if old.Name > archive.Name {
-				archive, old = old, archive
+				conflicts.add(&Conflict{
+					Kind:   GlobConflict,
+					Slices: [2]SliceKey{{old.Package, old.Name}, {new.Package, new.Name}},
+					Paths:  []string{oldPath, newPath},
+				})
 			}
-			return fmt.Errorf("chisel.yaml: archives %q and %q have the same priority value of %d", old.Name, archive.Name, archive.Priority)
 		}
-		priorities[archive.Priority] = archive
 	}
-
-	// Check that archives pinned in packages are defined.
-	for _, pkg := range r.Packages {
-		if pkg.Archive == "" {
-			continue
-		}
-		if _, ok := r.Archives[pkg.Archive]; !ok {
-			return fmt.Errorf("%s: package refers to undefined archive %q", pkg.Path, pkg.Archive)
-		}
-	}
-
-	return nil
 }
 
 func order(pkgs map[string]*Package, keys []SliceKey) ([]SliceKey, error) {
@@ -281,27 +558,56 @@ func order(pkgs map[string]*Package, keys []SliceKey) ([]SliceKey, error) {
 	}
 
 	// Sort them up.
+	order, err := sortSlices(successors, "essential loop detected")
+	if err != nil {
+		return nil, err
+	}
+
+	// Slices are also sorted after the selected slices they mutate after.
+	hasMutateAfter := false
+	for _, key := range order {
+		slice := pkgs[key.Package].Slices[key.Slice]
+		fqslice := slice.String()
+		for _, after := range slice.MutateAfter {
+			if afterPkg, ok := pkgs[after.Package]; !ok || afterPkg.Slices[after.Slice] == nil {
+				return nil, fmt.Errorf("%s mutates after %s, but slice is missing", fqslice, after)
+			}
+			if !seen[after] {
+				continue
+			}
+			successors[fqslice] = append(successors[fqslice], after.String())
+			hasMutateAfter = true
+		}
+	}
+	if !hasMutateAfter {
+		return order, nil
+	}
+	return sortSlices(successors, "mutate-after loop detected")
+}
+
+// sortSlices returns the slices in successors sorted after their
+// predecessors, failing with the message given if there is a loop.
+func sortSlices(successors map[string][]string, loopMessage string) ([]SliceKey, error) {
 	var order []SliceKey
 	for _, names := range tarjanSort(successors) {
 		if len(names) > 1 {
-			return nil, fmt.Errorf("essential loop detected: %s", strings.Join(names, ", "))
+			return nil, fmt.Errorf("%s: %s", loopMessage, strings.Join(names, ", "))
 		}
 		name := names[0]
 		dot := strings.IndexByte(name, '_')
 		order = append(order, SliceKey{name[:dot], name[dot+1:]})
 	}
-
 	return order, nil
 }
 
-func readRelease(baseDir string) (*Release, error) {
+func readRelease(baseDir, formatOverride string) (*Release, error) {
 	baseDir = filepath.Clean(baseDir)
 	filePath := filepath.Join(baseDir, "chisel.yaml")
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read release definition: %s", err)
 	}
-	release, err := parseRelease(baseDir, filePath, data)
+	release, err := parseRelease(baseDir, filePath, data, formatOverride)
 	if err != nil {
 		return nil, err
 	}
@@ -360,7 +666,33 @@ func stripBase(baseDir, path string) string {
 	return strings.TrimPrefix(path, baseDir+string(filepath.Separator))
 }
 
+// SelectOptions holds optional settings for selecting slices.
+type SelectOptions struct {
+	// Arch is the package architecture targeted, which resolves the
+	// variables in the contents of the selected slices. Without it, the
+	// variables are left unresolved.
+	Arch string
+}
+
 func Select(release *Release, slices []SliceKey) (*Selection, error) {
+	return SelectWithOptions(release, slices, nil)
+}
+
+// SelectWithOptions is like Select but accepts options which change the way
+// the slices are selected. The options may be nil.
+func SelectWithOptions(release *Release, slices []SliceKey, options *SelectOptions) (*Selection, error) {
+	if options == nil {
+		options = &SelectOptions{}
+	}
+	var vars map[string]string
+	if options.Arch != "" {
+		var err error
+		vars, err = archVars(options.Arch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	logf("Selecting slices...")
 
 	selection := &Selection{
@@ -373,7 +705,14 @@ func Select(release *Release, slices []SliceKey) (*Selection, error) {
 	}
 	selection.Slices = make([]*Slice, len(sorted))
 	for i, key := range sorted {
-		selection.Slices[i] = release.Packages[key.Package].Slices[key.Slice]
+		slice := release.Packages[key.Package].Slices[key.Slice]
+		if vars != nil && slice.usesVars() {
+			slice, err = slice.expandVars(vars)
+			if err != nil {
+				return nil, err
+			}
+		}
+		selection.Slices[i] = slice
 	}
 
 	paths := make(map[string]*Slice)
@@ -381,7 +720,8 @@ func Select(release *Release, slices []SliceKey) (*Selection, error) {
 		for newPath, newInfo := range new.Contents {
 			if old, ok := paths[newPath]; ok {
 				oldInfo := old.Contents[newPath]
-				if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
+				if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package ||
+					untilConflict(newInfo.Until, oldInfo.Until) {
 					if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
 						old, new = new, old
 					}
@@ -392,14 +732,60 @@ func Select(release *Release, slices []SliceKey) (*Selection, error) {
 			}
 			// An invalid "generate" value should only throw an error if that
 			// particular slice is selected. Hence, the check is here.
-			switch newInfo.Generate {
-			case GenerateNone, GenerateManifest:
-			default:
+			if newInfo.Generate != GenerateNone && !generateKinds[newInfo.Generate] {
 				return nil, fmt.Errorf("slice %s has invalid 'generate' for path %s: %q",
 					new, newPath, newInfo.Generate)
 			}
 		}
 	}
 
+	// Hard links can only be validated once all the selected paths are known,
+	// as their targets may come from any of the selected slices.
+	for _, slice := range selection.Slices {
+		for linkPath, linkInfo := range slice.Contents {
+			if linkInfo.Kind != HardLinkPath {
+				continue
+			}
+			err := validateHardLink(paths, slice, linkPath, &linkInfo)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return selection, nil
 }
+
+// validateHardLink checks that the target of the hard link at linkPath is a
+// regular file provided by a selected slice of the same package, for all the
+// architectures where the hard link is created.
+func validateHardLink(paths map[string]*Slice, slice *Slice, linkPath string, linkInfo *PathInfo) error {
+	targetPath := linkInfo.Info
+	target, ok := paths[targetPath]
+	if !ok || target.Package != slice.Package {
+		return fmt.Errorf("slice %s has invalid hardlink for path %s: %s is not in the selected slices of package %s",
+			slice, linkPath, targetPath, slice.Package)
+	}
+	targetInfo := target.Contents[targetPath]
+	if targetInfo.Kind != CopyPath && targetInfo.Kind != TextPath {
+		return fmt.Errorf("slice %s has invalid hardlink for path %s: %s is not a regular file",
+			slice, linkPath, targetPath)
+	}
+	if targetInfo.Mutable || targetInfo.Until != UntilNone {
+		return fmt.Errorf("slice %s has invalid hardlink for path %s: %s is mutable or removed with 'until'",
+			slice, linkPath, targetPath)
+	}
+	if len(targetInfo.Arch) > 0 {
+		if len(linkInfo.Arch) == 0 {
+			return fmt.Errorf("slice %s has invalid hardlink for path %s: %s is not available on all architectures",
+				slice, linkPath, targetPath)
+		}
+		for _, arch := range linkInfo.Arch {
+			if !slices.Contains(targetInfo.Arch, arch) {
+				return fmt.Errorf("slice %s has invalid hardlink for path %s: %s is not available on %s",
+					slice, linkPath, targetPath, arch)
+			}
+		}
+	}
+	return nil
+}
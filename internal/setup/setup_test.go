@@ -3,7 +3,9 @@ package setup_test
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
 	. "gopkg.in/check.v1"
@@ -24,6 +26,7 @@ type setupTest struct {
 	release   *setup.Release
 	relerror  string
 	selslices []setup.SliceKey
+	selarch   string
 	selection *setup.Selection
 	selerror  string
 }
@@ -154,7 +157,7 @@ var setupTests = []setupTest{{
 						Package: "mypkg",
 						Name:    "myslice3",
 						Scripts: setup.SliceScripts{
-							Mutate: "something",
+							Mutate: []string{"something"},
 						},
 					},
 				},
@@ -319,6 +322,116 @@ var setupTests = []setupTest{{
 			},
 		}},
 	},
+}, {
+	summary: "Selection ordered by mutate-after",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1: {mutate-after: [mypkg2_myslice1]}
+				myslice2: {mutate-after: [mypkg3_myslice1]}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1: {}
+		`,
+		"slices/mydir/mypkg3.yaml": `
+			package: mypkg3
+			slices:
+				myslice1: {}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}, {"mypkg1", "myslice2"}, {"mypkg2", "myslice1"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg2",
+			Name:    "myslice1",
+		}, {
+			Package: "mypkg1",
+			Name:    "myslice1",
+			MutateAfter: []setup.SliceKey{
+				{"mypkg2", "myslice1"},
+			},
+		}, {
+			Package: "mypkg1",
+			Name:    "myslice2",
+			MutateAfter: []setup.SliceKey{
+				{"mypkg3", "myslice1"},
+			},
+		}},
+	},
+}, {
+	summary: "Cycles are detected in mutate-after",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice: {mutate-after: [mypkg2_myslice]}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice: {mutate-after: [mypkg1_myslice]}
+		`,
+	},
+	relerror: `mutate-after loop detected: mypkg1_myslice, mypkg2_myslice`,
+}, {
+	summary: "Cycles are detected across mutate-after and essential",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice: {essential: [mypkg2_myslice]}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice: {mutate-after: [mypkg1_myslice]}
+		`,
+	},
+	relerror: `mutate-after loop detected: mypkg1_myslice, mypkg2_myslice`,
+}, {
+	summary: "Missing mutate-after slice",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice: {mutate-after: [mypkg2_myslice]}
+		`,
+	},
+	relerror: `mypkg1_myslice mutates after mypkg2_myslice, but slice is missing`,
+}, {
+	summary: "Invalid mutate-after slice reference",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice: {mutate-after: [mypkg-myslice]}
+		`,
+	},
+	relerror: `package "mypkg" has invalid mutate-after slice reference: "mypkg-myslice"`,
+}, {
+	summary: "Slices cannot mutate after themselves",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice: {mutate-after: [mypkg_myslice]}
+		`,
+	},
+	relerror: `cannot mutate slice after itself "mypkg_myslice" in slices/mydir/mypkg.yaml`,
+}, {
+	summary: "Redundant mutate-after slice",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1: {}
+				myslice2: {mutate-after: [mypkg_myslice1, mypkg_myslice1]}
+		`,
+	},
+	relerror: `slice mypkg_myslice2 defined with redundant mutate-after slice: mypkg_myslice1`,
 }, {
 	summary: "Selection with matching paths don't conflict",
 	input: map[string]string{
@@ -381,53 +494,127 @@ var setupTests = []setupTest{{
 	},
 	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
 }, {
-	summary: "Directories must be suffixed with /",
+	summary: "Slices include shared fragments",
 	input: map[string]string{
+		"includes/common.yaml": `
+			contents:
+				/usr/share/doc/common/copyright:
+				/etc/common.conf: {text: common, mutable: true}
+			mutate: common()
+		`,
+		"includes/extra.yaml": `
+			contents:
+				/etc/extra.conf: {text: extra}
+		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
-				myslice:
+				myslice1:
+					include:
+						- includes/common.yaml
+						- includes/extra.yaml
 					contents:
-						/foo: {make: true}
+						/usr/bin/foo:
+						/usr/share/doc/common/copyright:
+					mutate: |
+						foo()
+				myslice2:
+					include: [includes/extra.yaml]
 		`,
 	},
-	relerror: `slice mypkg_myslice path /foo must end in / for 'make' to be valid`,
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/foo":                    {Kind: "copy"},
+							"/usr/share/doc/common/copyright": {Kind: "copy"},
+							"/etc/common.conf":                {Kind: "text", Info: "common", Mutable: true},
+							"/etc/extra.conf":                 {Kind: "text", Info: "extra"},
+						},
+						Scripts: setup.SliceScripts{
+							Mutate: []string{"common()\nfoo()\n"},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Contents: map[string]setup.PathInfo{
+							"/etc/extra.conf": {Kind: "text", Info: "extra"},
+						},
+					},
+				},
+			},
+		},
+	},
 }, {
-	summary: "Slice path must be clean",
+	summary: "Included fragments cannot conflict with the slice",
 	input: map[string]string{
+		"includes/common.yaml": `
+			contents:
+				/path1: {text: common}
+		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
+					include: [includes/common.yaml]
 					contents:
-						/foo/../:
+						/path1: {text: other}
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+	relerror: `slice mypkg_myslice path /path1 defined differently in slices/mydir/mypkg.yaml and includes/common.yaml`,
 }, {
-	summary: "Slice path must be absolute",
+	summary: "Included fragments cannot conflict with each other",
 	input: map[string]string{
+		"includes/one.yaml": `
+			contents:
+				/path1: {copy: /one}
+		`,
+		"includes/two.yaml": `
+			contents:
+				/path1: {copy: /two}
+		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
-					contents:
-						./foo/:
+					include: [includes/one.yaml, includes/two.yaml]
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid content path: ./foo/`,
+	relerror: `slice mypkg_myslice path /path1 defined differently in includes/one.yaml and includes/two.yaml`,
 }, {
-	summary: "Globbing support",
+	summary: "Mutate scripts with several phases",
 	input: map[string]string{
+		"includes/common.yaml": `
+			mutate: common()
+		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice1:
-					contents:
-						/file/*:
+					mutate:
+						- one()
+						- two()
 				myslice2:
-					contents:
-						/another/**:
+					include: [includes/common.yaml]
+					mutate: [one(), two()]
+				myslice3:
+					mutate: [one()]
 		`,
 	},
 	release: &setup.Release{
@@ -448,15 +635,22 @@ var setupTests = []setupTest{{
 					"myslice1": {
 						Package: "mypkg",
 						Name:    "myslice1",
-						Contents: map[string]setup.PathInfo{
-							"/file/*": {Kind: "glob"},
+						Scripts: setup.SliceScripts{
+							Mutate: []string{"one()", "two()"},
 						},
 					},
 					"myslice2": {
 						Package: "mypkg",
 						Name:    "myslice2",
-						Contents: map[string]setup.PathInfo{
-							"/another/**": {Kind: "glob"},
+						Scripts: setup.SliceScripts{
+							Mutate: []string{"common()\none()", "two()"},
+						},
+					},
+					"myslice3": {
+						Package: "mypkg",
+						Name:    "myslice3",
+						Scripts: setup.SliceScripts{
+							Mutate: []string{"one()"},
 						},
 					},
 				},
@@ -464,173 +658,1149 @@ var setupTests = []setupTest{{
 		},
 	},
 }, {
-	summary: "Conflicting globs",
+	summary: "Mutate phases cannot be empty",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
-			slices:
-				myslice:
-					contents:
-						/file/f*obar:
-		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
 				myslice:
-					contents:
-						/file/foob*r:
+					mutate: [one(), ""]
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/f\*obar and /file/foob\*r`,
+	relerror: `slice mypkg_myslice has empty mutate phase 2`,
 }, {
-	summary: "Conflicting globs and plain copies",
+	summary: "Included paths conflict across packages",
 	input: map[string]string{
+		"includes/common.yaml": `
+			contents:
+				/path1: {text: common}
+		`,
 		"slices/mydir/mypkg1.yaml": `
 			package: mypkg1
 			slices:
-				myslice:
-					contents:
-						/file/foobar:
+				myslice1:
+					include: [includes/common.yaml]
 		`,
 		"slices/mydir/mypkg2.yaml": `
 			package: mypkg2
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file/foob*r:
+						/path1: {text: other}
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
+	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
 }, {
-	summary: "Conflicting matching globs",
+	summary: "Included paths are validated",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"includes/common.yaml": `
+			contents:
+				/foo/../:
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
 				myslice:
-					contents:
-						/file/foob*r:
+					include: [includes/common.yaml]
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+	},
+	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+}, {
+	summary: "Variables in paths are resolved for amd64",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/file/foob*r:
+						/usr/lib/${ARCH_TRIPLET}/libfoo.so.1:
+						/usr/lib/${ARCH_TRIPLET}/libfoo.so: {symlink: "/usr/lib/${ARCH_TRIPLET}/libfoo.so.1"}
+						/usr/share/foo/${ARCH}/: {make: true}
+						/usr/lib/${ARCH_TRIPLET}/foo/*.so:
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foob\*r`,
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selarch:   "amd64",
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/usr/lib/x86_64-linux-gnu/libfoo.so.1": {Kind: "copy"},
+				"/usr/lib/x86_64-linux-gnu/libfoo.so":   {Kind: "symlink", Info: "/usr/lib/x86_64-linux-gnu/libfoo.so.1"},
+				"/usr/share/foo/amd64/":                 {Kind: "dir"},
+				"/usr/lib/x86_64-linux-gnu/foo/*.so":    {Kind: "glob"},
+			},
+		}},
+	},
 }, {
-	summary: "Conflicting globs in same package is okay",
+	summary: "Variables in paths are resolved for arm64",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
-				myslice1:
-					contents:
-						/file/foob*r:
-						/file/f*r:
-				myslice2:
+				myslice:
 					contents:
-						/file/foob*r:
-						/file/f*obar:
+						/usr/lib/${ARCH_TRIPLET}/libfoo.so.1:
+						/usr/lib/${ARCH_TRIPLET}/libfoo.so: {symlink: "/usr/lib/${ARCH_TRIPLET}/libfoo.so.1"}
+						/usr/share/foo/${ARCH}/: {make: true}
+						/usr/lib/${ARCH_TRIPLET}/foo/*.so:
 		`,
 	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selarch:   "arm64",
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/usr/lib/aarch64-linux-gnu/libfoo.so.1": {Kind: "copy"},
+				"/usr/lib/aarch64-linux-gnu/libfoo.so":   {Kind: "symlink", Info: "/usr/lib/aarch64-linux-gnu/libfoo.so.1"},
+				"/usr/share/foo/arm64/":                  {Kind: "dir"},
+				"/usr/lib/aarch64-linux-gnu/foo/*.so":    {Kind: "glob"},
+			},
+		}},
+	},
 }, {
-	summary: "Invalid glob options",
+	summary: "Variables in paths are left unresolved without an architecture",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/file/foob*r: {text: foo}
+						/usr/lib/${ARCH_TRIPLET}/libfoo.so.1:
 		`,
 	},
-	relerror: `slice mypkg_myslice path /file/foob\*r has invalid wildcard options`,
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg",
+			Name:    "myslice",
+			Contents: map[string]setup.PathInfo{
+				"/usr/lib/${ARCH_TRIPLET}/libfoo.so.1": {Kind: "copy"},
+			},
+		}},
+	},
 }, {
-	summary: "Until is an okay option for globs",
+	summary: "Unknown variables in paths are rejected",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/file/foob*r: {until: mutate}
+						/usr/lib/${TRIPLET}/libfoo.so.1:
 		`,
 	},
+	relerror: `slice mypkg_myslice has invalid content path /usr/lib/\$\{TRIPLET\}/libfoo.so.1: unknown variable "TRIPLET"`,
 }, {
-	summary: "Mutable does not work for directories extractions",
+	summary: "Unterminated variables in paths are rejected",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/path/: {mutable: true}
+						/usr/lib/${ARCH/libfoo.so.1:
 		`,
 	},
-	relerror: `slice mypkg_myslice mutable is not a regular file: /path/`,
+	relerror: `slice mypkg_myslice has invalid content path /usr/lib/\$\{ARCH/libfoo.so.1: unterminated variable`,
 }, {
-	summary: "Mutable does not work for directory making",
+	summary: "Unknown variables in symlink targets are rejected",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/path/: {make: true, mutable: true}
+						/usr/lib/libfoo.so: {symlink: "/usr/lib/${FOO}/libfoo.so"}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /usr/lib/libfoo.so has invalid symlink: unknown variable "FOO"`,
+}, {
+	summary: "Resolved paths conflict across packages",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/usr/lib/${ARCH_TRIPLET}/foo.conf: {text: foo}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/usr/lib/aarch64-linux-gnu/foo.conf: {text: bar}
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /usr/lib/aarch64-linux-gnu/foo.conf`,
+}, {
+	summary: "Resolved paths conflict with globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/usr/lib/${ARCH_TRIPLET}/*.conf:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/usr/lib/s390x-linux-gnu/foo.conf:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /usr/lib/s390x-linux-gnu/\*.conf and /usr/lib/s390x-linux-gnu/foo.conf`,
+}, {
+	summary: "Resolved paths of a slice must be defined the same",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/${ARCH}.conf: {text: foo}
+						/etc/amd64.conf: {text: bar}
+		`,
+	},
+	relerror: `slice mypkg_myslice paths /etc/\$\{ARCH\}.conf and /etc/amd64.conf are both /etc/amd64.conf with different definitions`,
+}, {
+	summary: "Include paths must be within the release",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					include: [../common.yaml]
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid include path: "../common.yaml"`,
+}, {
+	summary: "Include file must exist",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					include: [includes/missing.yaml]
+		`,
+	},
+	relerror: `cannot read include file: open .*/includes/missing.yaml: no such file or directory`,
+}, {
+	summary: "Text from a file",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/app.conf: {text-file: files/app.conf, mutable: true}
+		`,
+		"files/app.conf": "key: value",
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Contents: map[string]setup.PathInfo{
+							"/etc/app.conf": {Kind: "text", Info: "key: value\n", Mutable: true},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Text file paths must be within the release",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/app.conf: {text-file: ../app.conf}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /etc/app.conf has invalid text-file: "../app.conf"`,
+}, {
+	summary: "Text file must exist",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/app.conf: {text-file: files/missing.conf}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /etc/app.conf cannot read text-file: open .*/files/missing.conf: no such file or directory`,
+}, {
+	summary: "Text file cannot be used with inline text",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/etc/app.conf: {text: data, text-file: files/app.conf}
+		`,
+		"files/app.conf": "key: value",
+	},
+	relerror: `conflict in slice mypkg_myslice definition for path /etc/app.conf: text, text`,
+}, {
+	summary: "Directories must be suffixed with /",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/foo: {make: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /foo must end in / for 'make' to be valid`,
+}, {
+	summary: "Slice path must be clean",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/foo/../:
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+}, {
+	summary: "Slice path must be absolute",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						./foo/:
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid content path: ./foo/`,
+}, {
+	summary: "Globbing support",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/file/*:
+				myslice2:
+					contents:
+						/another/**:
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/file/*": {Kind: "glob"},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Contents: map[string]setup.PathInfo{
+							"/another/**": {Kind: "glob"},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Conflicting globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/f*obar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/f\*obar and /file/foob\*r`,
+}, {
+	summary: "Conflicting globs and plain copies",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foobar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
+}, {
+	summary: "Conflicting matching globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foob\*r`,
+}, {
+	summary: "Directory copies",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/newdir/: {copy: /usr/share/template-dir/}
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Contents: map[string]setup.PathInfo{
+							"/newdir/": {Kind: "copy", Info: "/usr/share/template-dir/"},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Directory copies require a trailing slash in the target",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/newdir: {copy: /usr/share/template-dir/}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /newdir and its copy source /usr/share/template-dir/ must both end in / to copy a directory`,
+}, {
+	summary: "Directory copies require a trailing slash in the source",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/newdir/: {copy: /usr/share/template-dir}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /newdir/ and its copy source /usr/share/template-dir must both end in / to copy a directory`,
+}, {
+	summary: "Directory copies cannot be mixed with other kinds",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/newdir/: {copy: /usr/share/template-dir/, symlink: /foo/}
+		`,
+	},
+	relerror: `conflict in slice mypkg_myslice definition for path /newdir/: symlink, copy`,
+}, {
+	summary: "Directory copies conflict with paths under their target",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/newdir/: {copy: /usr/share/template-dir/}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/newdir/file:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /newdir/ and /newdir/file`,
+}, {
+	summary: "Conflicting globs in same package is okay",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/file/foob*r:
+						/file/f*r:
+				myslice2:
+					contents:
+						/file/foob*r:
+						/file/f*obar:
+		`,
+	},
+}, {
+	summary: "Path owners",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/path1: {uid: 1000, gid: 1000}
+						/file/path2: {text: content, uid: 0}
+						/file/path3/: {make: true, mode: 0700, gid: 42}
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Contents: map[string]setup.PathInfo{
+							"/file/path1":  {Kind: "copy", UID: intPtr(1000), GID: intPtr(1000)},
+							"/file/path2":  {Kind: "text", Info: "content", UID: intPtr(0)},
+							"/file/path3/": {Kind: "dir", Mode: 0700, GID: intPtr(42)},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Path parent modes",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/path1: {parent-mode: 0700}
+						/deep/file/path2: {text: content, parent-mode: 01750}
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Contents: map[string]setup.PathInfo{
+							"/file/path1":      {Kind: "copy", ParentMode: 0700},
+							"/deep/file/path2": {Kind: "text", Info: "content", ParentMode: 01750},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Invalid path parent mode",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/path: {parent-mode: 010000}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'parent-mode' for path /file/path: 010000`,
+}, {
+	summary: "Parent mode cannot be used with wildcards",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/*: {parent-mode: 0700}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /file/\* has invalid wildcard options`,
+}, {
+	summary: "Conflicting parent modes",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/path: {text: content, parent-mode: 0700}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/path: {text: content, parent-mode: 0750}
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/path`,
+}, {
+	summary: "Invalid path uid",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/path: {uid: -1}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'uid' for path /file/path: -1`,
+}, {
+	summary: "Invalid path gid",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/path: {gid: -2}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'gid' for path /file/path: -2`,
+}, {
+	summary: "Owners cannot be set on wildcards",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/foob*r: {uid: 1000}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /file/foob\*r has invalid wildcard options`,
+}, {
+	summary: "Conflicting path owners across slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/path1: {uid: 1000}
+				myslice2:
+					contents:
+						/path1: {uid: 1001}
+		`,
+	},
+	relerror: "slices mypkg_myslice1 and mypkg_myslice2 conflict on /path1",
+}, {
+	summary: "Path hard links",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/usr/bin/foo:
+						/usr/share/foo.txt: {text: foo}
+				myslice2:
+					contents:
+						/usr/bin/bar: {hardlink: /usr/bin/foo}
+						/usr/share/bar.txt: {hardlink: /usr/share/foo.txt, until: mutate}
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/foo":       {Kind: "copy"},
+							"/usr/share/foo.txt": {Kind: "text", Info: "foo"},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/bar":       {Kind: "hardlink", Info: "/usr/bin/foo"},
+							"/usr/share/bar.txt": {Kind: "hardlink", Info: "/usr/share/foo.txt", Until: "mutate"},
+						},
+					},
+				},
+			},
+		},
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice1"}, {"mypkg", "myslice2"}},
+}, {
+	summary: "Invalid hard link target",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/bar: {hardlink: foo}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /usr/bin/bar has invalid hardlink target: foo`,
+}, {
+	summary: "Hard link to a wildcard",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/bar: {hardlink: /usr/bin/f*}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /usr/bin/bar has invalid hardlink target: /usr/bin/f\*`,
+}, {
+	summary: "Hard link cannot be a directory",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/: {hardlink: /usr/sbin}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /usr/bin/ has invalid hardlink target: /usr/sbin`,
+}, {
+	summary: "Hard link cannot have mode or owner",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/foo:
+						/usr/bin/bar: {hardlink: /usr/bin/foo, mode: 0755}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /usr/bin/bar has invalid hardlink options`,
+}, {
+	summary: "Symlink mode cannot be changed",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/bar: {symlink: foo, mode: 0755}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /usr/bin/bar has invalid symlink mode: 0755 \(symlinks always have mode 0777\)`,
+}, {
+	summary: "Hard link conflicts with other kinds",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/bar: {hardlink: /usr/bin/foo, symlink: /usr/bin/foo}
+		`,
+	},
+	relerror: `conflict in slice mypkg_myslice definition for path /usr/bin/bar: symlink, hardlink`,
+}, {
+	summary: "Hard link target must be selected",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/usr/bin/foo:
+				myslice2:
+					contents:
+						/usr/bin/bar: {hardlink: /usr/bin/foo}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice2"}},
+	selerror:  `slice mypkg_myslice2 has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not in the selected slices of package mypkg`,
+}, {
+	summary: "Hard link target must be in the same package",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/usr/bin/foo:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/usr/bin/bar: {hardlink: /usr/bin/foo}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
+	selerror:  `slice mypkg2_myslice has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not in the selected slices of package mypkg2`,
+}, {
+	summary: "Hard link target must be a regular file",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/foo: {symlink: /usr/bin/baz}
+						/usr/bin/bar: {hardlink: /usr/bin/foo}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selerror:  `slice mypkg_myslice has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not a regular file`,
+}, {
+	summary: "Hard link target must exist on the same architectures",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/usr/bin/foo: {arch: amd64}
+						/usr/bin/bar: {hardlink: /usr/bin/foo, arch: [amd64, arm64]}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "myslice"}},
+	selerror:  `slice mypkg_myslice has invalid hardlink for path /usr/bin/bar: /usr/bin/foo is not available on arm64`,
+}, {
+	summary: "Invalid glob options",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/foob*r: {text: foo}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /file/foob\*r has invalid wildcard options`,
+}, {
+	summary: "Until is an okay option for globs",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file/foob*r: {until: mutate}
+		`,
+	},
+}, {
+	summary: "Mutable does not work for directories extractions",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/: {mutable: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice mutable is not a regular file: /path/`,
+}, {
+	summary: "Mutable does not work for directory making",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/: {make: true, mutable: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice mutable is not a regular file: /path/`,
+}, {
+	summary: "Mutable does not work for symlinks",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path: {symlink: /other, mutable: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice mutable is not a regular file: /path`,
+}, {
+	summary: "Until checks its value for validity",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path: {until: foo}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'until' for path /path: "foo" \(expected "mutate" or "end"\)`,
+}, {
+	summary: "Until accepts the end phase",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/path1: {text: foo, until: end}
+						/path2: {until: mutate}
+				myslice2:
+					contents:
+						/path1: {text: foo}
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/path1": {Kind: "text", Info: "foo", Until: "end"},
+							"/path2": {Kind: "copy", Until: "mutate"},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Contents: map[string]setup.PathInfo{
+							"/path1": {Kind: "text", Info: "foo"},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Until phases cannot disagree across slices",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/path: {until: mutate}
+				myslice2:
+					contents:
+						/path: {until: end}
+		`,
+	},
+	relerror: `slices mypkg_myslice1 and mypkg_myslice2 conflict on /path`,
+}, {
+	summary: "Arch checks its value for validity",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path: {arch: foo}
 		`,
 	},
-	relerror: `slice mypkg_myslice mutable is not a regular file: /path/`,
+	relerror: `slice mypkg_myslice has invalid 'arch' for path /path: "foo"`,
 }, {
-	summary: "Mutable does not work for symlinks",
+	summary: "Arch checks its value for validity",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/path: {symlink: /other, mutable: true}
+						/path: {arch: [i386, foo]}
 		`,
 	},
-	relerror: `slice mypkg_myslice mutable is not a regular file: /path`,
+	relerror: `slice mypkg_myslice has invalid 'arch' for path /path: "foo"`,
 }, {
-	summary: "Until checks its value for validity",
+	summary: "Arch keywords match any architecture",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/path: {until: foo}
+						/path1: {arch: any}
+						/path2: {arch: native}
+						/path3: {arch: [native]}
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid 'until' for path /path: "foo"`,
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Path: "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Contents: map[string]setup.PathInfo{
+							"/path1": {Kind: "copy"},
+							"/path2": {Kind: "copy"},
+							"/path3": {Kind: "copy"},
+						},
+					},
+				},
+			},
+		},
+	},
 }, {
-	summary: "Arch checks its value for validity",
+	summary: "Arch keywords cannot be listed with other architectures",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/path: {arch: foo}
+						/path: {arch: [amd64, any]}
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid 'arch' for path /path: "foo"`,
+	relerror: `slice mypkg_myslice has invalid 'arch' for path /path: "any" cannot be listed with other architectures`,
 }, {
-	summary: "Arch checks its value for validity",
+	summary: "Arch keyword native cannot be listed with other architectures",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
 				myslice:
 					contents:
-						/path: {arch: [i386, foo]}
+						/path: {arch: [native, i386]}
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid 'arch' for path /path: "foo"`,
+	relerror: `slice mypkg_myslice has invalid 'arch' for path /path: "native" cannot be listed with other architectures`,
 }, {
 	summary: "Single architecture selection",
 	input: map[string]string{
@@ -891,6 +2061,62 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `chisel.yaml: archives "bar" and "foo" have the same priority value of 20`,
+}, {
+	summary: "Archives may have the same priority with allow-equal-priorities",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			allow-equal-priorities: true
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					priority: 20
+					public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [universe]
+					suites: [jammy-updates]
+					priority: 20
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		AllowEqualPriorities: true,
+		Archives: map[string]*setup.Archive{
+			"foo": {
+				Name:       "foo",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				Priority:   20,
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+			"bar": {
+				Name:       "bar",
+				Version:    "22.04",
+				Suites:     []string{"jammy-updates"},
+				Components: []string{"universe"},
+				Priority:   20,
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:   "mypkg",
+				Path:   "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+	},
 }, {
 	summary: "Invalid archive priority",
 	input: map[string]string{
@@ -1512,6 +2738,30 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `slice mypkg_myslice has invalid generate path: /path/ does not end with /\*\*`,
+}, {
+	summary: "Paths with generate: filelist must have trailing /**",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/: {generate: "filelist"}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid generate path: /path/ does not end with /\*\*`,
+}, {
+	summary: "Paths with generate: package-files must have trailing /**",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/: {generate: "package-files"}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid generate path: /path/ does not end with /\*\*`,
 }, {
 	summary: "Paths with generate: manifest must not have any other wildcard except the trailing **",
 	input: map[string]string{
@@ -2020,6 +3270,46 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `chisel.yaml: archive "ubuntu" defined twice`,
+}, {
+	summary: "Archive with custom label",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v1
+			archives:
+				debian:
+					version: 12
+					components: [main]
+					suites: [bookworm]
+					label: Debian
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		Archives: map[string]*setup.Archive{
+			"debian": {
+				Name:         "debian",
+				Version:      "12",
+				Suites:       []string{"bookworm"},
+				Components:   []string{"main"},
+				PubKeys:      []*packet.PublicKey{testKey.PubKey},
+				ReleaseLabel: "Debian",
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name:   "mypkg",
+				Path:   "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{},
+			},
+		},
+	},
 }}
 
 var defaultChiselYaml = `
@@ -2091,7 +3381,11 @@ func runParseReleaseTests(c *C, tests []setupTest) {
 		}
 
 		if test.selslices != nil {
-			selection, err := setup.Select(release, test.selslices)
+			var options *setup.SelectOptions
+			if test.selarch != "" {
+				options = &setup.SelectOptions{Arch: test.selarch}
+			}
+			selection, err := setup.SelectWithOptions(release, test.selslices, options)
 			if test.selerror != "" {
 				c.Assert(err, ErrorMatches, test.selerror)
 				continue
@@ -2289,3 +3583,254 @@ func (s *S) TestYAMLPathGenerate(c *C) {
 		c.Assert(result, Equals, test.result)
 	}
 }
+
+func (s *S) TestConflictError(c *C) {
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1:
+					contents:
+						/file1: {text: foo}
+						/dir/**:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1:
+					contents:
+						/file1: {text: bar}
+						/dir/file:
+				myslice2:
+					contents:
+						/file1: {text: baz}
+		`,
+	}
+	dir := c.MkDir()
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	_, err := setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /dir/\*\* and /dir/file`)
+	conflictErr, ok := err.(*setup.ConflictError)
+	c.Assert(ok, Equals, true)
+
+	// The conflicts on /file1 depend on which slice is seen first, so only
+	// check that they are all reported.
+	c.Assert(conflictErr.Conflicts, HasLen, 3)
+	c.Assert(conflictErr.Conflicts[0], DeepEquals, &setup.Conflict{
+		Kind:   setup.GlobConflict,
+		Slices: [2]setup.SliceKey{{"mypkg1", "myslice1"}, {"mypkg2", "myslice1"}},
+		Paths:  []string{"/dir/**", "/dir/file"},
+	})
+	for _, conflict := range conflictErr.Conflicts[1:] {
+		c.Assert(conflict.Kind, Equals, setup.PathConflict)
+		c.Assert(conflict.Paths, DeepEquals, []string{"/file1"})
+	}
+}
+
+func (s *S) TestReadReleaseFormatOverride(c *C) {
+	input := map[string]string{
+		"chisel.yaml": strings.Replace(defaultChiselYaml, "format: v1", "format: v0", 1),
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	}
+	dir := c.MkDir()
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	_, err := setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `chisel.yaml: unknown format "v0"`)
+
+	release, err := setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{FormatOverride: "v1"})
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"].Slices["myslice"], NotNil)
+
+	_, err = setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{FormatOverride: "v9"})
+	c.Assert(err, ErrorMatches, `invalid format override: unknown format "v9"`)
+}
+
+func (s *S) TestReadReleaseKeyExpiry(c *C) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiringKey, err := testutil.MakeExpiringKey(created, 365*24*time.Hour)
+	c.Assert(err, IsNil)
+
+	input := map[string]string{
+		"chisel.yaml": `
+			format: v1
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					public-keys: [test-key, expiring-key]
+			public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+				expiring-key:
+					id: ` + expiringKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(expiringKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	}
+	dir := c.MkDir()
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+	archive := release.Archives["ubuntu"]
+	c.Assert(archive.PubKeys, HasLen, 2)
+	c.Assert(archive.KeyExpiry, HasLen, 1)
+	expiry := archive.KeyExpiry[expiringKey.PubKey.KeyId]
+	c.Assert(expiry.Equal(created.Add(365*24*time.Hour)), Equals, true, Commentf("expiry: %s", expiry))
+}
+
+func (s *S) TestReadReleasePrefer(c *C) {
+	input := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file: {text: foo}
+						/other:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file: {text: bar}
+						/other2:
+		`,
+	}
+	dir := c.MkDir()
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	_, err := setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `slices mypkg1_myslice and mypkg2_myslice conflict on /file`)
+
+	release, err := setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{
+		Prefer: map[string]string{"/file": "mypkg2"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg1"].Slices["myslice"].Contents, DeepEquals, map[string]setup.PathInfo{
+		"/other": {Kind: "copy"},
+	})
+	c.Assert(release.Packages["mypkg2"].Slices["myslice"].Contents, DeepEquals, map[string]setup.PathInfo{
+		"/file":   {Kind: "text", Info: "bar"},
+		"/other2": {Kind: "copy"},
+	})
+	c.Assert(release.Prefers, DeepEquals, map[string]*setup.Prefer{
+		"/file": {Path: "/file", Package: "mypkg2", Candidates: []string{"mypkg1", "mypkg2"}},
+	})
+
+	selection, err := setup.Select(release, []setup.SliceKey{{"mypkg2", "myslice"}})
+	c.Assert(err, IsNil)
+	c.Assert(selection.Prefers(), DeepEquals, []*setup.Prefer{release.Prefers["/file"]})
+	selection, err = setup.Select(release, []setup.SliceKey{{"mypkg1", "myslice"}})
+	c.Assert(err, IsNil)
+	c.Assert(selection.Prefers(), HasLen, 0)
+
+	_, err = setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{
+		Prefer: map[string]string{"/file": "mypkg3"},
+	})
+	c.Assert(err, ErrorMatches, `cannot prefer package "mypkg3" for path /file: package not found`)
+
+	_, err = setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{
+		Prefer: map[string]string{"/other": "mypkg2"},
+	})
+	c.Assert(err, ErrorMatches, `cannot prefer package "mypkg2" for path /other: path not in its slices`)
+}
+
+func (s *S) TestCompareArchives(c *C) {
+	archives := []*setup.Archive{
+		{Name: "foo", Priority: 10},
+		{Name: "qux", Priority: -1},
+		{Name: "baz", Priority: 20},
+		{Name: "bar", Priority: 10},
+	}
+	slices.SortFunc(archives, setup.CompareArchives)
+	var names []string
+	for _, archive := range archives {
+		names = append(names, archive.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"baz", "bar", "foo", "qux"})
+}
+
+func (s *S) TestSelectionPackages(c *C) {
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1: {}
+				myslice2: {}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1: {essential: [mypkg1_myslice1, mypkg1_myslice2]}
+				myslice2: {essential: [mypkg3_myslice]}
+		`,
+		"slices/mydir/mypkg3.yaml": `
+			package: mypkg3
+			slices:
+				myslice: {}
+		`,
+	}
+	dir := c.MkDir()
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+
+	selection, err := setup.Select(release, []setup.SliceKey{{"mypkg2", "myslice1"}})
+	c.Assert(err, IsNil)
+	c.Assert(selection.Packages(), DeepEquals, []string{"mypkg1", "mypkg2"})
+
+	selection, err = setup.Select(release, []setup.SliceKey{{"mypkg2", "myslice1"}, {"mypkg2", "myslice2"}})
+	c.Assert(err, IsNil)
+	c.Assert(selection.Packages(), DeepEquals, []string{"mypkg1", "mypkg2", "mypkg3"})
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,129 @@
+package setup
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/canonical/chisel/internal/deb"
+)
+
+// Variables accepted in content paths, in the form ${NAME}. They are resolved
+// from the architecture being targeted when the slices are selected.
+const (
+	// VarArch is the package architecture (e.g. amd64).
+	VarArch = "ARCH"
+	// VarArchTriplet is the GNU triplet of the architecture (e.g.
+	// x86_64-linux-gnu), as used in the multiarch library paths.
+	VarArchTriplet = "ARCH_TRIPLET"
+)
+
+var knownVars = []string{VarArch, VarArchTriplet}
+
+var varExp = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+func hasVars(path string) bool {
+	return strings.Contains(path, "${")
+}
+
+// validateVars checks that all the variables in path are known.
+func validateVars(path string) error {
+	if hasVars(varExp.ReplaceAllString(path, "")) {
+		return fmt.Errorf("unterminated variable")
+	}
+	for _, match := range varExp.FindAllStringSubmatch(path, -1) {
+		if !slices.Contains(knownVars, match[1]) {
+			return fmt.Errorf("unknown variable %q", match[1])
+		}
+	}
+	return nil
+}
+
+// archVars returns the values of the variables for the architecture.
+func archVars(arch string) (map[string]string, error) {
+	triplet, err := deb.ArchTriplet(arch)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		VarArch:        arch,
+		VarArchTriplet: triplet,
+	}, nil
+}
+
+func expandVars(path string, vars map[string]string) string {
+	if !hasVars(path) {
+		return path
+	}
+	return varExp.ReplaceAllStringFunc(path, func(ref string) string {
+		return vars[ref[2:len(ref)-1]]
+	})
+}
+
+// usesVars returns whether the contents of the slice have variables, either
+// in their paths or in the paths they refer to.
+func (s *Slice) usesVars() bool {
+	for path, info := range s.Contents {
+		if hasVars(path) || info.Kind != TextPath && hasVars(info.Info) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandVars returns a copy of the slice with the variables in its contents
+// resolved. It fails if two of its paths become the same one with different
+// definitions.
+func (s *Slice) expandVars(vars map[string]string) (*Slice, error) {
+	expanded := *s
+	expanded.Contents = make(map[string]PathInfo, len(s.Contents))
+	origins := make(map[string]string, len(s.Contents))
+	for path, info := range s.Contents {
+		newPath := expandVars(path, vars)
+		if info.Kind != TextPath {
+			info.Info = expandVars(info.Info, vars)
+		}
+		if old, ok := expanded.Contents[newPath]; ok {
+			if !old.SameContent(&info) || old.Until != info.Until || !slices.Equal(old.Arch, info.Arch) {
+				first, second := origins[newPath], path
+				if first > second {
+					first, second = second, first
+				}
+				return nil, fmt.Errorf("slice %s paths %s and %s are both %s with different definitions",
+					s, first, second, newPath)
+			}
+			continue
+		}
+		expanded.Contents[newPath] = info
+		origins[newPath] = path
+	}
+	return &expanded, nil
+}
+
+// expandPackages returns the packages with the variables in the contents of
+// their slices resolved for the architecture.
+func expandPackages(pkgs map[string]*Package, arch string) (map[string]*Package, error) {
+	vars, err := archVars(arch)
+	if err != nil {
+		return nil, err
+	}
+	expanded := make(map[string]*Package, len(pkgs))
+	for pkgName, pkg := range pkgs {
+		newPkg := *pkg
+		newPkg.Slices = make(map[string]*Slice, len(pkg.Slices))
+		for sliceName, slice := range pkg.Slices {
+			if !slice.usesVars() {
+				newPkg.Slices[sliceName] = slice
+				continue
+			}
+			newSlice, err := slice.expandVars(vars)
+			if err != nil {
+				return nil, err
+			}
+			newPkg.Slices[sliceName] = newSlice
+		}
+		expanded[pkgName] = &newPkg
+	}
+	return expanded, nil
+}
@@ -3,9 +3,13 @@ package setup
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
 	"gopkg.in/yaml.v3"
@@ -31,6 +35,9 @@ type yamlRelease struct {
 	// fields that break said compatibility (e.g. "pro" archives) and merged
 	// together with "archives".
 	V2Archives map[string]yamlArchive `yaml:"v2-archives"`
+	// AllowEqualPriorities accepts archives with the same priority, which
+	// are then ordered by name.
+	AllowEqualPriorities bool `yaml:"allow-equal-priorities"`
 }
 
 const (
@@ -44,6 +51,7 @@ type yamlArchive struct {
 	Components []string `yaml:"components"`
 	Priority   *int     `yaml:"priority"`
 	Pro        string   `yaml:"pro"`
+	Label      string   `yaml:"label"`
 	Default    bool     `yaml:"default"`
 	PubKeys    []string `yaml:"public-keys"`
 }
@@ -56,15 +64,20 @@ type yamlPackage struct {
 }
 
 type yamlPath struct {
-	Dir      bool         `yaml:"make,omitempty"`
-	Mode     yamlMode     `yaml:"mode,omitempty"`
-	Copy     string       `yaml:"copy,omitempty"`
-	Text     *string      `yaml:"text,omitempty"`
-	Symlink  string       `yaml:"symlink,omitempty"`
-	Mutable  bool         `yaml:"mutable,omitempty"`
-	Until    PathUntil    `yaml:"until,omitempty"`
-	Arch     yamlArch     `yaml:"arch,omitempty"`
-	Generate GenerateKind `yaml:"generate,omitempty"`
+	Dir        bool         `yaml:"make,omitempty"`
+	Mode       yamlMode     `yaml:"mode,omitempty"`
+	ParentMode yamlMode     `yaml:"parent-mode,omitempty"`
+	UID        *int         `yaml:"uid,omitempty"`
+	GID        *int         `yaml:"gid,omitempty"`
+	Copy       string       `yaml:"copy,omitempty"`
+	Text       *string      `yaml:"text,omitempty"`
+	TextFile   string       `yaml:"text-file,omitempty"`
+	Symlink    string       `yaml:"symlink,omitempty"`
+	HardLink   string       `yaml:"hardlink,omitempty"`
+	Mutable    bool         `yaml:"mutable,omitempty"`
+	Until      PathUntil    `yaml:"until,omitempty"`
+	Arch       yamlArch     `yaml:"arch,omitempty"`
+	Generate   GenerateKind `yaml:"generate,omitempty"`
 }
 
 func (yp *yamlPath) MarshalYAML() (interface{}, error) {
@@ -87,13 +100,26 @@ var _ yaml.Marshaler = (*yamlPath)(nil)
 func (yp *yamlPath) SameContent(other *yamlPath) bool {
 	return (yp.Dir == other.Dir &&
 		yp.Mode == other.Mode &&
+		yp.ParentMode == other.ParentMode &&
+		sameID(yp.UID, other.UID) &&
+		sameID(yp.GID, other.GID) &&
 		yp.Copy == other.Copy &&
 		yp.Text == other.Text &&
+		yp.TextFile == other.TextFile &&
 		yp.Symlink == other.Symlink &&
+		yp.HardLink == other.HardLink &&
 		yp.Mutable == other.Mutable &&
 		yp.Generate == other.Generate)
 }
 
+// Keywords accepted in the arch field of paths. ArchAny matches all
+// architectures, the same as omitting the field, and ArchNative matches the
+// architecture being targeted.
+const (
+	ArchAny    = "any"
+	ArchNative = "native"
+)
+
 type yamlArch struct {
 	List []string
 }
@@ -138,9 +164,47 @@ func (ym yamlMode) MarshalYAML() (interface{}, error) {
 var _ yaml.Marshaler = yamlMode(0)
 
 type yamlSlice struct {
-	Essential []string             `yaml:"essential,omitempty"`
-	Contents  map[string]*yamlPath `yaml:"contents,omitempty"`
-	Mutate    string               `yaml:"mutate,omitempty"`
+	Essential   []string             `yaml:"essential,omitempty"`
+	Include     []string             `yaml:"include,omitempty"`
+	Contents    map[string]*yamlPath `yaml:"contents,omitempty"`
+	Mutate      yamlMutate           `yaml:"mutate,omitempty"`
+	MutateAfter []string             `yaml:"mutate-after,omitempty"`
+}
+
+// yamlMutate is either a single mutation script or the list of its phases.
+type yamlMutate struct {
+	List []string
+}
+
+func (ym *yamlMutate) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	var l []string
+	if value.Decode(&s) == nil {
+		if s != "" {
+			ym.List = []string{s}
+		}
+	} else if value.Decode(&l) == nil {
+		ym.List = l
+	} else {
+		return fmt.Errorf("cannot decode mutate")
+	}
+	return nil
+}
+
+func (ym yamlMutate) MarshalYAML() (interface{}, error) {
+	if len(ym.List) == 1 {
+		return ym.List[0], nil
+	}
+	return ym.List, nil
+}
+
+var _ yaml.Marshaler = yamlMutate{}
+
+// yamlFragment is the content of a file included by slices, with the
+// contents and mutate script shared by all of them.
+type yamlFragment struct {
+	Contents map[string]*yamlPath `yaml:"contents,omitempty"`
+	Mutate   string               `yaml:"mutate,omitempty"`
 }
 
 type yamlPubKey struct {
@@ -148,7 +212,14 @@ type yamlPubKey struct {
 	Armor string `yaml:"armor"`
 }
 
-func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
+// knownFormats lists the values accepted in the format field of chisel.yaml.
+var knownFormats = []string{"v1"}
+
+func knownFormat(format string) bool {
+	return slices.Contains(knownFormats, format)
+}
+
+func parseRelease(baseDir, filePath string, data []byte, formatOverride string) (*Release, error) {
 	release := &Release{
 		Path:     baseDir,
 		Packages: make(map[string]*Package),
@@ -164,15 +235,21 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%s: cannot parse release definition: %v", fileName, err)
 	}
-	if yamlVar.Format != "v1" {
+	if formatOverride != "" {
+		logf("Parsing %s as format %s instead of %s.", fileName, formatOverride, yamlVar.Format)
+		yamlVar.Format = formatOverride
+	}
+	if !knownFormat(yamlVar.Format) {
 		return nil, fmt.Errorf("%s: unknown format %q", fileName, yamlVar.Format)
 	}
+	release.AllowEqualPriorities = yamlVar.AllowEqualPriorities
 	if len(yamlVar.Archives)+len(yamlVar.V2Archives) == 0 {
 		return nil, fmt.Errorf("%s: no archives defined", fileName)
 	}
 
 	// Decode the public keys and match against provided IDs.
 	pubKeys := make(map[string]*packet.PublicKey, len(yamlVar.PubKeys))
+	keyExpiry := make(map[string]time.Time)
 	for keyName, yamlPubKey := range yamlVar.PubKeys {
 		key, err := pgputil.DecodePubKey([]byte(yamlPubKey.Armor))
 		if err != nil {
@@ -182,6 +259,13 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 			return nil, fmt.Errorf("%s: public key %q armor has incorrect ID: expected %q, got %q", fileName, keyName, yamlPubKey.ID, key.KeyIdString())
 		}
 		pubKeys[keyName] = key
+		expiry, err := pgputil.DecodeKeyExpiry([]byte(yamlPubKey.Armor))
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot decode public key %q: %w", fileName, keyName, err)
+		}
+		if !expiry.IsZero() {
+			keyExpiry[keyName] = expiry
+		}
 	}
 
 	// Merge all archive definitions.
@@ -230,12 +314,19 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 			return nil, fmt.Errorf("%s: archive %q missing public-keys field", fileName, archiveName)
 		}
 		var archiveKeys []*packet.PublicKey
+		var archiveKeyExpiry map[uint64]time.Time
 		for _, keyName := range details.PubKeys {
 			key, ok := pubKeys[keyName]
 			if !ok {
 				return nil, fmt.Errorf("%s: archive %q refers to undefined public key %q", fileName, archiveName, keyName)
 			}
 			archiveKeys = append(archiveKeys, key)
+			if expiry, ok := keyExpiry[keyName]; ok {
+				if archiveKeyExpiry == nil {
+					archiveKeyExpiry = make(map[uint64]time.Time)
+				}
+				archiveKeyExpiry[key.KeyId] = expiry
+			}
 		}
 		priority := 0
 		if details.Priority != nil {
@@ -251,13 +342,15 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 			}
 		}
 		release.Archives[archiveName] = &Archive{
-			Name:       archiveName,
-			Version:    details.Version,
-			Suites:     details.Suites,
-			Components: details.Components,
-			Pro:        details.Pro,
-			Priority:   priority,
-			PubKeys:    archiveKeys,
+			Name:         archiveName,
+			Version:      details.Version,
+			Suites:       details.Suites,
+			Components:   details.Components,
+			Pro:          details.Pro,
+			Priority:     priority,
+			PubKeys:      archiveKeys,
+			KeyExpiry:    archiveKeyExpiry,
+			ReleaseLabel: details.Label,
 		}
 	}
 	if (hasPriority && archiveNoPriority != "") ||
@@ -283,6 +376,84 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 	return release, err
 }
 
+// includeFragments merges into the slice the contents and mutate scripts of
+// the fragment files it includes, which are relative to the release root.
+// Included mutate scripts run before the slice's own, in the order they are
+// included. A path may be listed more than once only with the same options.
+func includeFragments(baseDir, pkgName, pkgPath, sliceName string, yamlSlice *yamlSlice) error {
+	contents := make(map[string]*yamlPath, len(yamlSlice.Contents))
+	origins := make(map[string]string, len(yamlSlice.Contents))
+	for contPath, yamlPath := range yamlSlice.Contents {
+		contents[contPath] = yamlPath
+		origins[contPath] = pkgPath
+	}
+	var scripts []string
+	for i, include := range yamlSlice.Include {
+		if !filepath.IsLocal(include) || path.Clean(include) != include {
+			return fmt.Errorf("slice %s_%s has invalid include path: %q", pkgName, sliceName, include)
+		}
+		if slices.Contains(yamlSlice.Include[:i], include) {
+			return fmt.Errorf("slice %s_%s includes %s more than once", pkgName, sliceName, include)
+		}
+		data, err := os.ReadFile(filepath.Join(baseDir, include))
+		if err != nil {
+			// Errors from package os generally include the path.
+			return fmt.Errorf("cannot read include file: %v", err)
+		}
+		fragment := yamlFragment{}
+		dec := yaml.NewDecoder(bytes.NewBuffer(data))
+		dec.KnownFields(false)
+		err = dec.Decode(&fragment)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("cannot parse include file %s: %v", include, err)
+		}
+		for contPath, yamlPath := range fragment.Contents {
+			if origin, ok := origins[contPath]; ok {
+				if !sameYAMLPath(contents[contPath], yamlPath) {
+					return fmt.Errorf("slice %s_%s path %s defined differently in %s and %s",
+						pkgName, sliceName, contPath, origin, include)
+				}
+				continue
+			}
+			contents[contPath] = yamlPath
+			origins[contPath] = include
+		}
+		if fragment.Mutate != "" {
+			scripts = append(scripts, fragment.Mutate)
+		}
+	}
+	yamlSlice.Contents = contents
+	if len(scripts) == 0 {
+		return nil
+	}
+	// The included scripts are part of the first phase, so that the
+	// definitions they hold may be used by the slice's own.
+	phases := yamlSlice.Mutate.List
+	if len(phases) == 0 {
+		phases = []string{""}
+	} else {
+		phases = slices.Clone(phases)
+	}
+	if phases[0] != "" {
+		scripts = append(scripts, phases[0])
+	}
+	phases[0] = strings.Join(scripts, "\n")
+	yamlSlice.Mutate.List = phases
+	return nil
+}
+
+// sameYAMLPath returns whether both paths are defined with the same options,
+// with a missing definition being the same as one with no options.
+func sameYAMLPath(a, b *yamlPath) bool {
+	if a == nil {
+		a = &yamlPath{}
+	}
+	if b == nil {
+		b = &yamlPath{}
+	}
+	return a.SameContent(b) && a.Until == b.Until && slices.Equal(a.Arch.List, b.Arch.List)
+}
+
 func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, error) {
 	pkg := Package{
 		Name:   pkgName,
@@ -308,14 +479,25 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 		if match == nil {
 			return nil, fmt.Errorf("invalid slice name %q in %s", sliceName, pkgPath)
 		}
+		if len(yamlSlice.Include) > 0 {
+			err := includeFragments(baseDir, pkgName, pkgPath, sliceName, &yamlSlice)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		slice := &Slice{
 			Package: pkgName,
 			Name:    sliceName,
 			Scripts: SliceScripts{
-				Mutate: yamlSlice.Mutate,
+				Mutate: yamlSlice.Mutate.List,
 			},
 		}
+		for i, script := range slice.Scripts.Mutate {
+			if script == "" {
+				return nil, fmt.Errorf("slice %s_%s has empty mutate phase %d", pkgName, sliceName, i+1)
+			}
+		}
 		for _, refName := range yamlPkg.Essential {
 			sliceKey, err := ParseSliceKey(refName)
 			if err != nil {
@@ -343,6 +525,19 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			}
 			slice.Essential = append(slice.Essential, sliceKey)
 		}
+		for _, refName := range yamlSlice.MutateAfter {
+			sliceKey, err := ParseSliceKey(refName)
+			if err != nil {
+				return nil, fmt.Errorf("package %q has invalid mutate-after slice reference: %q", pkgName, refName)
+			}
+			if sliceKey.Package == slice.Package && sliceKey.Slice == slice.Name {
+				return nil, fmt.Errorf("cannot mutate slice after itself %q in %s", refName, pkgPath)
+			}
+			if slices.Contains(slice.MutateAfter, sliceKey) {
+				return nil, fmt.Errorf("slice %s defined with redundant mutate-after slice: %s", slice, refName)
+			}
+			slice.MutateAfter = append(slice.MutateAfter, sliceKey)
+		}
 
 		if len(yamlSlice.Contents) > 0 {
 			slice.Contents = make(map[string]PathInfo, len(yamlSlice.Contents))
@@ -356,9 +551,13 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			if !path.IsAbs(contPath) || path.Clean(contPath) != comparePath {
 				return nil, fmt.Errorf("slice %s_%s has invalid content path: %s", pkgName, sliceName, contPath)
 			}
+			if err := validateVars(contPath); err != nil {
+				return nil, fmt.Errorf("slice %s_%s has invalid content path %s: %v", pkgName, sliceName, contPath, err)
+			}
 			var kinds = make([]PathKind, 0, 3)
 			var info string
-			var mode uint
+			var mode, parentMode uint
+			var uid, gid *int
 			var mutable bool
 			var until PathUntil
 			var arch []string
@@ -385,6 +584,17 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			}
 			if yamlPath != nil {
 				mode = uint(yamlPath.Mode)
+				parentMode = uint(yamlPath.ParentMode)
+				if parentMode&^07777 != 0 {
+					return nil, fmt.Errorf("slice %s_%s has invalid 'parent-mode' for path %s: 0%o", pkgName, sliceName, contPath, parentMode)
+				}
+				uid, gid = yamlPath.UID, yamlPath.GID
+				if uid != nil && *uid < 0 {
+					return nil, fmt.Errorf("slice %s_%s has invalid 'uid' for path %s: %d", pkgName, sliceName, contPath, *uid)
+				}
+				if gid != nil && *gid < 0 {
+					return nil, fmt.Errorf("slice %s_%s has invalid 'gid' for path %s: %d", pkgName, sliceName, contPath, *gid)
+				}
 				mutable = yamlPath.Mutable
 				generate = yamlPath.Generate
 				if yamlPath.Dir {
@@ -398,25 +608,60 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 					kinds = append(kinds, TextPath)
 					info = *yamlPath.Text
 				}
+				if textFile := yamlPath.TextFile; textFile != "" {
+					kinds = append(kinds, TextPath)
+					if !filepath.IsLocal(textFile) || path.Clean(textFile) != textFile {
+						return nil, fmt.Errorf("slice %s_%s path %s has invalid text-file: %q",
+							pkgName, sliceName, contPath, textFile)
+					}
+					data, err := os.ReadFile(filepath.Join(baseDir, textFile))
+					if err != nil {
+						// Errors from package os generally include the path.
+						return nil, fmt.Errorf("slice %s_%s path %s cannot read text-file: %v",
+							pkgName, sliceName, contPath, err)
+					}
+					info = string(data)
+				}
 				if len(yamlPath.Symlink) > 0 {
 					kinds = append(kinds, SymlinkPath)
 					info = yamlPath.Symlink
 				}
+				if len(yamlPath.HardLink) > 0 {
+					kinds = append(kinds, HardLinkPath)
+					info = yamlPath.HardLink
+					if !path.IsAbs(info) || path.Clean(info) != info || info == contPath || isDir || strings.ContainsAny(info, "*?") {
+						return nil, fmt.Errorf("slice %s_%s path %s has invalid hardlink target: %s",
+							pkgName, sliceName, contPath, info)
+					}
+				}
 				if len(yamlPath.Copy) > 0 {
 					kinds = append(kinds, CopyPath)
 					info = yamlPath.Copy
 					if info == contPath {
 						info = ""
+					} else if strings.HasSuffix(info, "/") != isDir {
+						return nil, fmt.Errorf("slice %s_%s path %s and its copy source %s must both end in / to copy a directory",
+							pkgName, sliceName, contPath, info)
 					}
 				}
 				until = yamlPath.Until
 				switch until {
-				case UntilNone, UntilMutate:
+				case UntilNone, UntilMutate, UntilEnd:
 				default:
-					return nil, fmt.Errorf("slice %s_%s has invalid 'until' for path %s: %q", pkgName, sliceName, contPath, until)
+					return nil, fmt.Errorf("slice %s_%s has invalid 'until' for path %s: %q (expected %q or %q)",
+						pkgName, sliceName, contPath, until, UntilMutate, UntilEnd)
 				}
 				arch = yamlPath.Arch.List
 				for _, s := range arch {
+					if s == ArchAny || s == ArchNative {
+						if len(arch) > 1 {
+							return nil, fmt.Errorf("slice %s_%s has invalid 'arch' for path %s: %q cannot be listed with other architectures", pkgName, sliceName, contPath, s)
+						}
+						// Both keywords match the target architecture,
+						// whichever it is, so they impose no restriction.
+						arch = nil
+						break
+					}
 					if deb.ValidateArch(s) != nil {
 						return nil, fmt.Errorf("slice %s_%s has invalid 'arch' for path %s: %q", pkgName, sliceName, contPath, s)
 					}
@@ -435,14 +680,33 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			if mutable && kinds[0] != TextPath && (kinds[0] != CopyPath || isDir) {
 				return nil, fmt.Errorf("slice %s_%s mutable is not a regular file: %s", pkgName, sliceName, contPath)
 			}
+			if kinds[0] != TextPath {
+				if err := validateVars(info); err != nil {
+					return nil, fmt.Errorf("slice %s_%s path %s has invalid %s: %v", pkgName, sliceName, contPath, kinds[0], err)
+				}
+			}
+			if kinds[0] == HardLinkPath && (mode != 0 || uid != nil || gid != nil) {
+				// A hard link shares the mode and owner of its target.
+				return nil, fmt.Errorf("slice %s_%s path %s has invalid hardlink options",
+					pkgName, sliceName, contPath)
+			}
+			if kinds[0] == SymlinkPath && mode != 0 && mode != 0777 {
+				// The mode of symlinks cannot be changed on Linux, so it
+				// would not match the one recorded in the manifest.
+				return nil, fmt.Errorf("slice %s_%s path %s has invalid symlink mode: 0%o (symlinks always have mode 0777)",
+					pkgName, sliceName, contPath, mode)
+			}
 			slice.Contents[contPath] = PathInfo{
-				Kind:     kinds[0],
-				Info:     info,
-				Mode:     mode,
-				Mutable:  mutable,
-				Until:    until,
-				Arch:     arch,
-				Generate: generate,
+				Kind:       kinds[0],
+				Info:       info,
+				Mode:       mode,
+				ParentMode: parentMode,
+				UID:        uid,
+				GID:        gid,
+				Mutable:    mutable,
+				Until:      until,
+				Arch:       arch,
+				Generate:   generate,
 			}
 		}
 
@@ -472,11 +736,14 @@ func validateGeneratePath(path string) (string, error) {
 // The returned object takes pointers to the given PathInfo object.
 func pathInfoToYAML(pi *PathInfo) (*yamlPath, error) {
 	path := &yamlPath{
-		Mode:     yamlMode(pi.Mode),
-		Mutable:  pi.Mutable,
-		Until:    pi.Until,
-		Arch:     yamlArch{List: pi.Arch},
-		Generate: pi.Generate,
+		Mode:       yamlMode(pi.Mode),
+		ParentMode: yamlMode(pi.ParentMode),
+		UID:        pi.UID,
+		GID:        pi.GID,
+		Mutable:    pi.Mutable,
+		Until:      pi.Until,
+		Arch:       yamlArch{List: pi.Arch},
+		Generate:   pi.Generate,
 	}
 	switch pi.Kind {
 	case DirPath:
@@ -487,6 +754,8 @@ func pathInfoToYAML(pi *PathInfo) (*yamlPath, error) {
 		path.Text = &pi.Info
 	case SymlinkPath:
 		path.Symlink = pi.Info
+	case HardLinkPath:
+		path.HardLink = pi.Info
 	case GlobPath, GeneratePath:
 		// Nothing more needs to be done for these types.
 	default:
@@ -500,11 +769,14 @@ func sliceToYAML(s *Slice) (*yamlSlice, error) {
 	slice := &yamlSlice{
 		Essential: make([]string, 0, len(s.Essential)),
 		Contents:  make(map[string]*yamlPath, len(s.Contents)),
-		Mutate:    s.Scripts.Mutate,
+		Mutate:    yamlMutate{List: s.Scripts.Mutate},
 	}
 	for _, key := range s.Essential {
 		slice.Essential = append(slice.Essential, key.String())
 	}
+	for _, key := range s.MutateAfter {
+		slice.MutateAfter = append(slice.MutateAfter, key.String())
+	}
 	for path, info := range s.Contents {
 		yamlPath, err := pathInfoToYAML(&info)
 		if err != nil {
@@ -0,0 +1,222 @@
+package slicer
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// Generator produces the content of the files for a generate kind other
+// than "manifest". See RegisterGenerator.
+type Generator interface {
+	// Filename returns the name of the file created in the directory of
+	// every path with the generate kind, e.g. "index.txt" for the path
+	// /var/lib/index/**.
+	Filename() string
+	// Generate writes the content of the file described by options.
+	Generate(options *GenerateOptions, w io.Writer) error
+}
+
+// GenerateOptions describes the file to be written by a Generator.
+type GenerateOptions struct {
+	// Path is the absolute path of the file in the target directory.
+	Path string
+	// Slices are the selected slices which list the generate path.
+	Slices      []*setup.Slice
+	Selection   *setup.Selection
+	PackageInfo []*archive.PackageInfo
+	// Report holds all the content created so far. The manifests and the
+	// files of other generators may not be in it yet.
+	Report *manifestutil.Report
+	// Package is the package the file is about, for the generate kinds
+	// which write one file per selected package.
+	Package string
+}
+
+var generators = map[setup.GenerateKind]Generator{
+	setup.GenerateFileList:     fileListGenerator{},
+	setup.GeneratePackageFiles: packageFilesGenerator{},
+}
+
+// perPackageGenerator is implemented by the generators which write one file
+// per selected package instead of a single one. The file is named after the
+// package followed by the Filename suffix.
+type perPackageGenerator interface {
+	Generator
+	perPackage()
+}
+
+// RegisterGenerator makes kind acceptable in the "generate" field of paths,
+// with the content of the files produced by generator. It must be called
+// before the release is selected from, typically on init, and panics if a
+// generator was already registered for kind.
+func RegisterGenerator(kind setup.GenerateKind, generator Generator) {
+	if setup.IsBuiltinGenerateKind(kind) {
+		panic(fmt.Sprintf("cannot register generator for reserved kind %q", kind))
+	}
+	if _, ok := generators[kind]; ok {
+		panic(fmt.Sprintf("generator for kind %q already registered", kind))
+	}
+	generators[kind] = generator
+	setup.RegisterGenerateKind(kind)
+}
+
+// fileListGenerator lists all the paths in the report in order, one per
+// line. Directories have a trailing slash.
+type fileListGenerator struct{}
+
+func (fileListGenerator) Filename() string {
+	return "filelist.txt"
+}
+
+func (fileListGenerator) Generate(options *GenerateOptions, w io.Writer) error {
+	paths := make([]string, 0, len(options.Report.Entries))
+	for path := range options.Report.Entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		_, err := fmt.Fprintln(w, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageFilesGenerator lists, in a file for every selected package, the
+// paths created by the slices of that package in order, one per line.
+// Directories have a trailing slash. The list files themselves are left
+// out of the lists.
+type packageFilesGenerator struct{}
+
+func (packageFilesGenerator) Filename() string {
+	return ".list"
+}
+
+func (packageFilesGenerator) perPackage() {}
+
+func (packageFilesGenerator) Generate(options *GenerateOptions, w io.Writer) error {
+	listDir := filepath.Dir(options.Path)
+	var paths []string
+	for path, entry := range options.Report.Entries {
+		absPath := filepath.Join(options.Report.Root, path)
+		if filepath.Dir(absPath) == listDir && strings.HasSuffix(path, ".list") {
+			continue
+		}
+		for slice := range entry.Slices {
+			if slice.Package == options.Package {
+				paths = append(paths, path)
+				break
+			}
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		_, err := fmt.Fprintln(w, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateFiles writes the files produced by the registered generators and
+// adds them to the report.
+func generateFiles(fsys fsutil.FS, targetDir string, selection *setup.Selection, report *manifestutil.Report, pkgInfos []*archive.PackageInfo, sha512 bool) error {
+	type generatedFile struct {
+		generator Generator
+		slices    []*setup.Slice
+		pkg       string
+	}
+	files := make(map[string]*generatedFile)
+	var pkgNames []string
+	seenPkgs := make(map[string]bool)
+	for _, slice := range selection.Slices {
+		if !seenPkgs[slice.Package] {
+			seenPkgs[slice.Package] = true
+			pkgNames = append(pkgNames, slice.Package)
+		}
+	}
+	for _, slice := range selection.Slices {
+		for path, info := range slice.Contents {
+			if info.Kind != setup.GeneratePath || info.Generate == setup.GenerateManifest {
+				continue
+			}
+			generator, ok := generators[info.Generate]
+			if !ok {
+				return fmt.Errorf("internal error: no generator for kind %q", info.Generate)
+			}
+			dir := strings.TrimSuffix(path, "**")
+			names := []string{generator.Filename()}
+			pkgs := []string{""}
+			if _, ok := generator.(perPackageGenerator); ok {
+				names, pkgs = nil, pkgNames
+				for _, pkgName := range pkgNames {
+					names = append(names, pkgName+generator.Filename())
+				}
+			}
+			for i, name := range names {
+				relPath := filepath.Join(dir, name)
+				file, ok := files[relPath]
+				if !ok {
+					file = &generatedFile{generator: generator, pkg: pkgs[i]}
+					files[relPath] = file
+				} else if file.generator != generator {
+					return fmt.Errorf("cannot generate %s: requested by different generate kinds", relPath)
+				}
+				file.slices = append(file.slices, slice)
+			}
+		}
+	}
+
+	relPaths := make([]string, 0, len(files))
+	for relPath := range files {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		file := files[relPath]
+		logf("Generating %s...", relPath)
+		absPath := filepath.Join(targetDir, relPath)
+		writer, entry, err := fsutil.CreateWriter(&fsutil.CreateOptions{
+			Path:        absPath,
+			Mode:        manifestMode,
+			MakeParents: true,
+			SHA512:      sha512,
+			FS:          fsys,
+		})
+		if err != nil {
+			return err
+		}
+		err = file.generator.Generate(&GenerateOptions{
+			Path:        absPath,
+			Slices:      file.slices,
+			Selection:   selection,
+			PackageInfo: pkgInfos,
+			Report:      report,
+			Package:     file.pkg,
+		}, writer)
+		closeErr := writer.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("cannot generate %s: %w", relPath, err)
+		}
+		for _, slice := range file.slices {
+			err := report.Add(slice, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
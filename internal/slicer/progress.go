@@ -0,0 +1,76 @@
+package slicer
+
+import (
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// progressTracker accumulates the packages done in every phase and reports
+// them to the RunOptions.Progress callback. A nil tracker does nothing.
+type progressTracker struct {
+	report     func(event *ProgressEvent)
+	sizes      map[string]int64
+	totalBytes int64
+	packages   map[ProgressPhase]int
+	bytes      map[ProgressPhase]int64
+}
+
+func newProgressTracker(report func(event *ProgressEvent), selection *setup.Selection, pkgArchive map[string]archive.Archive) (*progressTracker, error) {
+	tracker := &progressTracker{
+		report:   report,
+		sizes:    make(map[string]int64),
+		packages: make(map[ProgressPhase]int),
+		bytes:    make(map[ProgressPhase]int64),
+	}
+	for _, slice := range selection.Slices {
+		if _, ok := tracker.sizes[slice.Package]; ok {
+			continue
+		}
+		info, err := pkgArchive[slice.Package].Info(slice.Package)
+		if err != nil {
+			return nil, err
+		}
+		tracker.sizes[slice.Package] = info.Size
+		tracker.totalBytes += info.Size
+	}
+	return tracker, nil
+}
+
+func (t *progressTracker) start(phase ProgressPhase, pkg string) {
+	if t == nil {
+		return
+	}
+	event := t.event(phase, pkg)
+	event.Started = true
+	t.report(event)
+}
+
+func (t *progressTracker) path(phase ProgressPhase, pkg, path string) {
+	if t == nil {
+		return
+	}
+	event := t.event(phase, pkg)
+	event.Path = path
+	t.report(event)
+}
+
+func (t *progressTracker) done(phase ProgressPhase, pkg string) {
+	if t == nil {
+		return
+	}
+	t.packages[phase]++
+	t.bytes[phase] += t.sizes[pkg]
+	t.report(t.event(phase, pkg))
+}
+
+// event returns an event for the package with the counts of the phase so far.
+func (t *progressTracker) event(phase ProgressPhase, pkg string) *ProgressEvent {
+	return &ProgressEvent{
+		Phase:         phase,
+		Package:       pkg,
+		Packages:      t.packages[phase],
+		TotalPackages: len(t.sizes),
+		Bytes:         t.bytes[phase],
+		TotalBytes:    t.totalBytes,
+	}
+}
@@ -3,17 +3,22 @@ package slicer
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/deb"
@@ -21,6 +26,7 @@ import (
 	"github.com/canonical/chisel/internal/manifestutil"
 	"github.com/canonical/chisel/internal/scripts"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/public/manifest"
 )
 
 const manifestMode fs.FileMode = 0644
@@ -28,7 +34,171 @@ const manifestMode fs.FileMode = 0644
 type RunOptions struct {
 	Selection *setup.Selection
 	Archives  map[string]archive.Archive
+	// TargetDir is the directory the content is written to. If it already
+	// holds a manifest from a previous run at one of the manifest paths of
+	// the selection, the new manifests record the content of both runs,
+	// which must agree on the paths they have in common.
 	TargetDir string
+	// TargetFS, if set, is the filesystem the content is written to instead
+	// of TargetDir, at the paths it has in the selection (e.g. /etc/foo).
+	// Run fails if any slice of the selection has a mutation script, as
+	// content.read, content.write and content.list work on a directory and
+	// are not routed through TargetFS. For the same reason, slices setting
+	// owners and the options working on the content once written, other
+	// than NormalizePerms and CheckCase, cannot be used with it.
+	TargetFS fsutil.FS
+	// MaxDownloadSize caps the total size in bytes of the packages
+	// fetched from the archives. Zero means no limit.
+	MaxDownloadSize int64
+	// PruneEmptyDirs removes the directories left empty after the removal
+	// of the "until: mutate" paths, unless they are listed explicitly in
+	// the contents of a selected slice.
+	PruneEmptyDirs bool
+	// StripDocs drops the documentation under /usr/share/man, /usr/share/doc
+	// and /usr/share/info matched by wildcards, except for the copyright
+	// files. Paths listed explicitly in slice contents are kept.
+	StripDocs bool
+	// SkipCopyright drops the copyright files under /usr/share/doc matched
+	// by wildcards, which StripDocs keeps, along with the directories left
+	// empty. Copyright files listed explicitly in slice contents are kept.
+	SkipCopyright bool
+	// Changelogs extracts the changelog and NEWS files found in the
+	// /usr/share/doc/<package>/ directory of every package, even if no slice
+	// lists them. They are recorded in the manifests as part of the first
+	// selected slice of the package.
+	Changelogs bool
+	// ModTime, if not zero, is set as the modification time of all the
+	// entries created in the target directory.
+	ModTime time.Time
+	// CheckCase fails if two of the resulting paths differ only in case,
+	// as they would clash on case-insensitive filesystems.
+	CheckCase bool
+	// StripBinaries removes the debug sections from the ELF executables and
+	// shared libraries in the output, using the strip tool from the host.
+	// The manifests record the hashes of the stripped files.
+	StripBinaries bool
+	// TmpDir is the directory under which temporary content is written,
+	// such as the copies of the binaries being stripped. It defaults to the
+	// system temporary directory.
+	TmpDir string
+	// NormalizePerms sets the permissions of all the directories to 0755
+	// and of all the files to either 0755 or 0644, depending on whether
+	// they were executable by their owner. The setuid, setgid and sticky
+	// bits are kept. The manifests record the resulting modes.
+	NormalizePerms bool
+	// PreserveXattrs sets the extended attributes found in the packages,
+	// such as file capabilities, on the extracted content. The manifests
+	// record them.
+	PreserveXattrs bool
+	// IgnoreOwners logs, instead of failing, when the owner of a path
+	// cannot be set to the uid or gid given in its slice, such as when
+	// running without the privilege to do so. The manifests only record
+	// the owners which were set.
+	IgnoreOwners bool
+	// FetchWorkers is the maximum number of packages fetched concurrently.
+	// It defaults to GOMAXPROCS. Packages are extracted one at a time, in
+	// the selection order, regardless.
+	FetchWorkers int
+	// ManifestSchema is the schema version of the generated manifests. It
+	// defaults to the current manifest schema, or to the schema of the prior
+	// manifest when one is merged.
+	ManifestSchema string
+	// HashAlgorithms lists the digests recorded in the manifests for
+	// packages and paths. The sha256 digests are always recorded, and
+	// "sha512" may be listed to record those as well.
+	HashAlgorithms []string
+	// Progress, if set, is called whenever a package starts being fetched
+	// or extracted and once it is done, and for every path created while
+	// extracting it.
+	Progress func(event *ProgressEvent)
+}
+
+// ProgressPhase identifies the stage of Run a ProgressEvent refers to.
+type ProgressPhase string
+
+const (
+	FetchPhase   ProgressPhase = "fetch"
+	ExtractPhase ProgressPhase = "extract"
+)
+
+// ProgressEvent reports that a package went through a phase of Run.
+type ProgressEvent struct {
+	Phase   ProgressPhase
+	Package string
+	// Started is set when the package is starting the phase rather than
+	// done with it, in which case it is not yet counted in Packages.
+	Started bool
+	// Path is set, while the package is being extracted, to the path just
+	// created in the target directory. It ends with a slash for directories.
+	Path string
+	// Packages counts the packages done in the phase so far, including
+	// this one, out of TotalPackages.
+	Packages      int
+	TotalPackages int
+	// Bytes is the size of those packages according to the archive
+	// index, out of TotalBytes.
+	Bytes      int64
+	TotalBytes int64
+}
+
+type fetchResult struct {
+	reader io.ReadSeekCloser
+	info   *archive.PackageInfo
+	err    error
+}
+
+// fetchPackages fetches the packages from their archives with up to
+// workers of them in flight, and returns the results in the same order.
+// Once a fetch fails, the packages not yet started are skipped, and the
+// error of the first package failing in order is returned along with the
+// results, whose readers must be closed.
+func fetchPackages(pkgNames []string, pkgArchive map[string]archive.Archive, workers int, progress *progressTracker) ([]fetchResult, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	results := make([]fetchResult, len(pkgNames))
+	indexes := make(chan int)
+	var mu sync.Mutex
+	var failed bool
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(pkgNames); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				pkgName := pkgNames[i]
+				mu.Lock()
+				skip := failed
+				mu.Unlock()
+				if skip {
+					continue
+				}
+				mu.Lock()
+				progress.start(FetchPhase, pkgName)
+				mu.Unlock()
+				reader, info, err := pkgArchive[pkgName].Fetch(pkgName)
+				results[i] = fetchResult{reader, info, err}
+				mu.Lock()
+				if err != nil {
+					failed = true
+				} else {
+					progress.done(FetchPhase, pkgName)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range pkgNames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for _, result := range results {
+		if result.err != nil {
+			return results, result.err
+		}
+	}
+	return results, nil
 }
 
 type pathData struct {
@@ -51,6 +221,9 @@ func (cc *contentChecker) checkMutable(path string) error {
 	return nil
 }
 
+// checkKnown checks that path may be read or listed by mutation scripts.
+// Paths marked with "until: mutate" are known as well, since they are only
+// removed after all the scripts have run.
 func (cc *contentChecker) checkKnown(path string) error {
 	var err error
 	if _, ok := cc.knownPaths[path]; !ok {
@@ -81,13 +254,45 @@ func Run(options *RunOptions) error {
 		syscall.Umask(oldUmask)
 	}()
 
-	targetDir := filepath.Clean(options.TargetDir)
-	if !filepath.IsAbs(targetDir) {
-		dir, err := os.Getwd()
+	fsys := fsutil.HostFS
+	var targetDir string
+	if options.TargetFS != nil {
+		err := checkTargetFS(options)
 		if err != nil {
-			return fmt.Errorf("cannot obtain current directory: %w", err)
+			return err
+		}
+		// Paths are created in the filesystem as they are in its root.
+		fsys = options.TargetFS
+		targetDir = "/"
+	} else {
+		targetDir = filepath.Clean(options.TargetDir)
+		if !filepath.IsAbs(targetDir) {
+			dir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("cannot obtain current directory: %w", err)
+			}
+			targetDir = filepath.Join(dir, targetDir)
+		}
+	}
+
+	var prior *manifest.Manifest
+	if options.TargetFS == nil {
+		var err error
+		prior, err = readPriorManifest(targetDir, options.Selection)
+		if err != nil {
+			return err
+		}
+	}
+
+	var sha512 bool
+	for _, algorithm := range options.HashAlgorithms {
+		switch algorithm {
+		case "sha256":
+		case "sha512":
+			sha512 = true
+		default:
+			return fmt.Errorf("unsupported hash algorithm %q", algorithm)
 		}
-		targetDir = filepath.Join(dir, targetDir)
 	}
 
 	pkgArchive, err := selectPkgArchives(options.Archives, options.Selection)
@@ -118,8 +323,9 @@ func Run(options *RunOptions) error {
 					sourcePath = targetPath
 				}
 				extractPackage[sourcePath] = append(extractPackage[sourcePath], deb.ExtractInfo{
-					Path:    targetPath,
-					Context: slice,
+					Path:      targetPath,
+					Context:   slice,
+					Recursive: pathInfo.IsDirCopy(),
 				})
 			} else {
 				// When the content is not extracted from the package (i.e. path is
@@ -136,21 +342,67 @@ func Run(options *RunOptions) error {
 			}
 		}
 	}
+	if options.Changelogs {
+		seen := make(map[string]bool)
+		for _, slice := range options.Selection.Slices {
+			if seen[slice.Package] {
+				continue
+			}
+			seen[slice.Package] = true
+			for _, name := range changelogNames {
+				docPath := "/usr/share/doc/" + slice.Package + "/" + name
+				extract[slice.Package][docPath] = append(extract[slice.Package][docPath], deb.ExtractInfo{
+					Path:     docPath,
+					Context:  packageDoc{slice},
+					Optional: true,
+				})
+			}
+		}
+	}
 
-	// Fetch all packages, using the selection order.
-	packages := make(map[string]io.ReadSeekCloser)
-	var pkgInfos []*archive.PackageInfo
+	var progress *progressTracker
+	if options.Progress != nil {
+		progress, err = newProgressTracker(options.Progress, options.Selection, pkgArchive)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Fetch all packages, recording them in the selection order.
+	var pkgOrder []string
+	seenPkgs := make(map[string]bool)
+	var downloadSize int64
 	for _, slice := range options.Selection.Slices {
-		if packages[slice.Package] != nil {
+		if seenPkgs[slice.Package] {
 			continue
 		}
-		reader, info, err := pkgArchive[slice.Package].Fetch(slice.Package)
-		if err != nil {
-			return err
+		seenPkgs[slice.Package] = true
+		if options.MaxDownloadSize > 0 {
+			info, err := pkgArchive[slice.Package].Info(slice.Package)
+			if err != nil {
+				return err
+			}
+			downloadSize += info.Size
+			if downloadSize > options.MaxDownloadSize {
+				return fmt.Errorf("cannot fetch package %q: total download size exceeds %d bytes", slice.Package, options.MaxDownloadSize)
+			}
+		}
+		pkgOrder = append(pkgOrder, slice.Package)
+	}
+	fetched, err := fetchPackages(pkgOrder, pkgArchive, options.FetchWorkers, progress)
+	for _, result := range fetched {
+		if result.reader != nil {
+			defer result.reader.Close()
 		}
-		defer reader.Close()
-		packages[slice.Package] = reader
-		pkgInfos = append(pkgInfos, info)
+	}
+	if err != nil {
+		return err
+	}
+	packages := make(map[string]io.ReadSeekCloser)
+	var pkgInfos []*archive.PackageInfo
+	for i, result := range fetched {
+		packages[pkgOrder[i]] = result.reader
+		pkgInfos = append(pkgInfos, result.info)
 	}
 
 	// When creating content, record if a path is known and whether they are
@@ -162,13 +414,50 @@ func Run(options *RunOptions) error {
 		return fmt.Errorf("internal error: cannot create report: %w", err)
 	}
 
+	// Parent directories of the documentation dropped with StripDocs or
+	// SkipCopyright.
+	strippedDirs := make(map[string]bool)
+
+	// The package being extracted, for reporting progress.
+	var extracting string
+
 	// Creates the filesystem entry and adds it to the report. It also updates
 	// knownPaths with the files created.
 	create := func(extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+		if (options.StripDocs || options.SkipCopyright) && len(extractInfos) > 0 && !o.Mode.IsDir() {
+			relPath := filepath.Clean("/" + strings.TrimPrefix(o.Path, targetDir))
+			if docDir, ok := strippedDocDir(relPath, extractInfos, options); ok {
+				// The directories are removed after extraction if
+				// nothing else is left in them.
+				for dir := filepath.Dir(relPath) + "/"; len(dir) >= len(docDir); dir = filepath.Dir(strings.TrimSuffix(dir, "/")) + "/" {
+					strippedDirs[dir] = true
+				}
+				return nil
+			}
+		}
+		for _, extractInfo := range extractInfos {
+			slice, ok := extractInfo.Context.(*setup.Slice)
+			if !ok {
+				continue
+			}
+			err := createParents(fsys, targetDir, o.Path, slice.Contents[extractInfo.Path], slice, report)
+			if err != nil {
+				return err
+			}
+		}
+		o.SHA512 = sha512
+		o.FS = fsys
 		entry, err := fsutil.Create(o)
 		if err != nil {
 			return err
 		}
+		if progress != nil {
+			createdPath := filepath.Clean("/" + strings.TrimPrefix(o.Path, targetDir))
+			if o.Mode.IsDir() && createdPath != "/" {
+				createdPath += "/"
+			}
+			progress.path(ExtractPhase, extracting, createdPath)
+		}
 		// Content created was not listed in a slice contents because extractInfo
 		// is empty.
 		if len(extractInfos) == 0 {
@@ -186,21 +475,27 @@ func Run(options *RunOptions) error {
 			if extractInfo.Context == nil {
 				continue
 			}
-			slice, ok := extractInfo.Context.(*setup.Slice)
-			if !ok {
+			var slice *setup.Slice
+			var pathInfo setup.PathInfo
+			switch context := extractInfo.Context.(type) {
+			case *setup.Slice:
+				slice = context
+				var ok bool
+				pathInfo, ok = slice.Contents[extractInfo.Path]
+				if !ok {
+					return fmt.Errorf("internal error: path %q not listed in slice contents", extractInfo.Path)
+				}
+			case packageDoc:
+				slice = context.slice
+				pathInfo = setup.PathInfo{Kind: setup.GlobPath}
+			default:
 				return fmt.Errorf("internal error: invalid Context of type %T in extractInfo", extractInfo.Context)
 			}
-			pathInfo, ok := slice.Contents[extractInfo.Path]
-			if !ok {
-				return fmt.Errorf("internal error: path %q not listed in slice contents", extractInfo.Path)
-			}
 			inSliceContents = true
 			mutable = mutable || pathInfo.Mutable
+			until = mergeUntil(until, pathInfo.Until)
+			// Do not add paths which are removed with "until".
 			if pathInfo.Until == setup.UntilNone {
-				until = setup.UntilNone
-			}
-			// Do not add paths with "until: mutate".
-			if pathInfo.Until != setup.UntilMutate {
 				err := report.Add(slice, entry)
 				if err != nil {
 					return err
@@ -220,30 +515,47 @@ func Run(options *RunOptions) error {
 	}
 
 	// Extract all packages, also using the selection order.
+	pkgStats := make(map[string]*deb.ExtractStats)
 	for _, slice := range options.Selection.Slices {
 		reader := packages[slice.Package]
 		if reader == nil {
 			continue
 		}
+		stats := &deb.ExtractStats{}
+		pkgStats[slice.Package] = stats
+		extracting = slice.Package
+		progress.start(ExtractPhase, slice.Package)
 		err := deb.Extract(reader, &deb.ExtractOptions{
 			Package:   slice.Package,
 			Extract:   extract[slice.Package],
 			TargetDir: targetDir,
 			Create:    create,
+			Stats:     stats,
+
+			PreserveXattrs: options.PreserveXattrs,
 		})
 		reader.Close()
 		packages[slice.Package] = nil
 		if err != nil {
 			return err
 		}
+		progress.done(ExtractPhase, slice.Package)
+	}
+	if len(strippedDirs) > 0 {
+		err := removeEmptyDirs(targetDir, strippedDirs, knownPaths, options.Selection, report)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Create new content not extracted from packages, e.g. TextPath or DirPath
 	// with {make: true}. The only exception is the manifest which will be created
 	// later.
 	// First group them by their relative path. Then create them and attribute
-	// them to the appropriate slices.
+	// them to the appropriate slices. Hard links are created last, once their
+	// targets exist.
 	relPaths := map[string][]*setup.Slice{}
+	hardLinks := map[string][]*setup.Slice{}
 	for _, slice := range options.Selection.Slices {
 		arch := pkgArchive[slice.Package].Options().Arch
 		for relPath, pathInfo := range slice.Contents {
@@ -254,43 +566,20 @@ func Run(options *RunOptions) error {
 				pathInfo.Kind == setup.GeneratePath {
 				continue
 			}
+			if pathInfo.Kind == setup.HardLinkPath {
+				hardLinks[relPath] = append(hardLinks[relPath], slice)
+				continue
+			}
 			relPaths[relPath] = append(relPaths[relPath], slice)
 		}
 	}
-	for relPath, slices := range relPaths {
-		until := setup.UntilMutate
-		for _, slice := range slices {
-			if slice.Contents[relPath].Until == setup.UntilNone {
-				until = setup.UntilNone
-				break
-			}
-		}
-		// It is okay to take the first pathInfo because the release has been
-		// validated when read and there are no conflicts. The only field that
-		// was not checked was until because it is not used for conflict
-		// validation.
-		pathInfo := slices[0].Contents[relPath]
-		pathInfo.Until = until
-		data := pathData{
-			until:   pathInfo.Until,
-			mutable: pathInfo.Mutable,
-		}
-		addKnownPath(knownPaths, relPath, data)
-		targetPath := filepath.Join(targetDir, relPath)
-		entry, err := createFile(targetPath, pathInfo)
-		if err != nil {
-			return err
-		}
-
-		// Do not add paths with "until: mutate".
-		if pathInfo.Until != setup.UntilMutate {
-			for _, slice := range slices {
-				err = report.Add(slice, entry)
-				if err != nil {
-					return err
-				}
-			}
-		}
+	err = createPaths(fsys, targetDir, relPaths, knownPaths, report, sha512)
+	if err != nil {
+		return err
+	}
+	err = createPaths(fsys, targetDir, hardLinks, knownPaths, report, sha512)
+	if err != nil {
+		return err
 	}
 
 	// Run mutation scripts. Order is fundamental here as
@@ -301,31 +590,328 @@ func Run(options *RunOptions) error {
 		CheckWrite: checker.checkMutable,
 		CheckRead:  checker.checkKnown,
 		OnWrite:    report.Mutate,
+		SHA512:     sha512,
+	}
+	selectedPkgs := make(map[string]*archive.PackageInfo, len(pkgInfos))
+	for i, info := range pkgInfos {
+		selectedPkgs[pkgOrder[i]] = info
 	}
 	for _, slice := range options.Selection.Slices {
-		opts := scripts.RunOptions{
-			Label:  "mutate",
-			Script: slice.Scripts.Mutate,
-			Namespace: map[string]scripts.Value{
-				"content": content,
-			},
-		}
-		err := scripts.Run(&opts)
+		// All the phases of a slice run before the next slice, each one
+		// on its own.
+		for i, script := range slice.Scripts.Mutate {
+			label := "mutate"
+			if len(slice.Scripts.Mutate) > 1 {
+				label = fmt.Sprintf("mutate[%d]", i+1)
+			}
+			opts := scripts.RunOptions{
+				Label:  label,
+				Script: script,
+				Namespace: map[string]scripts.Value{
+					"content": content,
+					"pkg": &scripts.PackageValue{
+						Name:     slice.Package,
+						Packages: selectedPkgs,
+					},
+				},
+			}
+			err := scripts.Run(&opts)
+			if err != nil {
+				return fmt.Errorf("slice %s: %w", slice, err)
+			}
+		}
+	}
+
+	err = removeUntil(fsys, targetDir, knownPaths, setup.UntilMutate)
+	if err != nil {
+		return err
+	}
+	if options.PruneEmptyDirs {
+		err = pruneEmptyDirs(targetDir, knownPaths, setup.UntilMutate, options.Selection, report)
 		if err != nil {
-			return fmt.Errorf("slice %s: %w", slice, err)
+			return err
 		}
 	}
 
-	err = removeAfterMutate(targetDir, knownPaths)
+	err = checkSymlinkLoops(report)
 	if err != nil {
 		return err
 	}
+	if options.CheckCase {
+		err = checkCaseConflicts(knownPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.StripBinaries {
+		err = stripBinaries(targetDir, options.TmpDir, report)
+		if err != nil {
+			return err
+		}
+	}
 
-	return generateManifests(targetDir, options.Selection, report, pkgInfos)
+	if options.NormalizePerms {
+		err = normalizePerms(fsys, targetDir, knownPaths, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setOwners(targetDir, options.Selection, pkgArchive, report, options.IgnoreOwners)
+	if err != nil {
+		return err
+	}
+
+	err = generateFiles(fsys, targetDir, options.Selection, report, pkgInfos, sha512)
+	if err != nil {
+		return err
+	}
+
+	// Paths with "until: end" are kept for all the processing above and only
+	// removed right before the manifest is generated.
+	err = removeUntil(fsys, targetDir, knownPaths, setup.UntilEnd)
+	if err != nil {
+		return err
+	}
+	if options.PruneEmptyDirs {
+		err = pruneEmptyDirs(targetDir, knownPaths, setup.UntilEnd, options.Selection, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = generateManifests(fsys, targetDir, options.ManifestSchema, sha512, options.Selection, report, pkgInfos, pkgStats, prior)
+	if err != nil {
+		return err
+	}
+
+	if !options.ModTime.IsZero() {
+		err = setModTimes(targetDir, knownPaths, report, options.ModTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var docDirs = []string{"/usr/share/man/", "/usr/share/doc/", "/usr/share/info/"}
+
+// changelogNames are the files extracted from the documentation directory
+// of every package with RunOptions.Changelogs.
+var changelogNames = []string{"changelog*", "NEWS*"}
+
+// packageDoc is the Context of the paths extracted with RunOptions.Changelogs,
+// which are reported as part of slice.
+type packageDoc struct {
+	slice *setup.Slice
+}
+
+// strippedDocDir returns the documentation directory holding path if it is
+// to be dropped, which is the case for copyright files with
+// RunOptions.SkipCopyright and for the rest of the documentation with
+// RunOptions.StripDocs, as long as none of the extractInfos lists the path
+// explicitly.
+func strippedDocDir(path string, extractInfos []deb.ExtractInfo, options *RunOptions) (docDir string, ok bool) {
+	for _, dir := range docDirs {
+		if strings.HasPrefix(path, dir) {
+			docDir = dir
+			break
+		}
+	}
+	if docDir == "" {
+		return "", false
+	}
+	copyright := false
+	if rest, ok := strings.CutPrefix(path, "/usr/share/doc/"); ok {
+		pkg, file, _ := strings.Cut(rest, "/")
+		copyright = pkg != "" && file == "copyright"
+	}
+	if copyright && !options.SkipCopyright || !copyright && !options.StripDocs {
+		return "", false
+	}
+	for _, extractInfo := range extractInfos {
+		if _, ok := extractInfo.Context.(packageDoc); ok || !strings.ContainsAny(extractInfo.Path, "*?") {
+			return "", false
+		}
+	}
+	return docDir, true
 }
 
-func generateManifests(targetDir string, selection *setup.Selection,
-	report *manifestutil.Report, pkgInfos []*archive.PackageInfo) error {
+// setModTimes sets mtime as the access and modification times of the known
+// paths and of the paths in the report, including their parent directories.
+// Symbolic links are not followed.
+func setModTimes(rootDir string, knownPaths map[string]pathData, report *manifestutil.Report, mtime time.Time) error {
+	paths := make(map[string]bool)
+	for path := range knownPaths {
+		paths[path] = true
+	}
+	for path := range report.Entries {
+		paths[path] = true
+		for dir := filepath.Dir(filepath.Clean(path)); dir != "/"; dir = filepath.Dir(dir) {
+			paths[dir+"/"] = true
+		}
+	}
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	for path := range paths {
+		realPath := filepath.Join(rootDir, path)
+		err := unix.UtimesNanoAt(unix.AT_FDCWD, realPath, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+		if os.IsNotExist(err) {
+			// Removed after mutation.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set modification time of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// normalizePerms applies the permissions described in
+// RunOptions.NormalizePerms to the paths created in rootDir and their parent
+// directories, and updates the report with the new modes.
+func normalizePerms(fsys fsutil.FS, rootDir string, knownPaths map[string]pathData, report *manifestutil.Report) error {
+	paths := make(map[string]bool)
+	for path := range knownPaths {
+		paths[path] = true
+	}
+	for path := range report.Entries {
+		paths[path] = true
+		for dir := filepath.Dir(filepath.Clean(path)); dir != "/"; dir = filepath.Dir(dir) {
+			paths[dir+"/"] = true
+		}
+	}
+	for path := range paths {
+		realPath := filepath.Join(rootDir, path)
+		info, err := fsys.Lstat(realPath)
+		if os.IsNotExist(err) {
+			// Removed after mutation.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot normalize permissions of %s: %w", path, err)
+		}
+		mode := normalizedMode(info.Mode())
+		if mode == info.Mode() {
+			continue
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			err = fsys.Chmod(realPath, mode)
+			if err != nil {
+				return fmt.Errorf("cannot normalize permissions of %s: %w", path, err)
+			}
+		}
+		if entry, ok := report.Entries[path]; ok {
+			entry.Mode = mode
+			report.Entries[path] = entry
+		}
+	}
+	return nil
+}
+
+// setOwners changes the owner of the paths of the selected slices which have
+// a uid or gid set, and records them in the report. Symbolic links are not
+// followed.
+func setOwners(rootDir string, selection *setup.Selection, pkgArchive map[string]archive.Archive,
+	report *manifestutil.Report, ignoreErrors bool) error {
+	for _, slice := range selection.Slices {
+		arch := pkgArchive[slice.Package].Options().Arch
+		for relPath, pathInfo := range slice.Contents {
+			if pathInfo.UID == nil && pathInfo.GID == nil {
+				continue
+			}
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+				continue
+			}
+			uid, gid := -1, -1
+			if pathInfo.UID != nil {
+				uid = *pathInfo.UID
+			}
+			if pathInfo.GID != nil {
+				gid = *pathInfo.GID
+			}
+			err := os.Lchown(filepath.Join(rootDir, relPath), uid, gid)
+			if os.IsNotExist(err) {
+				// Removed after mutation.
+				continue
+			}
+			if err != nil {
+				if ignoreErrors && errors.Is(err, fs.ErrPermission) {
+					logf("Cannot set owner of %s: %v", relPath, err)
+					continue
+				}
+				return fmt.Errorf("cannot set owner of %s: %w", relPath, err)
+			}
+			if entry, ok := report.Entries[relPath]; ok {
+				// Changing the owner drops file capabilities.
+				for name, value := range entry.Xattrs {
+					err := unix.Setxattr(filepath.Join(rootDir, relPath), name, []byte(value), 0)
+					if err != nil {
+						return fmt.Errorf("cannot set extended attribute %s of %s: %w", name, relPath, err)
+					}
+				}
+				entry.UID = pathInfo.UID
+				entry.GID = pathInfo.GID
+				report.Entries[relPath] = entry
+			}
+		}
+	}
+	return nil
+}
+
+// normalizedMode returns the mode for RunOptions.NormalizePerms. Symlinks
+// and other special files are left as they are.
+func normalizedMode(mode fs.FileMode) fs.FileMode {
+	var perm fs.FileMode
+	switch {
+	case mode.IsDir():
+		perm = 0755
+	case mode.IsRegular() && mode&0100 != 0:
+		perm = 0755
+	case mode.IsRegular():
+		perm = 0644
+	default:
+		return mode
+	}
+	return mode&^fs.ModePerm | perm
+}
+
+// readPriorManifest reads the manifest left in targetDir by a previous run at
+// the first of the manifest paths of selection found, if any.
+func readPriorManifest(targetDir string, selection *setup.Selection) (*manifest.Manifest, error) {
+	var relPaths []string
+	for relPath := range manifestutil.FindPaths(selection.Slices) {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		f, err := os.Open(filepath.Join(targetDir, relPath))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read prior manifest: %w", err)
+		}
+		defer f.Close()
+		r, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read prior manifest: %w", err)
+		}
+		defer r.Close()
+		mfest, err := manifest.Read(r)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read prior manifest: %w", err)
+		}
+		logf("Merging prior manifest at %s...", relPath)
+		return mfest, nil
+	}
+	return nil, nil
+}
+
+// generateManifests writes the manifests of the selection. If prior is set,
+// the content it records is merged into them.
+func generateManifests(fsys fsutil.FS, targetDir string, schema string, sha512 bool, selection *setup.Selection,
+	report *manifestutil.Report, pkgInfos []*archive.PackageInfo, pkgStats map[string]*deb.ExtractStats, prior *manifest.Manifest) error {
 	manifestSlices := manifestutil.FindPaths(selection.Slices)
 	if len(manifestSlices) == 0 {
 		// Nothing to do.
@@ -339,6 +925,7 @@ func generateManifests(targetDir string, selection *setup.Selection,
 			Path:        absPath,
 			Mode:        manifestMode,
 			MakeParents: true,
+			FS:          fsys,
 		}
 		writer, info, err := fsutil.CreateWriter(createOptions)
 		if err != nil {
@@ -358,28 +945,62 @@ func generateManifests(targetDir string, selection *setup.Selection,
 		return err
 	}
 	defer w.Close()
+	if prior != nil {
+		err := report.Merge(prior)
+		if err != nil {
+			return err
+		}
+		rewriteOptions := &manifestutil.RewriteOptions{
+			Manifest:     prior,
+			PackageInfo:  pkgInfos,
+			PackageStats: pkgStats,
+			Selection:    selection.Slices,
+			Report:       report,
+			Schema:       schema,
+			SHA512:       sha512,
+			Prefers:      selection.Prefers(),
+		}
+		return manifestutil.Rewrite(rewriteOptions, w)
+	}
 	writeOptions := &manifestutil.WriteOptions{
-		PackageInfo: pkgInfos,
-		Selection:   selection.Slices,
-		Report:      report,
+		PackageInfo:  pkgInfos,
+		PackageStats: pkgStats,
+		Selection:    selection.Slices,
+		Report:       report,
+		Schema:       schema,
+		SHA512:       sha512,
+		Prefers:      selection.Prefers(),
 	}
 	err = manifestutil.Write(writeOptions, w)
 	return err
 }
 
-// removeAfterMutate removes entries marked with until: mutate. A path is marked
-// only when all slices that refer to the path mark it with until: mutate.
-func removeAfterMutate(rootDir string, knownPaths map[string]pathData) error {
+// mergeUntil returns the phase until which a path must be kept when it is
+// listed with both a and b. Paths are removed at the earliest at "mutate",
+// then at "end", and not at all if any slice does not remove them.
+func mergeUntil(a, b setup.PathUntil) setup.PathUntil {
+	if a == setup.UntilNone || b == setup.UntilNone {
+		return setup.UntilNone
+	}
+	if a == setup.UntilEnd || b == setup.UntilEnd {
+		return setup.UntilEnd
+	}
+	return setup.UntilMutate
+}
+
+// removeUntil removes entries marked with the given until phase. A path is
+// marked only when all slices that refer to the path mark it with until.
+func removeUntil(fsys fsutil.FS, rootDir string, knownPaths map[string]pathData, until setup.PathUntil) error {
 	var untilDirs []string
 	for path, data := range knownPaths {
-		if data.until != setup.UntilMutate {
+		if data.until != until {
 			continue
 		}
 		realPath := filepath.Join(rootDir, path)
 		if strings.HasSuffix(path, "/") {
 			untilDirs = append(untilDirs, realPath)
 		} else {
-			err := os.Remove(realPath)
+			err := fsys.Remove(realPath)
 			if err != nil {
 				return fmt.Errorf("cannot perform 'until' removal: %w", err)
 			}
@@ -391,7 +1012,7 @@ func removeAfterMutate(rootDir string, knownPaths map[string]pathData) error {
 		return untilDirs[i] > untilDirs[j]
 	})
 	for _, realPath := range untilDirs {
-		err := os.Remove(realPath)
+		err := fsys.Remove(realPath)
 		// The non-empty directory error is caught by IsExist as well.
 		if err != nil && !os.IsExist(err) {
 			return fmt.Errorf("cannot perform 'until' removal: %#v", err)
@@ -400,6 +1021,147 @@ func removeAfterMutate(rootDir string, knownPaths map[string]pathData) error {
 	return nil
 }
 
+// pruneEmptyDirs removes the parent directories of the paths marked with the
+// given until phase which are empty after their removal, and drops them from
+// the report. Directories listed explicitly in the contents of a slice are kept.
+func pruneEmptyDirs(rootDir string, knownPaths map[string]pathData, until setup.PathUntil, selection *setup.Selection, report *manifestutil.Report) error {
+	candidates := make(map[string]bool)
+	for path, data := range knownPaths {
+		if data.until != until {
+			continue
+		}
+		for dir := filepath.Dir(strings.TrimSuffix(path, "/")); dir != "/"; dir = filepath.Dir(dir) {
+			candidates[dir+"/"] = true
+		}
+	}
+	return removeEmptyDirs(rootDir, candidates, knownPaths, selection, report)
+}
+
+// removeEmptyDirs removes the candidate directories which are empty, deepest
+// first, and drops them from knownPaths and from the report. Directories
+// listed explicitly in the contents of a slice are kept.
+func removeEmptyDirs(rootDir string, candidates map[string]bool, knownPaths map[string]pathData, selection *setup.Selection, report *manifestutil.Report) error {
+	explicit := make(map[string]bool)
+	for _, slice := range selection.Slices {
+		for path := range slice.Contents {
+			if strings.HasSuffix(path, "/") {
+				explicit[path] = true
+			}
+		}
+	}
+	var dirs []string
+	for dir := range candidates {
+		if !explicit[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	// Deepest directories first, so their parents may be empty once they
+	// are removed.
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i] > dirs[j]
+	})
+	for _, dir := range dirs {
+		realPath := filepath.Join(rootDir, dir)
+		entries, err := os.ReadDir(realPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot prune empty directory: %w", err)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		err = os.Remove(realPath)
+		if err != nil {
+			return fmt.Errorf("cannot prune empty directory: %w", err)
+		}
+		delete(knownPaths, dir)
+		delete(report.Entries, dir)
+	}
+	return nil
+}
+
+// checkCaseConflicts returns an error if two of the known paths differ only in
+// case, in which case one would overwrite the other on a case-insensitive
+// filesystem. Paths removed with "until" are not considered.
+func checkCaseConflicts(knownPaths map[string]pathData) error {
+	paths := make([]string, 0, len(knownPaths))
+	for path, data := range knownPaths {
+		if data.until != setup.UntilNone {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	folded := make(map[string]string, len(paths))
+	for _, path := range paths {
+		key := strings.ToLower(strings.TrimSuffix(path, "/"))
+		if other, ok := folded[key]; ok {
+			return fmt.Errorf("paths %s and %s differ only in case", other, path)
+		}
+		folded[key] = path
+	}
+	return nil
+}
+
+// checkSymlinkLoops returns an error if following the symlinks in the report
+// may lead to an endless loop.
+func checkSymlinkLoops(report *manifestutil.Report) error {
+	links := make(map[string]string)
+	for path, entry := range report.Entries {
+		if entry.Mode&fs.ModeSymlink != 0 {
+			links[path] = entry.Link
+		}
+	}
+	paths := make([]string, 0, len(links))
+	for path := range links {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		loop := findSymlinkLoop(links, path)
+		if loop != nil {
+			return fmt.Errorf("symlink loop detected: %s", strings.Join(loop, " -> "))
+		}
+	}
+	return nil
+}
+
+// findSymlinkLoop resolves path using links, which maps the symlink paths to
+// their targets, and returns the symlinks forming a loop, if any.
+func findSymlinkLoop(links map[string]string, path string) []string {
+	var chain []string
+	visited := make(map[string]int)
+	for {
+		// Find the first symlink in the path, which may be a parent
+		// directory of the final entry.
+		link := ""
+		prefix := ""
+		for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+			prefix += "/" + name
+			if _, ok := links[prefix]; ok {
+				link = prefix
+				break
+			}
+		}
+		if link == "" {
+			return nil
+		}
+		if i, ok := visited[link]; ok {
+			return append(chain[i:], link)
+		}
+		visited[link] = len(chain)
+		chain = append(chain, link)
+
+		target := links[link]
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(link), target)
+		}
+		path = filepath.Join(target, strings.TrimPrefix(path, link))
+	}
+}
+
 // addKnownPath adds a path with its data to the list of known paths. Then it
 // records that the parent directories of the path are also known.
 func addKnownPath(knownPaths map[string]pathData, path string, data pathData) {
@@ -426,7 +1188,58 @@ func addKnownPath(knownPaths map[string]pathData, path string, data pathData) {
 	}
 }
 
-func createFile(targetPath string, pathInfo setup.PathInfo) (*fsutil.Entry, error) {
+// createPaths creates the content not extracted from packages at relPaths,
+// which are grouped by their relative path, and attributes it to the slices.
+// Paths are created in order so that hard link identifiers are deterministic.
+func createPaths(fsys fsutil.FS, targetDir string, relPaths map[string][]*setup.Slice, knownPaths map[string]pathData, report *manifestutil.Report, sha512 bool) error {
+	sortedPaths := make([]string, 0, len(relPaths))
+	for relPath := range relPaths {
+		sortedPaths = append(sortedPaths, relPath)
+	}
+	sort.Strings(sortedPaths)
+	for _, relPath := range sortedPaths {
+		slices := relPaths[relPath]
+		until := setup.UntilMutate
+		for _, slice := range slices {
+			until = mergeUntil(until, slice.Contents[relPath].Until)
+		}
+		// It is okay to take the first pathInfo because the release has been
+		// validated when read and there are no conflicts. The only field that
+		// was not checked was until because it is not used for conflict
+		// validation.
+		pathInfo := slices[0].Contents[relPath]
+		pathInfo.Until = until
+		data := pathData{
+			until:   pathInfo.Until,
+			mutable: pathInfo.Mutable,
+		}
+		addKnownPath(knownPaths, relPath, data)
+		targetPath := filepath.Join(targetDir, relPath)
+		for _, slice := range slices {
+			err := createParents(fsys, targetDir, targetPath, pathInfo, slice, report)
+			if err != nil {
+				return err
+			}
+		}
+		entry, err := createFile(fsys, targetDir, targetPath, pathInfo, sha512)
+		if err != nil {
+			return err
+		}
+
+		// Do not add paths which are removed with "until".
+		if pathInfo.Until == setup.UntilNone {
+			for _, slice := range slices {
+				err = report.Add(slice, entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func createFile(fsys fsutil.FS, targetDir, targetPath string, pathInfo setup.PathInfo, sha512 bool) (*fsutil.Entry, error) {
 	targetMode := pathInfo.Mode
 	if targetMode == 0 {
 		if pathInfo.Kind == setup.DirPath {
@@ -449,6 +1262,9 @@ func createFile(targetPath string, pathInfo setup.PathInfo) (*fsutil.Entry, erro
 	case setup.SymlinkPath:
 		tarHeader.Typeflag = tar.TypeSymlink
 		linkTarget = pathInfo.Info
+	case setup.HardLinkPath:
+		tarHeader.Typeflag = tar.TypeReg
+		linkTarget = filepath.Join(targetDir, pathInfo.Info)
 	default:
 		return nil, fmt.Errorf("internal error: cannot extract path of kind %q", pathInfo.Kind)
 	}
@@ -459,9 +1275,93 @@ func createFile(targetPath string, pathInfo setup.PathInfo) (*fsutil.Entry, erro
 		Data:        fileContent,
 		Link:        linkTarget,
 		MakeParents: true,
+		SHA512:      sha512,
+		FS:          fsys,
 	})
 }
 
+// createParents creates the missing parent directories of targetPath under
+// targetDir with the parent mode of pathInfo, if set, and reports them as
+// content of the slice unless the path is removed with "until".
+func createParents(fsys fsutil.FS, targetDir, targetPath string, pathInfo setup.PathInfo, slice *setup.Slice, report *manifestutil.Report) error {
+	if pathInfo.ParentMode == 0 {
+		return nil
+	}
+	rootDir := filepath.Clean(targetDir)
+	var missing []string
+	for dir := filepath.Dir(filepath.Clean(targetPath)); dir != rootDir && strings.HasPrefix(dir, rootDir); dir = filepath.Dir(dir) {
+		_, err := fsys.Lstat(dir)
+		if err == nil {
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, dir)
+	}
+	// Leverage tar handling of mode bits.
+	tarHeader := tar.Header{Typeflag: tar.TypeDir, Mode: int64(pathInfo.ParentMode)}
+	for i := len(missing) - 1; i >= 0; i-- {
+		entry, err := fsutil.Create(&fsutil.CreateOptions{
+			Path: missing[i],
+			Mode: tarHeader.FileInfo().Mode(),
+			FS:   fsys,
+		})
+		if err != nil {
+			return err
+		}
+		if pathInfo.Until == setup.UntilNone {
+			err = report.Add(slice, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkTargetFS returns an error if the options need the content to be
+// written to a target directory rather than to RunOptions.TargetFS.
+func checkTargetFS(options *RunOptions) error {
+	if options.TargetDir != "" {
+		return fmt.Errorf("cannot use both a target directory and a target filesystem")
+	}
+	unsupported := ""
+	switch {
+	case options.PruneEmptyDirs:
+		unsupported = "pruning empty directories"
+	case options.StripDocs:
+		unsupported = "stripping documentation"
+	case options.SkipCopyright:
+		unsupported = "skipping copyright files"
+	case options.StripBinaries:
+		unsupported = "stripping binaries"
+	case options.PreserveXattrs:
+		unsupported = "preserving extended attributes"
+	case !options.ModTime.IsZero():
+		unsupported = "setting modification times"
+	}
+	if unsupported != "" {
+		return fmt.Errorf("cannot write to a target filesystem when %s", unsupported)
+	}
+	for _, slice := range options.Selection.Slices {
+		if len(slice.Scripts.Mutate) > 0 {
+			return fmt.Errorf("cannot write to a target filesystem: slice %s has a mutation script", slice)
+		}
+		for path, info := range slice.Contents {
+			if info.UID != nil || info.GID != nil {
+				return fmt.Errorf("cannot write to a target filesystem: slice %s sets the owner of %s", slice, path)
+			}
+		}
+	}
+	return nil
+}
+
+// PackageArchives returns the archives the packages of the selection are
+// fetched from by Run, indexed by package names.
+func PackageArchives(archives map[string]archive.Archive, selection *setup.Selection) (map[string]archive.Archive, error) {
+	return selectPkgArchives(archives, selection)
+}
+
 // selectPkgArchives selects the highest priority archive containing the package
 // unless a particular archive is pinned within the slice definition file. It
 // returns a map of archives indexed by package names.
@@ -475,9 +1375,7 @@ func selectPkgArchives(archives map[string]archive.Archive, selection *setup.Sel
 		}
 		sortedArchives = append(sortedArchives, archive)
 	}
-	slices.SortFunc(sortedArchives, func(a, b *setup.Archive) int {
-		return b.Priority - a.Priority
-	})
+	slices.SortFunc(sortedArchives, setup.CompareArchives)
 
 	pkgArchive := make(map[string]archive.Archive)
 	for _, s := range selection.Slices {
@@ -2,16 +2,27 @@ package slicer_test
 
 import (
 	"archive/tar"
+	"crypto/sha256"
+	"crypto/sha512"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/archive"
@@ -36,9 +47,27 @@ type slicerTest struct {
 	filesystem    map[string]string
 	manifestPaths map[string]string
 	manifestPkgs  map[string]string
+	progress      []slicer.ProgressEvent
+	check         func(c *C, opts *slicer.RunOptions)
 	error         string
 }
 
+// pathCountGenerator writes the number of paths created so far.
+type pathCountGenerator struct{}
+
+func (pathCountGenerator) Filename() string {
+	return "count.txt"
+}
+
+func (pathCountGenerator) Generate(options *slicer.GenerateOptions, w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d\n", len(options.Report.Entries))
+	return err
+}
+
+func init() {
+	slicer.RegisterGenerator("path-count", pathCountGenerator{})
+}
+
 var packageEntries = map[string][]testutil.TarEntry{
 	"copyright-symlink-libssl3": {
 		{Header: tar.Header{Name: "./"}},
@@ -75,6 +104,17 @@ var testPackageCopyrightEntries = []testutil.TarEntry{
 	testutil.Reg(0644, "./usr/share/doc/test-package/copyright", "copyright"),
 }
 
+// progressOtherData is the second package in the progress test, whose size
+// is reported in the events along with the size of the test package.
+var (
+	progressOtherData = testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Reg(0644, "./file", "data"),
+	})
+	progressOtherSize = int64(len(progressOtherData))
+	progressTotalSize = progressOtherSize + int64(len(testutil.PackageData["test-package"]))
+)
+
 var slicerTests = []slicerTest{{
 	summary: "Basic slicing",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -108,6 +148,128 @@ var slicerTests = []slicerTest{{
 		"/dir/text-file":  "file 0644 5b41362b {test-package_myslice}",
 		"/other-dir/file": "symlink ../dir/file {test-package_myslice}",
 	},
+}, {
+	summary: "Copy of a directory with its content",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/other-dir/copy/: {copy: /parent/}
+		`,
+	},
+	filesystem: map[string]string{
+		"/other-dir/":                      "dir 0755",
+		"/other-dir/copy/":                 "dir 01777",
+		"/other-dir/copy/permissions/":     "dir 0764",
+		"/other-dir/copy/permissions/file": "file 0755 722c14b3",
+	},
+	manifestPaths: map[string]string{
+		"/other-dir/copy/":                 "dir 01777 {test-package_myslice}",
+		"/other-dir/copy/permissions/":     "dir 0764 {test-package_myslice}",
+		"/other-dir/copy/permissions/file": "file 0755 722c14b3 {test-package_myslice}",
+	},
+}, {
+	summary: "Custom generate kind",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/count/**: {generate: path-count}
+		`,
+	},
+	filesystem: map[string]string{
+		"/count/":          "dir 0755",
+		"/count/count.txt": "file 0644 4355a46b",
+		"/dir/":            "dir 0755",
+		"/dir/file":        "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/count/count.txt": "file 0644 4355a46b {test-package_myslice}",
+		"/dir/file":        "file 0644 cc55e2ec {test-package_myslice}",
+	},
+}, {
+	summary: "Generate a file list",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/nested/:
+						/other-dir/file: {symlink: ../dir/file}
+						/index/**: {generate: filelist}
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":               "dir 0755",
+		"/dir/file":           "file 0644 cc55e2ec",
+		"/dir/nested/":        "dir 0755",
+		"/index/":             "dir 0755",
+		"/index/filelist.txt": "file 0644 40383834",
+		"/other-dir/":         "dir 0755",
+		"/other-dir/file":     "symlink ../dir/file",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":           "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/nested/":        "dir 0755 {test-package_myslice}",
+		"/index/filelist.txt": "file 0644 40383834 {test-package_myslice}",
+		"/other-dir/file":     "symlink ../dir/file {test-package_myslice}",
+	},
+}, {
+	summary: "Generate per-package file lists",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/foo/: {make: true}
+						/packages/**: {generate: package-files}
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":                        "dir 0755",
+		"/dir/file":                    "file 0644 cc55e2ec",
+		"/file":                        "file 0644 fc02ca0e",
+		"/foo/":                        "dir 0755",
+		"/packages/":                   "dir 0755",
+		"/packages/other-package.list": "file 0644 54ec9db0",
+		"/packages/test-package.list":  "file 0644 b6166c93",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":                    "file 0644 cc55e2ec {test-package_myslice}",
+		"/file":                        "file 0644 fc02ca0e {other-package_myslice}",
+		"/foo/":                        "dir 0755 {test-package_myslice}",
+		"/packages/other-package.list": "file 0644 54ec9db0 {test-package_myslice}",
+		"/packages/test-package.list":  "file 0644 b6166c93 {test-package_myslice}",
+	},
 }, {
 	summary: "Glob extraction",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -150,6 +312,65 @@ var slicerTests = []slicerTest{{
 	manifestPaths: map[string]string{
 		"/parent/new": "file 0644 5b41362b {test-package_myslice}",
 	},
+}, {
+	summary: "Create new file with text from a file",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/text-file: {text-file: files/data1}
+						/dir/text:      {text: "data1\n"}
+		`,
+		"files/data1": "data1",
+	},
+	filesystem: map[string]string{
+		"/dir/":          "dir 0755",
+		"/dir/text":      "file 0644 3e92ebf1",
+		"/dir/text-file": "file 0644 3e92ebf1",
+	},
+	manifestPaths: map[string]string{
+		"/dir/text":      "file 0644 3e92ebf1 {test-package_myslice}",
+		"/dir/text-file": "file 0644 3e92ebf1 {test-package_myslice}",
+	},
+}, {
+	summary: "Create missing parent directories with a custom mode",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/deep/nested/text: {text: data1, parent-mode: 0700}
+						/dir/file:
+						/dir/new/text:     {text: data1, parent-mode: 0750}
+						/copy/file:        {copy: /dir/file, parent-mode: 01777}
+		`,
+	},
+	filesystem: map[string]string{
+		"/copy/":            "dir 01777",
+		"/copy/file":        "file 0644 cc55e2ec",
+		"/deep/":            "dir 0700",
+		"/deep/nested/":     "dir 0700",
+		"/deep/nested/text": "file 0644 5b41362b",
+		"/dir/":             "dir 0755",
+		"/dir/file":         "file 0644 cc55e2ec",
+		"/dir/new/":         "dir 0750",
+		"/dir/new/text":     "file 0644 5b41362b",
+	},
+	manifestPaths: map[string]string{
+		"/copy/":            "dir 01777 {test-package_myslice}",
+		"/copy/file":        "file 0644 cc55e2ec {test-package_myslice}",
+		"/deep/":            "dir 0700 {test-package_myslice}",
+		"/deep/nested/":     "dir 0700 {test-package_myslice}",
+		"/deep/nested/text": "file 0644 5b41362b {test-package_myslice}",
+		"/dir/file":         "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/new/":         "dir 0750 {test-package_myslice}",
+		"/dir/new/text":     "file 0644 5b41362b {test-package_myslice}",
+	},
 }, {
 	summary: "Create new nested file under extracted directory and preserve parent directory permissions",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -270,6 +491,133 @@ var slicerTests = []slicerTest{{
 	manifestPaths: map[string]string{
 		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
 	},
+}, {
+	summary: "Copyright matched by wildcards is kept by default",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageCopyrightEntries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/usr/share/doc/*/*:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":                                 "dir 0755",
+		"/dir/file":                             "file 0644 cc55e2ec",
+		"/usr/":                                 "dir 0755",
+		"/usr/share/":                           "dir 0755",
+		"/usr/share/doc/":                       "dir 0755",
+		"/usr/share/doc/test-package/":          "dir 0755",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":                             "file 0644 cc55e2ec {test-package_myslice}",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa {test-package_myslice}",
+	},
+}, {
+	summary: "SkipCopyright drops copyright matched by wildcards",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageCopyrightEntries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/usr/share/doc/*/*:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SkipCopyright = true
+	},
+	filesystem: map[string]string{
+		"/dir/":       "dir 0755",
+		"/dir/file":   "file 0644 cc55e2ec",
+		"/usr/":       "dir 0755",
+		"/usr/share/": "dir 0755",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+}, {
+	summary: "SkipCopyright keeps copyright listed explicitly",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, testPackageCopyrightEntries...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/share/doc/test-package/copyright:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.SkipCopyright = true
+	},
+	filesystem: map[string]string{
+		"/usr/":                                 "dir 0755",
+		"/usr/share/":                           "dir 0755",
+		"/usr/share/doc/":                       "dir 0755",
+		"/usr/share/doc/test-package/":          "dir 0755",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa",
+	},
+	manifestPaths: map[string]string{
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa {test-package_myslice}",
+	},
+}, {
+	summary: "Changelogs extracts the changelog and NEWS files of the packages",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb(append(testutil.TestPackageEntries, append(testPackageCopyrightEntries,
+			testutil.Reg(0644, "./usr/share/doc/test-package/changelog.Debian.gz", "changelog"),
+			testutil.Reg(0644, "./usr/share/doc/test-package/NEWS.Debian.gz", "news"),
+			testutil.Reg(0644, "./usr/share/doc/test-package/README", "readme"),
+		)...)),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Changelogs = true
+		opts.StripDocs = true
+	},
+	filesystem: map[string]string{
+		"/dir/":                        "dir 0755",
+		"/dir/file":                    "file 0644 cc55e2ec",
+		"/usr/":                        "dir 0755",
+		"/usr/share/":                  "dir 0755",
+		"/usr/share/doc/":              "dir 0755",
+		"/usr/share/doc/test-package/": "dir 0755",
+		"/usr/share/doc/test-package/NEWS.Debian.gz":      "file 0644 19fba0e9",
+		"/usr/share/doc/test-package/changelog.Debian.gz": "file 0644 6665bc2f",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+		"/usr/share/doc/test-package/NEWS.Debian.gz":      "file 0644 19fba0e9 {test-package_manifest}",
+		"/usr/share/doc/test-package/changelog.Debian.gz": "file 0644 6665bc2f {test-package_manifest}",
+	},
 }, {
 	summary: "Install two packages",
 	slices: []setup.SliceKey{
@@ -452,49 +800,67 @@ var slicerTests = []slicerTest{{
 		"/foo/text-file-2": "file 0644 d98cf53e 5b41362b {test-package_myslice}",
 	},
 }, {
-	summary: "Script: use 'until' to remove file after mutate",
-	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	summary: "Script: read the information of the packages",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name:    "test-package",
+		Version: "1.2-3",
+		Arch:    "amd64",
+		Data:    testutil.PackageData["test-package"],
+	}, {
+		Name:    "other-package",
+		Version: "4.5",
+		Arch:    "all",
+		Data:    testutil.PackageData["other-package"],
+	}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
 				myslice:
 					contents:
-						/dir/text-file-1: {text: data1, until: mutate}
-						/foo/text-file-2: {text: data2, mutable: true}
+						/version: {text: "", mutable: true}
 					mutate: |
-						data = content.read("/dir/text-file-1")
-						content.write("/foo/text-file-2", data)
+						content.write("/version", "%s %s %s, other-package %s %s" % (
+							pkg.name, pkg.version(), pkg.arch(),
+							pkg.version("other-package"), pkg.arch("other-package")))
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
 		`,
 	},
 	filesystem: map[string]string{
-		"/dir/":            "dir 0755",
-		"/foo/":            "dir 0755",
-		"/foo/text-file-2": "file 0644 5b41362b",
+		"/version": "file 0644 80261ba1",
 	},
 	manifestPaths: map[string]string{
-		"/foo/text-file-2": "file 0644 d98cf53e 5b41362b {test-package_myslice}",
+		"/version": "file 0644 e3b0c442 80261ba1 {test-package_myslice}",
 	},
 }, {
-	summary: "Script: use 'until' to remove wildcard after mutate",
+	summary: "Script: cannot read the information of packages not selected",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
 				myslice:
-					contents:
-						/dir/nested**:  {until: mutate}
-						/other-dir/text-file: {until: mutate, text: data1}
+					mutate: |
+						pkg.version("other-package")
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
 		`,
 	},
-	filesystem: map[string]string{
-		"/dir/":       "dir 0755",
-		"/other-dir/": "dir 0755",
-	},
-	manifestPaths: map[string]string{},
+	error: `slice test-package_myslice: package "other-package" is not selected`,
 }, {
-	summary: "Script: 'until' does not remove non-empty directories",
+	summary: "Script: glob paths across directories",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
@@ -502,18 +868,264 @@ var slicerTests = []slicerTest{{
 			slices:
 				myslice:
 					contents:
-						/dir/nested/: {until: mutate}
-						/dir/nested/file-copy: {copy: /dir/file}
+						/dir/file:
+						/dir/nested/file:
+						/dir/several/levels/deep/file:
+						/dir/text-file: {text: "", mutable: true}
+					mutate: |
+						content.write("/dir/text-file", ",".join(content.glob("/dir/**/file")))
 		`,
 	},
 	filesystem: map[string]string{
-		"/dir/":                 "dir 0755",
-		"/dir/nested/":          "dir 0755",
-		"/dir/nested/file-copy": "file 0644 cc55e2ec",
-	},
-	manifestPaths: map[string]string{
-		"/dir/nested/file-copy": "file 0644 cc55e2ec {test-package_myslice}",
-	},
+		"/dir/":                         "dir 0755",
+		"/dir/file":                     "file 0644 cc55e2ec",
+		"/dir/nested/":                  "dir 0755",
+		"/dir/nested/file":              "file 0644 84237a05",
+		"/dir/several/":                 "dir 0755",
+		"/dir/several/levels/":          "dir 0755",
+		"/dir/several/levels/deep/":     "dir 0755",
+		"/dir/several/levels/deep/file": "file 0644 6bc26dff",
+		"/dir/text-file":                "file 0644 3ba25766", // "/dir/nested/file,/dir/several/levels/deep/file"
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":                     "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/nested/file":              "file 0644 84237a05 {test-package_myslice}",
+		"/dir/several/levels/deep/file": "file 0644 6bc26dff {test-package_myslice}",
+		"/dir/text-file":                "file 0644 e3b0c442 3ba25766 {test-package_myslice}",
+	},
+}, {
+	summary: "Script: cannot glob paths in directories which are not selected",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+					mutate: |
+						content.glob("/parent/permissions/*")
+		`,
+	},
+	error: `slice test-package_myslice: cannot list directory which is not selected: /parent/permissions/`,
+}, {
+	summary: "Script: phases run in order",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice2"},
+		{"other-package", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/log: {text: "", mutable: true}
+					mutate:
+						- |
+							content.write("/log", content.read("/log") + "1a;")
+						- |
+							content.write("/log", content.read("/log") + "1b;")
+				myslice2:
+					essential:
+						- test-package_myslice1
+					mutate:
+						- |
+							content.write("/log", content.read("/log") + "2a;")
+						- |
+							content.write("/log", content.read("/log") + "2b;")
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					mutate: |
+						content.write("/log", content.read("/log") + "other;")
+		`,
+	},
+	filesystem: map[string]string{
+		// Slices without dependencies between them run sorted by name.
+		"/log": "file 0644 3c070932", // "other;1a;1b;2a;2b;"
+	},
+	manifestPaths: map[string]string{
+		"/log": "file 0644 e3b0c442 3c070932 {test-package_myslice1}",
+	},
+}, {
+	summary: "Script: mutate-after orders scripts across slices",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"},
+	},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: testutil.PackageData["other-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/log: {text: "", mutable: true}
+					mutate: |
+						content.write("/log", content.read("/log") + "test;")
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					mutate-after:
+						- test-package_myslice
+					mutate: |
+						content.write("/log", content.read("/log") + "other;")
+		`,
+	},
+	filesystem: map[string]string{
+		"/log": "file 0644 c7e0a387", // "test;other;"
+	},
+	manifestPaths: map[string]string{
+		"/log": "file 0644 e3b0c442 c7e0a387 {test-package_myslice}",
+	},
+}, {
+	summary: "Script: use 'until' to remove file after mutate",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/text-file-1: {text: data1, until: mutate}
+						/foo/text-file-2: {text: data2, mutable: true}
+					mutate: |
+						data = content.read("/dir/text-file-1")
+						content.write("/foo/text-file-2", data)
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":            "dir 0755",
+		"/foo/":            "dir 0755",
+		"/foo/text-file-2": "file 0644 5b41362b",
+	},
+	manifestPaths: map[string]string{
+		"/foo/text-file-2": "file 0644 d98cf53e 5b41362b {test-package_myslice}",
+	},
+}, {
+	summary: "Script: use 'until' to remove wildcard after mutate",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/nested**:  {until: mutate}
+						/other-dir/text-file: {until: mutate, text: data1}
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":       "dir 0755",
+		"/other-dir/": "dir 0755",
+	},
+	manifestPaths: map[string]string{},
+}, {
+	summary: "Script: 'until' does not remove non-empty directories",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/nested/: {until: mutate}
+						/dir/nested/file-copy: {copy: /dir/file}
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":                 "dir 0755",
+		"/dir/nested/":          "dir 0755",
+		"/dir/nested/file-copy": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/nested/file-copy": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+}, {
+	summary: "Script: list directory whose contents are all until:mutate",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/**: {until: mutate}
+					mutate: |
+						entries = content.list("/dir/")
+						if entries != ["file", "nested/", "other-file", "several/"]:
+							fail("unexpected entries: %s" % entries)
+						for entry in content.list("/dir/nested/"):
+							content.read("/dir/nested/" + entry)
+		`,
+	},
+	filesystem:    map[string]string{},
+	manifestPaths: map[string]string{},
+}, {
+	summary: "Script: list until:mutate directory kept by other content",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/nested/**: {until: mutate}
+					mutate: |
+						entries = content.list("/dir/nested/")
+						if entries != ["file", "other-file"]:
+							fail("unexpected entries: %s" % entries)
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+}, {
+	summary: "Script: list implicit parent of until:mutate paths",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/nested/file: {until: mutate}
+						/dir/nested/other-file: {until: mutate}
+					mutate: |
+						entries = content.list("/dir/nested/")
+						if entries != ["file", "other-file"]:
+							fail("unexpected entries: %s" % entries)
+		`,
+	},
+	// Implicit parent directories are not removed, even when empty.
+	filesystem: map[string]string{
+		"/dir/":        "dir 0755",
+		"/dir/nested/": "dir 0755",
+	},
+	manifestPaths: map[string]string{},
 }, {
 	summary: "Script: writing same contents to existing file does not set the final hash in report",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -853,8 +1465,8 @@ var slicerTests = []slicerTest{{
 		"/other-file": "file 0644 fa0c9cdb {other-package_myslice}",
 	},
 	manifestPkgs: map[string]string{
-		"test-package":  "test-package v1 a1 h1",
-		"other-package": "other-package v3 a3 h3",
+		"test-package":  "test-package v1 a1 h1 1/1",
+		"other-package": "other-package v3 a3 h3 1/1",
 	},
 }, {
 	summary: "Pinned archive bypasses higher priority",
@@ -919,7 +1531,7 @@ var slicerTests = []slicerTest{{
 		"/file": "file 0644 fa0c9cdb {test-package_myslice}",
 	},
 	manifestPkgs: map[string]string{
-		"test-package": "test-package v2 a2 h2",
+		"test-package": "test-package v2 a2 h2 1/1",
 	},
 }, {
 	summary: "Pinned archive does not have the package",
@@ -1076,7 +1688,7 @@ var slicerTests = []slicerTest{{
 		"/file": "file 0644 7a3e00f5 {test-package_myslice}",
 	},
 	manifestPkgs: map[string]string{
-		"test-package": "test-package v1 a1 h1",
+		"test-package": "test-package v1 a1 h1 1/1",
 	},
 }, {
 	summary: "Multiple slices of same package",
@@ -1331,6 +1943,59 @@ var slicerTests = []slicerTest{{
 	},
 	filesystem:    map[string]string{"/file": "file 0644 2c26b46b"},
 	manifestPaths: map[string]string{"/file": "file 0644 2c26b46b {test-package_myslice1,test-package_myslice2}"},
+}, {
+	summary: "Content with until:end is kept through mutation and removed at the end",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice1"},
+		{"test-package", "myslice2"},
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/dir/file: {text: foo, until: end}
+						/dir/other: {text: bar, until: end}
+					mutate: |
+						content.read("/dir/file")
+				myslice2:
+					essential:
+						- test-package_myslice1
+					contents:
+						/text: {text: data}
+					mutate: |
+						content.read("/dir/other")
+						content.list("/dir/")
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/": "dir 0755",
+		"/text": "file 0644 3a6eb079",
+	},
+	manifestPaths: map[string]string{
+		"/text": "file 0644 3a6eb079 {test-package_myslice2}",
+	},
+}, {
+	summary: "Content with until:end in one slice only is kept",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice1"},
+		{"test-package", "myslice2"},
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/file: {text: foo, until: end}
+				myslice2:
+					contents:
+						/file: {text: foo}
+		`,
+	},
+	filesystem:    map[string]string{"/file": "file 0644 2c26b46b"},
+	manifestPaths: map[string]string{"/file": "file 0644 2c26b46b {test-package_myslice1,test-package_myslice2}"},
 }, {
 	summary: "Install two packages, both are recorded",
 	slices: []setup.SliceKey{
@@ -1365,8 +2030,8 @@ var slicerTests = []slicerTest{{
 	`,
 	},
 	manifestPkgs: map[string]string{
-		"test-package":  "test-package v1 a1 h1",
-		"other-package": "other-package v2 a2 h2",
+		"test-package":  "test-package v1 a1 h1 0/6",
+		"other-package": "other-package v2 a2 h2 0/1",
 	},
 }, {
 	summary: "Two packages, only one is selected and recorded",
@@ -1401,7 +2066,7 @@ var slicerTests = []slicerTest{{
 	`,
 	},
 	manifestPkgs: map[string]string{
-		"test-package": "test-package v1 a1 h1",
+		"test-package": "test-package v1 a1 h1 0/6",
 	},
 }, {
 	summary: "Relative paths are properly trimmed during extraction",
@@ -1480,7 +2145,7 @@ var slicerTests = []slicerTest{{
 	},
 	error: `cannot find package "test-package" in archive\(s\)`,
 }, {
-	summary: "Valid hard link in two slices in the same package",
+	summary: "Hard link declared in the slice definition",
 	slices: []setup.SliceKey{
 		{"test-package", "slice1"},
 		{"test-package", "slice2"}},
@@ -1488,26 +2153,67 @@ var slicerTests = []slicerTest{{
 		Name: "test-package",
 		Data: testutil.MustMakeDeb([]testutil.TarEntry{
 			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "foo"),
-			testutil.Hrd(0644, "./hardlink", "./file"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Reg(0644, "./dir/file", "foo"),
 		}),
 	}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
-		package: test-package
-		slices:
-			slice1:
-				contents:
-					/file:
-					/hardlink:
-			slice2:
-				contents:
-					/hardlink:
-	`,
-	},
-	filesystem: map[string]string{
-		"/file":     "file 0644 2c26b46b <1>",
-		"/hardlink": "file 0644 2c26b46b <1>",
+			package: test-package
+			slices:
+				slice1:
+					contents:
+						/dir/file:
+						/text: {text: data}
+				slice2:
+					contents:
+						/dir/hardlink: {hardlink: /dir/file}
+						/other/hardlink: {hardlink: /text}
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":           "dir 0755",
+		"/dir/file":       "file 0644 2c26b46b <1>",
+		"/dir/hardlink":   "file 0644 2c26b46b <1>",
+		"/other/":         "dir 0755",
+		"/other/hardlink": "file 0644 3a6eb079 <2>",
+		"/text":           "file 0644 3a6eb079 <2>",
+	},
+	manifestPaths: map[string]string{
+		"/dir/file":       "file 0644 2c26b46b <1> {test-package_slice1}",
+		"/dir/hardlink":   "file 0644 2c26b46b <1> {test-package_slice2}",
+		"/other/hardlink": "file 0644 3a6eb079 <2> {test-package_slice2}",
+		"/text":           "file 0644 3a6eb079 <2> {test-package_slice1}",
+	},
+}, {
+	summary: "Valid hard link in two slices in the same package",
+	slices: []setup.SliceKey{
+		{"test-package", "slice1"},
+		{"test-package", "slice2"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+		package: test-package
+		slices:
+			slice1:
+				contents:
+					/file:
+					/hardlink:
+			slice2:
+				contents:
+					/hardlink:
+	`,
+	},
+	filesystem: map[string]string{
+		"/file":     "file 0644 2c26b46b <1>",
+		"/hardlink": "file 0644 2c26b46b <1>",
 	},
 	manifestPaths: map[string]string{
 		"/file":     "file 0644 2c26b46b <1> {test-package_slice1}",
@@ -1651,98 +2357,759 @@ var slicerTests = []slicerTest{{
 			testutil.Hrd(0644, "./hardlink1", "./file1"),
 		}),
 	}, {
-		Name: "test-package2",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file2", "foo"),
-			testutil.Hrd(0644, "./hardlink2", "./file2"),
-		}),
+		Name: "test-package2",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file2", "foo"),
+			testutil.Hrd(0644, "./hardlink2", "./file2"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package1.yaml": `
+			package: test-package1
+			slices:
+				myslice:
+					contents:
+						/file1:
+						/hardlink1:
+		`,
+		"slices/mydir/test-package2.yaml": `
+			package: test-package2
+			slices:
+				myslice:
+					contents:
+						/file2:
+						/hardlink2:
+		`,
+	},
+	filesystem: map[string]string{
+		"/file1":     "file 0644 2c26b46b <1>",
+		"/hardlink1": "file 0644 2c26b46b <1>",
+		"/file2":     "file 0644 2c26b46b <2>",
+		"/hardlink2": "file 0644 2c26b46b <2>",
+	},
+	manifestPaths: map[string]string{
+		"/file1":     "file 0644 2c26b46b <1> {test-package1_myslice}",
+		"/hardlink1": "file 0644 2c26b46b <1> {test-package1_myslice}",
+		"/file2":     "file 0644 2c26b46b <2> {test-package2_myslice}",
+		"/hardlink2": "file 0644 2c26b46b <2> {test-package2_myslice}",
+	},
+}, {
+	summary: "Mutations for hard links are forbidden",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+						/hardlink: {mutable: true}
+					mutate: |
+						content.write("/hardlink", "new content")
+		`,
+	},
+	error: `slice test-package_myslice: cannot mutate a hard link: /hardlink`,
+}, {
+	summary: "Hard links can be marked as mutable, but not mutated",
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "foo"),
+			testutil.Hrd(0644, "./hardlink", "./file"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+						/hardlink: {mutable: true}
+		`,
+	},
+	filesystem: map[string]string{
+		"/file":     "file 0644 2c26b46b <1>",
+		"/hardlink": "file 0644 2c26b46b <1>",
+	},
+	manifestPaths: map[string]string{
+		"/file":     "file 0644 2c26b46b <1> {test-package_myslice}",
+		"/hardlink": "file 0644 2c26b46b <1> {test-package_myslice}",
+	},
+}, {
+	summary: "Prune directories left empty after mutate",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/nested/file: {until: mutate}
+						/dir/nested/other-file: {until: mutate}
+						/other-dir/text-file: {text: data1, until: mutate}
+						/foo/text-file: {text: data2, mutable: true}
+					mutate: |
+						data = content.read("/other-dir/text-file")
+						content.write("/foo/text-file", data)
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.PruneEmptyDirs = true
+	},
+	filesystem: map[string]string{
+		"/foo/":          "dir 0755",
+		"/foo/text-file": "file 0644 5b41362b",
+	},
+	manifestPaths: map[string]string{
+		"/foo/text-file": "file 0644 d98cf53e 5b41362b {test-package_myslice}",
+	},
+}, {
+	summary: "Prune directories left empty after mutate keeps explicit and non-empty directories",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file: {until: mutate}
+						/dir/: {make: true}
+						/other/nested/file: {text: data1, until: mutate}
+						/other/nested/keep: {text: data2}
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.PruneEmptyDirs = true
+	},
+	filesystem: map[string]string{
+		"/dir/":              "dir 0755",
+		"/other/":            "dir 0755",
+		"/other/nested/":     "dir 0755",
+		"/other/nested/keep": "file 0644 d98cf53e",
+	},
+	manifestPaths: map[string]string{
+		"/dir/":              "dir 0755 {test-package_myslice}",
+		"/other/nested/keep": "file 0644 d98cf53e {test-package_myslice}",
+	},
+}, {
+	summary: "Symlink chains are followed",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Reg(0644, "./dir/file", "foo"),
+			testutil.Lnk(0777, "./dir/link1", "link2"),
+			testutil.Lnk(0777, "./dir/link2", "/other/file"),
+			testutil.Lnk(0777, "./other", "dir"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/link1:
+						/dir/link2:
+						/other:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":      "dir 0755",
+		"/dir/file":  "file 0644 2c26b46b",
+		"/dir/link1": "symlink link2",
+		"/dir/link2": "symlink /other/file",
+		"/other":     "symlink dir",
+	},
+}, {
+	summary: "Symlink loop is detected",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Lnk(0777, "./dir/link0", "link1"),
+			testutil.Lnk(0777, "./dir/link1", "../link2"),
+			testutil.Lnk(0777, "./link2", "/dir/link1"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/link0:
+						/dir/link1:
+						/link2:
+		`,
+	},
+	error: `symlink loop detected: /dir/link1 -> /link2 -> /dir/link1`,
+}, {
+	summary: "Symlink loop through a parent directory is detected",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Lnk(0777, "./link", "link/sub"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/link:
+		`,
+	},
+	error: `symlink loop detected: /link -> /link`,
+}, {
+	summary: "Symlink loop removed after mutate is ignored",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Lnk(0777, "./link1", "link2"),
+			testutil.Lnk(0777, "./link2", "link1"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/link1: {until: mutate}
+						/link2:
+		`,
+	},
+	filesystem: map[string]string{
+		"/link2": "symlink link1",
+	},
+}, {
+	summary: "Download size within the limit",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.MaxDownloadSize = int64(len(testutil.PackageData["test-package"]))
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+}, {
+	summary: "Download size exceeds the limit",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.MaxDownloadSize = int64(len(testutil.PackageData["test-package"])) - 1
+	},
+	error: `cannot fetch package "test-package": total download size exceeds [0-9]+ bytes`,
+}, {
+	summary: "Strip documentation matched by wildcards",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/bin/"),
+			testutil.Reg(0755, "./usr/bin/tool", "tool"),
+			testutil.Dir(0755, "./usr/share/"),
+			testutil.Dir(0755, "./usr/share/doc/"),
+			testutil.Dir(0755, "./usr/share/doc/test-package/"),
+			testutil.Reg(0644, "./usr/share/doc/test-package/copyright", "copyright"),
+			testutil.Reg(0644, "./usr/share/doc/test-package/README", "readme"),
+			testutil.Reg(0644, "./usr/share/doc/test-package/NEWS", "news"),
+			testutil.Dir(0755, "./usr/share/man/"),
+			testutil.Dir(0755, "./usr/share/man/man1/"),
+			testutil.Reg(0644, "./usr/share/man/man1/tool.1.gz", "man"),
+			testutil.Dir(0755, "./usr/share/info/"),
+			testutil.Reg(0644, "./usr/share/info/tool.info.gz", "info"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/**:
+						/usr/share/doc/test-package/NEWS:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.StripDocs = true
+	},
+	filesystem: map[string]string{
+		"/usr/":                                 "dir 0755",
+		"/usr/bin/":                             "dir 0755",
+		"/usr/bin/tool":                         "file 0755 7c9bbe5e",
+		"/usr/share/":                           "dir 0755",
+		"/usr/share/doc/":                       "dir 0755",
+		"/usr/share/doc/test-package/":          "dir 0755",
+		"/usr/share/doc/test-package/NEWS":      "file 0644 19fba0e9",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa",
+	},
+	manifestPaths: map[string]string{
+		"/usr/":                                 "dir 0755 {test-package_myslice}",
+		"/usr/bin/":                             "dir 0755 {test-package_myslice}",
+		"/usr/bin/tool":                         "file 0755 7c9bbe5e {test-package_myslice}",
+		"/usr/share/":                           "dir 0755 {test-package_myslice}",
+		"/usr/share/doc/":                       "dir 0755 {test-package_myslice}",
+		"/usr/share/doc/test-package/":          "dir 0755 {test-package_myslice}",
+		"/usr/share/doc/test-package/NEWS":      "file 0644 19fba0e9 {test-package_myslice}",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa {test-package_myslice}",
+	},
+}, {
+	summary: "Documentation is kept by default",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./usr/"),
+			testutil.Dir(0755, "./usr/share/"),
+			testutil.Dir(0755, "./usr/share/man/"),
+			testutil.Dir(0755, "./usr/share/man/man1/"),
+			testutil.Reg(0644, "./usr/share/man/man1/tool.1.gz", "man"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/share/man/**:
+		`,
+	},
+	filesystem: map[string]string{
+		"/usr/":                         "dir 0755",
+		"/usr/share/":                   "dir 0755",
+		"/usr/share/man/":               "dir 0755",
+		"/usr/share/man/man1/":          "dir 0755",
+		"/usr/share/man/man1/tool.1.gz": "file 0644 48b676e2",
+	},
+}, {
+	summary: "Paths differing only in case fail with CheckCase",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/Config", "upper"),
+			testutil.Reg(0644, "./etc/config", "lower"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/etc/*:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.CheckCase = true
+	},
+	error: `paths /etc/Config and /etc/config differ only in case`,
+}, {
+	summary: "Normalize permissions",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0700, "./dir/"),
+			testutil.Reg(0600, "./dir/file", "data"),
+			testutil.Reg(0711, "./dir/exec", "binary"),
+			testutil.Lnk(0777, "./dir/link", "file"),
+			testutil.Dir(01777, "./tmp/"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/exec:
+						/dir/link:
+						/tmp/:
+						/etc/text: {text: data, mode: 0600}
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.NormalizePerms = true
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/exec": "file 0755 9a3a45d0",
+		"/dir/file": "file 0644 3a6eb079",
+		"/dir/link": "symlink file",
+		"/etc/":     "dir 0755",
+		"/etc/text": "file 0644 3a6eb079",
+		"/tmp/":     "dir 01755",
+	},
+	manifestPaths: map[string]string{
+		"/dir/exec": "file 0755 9a3a45d0 {test-package_myslice}",
+		"/dir/file": "file 0644 3a6eb079 {test-package_myslice}",
+		"/dir/link": "symlink file {test-package_myslice}",
+		"/etc/text": "file 0644 3a6eb079 {test-package_myslice}",
+		"/tmp/":     "dir 01755 {test-package_myslice}",
+	},
+}, {
+	summary: "Paths differing only in case are accepted by default",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./etc/"),
+			testutil.Reg(0644, "./etc/Config", "upper"),
+			testutil.Reg(0644, "./etc/config", "lower"),
+		}),
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/etc/*:
+		`,
+	},
+	filesystem: map[string]string{
+		"/etc/":       "dir 0755",
+		"/etc/Config": "file 0644 aee61055",
+		"/etc/config": "file 0644 8c6fb1e9",
+	},
+}, {
+	summary: "Paths get the modification time of the run",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/link: {symlink: /dir/missing}
+						/other-dir/text: {text: data}
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.ModTime = time.Unix(1700000000, 0)
+	},
+	check: func(c *C, opts *slicer.RunOptions) {
+		err := filepath.WalkDir(opts.TargetDir, func(path string, d fs.DirEntry, err error) error {
+			c.Assert(err, IsNil)
+			info, err := os.Lstat(path)
+			c.Assert(err, IsNil)
+			c.Assert(info.ModTime().Equal(opts.ModTime), Equals, true, Commentf("mtime of %s: %s", path, info.ModTime()))
+			return nil
+		})
+		c.Assert(err, IsNil)
+	},
+	filesystem: map[string]string{
+		"/dir/":           "dir 0755",
+		"/dir/file":       "file 0644 cc55e2ec",
+		"/dir/link":       "symlink /dir/missing",
+		"/other-dir/":     "dir 0755",
+		"/other-dir/text": "file 0644 3a6eb079",
+	},
+}, {
+	summary: "Manifest records the mode symlinks have on disk",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/link: {symlink: file}
+						/dir/link-mode: {symlink: file, mode: 0777}
+		`,
+	},
+	check: func(c *C, opts *slicer.RunOptions) {
+		mfest := readManifest(c, opts.TargetDir, "/chisel-data/manifest.wall")
+		for _, link := range []string{"/dir/link", "/dir/link-mode"} {
+			info, err := os.Lstat(filepath.Join(opts.TargetDir, link))
+			c.Assert(err, IsNil)
+			c.Assert(info.Mode()&fs.ModeSymlink, Not(Equals), fs.FileMode(0))
+			path, err := mfest.Path(link)
+			c.Assert(err, IsNil)
+			c.Assert(path.Mode, Equals, fmt.Sprintf("%#o", info.Mode().Perm()))
+		}
+	},
+}, {
+	summary: "Manifest records the requested hash algorithms",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name:   "test-package",
+		Hash:   "h1",
+		SHA512: "h1-512",
+		Data:   testutil.PackageData["test-package"],
+	}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/text: {text: initial, mutable: true}
+					mutate: |
+						content.write("/text", "final")
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.HashAlgorithms = []string{"sha512"}
+	},
+	check: func(c *C, opts *slicer.RunOptions) {
+		sha512hex := func(data string) string {
+			sum := sha512.Sum512([]byte(data))
+			return hex.EncodeToString(sum[:])
+		}
+		fileData, err := os.ReadFile(filepath.Join(opts.TargetDir, "dir/file"))
+		c.Assert(err, IsNil)
+		mfest := readManifest(c, opts.TargetDir, "/chisel-data/manifest.wall")
+		c.Assert(mfest.Schema(), Equals, manifest.Schema)
+		pkg, err := mfest.Package("test-package")
+		c.Assert(err, IsNil)
+		c.Assert(pkg.SHA512, Equals, "h1-512")
+		path, err := mfest.Path("/dir/file")
+		c.Assert(err, IsNil)
+		c.Assert(path.SHA512, Equals, sha512hex(string(fileData)))
+		c.Assert(path.FinalSHA512, Equals, "")
+		path, err = mfest.Path("/text")
+		c.Assert(err, IsNil)
+		c.Assert(path.SHA512, Equals, sha512hex("initial"))
+		c.Assert(path.FinalSHA512, Equals, sha512hex("final"))
+	},
+}, {
+	summary: "Unsupported hash algorithm",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.HashAlgorithms = []string{"md5"}
+	},
+	error: `unsupported hash algorithm "md5"`,
+}, {
+	summary: "Owners are set on disk and recorded in the manifest",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": fmt.Sprintf(`
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file: {uid: %[1]d, gid: %[2]d}
+						/dir/link: {symlink: file, uid: %[1]d}
+						/other-dir/text: {text: data, gid: %[2]d}
+		`, os.Getuid(), os.Getgid()),
+	},
+	check: func(c *C, opts *slicer.RunOptions) {
+		uid, gid := os.Getuid(), os.Getgid()
+		for _, path := range []string{"/dir/file", "/dir/link", "/other-dir/text"} {
+			info, err := os.Lstat(filepath.Join(opts.TargetDir, path))
+			c.Assert(err, IsNil)
+			stat := info.Sys().(*syscall.Stat_t)
+			c.Assert(int(stat.Uid), Equals, uid)
+			c.Assert(int(stat.Gid), Equals, gid)
+		}
+		owners := make(map[string]string)
+		mfest := readManifest(c, opts.TargetDir, "/chisel-data/manifest.wall")
+		err := mfest.IteratePaths("", func(path *manifest.Path) error {
+			var owner []string
+			if path.UID != nil {
+				owner = append(owner, fmt.Sprintf("uid=%d", *path.UID))
+			}
+			if path.GID != nil {
+				owner = append(owner, fmt.Sprintf("gid=%d", *path.GID))
+			}
+			if len(owner) > 0 {
+				owners[path.Path] = strings.Join(owner, " ")
+			}
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(owners, DeepEquals, map[string]string{
+			"/dir/file":       fmt.Sprintf("uid=%d gid=%d", uid, gid),
+			"/dir/link":       fmt.Sprintf("uid=%d", uid),
+			"/other-dir/text": fmt.Sprintf("gid=%d", gid),
+		})
+	},
+}, {
+	summary: "Owners are not set when ignored",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/root-file: {text: data, uid: 0, gid: 0}
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.IgnoreOwners = true
+	},
+	filesystem: map[string]string{
+		"/root-file": "file 0644 3a6eb079",
+	},
+}, {
+	summary: "Progress is reported while fetching and extracting",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: []*testutil.TestPackage{{
+		Name: "test-package",
+		Data: testutil.PackageData["test-package"],
+	}, {
+		Name: "other-package",
+		Data: progressOtherData,
 	}},
 	release: map[string]string{
-		"slices/mydir/test-package1.yaml": `
-			package: test-package1
+		"slices/mydir/test-package.yaml": `
+			package: test-package
 			slices:
 				myslice:
+					essential:
+						- other-package_myslice
 					contents:
-						/file1:
-						/hardlink1:
+						/dir/file:
 		`,
-		"slices/mydir/test-package2.yaml": `
-			package: test-package2
+		"slices/mydir/other-package.yaml": `
+			package: other-package
 			slices:
 				myslice:
 					contents:
-						/file2:
-						/hardlink2:
+						/file:
 		`,
 	},
-	filesystem: map[string]string{
-		"/file1":     "file 0644 2c26b46b <1>",
-		"/hardlink1": "file 0644 2c26b46b <1>",
-		"/file2":     "file 0644 2c26b46b <2>",
-		"/hardlink2": "file 0644 2c26b46b <2>",
-	},
-	manifestPaths: map[string]string{
-		"/file1":     "file 0644 2c26b46b <1> {test-package1_myslice}",
-		"/hardlink1": "file 0644 2c26b46b <1> {test-package1_myslice}",
-		"/file2":     "file 0644 2c26b46b <2> {test-package2_myslice}",
-		"/hardlink2": "file 0644 2c26b46b <2> {test-package2_myslice}",
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		// A single worker fetches the packages in order.
+		opts.FetchWorkers = 1
+	},
+	progress: []slicer.ProgressEvent{
+		{Phase: slicer.FetchPhase, Package: "other-package", Started: true, Packages: 0, TotalPackages: 2, Bytes: 0, TotalBytes: progressTotalSize},
+		{Phase: slicer.FetchPhase, Package: "other-package", Packages: 1, TotalPackages: 2, Bytes: progressOtherSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.FetchPhase, Package: "test-package", Started: true, Packages: 1, TotalPackages: 2, Bytes: progressOtherSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.FetchPhase, Package: "test-package", Packages: 2, TotalPackages: 2, Bytes: progressTotalSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "other-package", Started: true, Packages: 0, TotalPackages: 2, Bytes: 0, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "other-package", Path: "/file", Packages: 0, TotalPackages: 2, Bytes: 0, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "other-package", Packages: 1, TotalPackages: 2, Bytes: progressOtherSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "test-package", Started: true, Packages: 1, TotalPackages: 2, Bytes: progressOtherSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "test-package", Path: "/dir/", Packages: 1, TotalPackages: 2, Bytes: progressOtherSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "test-package", Path: "/dir/file", Packages: 1, TotalPackages: 2, Bytes: progressOtherSize, TotalBytes: progressTotalSize},
+		{Phase: slicer.ExtractPhase, Package: "test-package", Packages: 2, TotalPackages: 2, Bytes: progressTotalSize, TotalBytes: progressTotalSize},
 	},
 }, {
-	summary: "Mutations for hard links are forbidden",
-	slices: []setup.SliceKey{
-		{"test-package", "myslice"}},
-	pkgs: []*testutil.TestPackage{{
-		Name: "test-package",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "foo"),
-			testutil.Hrd(0644, "./hardlink", "./file"),
-		}),
-	}},
+	summary: "Mutation scripts cannot write to a target filesystem",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
 				myslice:
 					contents:
-						/file:
-						/hardlink: {mutable: true}
+						/dir/file:
 					mutate: |
-						content.write("/hardlink", "new content")
+						pass
 		`,
 	},
-	error: `slice test-package_myslice: cannot mutate a hard link: /hardlink`,
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.TargetDir = ""
+		opts.TargetFS = testutil.NewMemFS()
+	},
+	error: `cannot write to a target filesystem: slice test-package_myslice has a mutation script`,
 }, {
-	summary: "Hard links can be marked as mutable, but not mutated",
-	slices: []setup.SliceKey{
-		{"test-package", "myslice"}},
-	pkgs: []*testutil.TestPackage{{
-		Name: "test-package",
-		Data: testutil.MustMakeDeb([]testutil.TarEntry{
-			testutil.Dir(0755, "./"),
-			testutil.Reg(0644, "./file", "foo"),
-			testutil.Hrd(0644, "./hardlink", "./file"),
-		}),
-	}},
+	summary: "Mutation scripts of other slices cannot write to a target filesystem",
+	slices:  []setup.SliceKey{{"test-package", "myslice1"}, {"test-package", "myslice2"}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
 			package: test-package
 			slices:
-				myslice:
+				myslice1:
 					contents:
-						/file:
-						/hardlink: {mutable: true}
+						/dir/file:
+				myslice2:
+					contents:
+						/dir/other-file: {text: foo, mutable: true}
+					mutate: |
+						content.write("/dir/other-file", content.read("/dir/file"))
 		`,
 	},
-	filesystem: map[string]string{
-		"/file":     "file 0644 2c26b46b <1>",
-		"/hardlink": "file 0644 2c26b46b <1>",
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.TargetDir = ""
+		opts.TargetFS = testutil.NewMemFS()
 	},
-	manifestPaths: map[string]string{
-		"/file":     "file 0644 2c26b46b <1> {test-package_myslice}",
-		"/hardlink": "file 0644 2c26b46b <1> {test-package_myslice}",
+	error: `cannot write to a target filesystem: slice test-package_myslice2 has a mutation script`,
+}, {
+	summary: "Target directory and target filesystem are exclusive",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.TargetFS = testutil.NewMemFS()
 	},
+	error: `cannot use both a target directory and a target filesystem`,
 }}
 
 var defaultChiselYaml = `
@@ -1779,14 +3146,475 @@ func (s *S) TestRun(c *C) {
 	runSlicerTests(c, v2ArchiveTests)
 }
 
+func (s *S) TestRunPriorManifest(c *C) {
+	readRelease := func(text string) *setup.Release {
+		return readTestRelease(c, map[string]string{
+			"slices/mydir/test-package.yaml": `
+				package: test-package
+				slices:
+					myslice:
+						contents:
+							/dir/file:
+							/var/lib/chisel/**: {generate: manifest}
+					otherslice:
+						contents:
+							/other-dir/text: {text: ` + text + `}
+							/var/lib/chisel/**: {generate: manifest}
+			`,
+		})
+	}
+	pkgs := []*testutil.TestPackage{testPackage(testutil.PackageData["test-package"])}
+	run := func(release *setup.Release, targetDir string, sliceName string, schema string) error {
+		return runSlicer(c, release, []setup.SliceKey{{"test-package", sliceName}}, pkgs, &slicer.RunOptions{
+			TargetDir:      targetDir,
+			ManifestSchema: schema,
+		})
+	}
+
+	targetDir := c.MkDir()
+	err := run(readRelease("data"), targetDir, "myslice", "")
+	c.Assert(err, IsNil)
+	err = run(readRelease("data"), targetDir, "otherslice", "")
+	c.Assert(err, IsNil)
+
+	mfest := readManifest(c, targetDir, "/var/lib/chisel/manifest.wall")
+	pathSlices := map[string][]string{}
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		pathSlices[path.Path] = path.Slices
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(pathSlices, DeepEquals, map[string][]string{
+		"/dir/file":                     {"test-package_myslice"},
+		"/other-dir/text":               {"test-package_otherslice"},
+		"/var/lib/chisel/manifest.wall": {"test-package_myslice", "test-package_otherslice"},
+	})
+
+	// The same path cannot be written with different content on top.
+	err = run(readRelease("changed"), targetDir, "otherslice", "")
+	c.Assert(err, ErrorMatches, `cannot merge manifest: path /other-dir/text has diverging size: 7 != 4`)
+
+	// The schema of the prior manifest is kept unless another is requested.
+	targetDir = c.MkDir()
+	err = run(readRelease("data"), targetDir, "myslice", "1.0")
+	c.Assert(err, IsNil)
+	err = run(readRelease("data"), targetDir, "otherslice", "")
+	c.Assert(err, IsNil)
+	mfest = readManifest(c, targetDir, "/var/lib/chisel/manifest.wall")
+	c.Assert(mfest.Schema(), Equals, "1.0")
+	err = run(readRelease("data"), targetDir, "otherslice", "1.0")
+	c.Assert(err, IsNil)
+	mfest = readManifest(c, targetDir, "/var/lib/chisel/manifest.wall")
+	c.Assert(mfest.Schema(), Equals, "1.0")
+	err = run(readRelease("data"), targetDir, "otherslice", manifest.Schema)
+	c.Assert(err, IsNil)
+	mfest = readManifest(c, targetDir, "/var/lib/chisel/manifest.wall")
+	c.Assert(mfest.Schema(), Equals, manifest.Schema)
+}
+
+func (s *S) TestRunOwnersNotPermitted(c *C) {
+	if os.Getuid() == 0 {
+		c.Skip("the root user may set any owner")
+	}
+	release := readTestRelease(c, map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				root:
+					contents:
+						/root-file: {text: data, uid: 0, gid: 0}
+		`,
+	})
+	pkgs := []*testutil.TestPackage{testPackage(testutil.PackageData["test-package"])}
+	err := runSlicer(c, release, []setup.SliceKey{{"test-package", "root"}}, pkgs, &slicer.RunOptions{
+		TargetDir: c.MkDir(),
+	})
+	c.Assert(err, ErrorMatches, `cannot set owner of /root-file: lchown .*: operation not permitted`)
+}
+
+func (s *S) TestRunPreserveXattrs(c *C) {
+	ping := testutil.Reg(0755, "./usr/bin/ping", "ping")
+	ping.Header.Format = tar.FormatPAX
+	ping.Header.PAXRecords = map[string]string{
+		"SCHILY.xattr.user.capability": "\x01\x00\x00\x02",
+	}
+	pkgs := []*testutil.TestPackage{testPackage(testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		ping,
+		testutil.Reg(0755, "./usr/bin/other", "other"),
+	}))}
+	release := readTestRelease(c, map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/ping:
+						/usr/bin/other:
+						/chisel-data/**: {generate: manifest}
+		`,
+	})
+
+	targetDir := c.MkDir()
+	err := runSlicer(c, release, []setup.SliceKey{{"test-package", "myslice"}}, pkgs, &slicer.RunOptions{
+		TargetDir:      targetDir,
+		PreserveXattrs: true,
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		c.Skip("extended attributes are not supported in " + targetDir)
+	}
+	c.Assert(err, IsNil)
+
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(filepath.Join(targetDir, "usr/bin/ping"), "user.capability", value)
+	c.Assert(err, IsNil)
+	c.Assert(value[:n], DeepEquals, []byte("\x01\x00\x00\x02"))
+
+	xattrs := make(map[string]map[string][]byte)
+	mfest := readManifest(c, targetDir, "/chisel-data/manifest.wall")
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		if path.Xattrs != nil {
+			xattrs[path.Path] = path.Xattrs
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(xattrs, DeepEquals, map[string]map[string][]byte{
+		"/usr/bin/ping": {"user.capability": []byte("\x01\x00\x00\x02")},
+	})
+}
+
+func (s *S) TestRunTargetFS(c *C) {
+	release := readTestRelease(c, map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/nested/**:
+						/dir/text: {text: data, mode: 0600}
+						/dir/link: {symlink: file}
+						/dir/hard: {hardlink: /dir/file}
+						/dir/made/: {make: true}
+						/parent/**:
+						/tmp/until: {text: gone, until: mutate}
+						/chisel/**: {generate: manifest}
+		`,
+	})
+	keys := []setup.SliceKey{{"test-package", "myslice"}}
+	pkgs := []*testutil.TestPackage{testPackage(testutil.PackageData["test-package"])}
+
+	// The content written to the filesystem is the same as the one written
+	// to a directory.
+	targetDir := c.MkDir()
+	err := runSlicer(c, release, keys, pkgs, &slicer.RunOptions{TargetDir: targetDir})
+	c.Assert(err, IsNil)
+	memFS := testutil.NewMemFS()
+	err = runSlicer(c, release, keys, pkgs, &slicer.RunOptions{TargetFS: memFS})
+	c.Assert(err, IsNil)
+	c.Assert(memFS.Dump(), DeepEquals, testutil.TreeDump(targetDir))
+	c.Assert(memFS.Dump()["/dir/hard"], Equals, "file 0644 cc55e2ec <1>")
+	_, err = memFS.Lstat("/tmp/until")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *S) TestRunStripBinaries(c *C) {
+	for _, tool := range []string{"cc", "strip"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			c.Skip(tool + " is not available")
+		}
+	}
+	srcDir := c.MkDir()
+	srcPath := filepath.Join(srcDir, "prog.c")
+	err := os.WriteFile(srcPath, []byte("int main(void) { return 0; }\n"), 0644)
+	c.Assert(err, IsNil)
+	binPath := filepath.Join(srcDir, "prog")
+	output, err := exec.Command("cc", "-g", "-o", binPath, srcPath).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", output))
+	binData, err := os.ReadFile(binPath)
+	c.Assert(err, IsNil)
+	// File capabilities of version 2 granting cap_net_raw, as set on ping.
+	capability := "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	ping := testutil.Reg(04755, "./usr/bin/ping", string(binData))
+	ping.Header.Format = tar.FormatPAX
+	ping.Header.PAXRecords = map[string]string{
+		"SCHILY.xattr.security.capability": capability,
+	}
+
+	release := readTestRelease(c, map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/prog:
+						/usr/bin/prog-link:
+						/usr/bin/script:
+				caps:
+					contents:
+						/usr/bin/ping:
+				manifest:
+					contents:
+						/chisel-data/**: {generate: manifest}
+		`,
+	})
+	keys := []setup.SliceKey{{"test-package", "myslice"}, {"test-package", "manifest"}}
+	pkgs := []*testutil.TestPackage{testPackage(testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/prog", string(binData)),
+		testutil.Hrd(0755, "./usr/bin/prog-link", "./usr/bin/prog"),
+		testutil.Reg(0755, "./usr/bin/script", "#!/bin/sh\n"),
+		ping,
+	}))}
+
+	targetDir := c.MkDir()
+	err = runSlicer(c, release, keys, pkgs, &slicer.RunOptions{
+		TargetDir:     targetDir,
+		StripBinaries: true,
+	})
+	c.Assert(err, IsNil)
+
+	stripped, err := os.ReadFile(filepath.Join(targetDir, "usr/bin/prog"))
+	c.Assert(err, IsNil)
+	c.Assert(len(stripped) < len(binData), Equals, true)
+	elfFile, err := elf.Open(filepath.Join(targetDir, "usr/bin/prog"))
+	c.Assert(err, IsNil)
+	defer elfFile.Close()
+	for _, section := range elfFile.Sections {
+		c.Assert(strings.HasPrefix(section.Name, ".debug_"), Equals, false, Commentf("section %s", section.Name))
+	}
+	progInfo, err := os.Stat(filepath.Join(targetDir, "usr/bin/prog"))
+	c.Assert(err, IsNil)
+	linkInfo, err := os.Stat(filepath.Join(targetDir, "usr/bin/prog-link"))
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(progInfo, linkInfo), Equals, true)
+
+	// The manifest records the hashes of the stripped content.
+	mfest := readManifest(c, targetDir, "/chisel-data/manifest.wall")
+	finalHashes := map[string]string{}
+	err = mfest.IteratePaths("/usr/bin/", func(p *manifest.Path) error {
+		finalHashes[p.Path] = p.FinalSHA256
+		return nil
+	})
+	c.Assert(err, IsNil)
+	strippedHash := fmt.Sprintf("%x", sha256.Sum256(stripped))
+	c.Assert(finalHashes, DeepEquals, map[string]string{
+		"/usr/bin/prog":      strippedHash,
+		"/usr/bin/prog-link": strippedHash,
+		"/usr/bin/script":    "",
+	})
+
+	// The temporary copies are written under TmpDir.
+	err = runSlicer(c, release, keys, pkgs, &slicer.RunOptions{
+		TargetDir:     c.MkDir(),
+		StripBinaries: true,
+		TmpDir:        filepath.Join(c.MkDir(), "missing"),
+	})
+	c.Assert(err, ErrorMatches, `cannot strip binaries: .*/missing: no such file or directory`)
+
+	// Writing the stripped content drops the file capabilities and the
+	// setuid bit, which are set again.
+	targetDir = c.MkDir()
+	err = runSlicer(c, release, []setup.SliceKey{{"test-package", "caps"}, {"test-package", "manifest"}}, pkgs, &slicer.RunOptions{
+		TargetDir:      targetDir,
+		StripBinaries:  true,
+		PreserveXattrs: true,
+	})
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+		c.Skip("cannot set file capabilities in " + targetDir)
+	}
+	c.Assert(err, IsNil)
+	pingPath := filepath.Join(targetDir, "usr/bin/ping")
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(pingPath, "security.capability", value)
+	c.Assert(err, IsNil)
+	c.Assert(string(value[:n]), Equals, capability)
+	pingInfo, err := os.Stat(pingPath)
+	c.Assert(err, IsNil)
+	c.Assert(pingInfo.Mode(), Equals, fs.ModeSetuid|0755)
+	mfest = readManifest(c, targetDir, "/chisel-data/manifest.wall")
+	path, err := mfest.Path("/usr/bin/ping")
+	c.Assert(err, IsNil)
+	c.Assert(path.Xattrs, DeepEquals, map[string][]byte{"security.capability": []byte(capability)})
+	c.Assert(path.FinalSHA256, Not(Equals), "")
+}
+
+// concurrentArchive wraps an archive to record the maximum number of
+// packages fetched at the same time.
+type concurrentArchive struct {
+	archive.Archive
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (a *concurrentArchive) Fetch(pkgName string) (io.ReadSeekCloser, *archive.PackageInfo, error) {
+	a.mu.Lock()
+	a.active++
+	if a.active > a.maxSeen {
+		a.maxSeen = a.active
+	}
+	a.mu.Unlock()
+	// Give other fetches a chance to start.
+	time.Sleep(5 * time.Millisecond)
+	defer func() {
+		a.mu.Lock()
+		a.active--
+		a.mu.Unlock()
+	}()
+	return a.Archive.Fetch(pkgName)
+}
+
+func (s *S) TestRunFetchWorkers(c *C) {
+	const numPkgs = 40
+	files := map[string]string{
+		"slices/mydir/manifest-package.yaml": `
+			package: manifest-package
+			slices:
+				manifest:
+					contents:
+						/chisel-data/**: {generate: manifest}
+		`,
+	}
+	pkgs := []*testutil.TestPackage{{
+		Name:    "manifest-package",
+		Version: "1.0",
+		Arch:    "amd64",
+		Hash:    "manifest-package-hash",
+		Data:    testutil.MustMakeDeb([]testutil.TarEntry{testutil.Dir(0755, "./")}),
+	}}
+	keys := []setup.SliceKey{{"manifest-package", "manifest"}}
+	for i := 0; i < numPkgs; i++ {
+		pkgName := fmt.Sprintf("package-%02d", i)
+		files["slices/mydir/"+pkgName+".yaml"] = fmt.Sprintf(`
+			package: %s
+			slices:
+				myslice:
+					contents:
+						/dir/%s:
+		`, pkgName, pkgName)
+		pkgs = append(pkgs, &testutil.TestPackage{
+			Name:    pkgName,
+			Version: "1.0",
+			Arch:    "amd64",
+			Hash:    pkgName + "-hash",
+			Data: testutil.MustMakeDeb([]testutil.TarEntry{
+				testutil.Dir(0755, "./"),
+				testutil.Dir(0755, "./dir/"),
+				testutil.Reg(0644, "./dir/"+pkgName, pkgName+" data"),
+			}),
+		})
+		keys = append(keys, setup.SliceKey{pkgName, "myslice"})
+	}
+	release := readTestRelease(c, files)
+
+	run := func(workers int) (*concurrentArchive, map[string]string, map[string]string) {
+		testArchive := &concurrentArchive{Archive: newTestArchive(pkgs)}
+		targetDir := c.MkDir()
+		var events []*slicer.ProgressEvent
+		err := runSlicer(c, release, keys, nil, &slicer.RunOptions{
+			Archives:     map[string]archive.Archive{"ubuntu": testArchive},
+			TargetDir:    targetDir,
+			FetchWorkers: workers,
+			Progress: func(event *slicer.ProgressEvent) {
+				events = append(events, event)
+			},
+		})
+		c.Assert(err, IsNil)
+		fetched := 0
+		for _, event := range events {
+			if event.Phase == slicer.FetchPhase && !event.Started {
+				fetched++
+				c.Assert(event.Packages, Equals, fetched)
+			}
+		}
+		c.Assert(fetched, Equals, numPkgs+1)
+		mfest := readManifest(c, targetDir, "/chisel-data/manifest.wall")
+		manifestPaths, err := treeDumpManifestPaths(mfest)
+		c.Assert(err, IsNil)
+		return testArchive, testutil.TreeDump(targetDir), manifestPaths
+	}
+
+	serial, serialFS, serialPaths := run(1)
+	c.Assert(serial.maxSeen, Equals, 1)
+	parallel, parallelFS, parallelPaths := run(8)
+	c.Assert(parallel.maxSeen > 1, Equals, true)
+	c.Assert(parallel.maxSeen <= 8, Equals, true)
+
+	// The result does not depend on the order packages were fetched in.
+	c.Assert(parallelFS, DeepEquals, serialFS)
+	c.Assert(parallelPaths, DeepEquals, serialPaths)
+	c.Assert(serialPaths, HasLen, numPkgs+1)
+}
+
+// readTestRelease writes the files of a release, along with defaultChiselYaml
+// unless they have their own chisel.yaml, and reads it.
+func readTestRelease(c *C, files map[string]string) *setup.Release {
+	if _, ok := files["chisel.yaml"]; !ok {
+		files["chisel.yaml"] = defaultChiselYaml
+	}
+	releaseDir := c.MkDir()
+	for path, data := range files {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	release, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	return release
+}
+
+// testPackage returns the test-package package with the given data.
+func testPackage(data []byte) *testutil.TestPackage {
+	return &testutil.TestPackage{
+		Name:    "test-package",
+		Version: "1.0",
+		Arch:    "amd64",
+		Hash:    "h1",
+		Data:    data,
+	}
+}
+
+// newTestArchive returns the ubuntu archive of defaultChiselYaml with pkgs.
+func newTestArchive(pkgs []*testutil.TestPackage) *testutil.TestArchive {
+	packages := make(map[string]*testutil.TestPackage)
+	for _, pkg := range pkgs {
+		packages[pkg.Name] = pkg
+	}
+	return &testutil.TestArchive{
+		Opts: archive.Options{
+			Label:      "ubuntu",
+			Version:    "22.04",
+			Suites:     []string{"jammy"},
+			Components: []string{"main"},
+		},
+		Packages: packages,
+	}
+}
+
+// runSlicer selects keys from release and runs the slicer with options on
+// the selection. Unless options has archives set, pkgs are obtained from
+// the archive returned by newTestArchive.
+func runSlicer(c *C, release *setup.Release, keys []setup.SliceKey, pkgs []*testutil.TestPackage, options *slicer.RunOptions) error {
+	selection, err := setup.Select(release, keys)
+	c.Assert(err, IsNil)
+	options.Selection = selection
+	if options.Archives == nil {
+		options.Archives = map[string]archive.Archive{"ubuntu": newTestArchive(pkgs)}
+	}
+	return slicer.Run(options)
+}
+
 func runSlicerTests(c *C, tests []slicerTest) {
 	for _, test := range tests {
 		for _, testSlices := range testutil.Permutations(test.slices) {
 			c.Logf("Summary: %s", test.summary)
 
-			if _, ok := test.release["chisel.yaml"]; !ok {
-				test.release["chisel.yaml"] = defaultChiselYaml
-			}
 			if test.pkgs == nil {
 				test.pkgs = []*testutil.TestPackage{{
 					Name: "test-package",
@@ -1806,17 +3634,7 @@ func runSlicerTests(c *C, tests []slicerTest) {
 				}
 			}
 
-			releaseDir := c.MkDir()
-			for path, data := range test.release {
-				fpath := filepath.Join(releaseDir, path)
-				err := os.MkdirAll(filepath.Dir(fpath), 0755)
-				c.Assert(err, IsNil)
-				err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
-				c.Assert(err, IsNil)
-			}
-
-			release, err := setup.ReadRelease(releaseDir)
-			c.Assert(err, IsNil)
+			release := readTestRelease(c, test.release)
 
 			// Create a manifest slice and add it to the selection.
 			manifestPackage := test.slices[0].Package
@@ -1868,6 +3686,12 @@ func runSlicerTests(c *C, tests []slicerTest) {
 				Archives:  archives,
 				TargetDir: c.MkDir(),
 			}
+			var events []slicer.ProgressEvent
+			if test.progress != nil {
+				options.Progress = func(event *slicer.ProgressEvent) {
+					events = append(events, *event)
+				}
+			}
 			if test.hackopt != nil {
 				test.hackopt(c, &options)
 			}
@@ -1878,6 +3702,13 @@ func runSlicerTests(c *C, tests []slicerTest) {
 			}
 			c.Assert(err, IsNil)
 
+			if test.progress != nil {
+				c.Assert(events, DeepEquals, test.progress)
+			}
+			if test.check != nil {
+				test.check(c, &options)
+			}
+
 			if test.filesystem == nil && test.manifestPaths == nil && test.manifestPkgs == nil {
 				continue
 			}
@@ -1954,7 +3785,7 @@ func treeDumpManifestPaths(mfest *manifest.Manifest) (map[string]string, error)
 func dumpManifestPkgs(mfest *manifest.Manifest) (map[string]string, error) {
 	result := map[string]string{}
 	err := mfest.IteratePackages(func(pkg *manifest.Package) error {
-		result[pkg.Name] = fmt.Sprintf("%s %s %s %s", pkg.Name, pkg.Version, pkg.Arch, pkg.Digest)
+		result[pkg.Name] = fmt.Sprintf("%s %s %s %s %d/%d", pkg.Name, pkg.Version, pkg.Arch, pkg.Digest, pkg.ExtractedFiles, pkg.TotalFiles)
 		return nil
 	})
 	if err != nil {
@@ -1988,3 +3819,12 @@ func readManifest(c *C, targetDir, manifestPath string) *manifest.Manifest {
 
 	return mfest
 }
+
+func (s *S) TestRegisterGeneratorReserved(c *C) {
+	for _, kind := range []setup.GenerateKind{"", "manifest", "filelist", "package-files"} {
+		c.Assert(func() { slicer.RegisterGenerator(kind, pathCountGenerator{}) }, PanicMatches,
+			fmt.Sprintf("cannot register generator for reserved kind %q", kind))
+	}
+	c.Assert(func() { slicer.RegisterGenerator("path-count", pathCountGenerator{}) }, PanicMatches,
+		`generator for kind "path-count" already registered`)
+}
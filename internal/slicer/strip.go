@@ -0,0 +1,172 @@
+package slicer
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifestutil"
+)
+
+// stripBinaries removes the debug sections from the executables and shared
+// libraries in the report, updating their hash and size in it. Hard links
+// to the same inode are stripped only once. The binaries are copied under
+// tmpDir while being stripped, or under the system temporary directory if
+// tmpDir is empty.
+func stripBinaries(rootDir, tmpDir string, report *manifestutil.Report) error {
+	var paths []string
+	for path, entry := range report.Entries {
+		if entry.Mode.IsRegular() {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+
+	stripPath, err := exec.LookPath("strip")
+	if err != nil {
+		return fmt.Errorf("cannot strip binaries: %w", err)
+	}
+	tmpDir, err = os.MkdirTemp(tmpDir, "chisel-strip-")
+	if err != nil {
+		return fmt.Errorf("cannot strip binaries: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	stripped := make(map[uint64]*fsutil.Entry)
+	for _, relPath := range paths {
+		entry := report.Entries[relPath]
+		absPath := filepath.Join(rootDir, relPath)
+		if result, ok := stripped[entry.Inode]; ok && entry.Inode > 0 {
+			if result != nil {
+				err := report.Mutate(&fsutil.Entry{Path: absPath, Mode: entry.Mode, SHA256: result.SHA256, SHA512: result.SHA512, Size: result.Size})
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		result, err := stripBinary(stripPath, tmpDir, absPath, entry.Mode, entry.Xattrs)
+		if err != nil {
+			return fmt.Errorf("cannot strip %s: %w", relPath, err)
+		}
+		if entry.Inode > 0 {
+			stripped[entry.Inode] = result
+		}
+		if result == nil {
+			continue
+		}
+		logf("Stripped %s", relPath)
+		result.Mode = entry.Mode
+		err = report.Mutate(result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripBinary strips the debug sections of the file at path if it is an ELF
+// executable or shared library which has any. The stripped content is
+// written back into the same file, so that hard links to it are preserved,
+// and mode and xattrs are then set again as writing to the file drops the
+// setuid and setgid bits and the file capabilities. It returns nil if the
+// file was left untouched.
+func stripBinary(stripPath, tmpDir, path string, mode fs.FileMode, xattrs map[string]string) (*fsutil.Entry, error) {
+	if !hasDebugSections(path) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := filepath.Join(tmpDir, "binary")
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+	output, err := exec.Command(stripPath, "--strip-debug", tmpPath).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("strip failed: %s", strings.TrimSpace(string(output)))
+	}
+	data, err = os.ReadFile(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = file.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range xattrs {
+		err := unix.Setxattr(path, name, []byte(value), 0)
+		if err != nil {
+			return nil, &os.PathError{Op: "setxattr " + name, Path: path, Err: err}
+		}
+	}
+	sum := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+	return &fsutil.Entry{
+		Path:   path,
+		SHA256: hex.EncodeToString(sum[:]),
+		SHA512: hex.EncodeToString(sum512[:]),
+		Size:   len(data),
+	}, nil
+}
+
+// hasDebugSections returns whether the file at path is an ELF executable or
+// shared library with debug sections. Files which cannot be parsed as ELF
+// are reported as having none.
+func hasDebugSections(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var magic [len(elf.ELFMAG)]byte
+	_, err = io.ReadFull(file, magic[:])
+	if err != nil || string(magic[:]) != elf.ELFMAG {
+		return false
+	}
+	elfFile, err := elf.NewFile(file)
+	if err != nil {
+		return false
+	}
+	if elfFile.Type != elf.ET_EXEC && elfFile.Type != elf.ET_DYN {
+		return false
+	}
+	for _, section := range elfFile.Sections {
+		if strings.HasPrefix(section.Name, ".debug_") || strings.HasPrefix(section.Name, ".zdebug_") {
+			return true
+		}
+	}
+	return false
+}
@@ -17,6 +17,7 @@ type TestPackage struct {
 	Name     string
 	Version  string
 	Hash     string
+	SHA512   string
 	Arch     string
 	Data     []byte
 	Archives []string
@@ -35,7 +36,9 @@ func (a *TestArchive) Fetch(pkgName string) (io.ReadSeekCloser, *archive.Package
 		Name:    pkg.Name,
 		Version: pkg.Version,
 		SHA256:  pkg.Hash,
+		SHA512:  pkg.SHA512,
 		Arch:    pkg.Arch,
+		Size:    int64(len(pkg.Data)),
 	}
 	return ReadSeekNopCloser(bytes.NewReader(pkg.Data)), info, nil
 }
@@ -54,6 +57,8 @@ func (a *TestArchive) Info(pkgName string) (*archive.PackageInfo, error) {
 		Name:    pkg.Name,
 		Version: pkg.Version,
 		SHA256:  pkg.Hash,
+		SHA512:  pkg.SHA512,
 		Arch:    pkg.Arch,
+		Size:    int64(len(pkg.Data)),
 	}, nil
 }
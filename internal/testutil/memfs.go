@@ -0,0 +1,305 @@
+package testutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+// MemFS is an in-memory fsutil.FS, for checking what is written through
+// it. Paths are absolute, and symlinks are not followed in their parent
+// directories.
+type MemFS struct {
+	mu      sync.Mutex
+	entries map[string]*memEntry
+}
+
+type memEntry struct {
+	mode fs.FileMode
+	data []byte
+	link string
+}
+
+var _ fsutil.FS = (*MemFS)(nil)
+
+// NewMemFS returns an empty MemFS, with only its root directory.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		entries: map[string]*memEntry{
+			"/": {mode: fs.ModeDir | 0755},
+		},
+	}
+}
+
+func (m *MemFS) lookup(op, path string) (string, *memEntry, error) {
+	path = filepath.Clean(path)
+	if !filepath.IsAbs(path) {
+		return "", nil, &fs.PathError{Op: op, Path: path, Err: fs.ErrInvalid}
+	}
+	return path, m.entries[path], nil
+}
+
+// add adds the entry at path, whose parent directory must exist.
+func (m *MemFS) add(op, path string, entry *memEntry) error {
+	path, old, err := m.lookup(op, path)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		return &fs.PathError{Op: op, Path: path, Err: fs.ErrExist}
+	}
+	parent := m.entries[filepath.Dir(path)]
+	if parent == nil {
+		return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: path, Err: syscall.ENOTDIR}
+	}
+	m.entries[path] = entry
+	return nil
+}
+
+func (m *MemFS) Mkdir(path string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add("mkdir", path, &memEntry{mode: fs.ModeDir | perm})
+}
+
+func (m *MemFS) MkdirAll(path string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, _, err := m.lookup("mkdir", path)
+	if err != nil {
+		return err
+	}
+	dir := "/"
+	for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir = filepath.Join(dir, name)
+		if entry, ok := m.entries[dir]; ok {
+			if !entry.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		err := m.add("mkdir", dir, &memEntry{mode: fs.ModeDir | perm})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Create(path string, perm fs.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, entry, err := m.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		entry = &memEntry{mode: perm}
+		err := m.add("open", path, entry)
+		if err != nil {
+			return nil, err
+		}
+	} else if !entry.mode.IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	}
+	entry.data = nil
+	return &memWriter{m, entry}, nil
+}
+
+type memWriter struct {
+	fs    *MemFS
+	entry *memEntry
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.entry.data = append(w.entry.data, p...)
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
+
+func (m *MemFS) Symlink(target, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add("symlink", path, &memEntry{mode: fs.ModeSymlink | 0777, link: target})
+}
+
+func (m *MemFS) Link(target, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	target, entry, err := m.lookup("link", target)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return &fs.PathError{Op: "link", Path: target, Err: fs.ErrNotExist}
+	}
+	if entry.mode.IsDir() {
+		return &fs.PathError{Op: "link", Path: target, Err: fs.ErrPermission}
+	}
+	return m.add("link", path, entry)
+}
+
+func (m *MemFS) Chmod(path string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, entry, err := m.lookup("chmod", path)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return &fs.PathError{Op: "chmod", Path: path, Err: fs.ErrNotExist}
+	}
+	entry.mode = entry.mode.Type() | mode&^fs.ModeType
+	return nil
+}
+
+func (m *MemFS) Lstat(path string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, entry, err := m.lookup("lstat", path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, &fs.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+	}
+	return &memFileInfo{name: filepath.Base(path), mode: entry.mode, size: int64(len(entry.data))}, nil
+}
+
+func (m *MemFS) Readlink(path string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, entry, err := m.lookup("readlink", path)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", &fs.PathError{Op: "readlink", Path: path, Err: fs.ErrNotExist}
+	}
+	if entry.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: path, Err: fs.ErrInvalid}
+	}
+	return entry.link, nil
+}
+
+func (m *MemFS) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, entry, err := m.lookup("remove", path)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	if entry.mode.IsDir() {
+		for other := range m.entries {
+			if filepath.Dir(other) == path && other != path {
+				return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+			}
+		}
+	}
+	delete(m.entries, path)
+	return nil
+}
+
+// Dump returns the entries of the filesystem in the same format as
+// [testutil.TreeDump].
+func (m *MemFS) Dump() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.entries))
+	for path := range m.entries {
+		if path != "/" {
+			paths = append(paths, path)
+		}
+	}
+	// Sort as the directories are walked by TreeDump, so hard links get
+	// the same identifiers.
+	sort.Slice(paths, func(i, j int) bool {
+		return slices.Compare(strings.Split(paths[i], "/"), strings.Split(paths[j], "/")) < 0
+	})
+	result := make(map[string]string)
+	var groups []*memEntry
+	pathsByEntry := make(map[*memEntry][]string)
+	for _, path := range paths {
+		entry := m.entries[path]
+		perm := entry.mode & fs.ModePerm
+		if entry.mode&fs.ModeSticky != 0 {
+			perm |= 01000
+		}
+		switch {
+		case entry.mode.IsDir():
+			path += "/"
+			result[path] = fmt.Sprintf("dir %#o", perm)
+			continue
+		case entry.mode&fs.ModeSymlink != 0:
+			result[path] = fmt.Sprintf("symlink %s", entry.link)
+		case len(entry.data) == 0:
+			result[path] = fmt.Sprintf("file %#o empty", perm)
+		default:
+			result[path] = fmt.Sprintf("file %#o %.4x", perm, sha256.Sum256(entry.data))
+		}
+		if len(pathsByEntry[entry]) == 1 {
+			groups = append(groups, entry)
+		}
+		pathsByEntry[entry] = append(pathsByEntry[entry], path)
+	}
+	for i, entry := range groups {
+		for _, path := range pathsByEntry[entry] {
+			result[path] = fmt.Sprintf("%s <%d>", result[path], i+1)
+		}
+	}
+	return result
+}
+
+// ReadFile returns the content of the regular file at path.
+func (m *MemFS) ReadFile(path string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, entry, err := m.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	if !entry.mode.IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	return slices.Clone(entry.data), nil
+}
+
+type memFileInfo struct {
+	name string
+	mode fs.FileMode
+	size int64
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() any           { return nil }
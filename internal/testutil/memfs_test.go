@@ -0,0 +1,59 @@
+package testutil_test
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type memFSSuite struct{}
+
+var _ = Suite(&memFSSuite{})
+
+func (s *memFSSuite) TestMemFS(c *C) {
+	memFS := testutil.NewMemFS()
+	err := memFS.MkdirAll("/usr/bin", 0755)
+	c.Assert(err, IsNil)
+	_, err = fsutil.Create(&fsutil.CreateOptions{
+		Path:        "/usr/bin/foo",
+		Mode:        0755,
+		Data:        strings.NewReader("foo"),
+		FS:          memFS,
+		MakeParents: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(memFS.Link("/usr/bin/foo", "/usr/bin/bar"), IsNil)
+	c.Assert(memFS.Symlink("foo", "/usr/bin/baz"), IsNil)
+	c.Assert(memFS.Mkdir("/tmp", 0777|fs.ModeSticky), IsNil)
+	c.Assert(memFS.Chmod("/usr/bin/bar", 0700), IsNil)
+
+	c.Assert(memFS.Dump(), DeepEquals, map[string]string{
+		"/tmp/":        "dir 01777",
+		"/usr/":        "dir 0755",
+		"/usr/bin/":    "dir 0755",
+		"/usr/bin/bar": "file 0700 2c26b46b <1>",
+		"/usr/bin/baz": "symlink foo",
+		"/usr/bin/foo": "file 0700 2c26b46b <1>",
+	})
+	data, err := memFS.ReadFile("/usr/bin/bar")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "foo")
+	link, err := memFS.Readlink("/usr/bin/baz")
+	c.Assert(err, IsNil)
+	c.Assert(link, Equals, "foo")
+
+	err = memFS.Mkdir("/usr", 0755)
+	c.Assert(os.IsExist(err), Equals, true)
+	err = memFS.Mkdir("/missing/dir", 0755)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	err = memFS.Remove("/usr/bin")
+	c.Assert(err, ErrorMatches, "remove /usr/bin: directory not empty")
+	c.Assert(memFS.Remove("/usr/bin/bar"), IsNil)
+	_, err = memFS.Lstat("/usr/bin/bar")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -1,8 +1,13 @@
 package testutil
 
 import (
+	"bytes"
+	"crypto"
 	"log"
+	"time"
 
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
 
 	"github.com/canonical/chisel/internal/pgputil"
@@ -64,6 +69,72 @@ func init() {
 	}
 }
 
+// MakeExpiringKey returns a new key created at the given time which expires
+// after lifetime, with the armored public key holding the self-signature
+// which sets the expiry.
+func MakeExpiringKey(created time.Time, lifetime time.Duration) (*PGPKeyData, error) {
+	config := &packet.Config{
+		RSABits: 1024,
+		Time:    func() time.Time { return created },
+	}
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", config)
+	if err != nil {
+		return nil, err
+	}
+	for _, ident := range entity.Identities {
+		secs := uint32(lifetime / time.Second)
+		ident.SelfSignature.KeyLifetimeSecs = &secs
+		err = ident.SelfSignature.SignUserId(ident.UserId.Id, entity.PrimaryKey, entity.PrivateKey, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Only the primary key is kept, as in chisel.yaml.
+	entity.Subkeys = nil
+	var buf bytes.Buffer
+	writer, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = entity.Serialize(writer)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &PGPKeyData{
+		ID:          entity.PrimaryKey.KeyIdString(),
+		PubKeyArmor: buf.String(),
+		PubKey:      entity.PrimaryKey,
+		PrivKey:     entity.PrivateKey,
+	}, nil
+}
+
+// DetachSign returns the binary detached signature of data made with privKey.
+func DetachSign(privKey *packet.PrivateKey, data []byte) ([]byte, error) {
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeBinary,
+		PubKeyAlgo:   privKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: epochStartTime,
+		IssuerKeyId:  &privKey.KeyId,
+	}
+	hash := sig.Hash.New()
+	hash.Write(data)
+	err := sig.Sign(hash, privKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = sig.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // Ubuntu Archive Automatic Signing Key (2018) <ftpmaster@ubuntu.com>.
 // ID: 871920D1991BC93C.
 // Useful to validate InRelease files from live archive.
@@ -3,11 +3,17 @@ package testutil
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/blakesmith/ar"
 	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 var PackageData = map[string][]byte{}
@@ -122,14 +128,76 @@ func compressBytesZstd(input []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func compressBytesGzip(input []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(input); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compressBytesXz(input []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := xz.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(input); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compressBytesLzma(input []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := lzma.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(input); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func MakeDeb(entries []TarEntry) ([]byte, error) {
+	return MakeDebWithData("data.tar.zst", entries)
+}
+
+// MakeDebWithData creates a package like MakeDeb, storing the data under the
+// given ar member name and compressing it according to the name's extension.
+func MakeDebWithData(member string, entries []TarEntry) ([]byte, error) {
 	var buf bytes.Buffer
 
 	tarData, err := makeTar(entries)
 	if err != nil {
 		return nil, err
 	}
-	compTarData, err := compressBytesZstd(tarData)
+	var compTarData []byte
+	switch ext := path.Ext(strings.TrimSuffix(member, "/")); ext {
+	case ".tar":
+		compTarData = tarData
+	case ".gz":
+		compTarData, err = compressBytesGzip(tarData)
+	case ".xz":
+		compTarData, err = compressBytesXz(tarData)
+	case ".lzma":
+		compTarData, err = compressBytesLzma(tarData)
+	case ".zst":
+		compTarData, err = compressBytesZstd(tarData)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", ext)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +207,7 @@ func MakeDeb(entries []TarEntry) ([]byte, error) {
 		return nil, err
 	}
 	dataHeader := ar.Header{
-		Name: "data.tar.zst",
+		Name: member,
 		Mode: 0644,
 		Size: int64(len(compTarData)),
 	}
@@ -152,6 +220,105 @@ func MakeDeb(entries []TarEntry) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// MakeSignedDeb creates a package like MakeDeb, also including the
+// debian-binary member and a debsig origin signature made with privKey.
+func MakeSignedDeb(entries []TarEntry, privKey *packet.PrivateKey) ([]byte, error) {
+	tarData, err := makeTar(entries)
+	if err != nil {
+		return nil, err
+	}
+	compTarData, err := compressBytesZstd(tarData)
+	if err != nil {
+		return nil, err
+	}
+	debianBinary := []byte("2.0\n")
+	sigData, err := DetachSign(privKey, append(append([]byte(nil), debianBinary...), compTarData...))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writer := ar.NewWriter(&buf)
+	if err := writer.WriteGlobalHeader(); err != nil {
+		return nil, err
+	}
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", debianBinary},
+		{"data.tar.zst", compTarData},
+		{"_gpgorigin", sigData},
+	}
+	for _, member := range members {
+		header := ar.Header{
+			Name: member.name,
+			Mode: 0644,
+			Size: int64(len(member.data)),
+		}
+		if err := writer.WriteHeader(&header); err != nil {
+			return nil, err
+		}
+		if _, err = writer.Write(member.data); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// MakeDebWithControl creates a package like MakeDeb, also including the
+// debian-binary member and a control member holding the provided control
+// file.
+func MakeDebWithControl(control string, entries []TarEntry) ([]byte, error) {
+	controlTar, err := makeTar([]TarEntry{
+		Dir(0755, "./"),
+		Reg(0644, "./control", control),
+	})
+	if err != nil {
+		return nil, err
+	}
+	compControlTar, err := compressBytesZstd(controlTar)
+	if err != nil {
+		return nil, err
+	}
+	tarData, err := makeTar(entries)
+	if err != nil {
+		return nil, err
+	}
+	compTarData, err := compressBytesZstd(tarData)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writer := ar.NewWriter(&buf)
+	if err := writer.WriteGlobalHeader(); err != nil {
+		return nil, err
+	}
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.zst", compControlTar},
+		{"data.tar.zst", compTarData},
+	}
+	for _, member := range members {
+		header := ar.Header{
+			Name: member.name,
+			Mode: 0644,
+			Size: int64(len(member.data)),
+		}
+		if err := writer.WriteHeader(&header); err != nil {
+			return nil, err
+		}
+		if _, err = writer.Write(member.data); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
 func MustMakeDeb(entries []TarEntry) []byte {
 	data, err := MakeDeb(entries)
 	if err != nil {
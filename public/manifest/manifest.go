@@ -3,13 +3,21 @@
 package manifest
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 
 	"github.com/canonical/chisel/public/jsonwall"
 )
 
-const Schema = "1.0"
+// Schema is the current schema version. Version 1.1 adds the optional sha512
+// digests of packages and paths, so manifests written with 1.0 only lack them.
+const Schema = "1.1"
+
+// SupportedSchemas lists the schema versions which may be read and written,
+// including the current Schema.
+var SupportedSchemas = []string{"1.0", "1.1"}
 
 type Package struct {
 	Kind    string `json:"kind"`
@@ -17,6 +25,16 @@ type Package struct {
 	Version string `json:"version,omitempty"`
 	Digest  string `json:"sha256,omitempty"`
 	Arch    string `json:"arch,omitempty"`
+	// SHA512 is the digest of the package file, if requested when writing
+	// the manifest.
+	SHA512 string `json:"sha512,omitempty"`
+	// Section and Priority are copied from the archive index, if present.
+	Section  string `json:"section,omitempty"`
+	Priority string `json:"priority,omitempty"`
+	// ExtractedFiles and TotalFiles record, when known, how many of the
+	// files in the package were extracted.
+	ExtractedFiles int `json:"extracted_files,omitempty"`
+	TotalFiles     int `json:"total_files,omitempty"`
 }
 
 type Slice struct {
@@ -31,9 +49,20 @@ type Path struct {
 	Slices      []string `json:"slices,omitempty"`
 	SHA256      string   `json:"sha256,omitempty"`
 	FinalSHA256 string   `json:"final_sha256,omitempty"`
-	Size        uint64   `json:"size,omitempty"`
-	Link        string   `json:"link,omitempty"`
-	Inode       uint64   `json:"inode,omitempty"`
+	// SHA512 and FinalSHA512 are like SHA256 and FinalSHA256, and are only
+	// present when requested when writing the manifest.
+	SHA512      string `json:"sha512,omitempty"`
+	FinalSHA512 string `json:"final_sha512,omitempty"`
+	Size        uint64 `json:"size,omitempty"`
+	Link        string `json:"link,omitempty"`
+	Inode       uint64 `json:"inode,omitempty"`
+	// UID and GID are only present when the owner of the path was set
+	// explicitly in its slice.
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+	// Xattrs holds the extended attributes preserved from the package,
+	// such as security.capability, indexed by name.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
 }
 
 type Content struct {
@@ -42,13 +71,43 @@ type Content struct {
 	Path  string `json:"path,omitempty"`
 }
 
+// Prefer records that the slices of several packages listed Path, and
+// that Package was preferred over the other Candidates to provide it. These
+// entries are optional, and older readers ignore them.
+type Prefer struct {
+	Kind       string   `json:"kind"`
+	Path       string   `json:"path,omitempty"`
+	Package    string   `json:"package,omitempty"`
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+// Manifest provides read access to the content of a Chisel manifest. The
+// Package, Path, PathsInSlice and SlicesForPath methods, along with the
+// Iterate* ones, are the supported interface for querying it.
 type Manifest struct {
 	db *jsonwall.DB
 }
 
+// ErrNotFound is returned, wrapped, by the query methods of Manifest when
+// the requested entry is not in the manifest.
+var ErrNotFound = errors.New("not in manifest")
+
+// ReadOptions holds optional settings for reading a Manifest.
+type ReadOptions struct {
+	// Schemas lists the schema versions accepted. When empty, all the
+	// SupportedSchemas are accepted.
+	Schemas []string
+}
+
 // Read loads a Manifest without performing any validation. The data is assumed
 // to be both valid jsonwall and a valid Manifest (see Validate).
 func Read(reader io.Reader) (manifest *Manifest, err error) {
+	return ReadWithOptions(reader, nil)
+}
+
+// ReadWithOptions is like Read, but accepts options which change the way the
+// manifest is read. The options may be nil.
+func ReadWithOptions(reader io.Reader, options *ReadOptions) (manifest *Manifest, err error) {
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("cannot read manifest: %s", err)
@@ -59,8 +118,12 @@ func Read(reader io.Reader) (manifest *Manifest, err error) {
 	if err != nil {
 		return nil, err
 	}
+	schemas := SupportedSchemas
+	if options != nil && len(options.Schemas) > 0 {
+		schemas = options.Schemas
+	}
 	mfestSchema := db.Schema()
-	if mfestSchema != Schema {
+	if !slices.Contains(schemas, mfestSchema) {
 		return nil, fmt.Errorf("unknown schema version %q", mfestSchema)
 	}
 
@@ -68,6 +131,11 @@ func Read(reader io.Reader) (manifest *Manifest, err error) {
 	return manifest, nil
 }
 
+// Schema returns the schema version of the manifest.
+func (manifest *Manifest) Schema() string {
+	return manifest.db.Schema()
+}
+
 func (manifest *Manifest) IteratePaths(pathPrefix string, onMatch func(*Path) error) (err error) {
 	return iteratePrefix(manifest, &Path{Kind: "path", Path: pathPrefix}, onMatch)
 }
@@ -84,8 +152,101 @@ func (manifest *Manifest) IterateContents(slice string, onMatch func(*Content) e
 	return iteratePrefix(manifest, &Content{Kind: "content", Slice: slice}, onMatch)
 }
 
+// IteratePrefers calls onMatch for every recorded preference whose path
+// starts with pathPrefix.
+func (manifest *Manifest) IteratePrefers(pathPrefix string, onMatch func(*Prefer) error) (err error) {
+	return iteratePrefix(manifest, &Prefer{Kind: "prefer", Path: pathPrefix}, onMatch)
+}
+
+// IteratePathsBySlice calls onMatch for every path owned by the given slice,
+// using the content entries to find them without scanning all paths.
+func (manifest *Manifest) IteratePathsBySlice(sliceName string, onMatch func(*Path) error) (err error) {
+	return manifest.IterateContents(sliceName, func(content *Content) error {
+		// IterateContents matches slice names by prefix.
+		if content.Slice != sliceName {
+			return nil
+		}
+		path := &Path{Kind: "path", Path: content.Path}
+		err := manifest.db.Get(path)
+		if err != nil {
+			return fmt.Errorf("cannot read manifest: cannot find path %q of slice %q: %s", content.Path, sliceName, err)
+		}
+		return onMatch(path)
+	})
+}
+
+// Package returns the package with the given name.
+func (manifest *Manifest) Package(name string) (*Package, error) {
+	pkg := &Package{Kind: "package", Name: name}
+	err := getEntry(manifest, pkg, "package", name)
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Path returns the entry for the given path. Directories are recorded with a
+// trailing slash, which must be included in p.
+func (manifest *Manifest) Path(p string) (*Path, error) {
+	path := &Path{Kind: "path", Path: p}
+	err := getEntry(manifest, path, "path", p)
+	if err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// PathsInSlice returns the entries for all the paths owned by the given
+// slice, ordered by path.
+func (manifest *Manifest) PathsInSlice(slice string) ([]*Path, error) {
+	// Slice entries have no fields after the name, so they cannot be looked
+	// up with Get and are found by prefix instead.
+	found := false
+	err := manifest.IterateSlices(slice, func(s *Slice) error {
+		found = found || s.Name == slice
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot find slice %q: %w", slice, ErrNotFound)
+	}
+	var paths []*Path
+	err = manifest.IteratePathsBySlice(slice, func(path *Path) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// SlicesForPath returns the names of the slices which own the given path.
+func (manifest *Manifest) SlicesForPath(p string) ([]string, error) {
+	path, err := manifest.Path(p)
+	if err != nil {
+		return nil, err
+	}
+	return path.Slices, nil
+}
+
+// getEntry looks up the entry with the same initial fields as value, which
+// is a binary search in the underlying database, and decodes it into value.
+func getEntry(manifest *Manifest, value any, kind, name string) error {
+	err := manifest.db.Get(value)
+	if errors.Is(err, jsonwall.ErrNotFound) {
+		return fmt.Errorf("cannot find %s %q: %w", kind, name, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read manifest: %s", err)
+	}
+	return nil
+}
+
 type prefixable interface {
-	Path | Content | Package | Slice
+	Path | Content | Package | Slice | Prefer
 }
 
 func iteratePrefix[T prefixable](manifest *Manifest, prefix *T, onMatch func(*T) error) error {
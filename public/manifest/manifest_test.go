@@ -3,6 +3,7 @@
 package manifest_test
 
 import (
+	"errors"
 	"os"
 	"path"
 	"slices"
@@ -70,6 +71,60 @@ var readManifestTests = []struct {
 			{Kind: "content", Slice: "pkg2_myotherslice", Path: "/dir/foo/bar/"},
 		},
 	},
+}, {
+	summary: "SHA512 digests in schema 1.1",
+	input: `
+		{"jsonwall":"1.0","schema":"1.1","count":6}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/other"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1","sha512":"hash1-512"}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","final_sha256":"final","sha512":"hash512","final_sha512":"final512","size":3}
+		{"kind":"path","path":"/dir/other","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","size":3}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	mfest: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/dir/file", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "hash", FinalSHA256: "final", SHA512: "hash512", FinalSHA512: "final512", Size: 3},
+			{Kind: "path", Path: "/dir/other", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "hash", Size: 3},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "arch1", SHA512: "hash1-512"},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_myslice"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/dir/file"},
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/dir/other"},
+		},
+	},
+}, {
+	summary: "Preferences",
+	input: `
+		{"jsonwall":"1.0","schema":"1.1","count":5}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","size":3}
+		{"kind":"prefer","path":"/dir/file","package":"pkg1","candidates":["pkg1","pkg2"]}
+		{"kind":"slice","name":"pkg1_myslice"}
+	`,
+	mfest: &apachetestutil.ManifestContents{
+		Paths: []*manifest.Path{
+			{Kind: "path", Path: "/dir/file", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "hash", Size: 3},
+		},
+		Packages: []*manifest.Package{
+			{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "arch1"},
+		},
+		Slices: []*manifest.Slice{
+			{Kind: "slice", Name: "pkg1_myslice"},
+		},
+		Contents: []*manifest.Content{
+			{Kind: "content", Slice: "pkg1_myslice", Path: "/dir/file"},
+		},
+		Prefers: []*manifest.Prefer{
+			{Kind: "prefer", Path: "/dir/file", Package: "pkg1", Candidates: []string{"pkg1", "pkg2"}},
+		},
+	},
 }, {
 	summary: "Unknown schema",
 	input: `
@@ -118,3 +173,124 @@ func (s *S) TestManifestRead(c *C) {
 		}
 	}
 }
+
+func (s *S) TestIteratePathsBySlice(c *C) {
+	input := trimLines(`
+		{"jsonwall":"1.0","schema":"1.0","count":8}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/foo/"}
+		{"kind":"content","slice":"pkg1_myslice2","path":"/dir/other"}
+		{"kind":"content","slice":"pkg2_myotherslice","path":"/dir/foo/"}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"size":0}
+		{"kind":"path","path":"/dir/foo/","mode":"0755","slices":["pkg1_myslice","pkg2_myotherslice"]}
+		{"kind":"path","path":"/dir/other","mode":"0644","slices":["pkg1_myslice2"],"size":0}
+	`)
+	mfest, err := manifest.Read(strings.NewReader(input))
+	c.Assert(err, IsNil)
+
+	collect := func(sliceName string) []string {
+		var paths []string
+		err := mfest.IteratePathsBySlice(sliceName, func(path *manifest.Path) error {
+			paths = append(paths, path.Path)
+			return nil
+		})
+		c.Assert(err, IsNil)
+		return paths
+	}
+	c.Assert(collect("pkg1_myslice"), DeepEquals, []string{"/dir/file", "/dir/foo/"})
+	c.Assert(collect("pkg1_myslice2"), DeepEquals, []string{"/dir/other"})
+	c.Assert(collect("pkg2_myotherslice"), DeepEquals, []string{"/dir/foo/"})
+	c.Assert(collect("pkg3_missing"), IsNil)
+}
+
+func (s *S) TestIteratePathsBySliceMissingPath(c *C) {
+	input := trimLines(`
+		{"jsonwall":"1.0","schema":"1.0","count":1}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+	`)
+	mfest, err := manifest.Read(strings.NewReader(input))
+	c.Assert(err, IsNil)
+	err = mfest.IteratePathsBySlice("pkg1_myslice", func(path *manifest.Path) error {
+		return nil
+	})
+	c.Assert(err, ErrorMatches, `cannot read manifest: cannot find path "/dir/file" of slice "pkg1_myslice": value not found in database`)
+}
+
+func (s *S) TestManifestQueries(c *C) {
+	input := trimLines(`
+		{"jsonwall":"1.0","schema":"1.0","count":12}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/file"}
+		{"kind":"content","slice":"pkg1_myslice","path":"/dir/foo/"}
+		{"kind":"content","slice":"pkg1_myslice2","path":"/dir/other"}
+		{"kind":"content","slice":"pkg2_myotherslice","path":"/dir/foo/"}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+		{"kind":"package","name":"pkg12","version":"v12","sha256":"hash12","arch":"arch1"}
+		{"kind":"path","path":"/dir/file","mode":"0644","slices":["pkg1_myslice"],"sha256":"hash","size":3}
+		{"kind":"path","path":"/dir/foo/","mode":"0755","slices":["pkg1_myslice","pkg2_myotherslice"]}
+		{"kind":"path","path":"/dir/other","mode":"0644","slices":["pkg1_myslice2"],"size":0}
+		{"kind":"slice","name":"pkg1_myslice"}
+		{"kind":"slice","name":"pkg1_myslice2"}
+		{"kind":"slice","name":"pkg2_myotherslice"}
+	`)
+	mfest, err := manifest.Read(strings.NewReader(input))
+	c.Assert(err, IsNil)
+
+	pkg, err := mfest.Package("pkg1")
+	c.Assert(err, IsNil)
+	c.Assert(pkg, DeepEquals, &manifest.Package{Kind: "package", Name: "pkg1", Version: "v1", Digest: "hash1", Arch: "arch1"})
+	_, err = mfest.Package("pkg")
+	c.Assert(err, ErrorMatches, `cannot find package "pkg": not in manifest`)
+	c.Assert(errors.Is(err, manifest.ErrNotFound), Equals, true)
+
+	path, err := mfest.Path("/dir/file")
+	c.Assert(err, IsNil)
+	c.Assert(path, DeepEquals, &manifest.Path{Kind: "path", Path: "/dir/file", Mode: "0644", Slices: []string{"pkg1_myslice"}, SHA256: "hash", Size: 3})
+	_, err = mfest.Path("/dir/foo")
+	c.Assert(err, ErrorMatches, `cannot find path "/dir/foo": not in manifest`)
+
+	paths, err := mfest.PathsInSlice("pkg1_myslice")
+	c.Assert(err, IsNil)
+	var names []string
+	for _, path := range paths {
+		names = append(names, path.Path)
+	}
+	c.Assert(names, DeepEquals, []string{"/dir/file", "/dir/foo/"})
+	_, err = mfest.PathsInSlice("pkg1_mys")
+	c.Assert(err, ErrorMatches, `cannot find slice "pkg1_mys": not in manifest`)
+
+	sliceNames, err := mfest.SlicesForPath("/dir/foo/")
+	c.Assert(err, IsNil)
+	c.Assert(sliceNames, DeepEquals, []string{"pkg1_myslice", "pkg2_myotherslice"})
+	_, err = mfest.SlicesForPath("/missing")
+	c.Assert(errors.Is(err, manifest.ErrNotFound), Equals, true)
+}
+
+func (s *S) TestReadWithOptionsSchemas(c *C) {
+	input := trimLines(`
+		{"jsonwall":"1.0","schema":"2.0","count":1}
+		{"kind":"package","name":"pkg1","version":"v1","sha256":"hash1","arch":"arch1"}
+	`)
+	_, err := manifest.ReadWithOptions(strings.NewReader(input), nil)
+	c.Assert(err, ErrorMatches, `cannot read manifest: unknown schema version "2.0"`)
+
+	_, err = manifest.ReadWithOptions(strings.NewReader(input), &manifest.ReadOptions{
+		Schemas: []string{"1.0"},
+	})
+	c.Assert(err, ErrorMatches, `cannot read manifest: unknown schema version "2.0"`)
+
+	mfest, err := manifest.ReadWithOptions(strings.NewReader(input), &manifest.ReadOptions{
+		Schemas: []string{"1.0", "2.0"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(mfest.Schema(), Equals, "2.0")
+}
+
+// trimLines removes the leading tabs and surrounding blank lines from a
+// jsonwall embedded in the test source.
+func trimLines(in string) string {
+	lines := strings.Split(strings.TrimSpace(in), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, "\t")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// SPDXOptions holds optional settings for writing an SPDX document.
+type SPDXOptions struct {
+	// Name is the name of the document. It defaults to "chisel".
+	Name string
+	// Namespace is the unique URI of the document. When empty, one is
+	// derived from the content of the manifest.
+	Namespace string
+	// Created is the creation time recorded in the document. When zero,
+	// the Unix epoch is used so that the output is reproducible.
+	Created time.Time
+}
+
+// WriteSPDX writes the packages and files in the manifest to w as an
+// SPDX 2.3 JSON document.
+func WriteSPDX(manifest *Manifest, w io.Writer) error {
+	return WriteSPDXWithOptions(manifest, w, nil)
+}
+
+// WriteSPDXWithOptions is like WriteSPDX, but accepts options which change
+// the document metadata. The options may be nil.
+//
+// Every package is described by the document, and contains the regular files
+// owned by its slices according to the content entries. Directories and
+// symlinks are left out, as they have no checksum.
+func WriteSPDXWithOptions(manifest *Manifest, w io.Writer, options *SPDXOptions) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cannot write SPDX document: %s", err)
+		}
+	}()
+
+	if options == nil {
+		options = &SPDXOptions{}
+	}
+	doc := &spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      spdxDocumentID,
+		Name:        options.Name,
+		Namespace:   options.Namespace,
+		CreationInfo: spdxCreationInfo{
+			Created:  options.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: chisel"},
+		},
+		Packages:      []spdxPackage{},
+		Files:         []spdxFile{},
+		Relationships: []spdxRelationship{},
+	}
+	if doc.Name == "" {
+		doc.Name = "chisel"
+	}
+	if options.Created.IsZero() {
+		doc.CreationInfo.Created = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	}
+
+	// The digest identifies the manifest content for the default namespace.
+	digest := sha256.New()
+
+	pkgIDs := make(map[string]string)
+	usedIDs := make(map[string]bool)
+	err = manifest.IteratePackages(func(pkg *Package) error {
+		id := spdxID("Package", pkg.Name, usedIDs)
+		pkgIDs[pkg.Name] = id
+		spdxPkg := spdxPackage{
+			SPDXID:           id,
+			Name:             pkg.Name,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			FilesAnalyzed:    false,
+		}
+		if pkg.Digest != "" {
+			spdxPkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", Value: pkg.Digest}}
+		}
+		if pkg.Version != "" {
+			locator := fmt.Sprintf("pkg:deb/%s@%s", pkg.Name, pkg.Version)
+			if pkg.Arch != "" {
+				locator += "?arch=" + pkg.Arch
+			}
+			spdxPkg.ExternalRefs = []spdxExternalRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  locator,
+			}}
+		}
+		doc.Packages = append(doc.Packages, spdxPkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			Element: spdxDocumentID,
+			Type:    "DESCRIBES",
+			Related: id,
+		})
+		fmt.Fprintf(digest, "package %s %s %s %s\n", pkg.Name, pkg.Version, pkg.Arch, pkg.Digest)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fileIDs := make(map[string]string)
+	err = manifest.IteratePaths("", func(path *Path) error {
+		if strings.HasSuffix(path.Path, "/") || path.Link != "" {
+			return nil
+		}
+		sha := path.FinalSHA256
+		if sha == "" {
+			sha = path.SHA256
+		}
+		if sha == "" {
+			// SPDX requires a checksum for every file, and paths such as
+			// the manifest itself are recorded without one.
+			return nil
+		}
+		id := fmt.Sprintf("SPDXRef-File-%d", len(doc.Files)+1)
+		fileIDs[path.Path] = id
+		doc.Files = append(doc.Files, spdxFile{
+			SPDXID:    id,
+			FileName:  path.Path,
+			Checksums: []spdxChecksum{{Algorithm: "SHA256", Value: sha}},
+		})
+		fmt.Fprintf(digest, "path %s %s\n", path.Path, sha)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A file owned by several slices of a package is contained only once.
+	contained := make(map[[2]string]bool)
+	err = manifest.IterateContents("", func(content *Content) error {
+		fileID, ok := fileIDs[content.Path]
+		if !ok {
+			return nil
+		}
+		pkgName, _, ok := strings.Cut(content.Slice, "_")
+		if !ok {
+			return fmt.Errorf("invalid slice name %q", content.Slice)
+		}
+		pkgID, ok := pkgIDs[pkgName]
+		if !ok {
+			return fmt.Errorf("slice %q has no package entry", content.Slice)
+		}
+		if contained[[2]string{pkgID, fileID}] {
+			return nil
+		}
+		contained[[2]string{pkgID, fileID}] = true
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			Element: pkgID,
+			Type:    "CONTAINS",
+			Related: fileID,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if doc.Namespace == "" {
+		sum := hex.EncodeToString(digest.Sum(nil))
+		doc.Namespace = fmt.Sprintf("https://github.com/canonical/chisel/spdx/%s-%s", doc.Name, sum[:16])
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+const spdxDocumentID = "SPDXRef-DOCUMENT"
+
+// spdxID returns an element identifier for name, replacing the characters
+// that SPDX does not allow in identifiers, such as "+", with "-". Since
+// distinct names may map to the same identifier, a numeric suffix is added
+// when the identifier is already used, and the result is recorded there.
+func spdxID(kind, name string, used map[string]bool) string {
+	id := "SPDXRef-" + kind + "-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	unique := id
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+type spdxDocument struct {
+	SPDXVersion   string             `json:"spdxVersion"`
+	DataLicense   string             `json:"dataLicense"`
+	SPDXID        string             `json:"SPDXID"`
+	Name          string             `json:"name"`
+	Namespace     string             `json:"documentNamespace"`
+	CreationInfo  spdxCreationInfo   `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Files         []spdxFile         `json:"files"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxFile struct {
+	SPDXID    string         `json:"SPDXID"`
+	FileName  string         `json:"fileName"`
+	Checksums []spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/public/manifest"
+)
+
+var spdxManifest = trimLines(`
+	{"jsonwall":"1.0","schema":"1.0","count":15}
+	{"kind":"content","slice":"libc++1_libs","path":"/lib/libc++.so.1"}
+	{"kind":"content","slice":"pkg1_bins","path":"/bin/"}
+	{"kind":"content","slice":"pkg1_bins","path":"/bin/tool"}
+	{"kind":"content","slice":"pkg1_bins","path":"/bin/tool-link"}
+	{"kind":"content","slice":"pkg1_config","path":"/bin/tool"}
+	{"kind":"content","slice":"pkg1_config","path":"/var/lib/chisel/manifest.wall"}
+	{"kind":"package","name":"libc++1","version":"1:2.0","sha256":"libc-digest","arch":"amd64"}
+	{"kind":"package","name":"pkg1","version":"1.0","sha256":"pkg1-digest","arch":"amd64"}
+	{"kind":"path","path":"/bin/","mode":"0755","slices":["pkg1_bins"]}
+	{"kind":"path","path":"/bin/tool","mode":"0755","slices":["pkg1_bins","pkg1_config"],"sha256":"tool-hash","final_sha256":"tool-final-hash","size":10}
+	{"kind":"path","path":"/bin/tool-link","mode":"0777","slices":["pkg1_bins"],"link":"tool"}
+	{"kind":"path","path":"/lib/libc++.so.1","mode":"0644","slices":["libc++1_libs"],"sha256":"lib-hash","size":20}
+	{"kind":"path","path":"/var/lib/chisel/manifest.wall","mode":"0644","slices":["pkg1_config"]}
+	{"kind":"slice","name":"libc++1_libs"}
+	{"kind":"slice","name":"pkg1_bins"}
+	{"kind":"slice","name":"pkg1_config"}
+`)
+
+var spdxDocument = `{
+	"spdxVersion": "SPDX-2.3",
+	"dataLicense": "CC0-1.0",
+	"SPDXID": "SPDXRef-DOCUMENT",
+	"name": "test-root",
+	"documentNamespace": "https://example.com/test-root",
+	"creationInfo": {
+		"created": "2024-01-02T03:04:05Z",
+		"creators": ["Tool: chisel"]
+	},
+	"packages": [{
+		"SPDXID": "SPDXRef-Package-libc--1",
+		"name": "libc++1",
+		"versionInfo": "1:2.0",
+		"downloadLocation": "NOASSERTION",
+		"filesAnalyzed": false,
+		"checksums": [{"algorithm": "SHA256", "checksumValue": "libc-digest"}],
+		"externalRefs": [{
+			"referenceCategory": "PACKAGE-MANAGER",
+			"referenceType": "purl",
+			"referenceLocator": "pkg:deb/libc++1@1:2.0?arch=amd64"
+		}]
+	}, {
+		"SPDXID": "SPDXRef-Package-pkg1",
+		"name": "pkg1",
+		"versionInfo": "1.0",
+		"downloadLocation": "NOASSERTION",
+		"filesAnalyzed": false,
+		"checksums": [{"algorithm": "SHA256", "checksumValue": "pkg1-digest"}],
+		"externalRefs": [{
+			"referenceCategory": "PACKAGE-MANAGER",
+			"referenceType": "purl",
+			"referenceLocator": "pkg:deb/pkg1@1.0?arch=amd64"
+		}]
+	}],
+	"files": [{
+		"SPDXID": "SPDXRef-File-1",
+		"fileName": "/bin/tool",
+		"checksums": [{"algorithm": "SHA256", "checksumValue": "tool-final-hash"}]
+	}, {
+		"SPDXID": "SPDXRef-File-2",
+		"fileName": "/lib/libc++.so.1",
+		"checksums": [{"algorithm": "SHA256", "checksumValue": "lib-hash"}]
+	}],
+	"relationships": [
+		{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": "SPDXRef-Package-libc--1"},
+		{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": "SPDXRef-Package-pkg1"},
+		{"spdxElementId": "SPDXRef-Package-libc--1", "relationshipType": "CONTAINS", "relatedSpdxElement": "SPDXRef-File-2"},
+		{"spdxElementId": "SPDXRef-Package-pkg1", "relationshipType": "CONTAINS", "relatedSpdxElement": "SPDXRef-File-1"}
+	]
+}`
+
+func (s *S) TestWriteSPDX(c *C) {
+	mfest, err := manifest.Read(strings.NewReader(spdxManifest))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	err = manifest.WriteSPDXWithOptions(mfest, &buf, &manifest.SPDXOptions{
+		Name:      "test-root",
+		Namespace: "https://example.com/test-root",
+		Created:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	c.Assert(err, IsNil)
+
+	var obtained, expected any
+	err = json.Unmarshal(buf.Bytes(), &obtained)
+	c.Assert(err, IsNil)
+	err = json.Unmarshal([]byte(spdxDocument), &expected)
+	c.Assert(err, IsNil)
+	c.Assert(obtained, DeepEquals, expected)
+}
+
+func (s *S) TestWriteSPDXDefaults(c *C) {
+	mfest, err := manifest.Read(strings.NewReader(spdxManifest))
+	c.Assert(err, IsNil)
+
+	var buf1, buf2 bytes.Buffer
+	err = manifest.WriteSPDX(mfest, &buf1)
+	c.Assert(err, IsNil)
+	err = manifest.WriteSPDX(mfest, &buf2)
+	c.Assert(err, IsNil)
+	c.Assert(buf1.String(), Equals, buf2.String())
+
+	var doc struct {
+		Name         string `json:"name"`
+		Namespace    string `json:"documentNamespace"`
+		CreationInfo struct {
+			Created string `json:"created"`
+		} `json:"creationInfo"`
+	}
+	err = json.Unmarshal(buf1.Bytes(), &doc)
+	c.Assert(err, IsNil)
+	c.Assert(doc.Name, Equals, "chisel")
+	c.Assert(doc.Namespace, Matches, `https://github.com/canonical/chisel/spdx/chisel-[0-9a-f]{16}`)
+	c.Assert(doc.CreationInfo.Created, Equals, "1970-01-01T00:00:00Z")
+}
+
+func (s *S) TestWriteSPDXUniqueIDs(c *C) {
+	input := trimLines(`
+		{"jsonwall":"1.0","schema":"1.0","count":9}
+		{"kind":"content","slice":"libc++1_libs","path":"/file1"}
+		{"kind":"content","slice":"libc--1_libs","path":"/file2"}
+		{"kind":"package","name":"libc++1","version":"1.0","sha256":"digest1","arch":"amd64"}
+		{"kind":"package","name":"libc--1","version":"1.0","sha256":"digest2","arch":"amd64"}
+		{"kind":"package","name":"libc--1-2","version":"1.0","sha256":"digest3","arch":"amd64"}
+		{"kind":"path","path":"/file1","mode":"0644","slices":["libc++1_libs"],"sha256":"hash1"}
+		{"kind":"path","path":"/file2","mode":"0644","slices":["libc--1_libs"],"sha256":"hash2"}
+		{"kind":"slice","name":"libc++1_libs"}
+		{"kind":"slice","name":"libc--1_libs"}
+	`)
+	mfest, err := manifest.Read(strings.NewReader(input))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	err = manifest.WriteSPDX(mfest, &buf)
+	c.Assert(err, IsNil)
+
+	var doc struct {
+		Packages []struct {
+			SPDXID string `json:"SPDXID"`
+			Name   string `json:"name"`
+		} `json:"packages"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	c.Assert(err, IsNil)
+	ids := make(map[string]string)
+	for _, pkg := range doc.Packages {
+		ids[pkg.Name] = pkg.SPDXID
+	}
+	c.Assert(ids, DeepEquals, map[string]string{
+		"libc++1":   "SPDXRef-Package-libc--1",
+		"libc--1":   "SPDXRef-Package-libc--1-2",
+		"libc--1-2": "SPDXRef-Package-libc--1-2-2",
+	})
+}
+
+func (s *S) TestWriteSPDXMissingPackage(c *C) {
+	input := trimLines(`
+		{"jsonwall":"1.0","schema":"1.0","count":3}
+		{"kind":"content","slice":"pkg1_slice","path":"/file"}
+		{"kind":"path","path":"/file","mode":"0644","slices":["pkg1_slice"],"sha256":"hash"}
+		{"kind":"slice","name":"pkg1_slice"}
+	`)
+	mfest, err := manifest.Read(strings.NewReader(input))
+	c.Assert(err, IsNil)
+
+	err = manifest.WriteSPDX(mfest, &bytes.Buffer{})
+	c.Assert(err, ErrorMatches, `cannot write SPDX document: slice "pkg1_slice" has no package entry`)
+}